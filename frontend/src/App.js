@@ -257,6 +257,13 @@ function AppContent() {
     }
   };
 
+  const handlePurgeServerPrivateKeys = async (serverId) => {
+    const interface_ = serverDialog.interface;
+    await apiService.purgeServerPrivateKeys(interface_.id, serverId);
+    // Trigger refresh of interface view data
+    setSelectedInterface(prev => ({ ...prev, lastModified: Date.now() }));
+  };
+
   const handleToggleServer = async (server, enabled) => {
     try {
       await apiService.setServerEnabled(selectedInterface.id, server.id, enabled);
@@ -288,14 +295,27 @@ function AppContent() {
   const handleSaveClient = async (clientData) => {
     const { interface: interface_, server } = clientDialog;
     
+    let savedClient;
     if (clientDialog.client) {
       // Edit existing
-      await apiService.updateClient(interface_.id, server.id, clientDialog.client.id, clientData);
+      savedClient = await apiService.updateClient(interface_.id, server.id, clientDialog.client.id, clientData);
     } else {
       // Create new
-      const newClient = await apiService.createClient(interface_.id, server.id, clientData);
+      savedClient = await apiService.createClient(interface_.id, server.id, clientData);
       // Enable the newly created client
-      await apiService.setClientEnabled(interface_.id, server.id, newClient.id, true);
+      await apiService.setClientEnabled(interface_.id, server.id, savedClient.id, true);
+    }
+    // With ephemeral private keys the server doesn't keep the key, this is the only copy
+    if (savedClient && savedClient.config) {
+      const blob = new Blob([savedClient.config], { type: 'text/plain' });
+      const url = URL.createObjectURL(blob);
+      const a = document.createElement('a');
+      a.href = url;
+      a.download = `${server.name || 'server'}-${savedClient.name || 'client'}.conf`;
+      document.body.appendChild(a);
+      a.click();
+      document.body.removeChild(a);
+      URL.revokeObjectURL(url);
     }
     // Trigger refresh of interface view data
     setSelectedInterface(prev => ({ ...prev, lastModified: Date.now() }));
@@ -408,6 +428,7 @@ function AppContent() {
         onClose={() => setServerDialog({ open: false, server: null, interface: null })}
         onSave={handleSaveServer}
         onDelete={handleDeleteServer}
+        onPurgePrivateKeys={handlePurgeServerPrivateKeys}
         wgvrf={selectedInterface?.vrfName}
         server={serverDialog.server}
       />
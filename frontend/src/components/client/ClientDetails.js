@@ -24,6 +24,8 @@ const ClientDetails = ({ client, clientState, lastUpdateTime, interfaceId, serve
   const [qrDialogOpen, setQrDialogOpen] = useState(false);
   const [error, setError] = useState(null);
   const [copySuccess, setCopySuccess] = useState(false);
+  const [usage, setUsage] = useState(null);
+  const [history, setHistory] = useState(null);
 
   // Only load config when component becomes visible
   useEffect(() => {
@@ -32,6 +34,22 @@ const ClientDetails = ({ client, clientState, lastUpdateTime, interfaceId, serve
     }
   }, [visible, client.id, configLoaded]);
 
+  // Traffic of the quota period, refreshed with the client state
+  useEffect(() => {
+    if (!visible || !interfaceId || !serverId || !client.id) return;
+    apiService.getClientUsage(interfaceId, serverId, client.id)
+      .then(setUsage)
+      .catch((error) => console.error('Failed to load client usage:', error));
+  }, [visible, interfaceId, serverId, client.id, lastUpdateTime]);
+
+  // Traffic of the last day per hour
+  useEffect(() => {
+    if (!visible || !interfaceId || !serverId || !client.id) return;
+    apiService.getHistory(interfaceId, serverId, client.id, { resolution: 'hour' })
+      .then(setHistory)
+      .catch((error) => console.error('Failed to load client history:', error));
+  }, [visible, interfaceId, serverId, client.id, lastUpdateTime]);
+
   // Reset config when interface or server changes
   useEffect(() => {
     setConfig('');
@@ -136,6 +154,53 @@ const ClientDetails = ({ client, clientState, lastUpdateTime, interfaceId, serve
     return `Tx: ${tx}, Rx: ${rx}`;
   };
 
+  const getUsageDisplay = () => {
+    if (!usage) {
+      return 'No data';
+    }
+
+    const since = new Date(usage.periodStart).toLocaleDateString();
+    let text = `Tx: ${formatBytes(usage.txBytes)}, Rx: ${formatBytes(usage.rxBytes)} since ${since}`;
+    const quota = usage.quota;
+    if (quota) {
+      const limits = [];
+      if (quota.rxBytes) limits.push(`Rx ${formatBytes(quota.rxBytes)}`);
+      if (quota.txBytes) limits.push(`Tx ${formatBytes(quota.txBytes)}`);
+      if (quota.totalBytes) limits.push(`total ${formatBytes(quota.totalBytes)}`);
+      text += ` (quota: ${limits.join(', ')})`;
+    }
+    if (usage.enforced === 'disable') {
+      text += ', disabled by quota';
+    } else if (usage.enforced === 'throttle') {
+      text += ', throttled by quota';
+    }
+    return text;
+  };
+
+  const getHistoryDisplay = () => {
+    if (!history || history.points.length === 0) {
+      return 'No data';
+    }
+
+    const points = history.points;
+    const tx = points.reduce((sum, point) => sum + point.tx, 0);
+    const rx = points.reduce((sum, point) => sum + point.rx, 0);
+    const samples = points.reduce((sum, point) => sum + point.samples, 0);
+    const online = points.reduce((sum, point) => sum + point.online, 0);
+    const max = Math.max(...points.map((point) => point.rx + point.tx), 1);
+    return (
+      <Box sx={{ display: 'flex', alignItems: 'center', gap: 2 }}>
+        <svg width={points.length * 4} height={20} aria-label="Traffic per hour">
+          {points.map((point, index) => {
+            const height = Math.max(1, Math.round(((point.rx + point.tx) / max) * 20));
+            return <rect key={point.time} x={index * 4} y={20 - height} width={3} height={height} fill="currentColor" />;
+          })}
+        </svg>
+        <span>{`Tx: ${formatBytes(tx)}, Rx: ${formatBytes(rx)}, online ${Math.round((online / samples) * 100)}% of the time`}</span>
+      </Box>
+    );
+  };
+
   return (
     <Box>
       <TableContainer component={Paper} elevation={0}>
@@ -153,6 +218,14 @@ const ClientDetails = ({ client, clientState, lastUpdateTime, interfaceId, serve
               <TableCell sx={{ fontWeight: 'bold' }}>Transferred:</TableCell>
               <TableCell>{getTrafficDisplay()}</TableCell>
             </TableRow>
+            <TableRow>
+              <TableCell sx={{ fontWeight: 'bold' }}>Period usage:</TableCell>
+              <TableCell>{getUsageDisplay()}</TableCell>
+            </TableRow>
+            <TableRow>
+              <TableCell sx={{ fontWeight: 'bold' }}>Last 24 hours:</TableCell>
+              <TableCell>{getHistoryDisplay()}</TableCell>
+            </TableRow>
             <TableRow>
               <TableCell sx={{ fontWeight: 'bold' }}>Last handshake:</TableCell>
               <TableCell>{formatLastHandshake(lastUpdateTime, clientState?.latestHandshake)}</TableCell>
@@ -161,6 +234,10 @@ const ClientDetails = ({ client, clientState, lastUpdateTime, interfaceId, serve
               <TableCell sx={{ fontWeight: 'bold' }}>Endpoint:</TableCell>
               <TableCell>{clientState?.endpoint || 'Not connected'}</TableCell>
             </TableRow>
+            <TableRow>
+              <TableCell sx={{ fontWeight: 'bold' }}>Expires:</TableCell>
+              <TableCell>{client.expiresAt ? new Date(client.expiresAt).toLocaleString() : 'Never'}</TableCell>
+            </TableRow>
           </TableBody>
         </Table>
       </TableContainer>
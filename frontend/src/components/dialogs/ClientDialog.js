@@ -6,10 +6,49 @@ import {
   DialogActions,
   TextField,
   Button,
-  Box
+  Box,
+  MenuItem
 } from '@mui/material';
 import ErrorDialog from './ErrorDialog';
 
+// toLocalDateTime formats a date for a datetime-local input
+const toLocalDateTime = (value) => {
+  if (!value) return '';
+  const date = new Date(value);
+  const offset = date.getTimezoneOffset() * 60000;
+  return new Date(date.getTime() - offset).toISOString().slice(0, 16);
+};
+
+const GB = 1024 * 1024 * 1024;
+
+// toGB formats a quota limit in bytes for a GB input
+const toGB = (bytes) => (bytes ? (bytes / GB).toString() : '');
+
+const emptyQuota = {
+  quotaPeriod: 'none',
+  quotaResetDay: '1',
+  quotaRollingDays: '30',
+  quotaRx: '',
+  quotaTx: '',
+  quotaTotal: '',
+  quotaAction: 'disable',
+  quotaThrottleKbit: ''
+};
+
+const quotaFormData = (quota) => {
+  if (!quota) return emptyQuota;
+  return {
+    quotaPeriod: quota.period,
+    quotaResetDay: (quota.resetDay || 1).toString(),
+    quotaRollingDays: (quota.rollingDays || 30).toString(),
+    quotaRx: toGB(quota.rxBytes),
+    quotaTx: toGB(quota.txBytes),
+    quotaTotal: toGB(quota.totalBytes),
+    quotaAction: quota.action,
+    quotaThrottleKbit: quota.throttleKbit?.toString() || ''
+  };
+};
+
 const ClientDialog = ({ 
   open, 
   onClose, 
@@ -26,7 +65,11 @@ const ClientDialog = ({
     privateKey: '',
     publicKey: '',
     presharedKey: '',
-    keepalive: ''
+    keepalive: '',
+    rateLimitUp: '',
+    rateLimitDown: '',
+    expiresAt: '',
+    ...emptyQuota
   });
   const [loading, setLoading] = useState(false);
   const [errorDialog, setErrorDialog] = useState({ open: false, error: null, title: 'Error' });
@@ -45,7 +88,11 @@ const ClientDialog = ({
         privateKey: '',
         publicKey: client.publicKey || '',
         presharedKey: '',
-        keepalive: client.keepalive?.toString() || ''
+        keepalive: client.keepalive?.toString() || '',
+        rateLimitUp: client.rateLimitUp?.toString() || '',
+        rateLimitDown: client.rateLimitDown?.toString() || '',
+        expiresAt: toLocalDateTime(client.expiresAt),
+        ...quotaFormData(client.quota)
       });
     } else {
       setFormData({
@@ -56,7 +103,11 @@ const ClientDialog = ({
         privateKey: '',
         publicKey: '',
         presharedKey: '',
-        keepalive: ''
+        keepalive: '',
+        rateLimitUp: '',
+        rateLimitDown: '',
+        expiresAt: '',
+        ...emptyQuota
       });
     }
   }, [client, open]);
@@ -101,6 +152,29 @@ const ClientDialog = ({
         data.keepalive = parseInt(formData.keepalive) >= -1? parseInt(formData.keepalive) : null;
       }
 
+      // Rate limits, the ones of the server when empty
+      data.rateLimitUp = formData.rateLimitUp !== '' ? parseInt(formData.rateLimitUp) : null;
+      data.rateLimitDown = formData.rateLimitDown !== '' ? parseInt(formData.rateLimitDown) : null;
+
+      // Expiry, none when empty
+      data.expiresAt = formData.expiresAt ? new Date(formData.expiresAt).toISOString() : null;
+
+      // Traffic quota, none without a period
+      data.quota = null;
+      if (formData.quotaPeriod !== 'none') {
+        const toBytes = (value) => (value ? Math.round(parseFloat(value) * GB) : 0);
+        data.quota = {
+          period: formData.quotaPeriod,
+          resetDay: formData.quotaPeriod === 'monthly' ? parseInt(formData.quotaResetDay) || 1 : 0,
+          rollingDays: formData.quotaPeriod === 'rolling' ? parseInt(formData.quotaRollingDays) || 30 : 0,
+          rxBytes: toBytes(formData.quotaRx),
+          txBytes: toBytes(formData.quotaTx),
+          totalBytes: toBytes(formData.quotaTotal),
+          action: formData.quotaAction,
+          throttleKbit: formData.quotaAction === 'throttle' ? parseInt(formData.quotaThrottleKbit) || 0 : 0
+        };
+      }
+
       await onSave(data);
       onClose();
     } catch (err) {
@@ -226,6 +300,133 @@ const ClientDialog = ({
             variant="outlined"
             helperText="PersistentKeepalive interval in seconds"
           />
+
+          <Box sx={{ display: 'flex', gap: 2 }}>
+            <TextField
+              label="Upload limit (kbit/s)"
+              type="number"
+              value={formData.rateLimitUp}
+              onChange={handleChange('rateLimitUp')}
+              fullWidth
+              variant="outlined"
+              helperText="Empty uses the server default, 0 is unlimited"
+            />
+            <TextField
+              label="Download limit (kbit/s)"
+              type="number"
+              value={formData.rateLimitDown}
+              onChange={handleChange('rateLimitDown')}
+              fullWidth
+              variant="outlined"
+              helperText="Empty uses the server default, 0 is unlimited"
+            />
+          </Box>
+
+          <TextField
+            label="Expires at"
+            type="datetime-local"
+            value={formData.expiresAt}
+            onChange={handleChange('expiresAt')}
+            fullWidth
+            variant="outlined"
+            InputLabelProps={{ shrink: true }}
+            helperText="The client is disabled after this date, leave empty to never expire"
+          />
+
+          <TextField
+            select
+            label="Traffic quota"
+            value={formData.quotaPeriod}
+            onChange={handleChange('quotaPeriod')}
+            fullWidth
+            variant="outlined"
+          >
+            <MenuItem value="none">Unlimited</MenuItem>
+            <MenuItem value="monthly">Monthly</MenuItem>
+            <MenuItem value="rolling">Rolling</MenuItem>
+          </TextField>
+
+          {formData.quotaPeriod === 'monthly' && (
+            <TextField
+              label="Reset day"
+              type="number"
+              value={formData.quotaResetDay}
+              onChange={handleChange('quotaResetDay')}
+              fullWidth
+              variant="outlined"
+              helperText="Day of the month the quota starts again, 1 to 28"
+            />
+          )}
+
+          {formData.quotaPeriod === 'rolling' && (
+            <TextField
+              label="Rolling days"
+              type="number"
+              value={formData.quotaRollingDays}
+              onChange={handleChange('quotaRollingDays')}
+              fullWidth
+              variant="outlined"
+              helperText="The quota counts the traffic of the last days, 1 to 60"
+            />
+          )}
+
+          {formData.quotaPeriod !== 'none' && (
+            <>
+              <Box sx={{ display: 'flex', gap: 2 }}>
+                <TextField
+                  label="Rx (GB)"
+                  type="number"
+                  value={formData.quotaRx}
+                  onChange={handleChange('quotaRx')}
+                  fullWidth
+                  variant="outlined"
+                  helperText="Received from the client"
+                />
+                <TextField
+                  label="Tx (GB)"
+                  type="number"
+                  value={formData.quotaTx}
+                  onChange={handleChange('quotaTx')}
+                  fullWidth
+                  variant="outlined"
+                  helperText="Sent to the client"
+                />
+                <TextField
+                  label="Total (GB)"
+                  type="number"
+                  value={formData.quotaTotal}
+                  onChange={handleChange('quotaTotal')}
+                  fullWidth
+                  variant="outlined"
+                  helperText="Empty is unlimited"
+                />
+              </Box>
+
+              <TextField
+                select
+                label="When exceeded"
+                value={formData.quotaAction}
+                onChange={handleChange('quotaAction')}
+                fullWidth
+                variant="outlined"
+              >
+                <MenuItem value="disable">Disable the client</MenuItem>
+                <MenuItem value="throttle">Throttle the client</MenuItem>
+              </TextField>
+
+              {formData.quotaAction === 'throttle' && (
+                <TextField
+                  label="Throttle rate (kbit/s)"
+                  type="number"
+                  value={formData.quotaThrottleKbit}
+                  onChange={handleChange('quotaThrottleKbit')}
+                  fullWidth
+                  variant="outlined"
+                  helperText="Rate in both directions until the quota starts again"
+                />
+              )}
+            </>
+          )}
         </Box>
       </DialogContent>
       
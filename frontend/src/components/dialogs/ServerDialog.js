@@ -44,6 +44,7 @@ const ServerDialog = ({
   onClose, 
   onSave, 
   onDelete,
+  onPurgePrivateKeys,
   server,
   wgvrf,
   title 
@@ -83,6 +84,9 @@ const ServerDialog = ({
       }
     },
     keepalive: '',
+    rateLimitUp: '',
+    rateLimitDown: '',
+    ephemeralPrivateKeys: false,
   });
   const [warnings, setWarnings] = useState([]);
   const [loading, setLoading] = useState(false);
@@ -146,6 +150,9 @@ const ServerDialog = ({
           }
         },
         keepalive: server.keepalive || '',
+        rateLimitUp: server.rateLimitUp?.toString() || '',
+        rateLimitDown: server.rateLimitDown?.toString() || '',
+        ephemeralPrivateKeys: server.ephemeralPrivateKeys || false,
       });
     } else {
       setFormData({
@@ -183,6 +190,9 @@ const ServerDialog = ({
           }
         },
         keepalive: '',
+        rateLimitUp: '',
+        rateLimitDown: '',
+        ephemeralPrivateKeys: false,
       });
     }
     setWarnings([]);
@@ -449,6 +459,10 @@ const ServerDialog = ({
       const data = {
         name: formData.name,
         dns: formData.dns ? formData.dns.split(',').map(s => s.trim()).filter(s => s) : null,
+        ephemeralPrivateKeys: formData.ephemeralPrivateKeys,
+        // Default rate limits of the clients, empty is unlimited
+        rateLimitUp: parseInt(formData.rateLimitUp) || 0,
+        rateLimitDown: parseInt(formData.rateLimitDown) || 0,
       };
       if (formData.keepalive) {
         data.keepalive = parseInt(formData.keepalive) >= -1? parseInt(formData.keepalive) : null;
@@ -530,6 +544,25 @@ const ServerDialog = ({
     }
   };
 
+  const handlePurgePrivateKeys = async () => {
+    if (!server) return;
+    if (!window.confirm('Delete the stored private keys of every client of this server? Their configurations will only have a placeholder for the key.')) return;
+
+    setLoading(true);
+
+    try {
+      await onPurgePrivateKeys(server.id);
+    } catch (err) {
+      setErrorDialog({ 
+        open: true, 
+        error: err.message || 'Failed to purge private keys', 
+        title: 'Purge Failed' 
+      });
+    } finally {
+      setLoading(false);
+    }
+  };
+
   const renderIPSection = (ipVersion) => {
     const ip = formData[ipVersion];
     const isEnabled = ip.enabled;
@@ -759,6 +792,49 @@ const ServerDialog = ({
             helperText="PersistentKeepalive interval in seconds"
             placeholder="25"
           />
+
+          <Box sx={{ display: 'flex', gap: 2 }}>
+            <TextField
+              label="Client upload limit (kbit/s)"
+              type="number"
+              value={formData.rateLimitUp}
+              onChange={(e) => handleChange('rateLimitUp', e.target.value)}
+              fullWidth
+              variant="outlined"
+              helperText="Default of the clients, empty is unlimited"
+            />
+            <TextField
+              label="Client download limit (kbit/s)"
+              type="number"
+              value={formData.rateLimitDown}
+              onChange={(e) => handleChange('rateLimitDown', e.target.value)}
+              fullWidth
+              variant="outlined"
+              helperText="Default of the clients, empty is unlimited"
+            />
+          </Box>
+
+          <Box sx={{ display: 'flex', alignItems: 'center' }}>
+            <FormControlLabel
+              control={
+                <Checkbox
+                  checked={formData.ephemeralPrivateKeys}
+                  onChange={(e) => handleChange('ephemeralPrivateKeys', e.target.checked)}
+                />
+              }
+              label="Ephemeral private keys (downloaded once, never stored)"
+            />
+            <Box sx={{ flexGrow: 1 }} />
+            {isEdit && (
+              <Button
+                onClick={handlePurgePrivateKeys}
+                color="warning"
+                disabled={loading}
+              >
+                PURGE STORED KEYS
+              </Button>
+            )}
+          </Box>
         </Box>
       </DialogContent>
       
@@ -125,6 +125,21 @@ const InterfaceView = ({
     return () => clearInterval(interval);
   }, [interface_?.id, trafficDisplayMode]);
 
+  // Refresh the client states as soon as a peer of the interface changes
+  useEffect(() => {
+    if (!interface_) return;
+
+    const close = apiService.subscribeEvents(
+      ['peer.handshake', 'peer.online', 'peer.offline', 'peer.endpoint'],
+      (event) => {
+        if (event.interfaceId === interface_.id) {
+          loadClientsState();
+        }
+      }
+    );
+    return close;
+  }, [interface_?.id]);
+
   const loadServers = async () => {
     if (!interface_) return;
     
@@ -1,4 +1,4 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import {
   Dialog,
   DialogTitle,
@@ -13,13 +13,23 @@ import {
 } from '@mui/material';
 import { GitHub } from '@mui/icons-material';
 import { useAuth } from '../../context/AuthContext';
+import authService from '../../services/authService';
 
 const LoginDialog = ({ open, onClose, suppressFocusTrap = false }) => {
   const { login } = useAuth();
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
+  const [code, setCode] = useState('');
+  const [codeRequired, setCodeRequired] = useState(false);
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
+  const [oidc, setOIDC] = useState({ enabled: false });
+
+  useEffect(() => {
+    if (open) {
+      authService.getOIDCInfo().then(setOIDC).catch(() => setOIDC({ enabled: false }));
+    }
+  }, [open]);
 
   const handleSubmit = async (e) => {
     e.preventDefault();
@@ -27,12 +37,22 @@ const LoginDialog = ({ open, onClose, suppressFocusTrap = false }) => {
     setLoading(true);
 
     try {
-      await login(username, password);
+      await login(username, password, codeRequired ? code : undefined);
       setUsername('');
       setPassword('');
+      setCode('');
+      setCodeRequired(false);
       onClose();
     } catch (err) {
-      setError('Invalid username or password');
+      if (err.retryAfter) {
+        setError(`Too many failed logins, try again in ${err.retryAfter} seconds`);
+      } else if (err.twoFactorRequired) {
+        // Second step, ask for the code and send the credentials again with it
+        setError(codeRequired ? 'Invalid two-factor code' : '');
+        setCodeRequired(true);
+      } else {
+        setError('Invalid username or password');
+      }
     } finally {
       setLoading(false);
     }
@@ -81,6 +101,21 @@ const LoginDialog = ({ open, onClose, suppressFocusTrap = false }) => {
             onChange={(e) => setPassword(e.target.value)}
             required
           />
+          {codeRequired && (
+            <TextField
+              autoFocus
+              margin="dense"
+              label="Authentication code or recovery code"
+              type="text"
+              fullWidth
+              variant="outlined"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              inputProps={{ autoComplete: 'one-time-code' }}
+              required
+              sx={{ mt: 2 }}
+            />
+          )}
         </DialogContent>
         <DialogActions sx={{ px: 3, pb: 3, justifyContent: 'space-between' }}>
           <Tooltip title="View on GitHub">
@@ -91,6 +126,15 @@ const LoginDialog = ({ open, onClose, suppressFocusTrap = false }) => {
               <GitHub />
             </IconButton>
           </Tooltip>
+          {oidc.enabled && (
+            <Button
+              variant="outlined"
+              onClick={() => { window.location.href = authService.getOIDCLoginUrl(); }}
+              sx={{ ml: 'auto', mr: 1 }}
+            >
+              Sign in with {oidc.displayName || 'SSO'}
+            </Button>
+          )}
           <Button 
             type="submit" 
             variant="contained"
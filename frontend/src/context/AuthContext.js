@@ -40,9 +40,9 @@ export const AuthProvider = ({ children }) => {
     }
   };
 
-  const login = async (username, password) => {
+  const login = async (username, password, code) => {
     try {
-      await authService.login(username, password);
+      await authService.login(username, password, code);
       
       // After successful login, get service config and reinitialize state manager
       const config = await authService.getServiceConfig();
@@ -151,6 +151,12 @@ class ApiService {
     });
   }
 
+  async purgeServerPrivateKeys(ifId, serverId) {
+    return this.request(`/interfaces/${ifId}/servers/${serverId}/purge-private-keys`, {
+      method: 'POST',
+    });
+  }
+
   // Client endpoints
   async getServerClients(ifId, serverId) {
     return this.request(`/interfaces/${ifId}/servers/${serverId}/clients`);
@@ -188,6 +194,38 @@ class ApiService {
   }
 
 
+  async extendClient(ifId, serverId, clientId, extension) {
+    return this.request(`/interfaces/${ifId}/servers/${serverId}/clients/${clientId}/extend`, {
+      method: 'POST',
+      body: JSON.stringify(extension),
+    });
+  }
+
+  async getClientUsage(ifId, serverId, clientId) {
+    return this.request(`/interfaces/${ifId}/servers/${serverId}/clients/${clientId}/usage`);
+  }
+
+  // Event stream of the given event types, such as 'peer.online'. Returns a function
+  // closing the stream.
+  subscribeEvents(types, onEvent) {
+    const source = new EventSource(
+      `${this.getApiBaseUrl()}/events?types=${encodeURIComponent(types.join(','))}`,
+      { withCredentials: true }
+    );
+    const handler = (message) => onEvent(JSON.parse(message.data));
+    types.forEach((type) => source.addEventListener(type, handler));
+    return () => source.close();
+  }
+
+  // Traffic history of an interface, a server or a client, params are since, until and resolution
+  async getHistory(ifId, serverId, clientId, params = {}) {
+    let path = `/interfaces/${ifId}`;
+    if (serverId) path += `/servers/${serverId}`;
+    if (clientId) path += `/clients/${clientId}`;
+    const query = new URLSearchParams(params).toString();
+    return this.request(`${path}/history${query ? `?${query}` : ''}`);
+  }
+
   async getClientConfig(ifId, serverId, clientId) {
     return this.request(`/interfaces/${ifId}/servers/${serverId}/clients/${clientId}/config`);
   }
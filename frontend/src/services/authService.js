@@ -4,23 +4,43 @@ class AuthService {
     return window.RUNTIME_API_PATH || './api';
   }
 
-  async login(username, password) {
+  async login(username, password, code) {
     const response = await fetch(`${this.getApiBaseUrl()}/service/login`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
       },
       credentials: 'include',
-      body: JSON.stringify({ username, password }),
+      body: JSON.stringify({ username, password, code }),
     });
 
     if (!response.ok) {
-      throw new Error('Login failed');
+      const error = new Error('Login failed');
+      const data = await response.json().catch(() => ({}));
+      error.twoFactorRequired = data.twoFactorRequired === true;
+      error.retryAfter = data.retryAfter;
+      throw error;
     }
 
     return response;
   }
 
+  async getOIDCInfo() {
+    const response = await fetch(`${this.getApiBaseUrl()}/service/oidc`, {
+      credentials: 'include',
+    });
+
+    if (!response.ok) {
+      return { enabled: false };
+    }
+
+    return response.json();
+  }
+
+  getOIDCLoginUrl() {
+    return `${this.getApiBaseUrl()}/service/oidc/login`;
+  }
+
   async logout() {
     const response = await fetch(`${this.getApiBaseUrl()}/service/logout`, {
       method: 'POST',
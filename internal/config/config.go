@@ -1,10 +1,14 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,6 +26,98 @@ type Session struct {
 	LastSeen  time.Time `json:"lastSeen"`
 }
 
+// Values of the wireguardBackend option
+const (
+	WireGuardBackendWgQuick = "wg-quick"
+	WireGuardBackendNetlink = "netlink"
+)
+
+// ReconcileConfig controls the background loop comparing the running state with the configuration
+type ReconcileConfig struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"intervalSeconds"`
+	AutoRepair      bool `json:"autoRepair"`
+}
+
+// ClientExpiryConfig controls the background loop disabling the expired clients
+type ClientExpiryConfig struct {
+	IntervalSeconds   int    `json:"intervalSeconds"`
+	WarnBeforeSeconds int    `json:"warnBeforeSeconds"`    // Warning published this long before the expiry, 0 publishes none
+	WebhookURL        string `json:"webhookUrl,omitempty"` // Deprecated: moved to a webhook of the expiry events when loaded
+}
+
+// TrafficQuotaConfig controls the background loop counting the traffic of the
+// clients and enforcing their quotas
+type TrafficQuotaConfig struct {
+	IntervalSeconds int    `json:"intervalSeconds"`
+	UsagePath       string `json:"usagePath"` // Traffic counters, kept across restarts
+}
+
+// TrafficHistoryConfig controls the sampler recording the traffic of the clients.
+// The samples are kept per minute, per hour and per day.
+type TrafficHistoryConfig struct {
+	IntervalSeconds int    `json:"intervalSeconds"`
+	Path            string `json:"path"`        // SQLite database of the samples
+	MinuteHours     int    `json:"minuteHours"` // How long the samples per minute are kept
+	HourDays        int    `json:"hourDays"`    // How long the samples per hour are kept
+	DayDays         int    `json:"dayDays"`     // How long the samples per day are kept, 0 keeps them
+}
+
+// EventsConfig controls the event stream
+type EventsConfig struct {
+	PollSeconds int `json:"pollSeconds"` // Interval of the polls of the peers for their transitions
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint. Served on the panel's
+// listener it needs the token, on its own listen address the token is optional.
+type MetricsConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Token         string `json:"token"`         // Bearer token of the scrapes
+	ListenAddress string `json:"listenAddress"` // Such as 127.0.0.1:9586, the panel's listener when empty
+}
+
+// LoginProtectionConfig controls the backoff and lockout after failed logins,
+// counted per source IP and per username
+type LoginProtectionConfig struct {
+	MaxFailures      int `json:"maxFailures"`      // Failures before a lockout
+	BaseDelaySeconds int `json:"baseDelaySeconds"` // Wait after the first failure, doubled on each failure
+	MaxDelaySeconds  int `json:"maxDelaySeconds"`
+	LockoutSeconds   int `json:"lockoutSeconds"`
+	ResetSeconds     int `json:"resetSeconds"` // Failures are forgotten after this time without a new one
+}
+
+// Values of the tls.mode option
+const (
+	TLSModeOff        = "off"
+	TLSModeFiles      = "files"
+	TLSModeSelfSigned = "self-signed"
+	TLSModeACME       = "acme"
+)
+
+// TLSConfig controls HTTPS on the listen address
+type TLSConfig struct {
+	Mode         string     `json:"mode"`
+	CertFile     string     `json:"certFile"` // For the files mode, reloaded when it changes
+	KeyFile      string     `json:"keyFile"`
+	CertDir      string     `json:"certDir"` // Generated self-signed certificate and ACME cache
+	RedirectHTTP bool       `json:"redirectHttp"`
+	HTTPPort     int        `json:"httpPort"` // Plain HTTP port for the redirect and the ACME HTTP-01 challenge
+	ACME         ACMEConfig `json:"acme"`
+}
+
+// ACMEConfig selects the ACME directory and the domains of the certificate
+type ACMEConfig struct {
+	DirectoryURL string   `json:"directoryUrl"` // Let's Encrypt when empty
+	Email        string   `json:"email"`
+	Domains      []string `json:"domains"`
+	CACertFile   string   `json:"caCertFile"` // Extra CA trusted to reach the directory, such as the one of a test CA
+}
+
+// Enabled tells if the panel is served over HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.Mode != "" && t.Mode != TLSModeOff
+}
+
 type ToFrontendMessage struct {
 	Firewalldefault bool
 	InitWarningMsg  string
@@ -30,10 +126,18 @@ type ToFrontendMessage struct {
 type Config struct {
 	ConfigPath          string                       `json:"-"`
 	WireGuardConfigPath string                       `json:"wireguardConfigPath"`
+	WireGuardBackend    string                       `json:"wireguardBackend"`
+	FirewallBackend     string                       `json:"firewallBackend"`
 	WgIfPrefix          string                       `json:"wgIfPrefix"`
 	LogLevel            logging.LogLevel             `json:"logLevel"`
 	User                string                       `json:"user"`
 	Password            string                       `json:"password"`
+	TwoFactor           *TwoFactor                   `json:"twoFactor,omitempty"` // Of the built-in user
+	Users               map[string]*User             `json:"users"`
+	APITokens           map[string]*APIToken         `json:"apiTokens"`
+	PortalLinks         map[string]*PortalLink       `json:"portalLinks"`
+	DownloadLinks       map[string]*DownloadLink     `json:"downloadLinks"`
+	DownloadKey         string                       `json:"downloadKey"` // Signs the download links
 	ListenIP            string                       `json:"listenIP"`
 	ListenPort          int                          `json:"listenPort"`
 	BasePath            string                       `json:"basePath"`
@@ -42,12 +146,39 @@ type Config struct {
 	WGPanelTitle        string                       `json:"frontendTitle"`
 	Interfaces          map[string]*models.Interface `json:"interfaces"`
 	Sessions            map[string]*Session          `json:"sessions"`
+	Reconcile           ReconcileConfig              `json:"reconcile"`
+	ClientExpiry        ClientExpiryConfig           `json:"clientExpiry"`
+	TrafficQuota        TrafficQuotaConfig           `json:"trafficQuota"`
+	TrafficHistory      TrafficHistoryConfig         `json:"trafficHistory"`
+	Metrics             MetricsConfig                `json:"metrics"`
+	Events              EventsConfig                 `json:"events"`
+	Webhooks            map[string]*Webhook          `json:"webhooks"`
+	LoginProtection     LoginProtectionConfig        `json:"loginProtection"`
+	TrustedProxies      []string                     `json:"trustedProxies"` // Proxies whose X-Forwarded-For gives the client IP
+	OIDC                OIDCConfig                   `json:"oidc"`
+	AuditLogPath        string                       `json:"auditLogPath"`
+	RevisionsPath       string                       `json:"revisionsPath"`
+	RevisionsKeep       int                          `json:"revisionsKeep"` // 0 keeps every revision
+	Storage             string                       `json:"storage"`
+	StoragePath         string                       `json:"storagePath"`
+	TLS                 TLSConfig                    `json:"tls"`
+	SecretKeyFile       string                       `json:"secretKeyFile,omitempty"` // Key encrypting the secrets, see secrets.go
 
 	// For thread safety
 	mu      sync.RWMutex                         `json:"-"`
+	applyMu sync.Mutex                           `json:"-"`
 	FendMsg ToFrontendMessage                    `json:"-"`
 	pbs     *internalservice.PseudoBridgeService `json:"-"`
 	srs     *internalservice.SNATRoamingService  `json:"-"`
+
+	// Revision history, see revisions.go
+	revMu      sync.Mutex `json:"-"`
+	revAuthor  string     `json:"-"`
+	revMessage string     `json:"-"`
+	revLast    *Revision  `json:"-"`
+
+	store   Storage    `json:"-"`
+	secrets *secretBox `json:"-"` // Nil without a secret key
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -73,6 +204,122 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Sessions == nil {
 		cfg.Sessions = make(map[string]*Session)
 	}
+	if cfg.Users == nil {
+		cfg.Users = make(map[string]*User)
+	}
+	if cfg.APITokens == nil {
+		cfg.APITokens = make(map[string]*APIToken)
+	}
+	if cfg.PortalLinks == nil {
+		cfg.PortalLinks = make(map[string]*PortalLink)
+	}
+	if cfg.DownloadLinks == nil {
+		cfg.DownloadLinks = make(map[string]*DownloadLink)
+	}
+	if cfg.Webhooks == nil {
+		cfg.Webhooks = make(map[string]*Webhook)
+	}
+	cfg.migrateExpiryWebhook()
+
+	if cfg.WGPanelTitle == "" {
+		cfg.WGPanelTitle = "Wireguard Server Panel"
+	}
+	if cfg.WireGuardBackend == "" {
+		cfg.WireGuardBackend = WireGuardBackendWgQuick
+	}
+	if cfg.FirewallBackend == "" {
+		cfg.FirewallBackend = internalservice.FirewallBackendIptables
+	}
+	if cfg.Reconcile.IntervalSeconds <= 0 {
+		cfg.Reconcile.IntervalSeconds = 60
+	}
+	if cfg.ClientExpiry.IntervalSeconds <= 0 {
+		cfg.ClientExpiry.IntervalSeconds = 60
+	}
+	if cfg.TrafficQuota.IntervalSeconds <= 0 {
+		cfg.TrafficQuota.IntervalSeconds = 60
+	}
+	if cfg.TrafficHistory.IntervalSeconds <= 0 {
+		cfg.TrafficHistory.IntervalSeconds = 60
+	}
+	if cfg.TrafficHistory.MinuteHours <= 0 {
+		cfg.TrafficHistory.MinuteHours = 24
+	}
+	if cfg.TrafficHistory.HourDays <= 0 {
+		cfg.TrafficHistory.HourDays = 31
+	}
+	if cfg.Events.PollSeconds <= 0 {
+		cfg.Events.PollSeconds = 5
+	}
+	if cfg.LoginProtection.MaxFailures <= 0 {
+		cfg.LoginProtection.MaxFailures = 10
+	}
+	if cfg.LoginProtection.BaseDelaySeconds <= 0 {
+		cfg.LoginProtection.BaseDelaySeconds = 1
+	}
+	if cfg.LoginProtection.MaxDelaySeconds <= 0 {
+		cfg.LoginProtection.MaxDelaySeconds = 60
+	}
+	if cfg.LoginProtection.LockoutSeconds <= 0 {
+		cfg.LoginProtection.LockoutSeconds = 900
+	}
+	if cfg.LoginProtection.ResetSeconds <= 0 {
+		cfg.LoginProtection.ResetSeconds = 3600
+	}
+	if cfg.OIDC.UsernameClaim == "" {
+		cfg.OIDC.UsernameClaim = "preferred_username"
+	}
+	if cfg.OIDC.GroupsClaim == "" {
+		cfg.OIDC.GroupsClaim = "groups"
+	}
+	if cfg.OIDC.Scopes == nil {
+		cfg.OIDC.Scopes = []string{"profile", "email"}
+	}
+	if cfg.AuditLogPath == "" {
+		cfg.AuditLogPath = filepath.Join(filepath.Dir(path), "audit.jsonl")
+	}
+	if cfg.TrafficQuota.UsagePath == "" {
+		cfg.TrafficQuota.UsagePath = filepath.Join(filepath.Dir(path), "usage.json")
+	}
+	if cfg.TrafficHistory.Path == "" {
+		cfg.TrafficHistory.Path = filepath.Join(filepath.Dir(path), "history.db")
+	}
+	if cfg.RevisionsPath == "" {
+		cfg.RevisionsPath = filepath.Join(filepath.Dir(path), "revisions")
+	}
+	if cfg.Storage == "" {
+		cfg.Storage = StorageJSON
+	}
+	if cfg.StoragePath == "" {
+		cfg.StoragePath = filepath.Join(filepath.Dir(path), "wg-panel.db")
+	}
+
+	if cfg.TLS.Mode == "" {
+		cfg.TLS.Mode = TLSModeOff
+	}
+	if cfg.TLS.CertDir == "" {
+		cfg.TLS.CertDir = filepath.Join(filepath.Dir(path), "certs")
+	}
+	if cfg.TLS.HTTPPort <= 0 {
+		cfg.TLS.HTTPPort = 80
+	}
+
+	store, err := NewStorage(&cfg)
+	if err != nil {
+		logging.LogError("Failed to open %s storage: %v", cfg.Storage, err)
+		return nil, fmt.Errorf("failed to open storage:-> %v", err)
+	}
+	if err := store.Load(&cfg); err != nil {
+		store.Close()
+		logging.LogError("Failed to load %s storage: %v", cfg.Storage, err)
+		return nil, fmt.Errorf("failed to load storage:-> %v", err)
+	}
+	cfg.store = store
+
+	if err := cfg.openSecrets(); err != nil {
+		logging.LogError("Failed to decrypt secrets: %v", err)
+		return nil, fmt.Errorf("failed to decrypt secrets:-> %v", err)
+	}
 
 	// Generate ServerId if not present
 	if cfg.WGPanelId == "" {
@@ -91,8 +338,18 @@ func LoadConfig(path string) (*Config, error) {
 		}
 		logging.LogInfo("Saved configuration with new server ID")
 	}
-	if cfg.WGPanelTitle == "" {
-		cfg.WGPanelTitle = "Wireguard Server Panel"
+
+	// Generate the key signing the download links if not present
+	if cfg.DownloadKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate download key:-> %v", err)
+		}
+		cfg.DownloadKey = hex.EncodeToString(key)
+		if err := cfg.Save(); err != nil {
+			logging.LogError("Failed to save config with new download key: %v", err)
+			return nil, fmt.Errorf("failed to save config with new download key:-> %v", err)
+		}
 	}
 
 	return &cfg, nil
@@ -104,16 +361,37 @@ func (c *Config) LoadInternalServices(pbs *internalservice.PseudoBridgeService,
 	c.FendMsg = fendMsg
 }
 
+// Save writes the configuration, and records a revision if the interfaces changed
 func (c *Config) Save() error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	store := c.store
+	if store == nil {
+		store = &jsonStorage{}
+	}
 
-	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.RLock()
+	if err := store.Save(c); err != nil {
+		c.mu.RUnlock()
+		return err
+	}
+	interfaces, err := json.Marshal(c.secrets.sealInterfaces(c.Interfaces))
+	c.mu.RUnlock()
 	if err != nil {
-		return fmt.Errorf("failed to marshal config:-> %v", err)
+		return fmt.Errorf("failed to marshal interfaces:-> %v", err)
 	}
 
-	return utils.WriteFileAtomic(c.ConfigPath, data, 0600)
+	if err := c.recordRevision(interfaces); err != nil {
+		// The configuration is saved, only its history is missing
+		logging.LogError("Failed to record configuration revision: %v", err)
+	}
+	return nil
+}
+
+// Close closes the storage
+func (c *Config) Close() error {
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Close()
 }
 
 func (c *Config) GetInterface(id string) *models.Interface {
@@ -133,18 +411,34 @@ func (c *Config) GetAllInterfaces() map[string]*models.Interface {
 	return result
 }
 
-func (c *Config) CleanUp() {
+// LockApply serializes changes applied to the running interfaces and firewall
+func (c *Config) LockApply() {
+	c.applyMu.Lock()
+}
+
+func (c *Config) UnlockApply() {
+	c.applyMu.Unlock()
+}
+
+func (c *Config) CleanUp(fw *internalservice.FirewallService) {
+	// The apply lock is never released, nothing may be applied after cleanup
+	c.LockApply()
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	for _, v := range c.Interfaces {
-		if v.Enabled {
-			if err := utils.CleanupWireGuardInterface(v.Ifname); err != nil {
+		if !v.Enabled {
+			continue
+		}
+		if c.WireGuardBackend == WireGuardBackendNetlink {
+			if err := utils.DeleteWireGuardLink(v.Ifname); err != nil {
 				logging.LogError("Exit cleanup: %v", err)
 			}
+		} else if err := utils.CleanupWireGuardInterface(v.Ifname); err != nil {
+			logging.LogError("Exit cleanup: %v", err)
 		}
 	}
-	if err := utils.CleanupRules(c.WGPanelId, 46, nil, true); err != nil {
+	if err := fw.RemoveAllRules(); err != nil {
 		logging.LogError("Warning: failed to cleanup orphaned rules: %v", err)
 	}
 }
@@ -368,10 +662,22 @@ func (c *Config) CheckSnatOffsetOverlapsInRoamingMasterInterface(ifname string,
 
 func (c *Config) SyncToInternalService() {
 	c.mu.RLock()
-	srsConfig := make(map[string]map[string]*models.ServerNetworkConfig)
-	srsVrfmapss := make(map[string]map[string]*string)
-	pbsConfig := make(map[string]internalservice.ResponderNetworks)
-	for _, iface := range c.GetAllInterfaces() {
+	pbsConfig, srsConfig, srsVrfmapss := internalServiceConfigs(c.GetAllInterfaces())
+	c.mu.RUnlock()
+	c.pbs.UpdateConfiguration(pbsConfig)
+	c.srs.UpdateConfiguration(srsConfig, srsVrfmapss)
+}
+
+// internalServiceConfigs builds the pseudo-bridge and SNAT roaming configurations needed by interfaces
+func internalServiceConfigs(interfaces map[string]*models.Interface) (
+	pbsConfig map[string]internalservice.ResponderNetworks,
+	srsConfig map[string]map[string]*models.ServerNetworkConfig,
+	srsVrfmapss map[string]map[string]*string,
+) {
+	srsConfig = make(map[string]map[string]*models.ServerNetworkConfig)
+	srsVrfmapss = make(map[string]map[string]*string)
+	pbsConfig = make(map[string]internalservice.ResponderNetworks)
+	for _, iface := range interfaces {
 		// Check for network overlaps among child servers
 		if !iface.Enabled {
 			continue
@@ -459,9 +765,44 @@ func (c *Config) SyncToInternalService() {
 			}
 		}
 	}
-	c.mu.RUnlock()
-	c.pbs.UpdateConfiguration(pbsConfig)
-	c.srs.UpdateConfiguration(srsConfig, srsVrfmapss)
+	return
+}
+
+// InternalServiceEntries describes the pseudo-bridge responders and SNAT roaming listeners
+// needed by interfaces, one sorted line per network, address or server network
+func InternalServiceEntries(interfaces map[string]*models.Interface) (pbsEntries []string, srsEntries []string) {
+	pbsConfig, srsConfig, _ := internalServiceConfigs(interfaces)
+	pbsEntries, srsEntries = []string{}, []string{}
+	for ifname, rn := range pbsConfig {
+		for _, network := range rn.V4Networks {
+			pbsEntries = append(pbsEntries, fmt.Sprintf("%s: respond for %s", ifname, network.String()))
+		}
+		for _, network := range rn.V6Networks {
+			pbsEntries = append(pbsEntries, fmt.Sprintf("%s: respond for %s", ifname, network.String()))
+		}
+		for _, network := range append(rn.V4Offsets, rn.V6Offsets...) {
+			pbsEntries = append(pbsEntries, fmt.Sprintf("%s: respond for offset %s", ifname, network.String()))
+		}
+		for _, ip := range append(rn.V4Skipped, rn.V6Skipped...) {
+			pbsEntries = append(pbsEntries, fmt.Sprintf("%s: skip %s", ifname, ip.String()))
+		}
+	}
+	for ifname, networks := range srsConfig {
+		srsEntries = append(srsEntries, fmt.Sprintf("%s: watch addresses", ifname))
+		for comment, network := range networks {
+			source, snat := "", ""
+			if network.Network != nil {
+				source = network.Network.String()
+			}
+			if network.Snat != nil && network.Snat.SnatIPNet != nil {
+				snat = network.Snat.SnatIPNet.String()
+			}
+			srsEntries = append(srsEntries, fmt.Sprintf("%s: SNAT %s offset %s (%s)", ifname, source, snat, comment))
+		}
+	}
+	sort.Strings(pbsEntries)
+	sort.Strings(srsEntries)
+	return
 }
 
 func addSrsConf(srsConfig map[string]map[string]*models.ServerNetworkConfig, srsVrfmapss map[string]map[string]*string, ifname string, network *models.ServerNetworkConfig, vrf *string) {
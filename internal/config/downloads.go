@@ -0,0 +1,123 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DownloadLink is a pending single-use download of the configuration of a client.
+// The URL of the download is signed with the download key of the panel, and the
+// link is deleted by the first download.
+type DownloadLink struct {
+	ID          string    `json:"id"`
+	InterfaceID string    `json:"interfaceId"`
+	ServerID    string    `json:"serverId"`
+	ClientID    string    `json:"clientId"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// SignDownloadLink returns the signature of the URL of a link
+func (c *Config) SignDownloadLink(link *DownloadLink) (string, error) {
+	c.mu.RLock()
+	key := c.DownloadKey
+	c.mu.RUnlock()
+	if key == "" {
+		return "", fmt.Errorf("download key not configured")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%d", link.ID, link.InterfaceID, link.ServerID, link.ClientID, link.ExpiresAt.Unix())
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// TakeDownloadLink checks the expiry and signature of a download URL and deletes
+// its link, so the URL only works once
+func (c *Config) TakeDownloadLink(id string, expires int64, signature string) (*DownloadLink, error) {
+	link, err := c.GetDownloadLink(id)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := c.SignDownloadLink(link)
+	if err != nil {
+		return nil, err
+	}
+	if expires != link.ExpiresAt.Unix() || !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another download may have taken the link meanwhile
+	if _, ok := c.DownloadLinks[id]; !ok {
+		return nil, fmt.Errorf("download link not found")
+	}
+	delete(c.DownloadLinks, id)
+	if time.Now().After(link.ExpiresAt) {
+		return nil, fmt.Errorf("download link expired")
+	}
+	return link, nil
+}
+
+// ListDownloadLinks returns the pending links of a client sorted by creation time
+func (c *Config) ListDownloadLinks(interfaceID, serverID, clientID string) []*DownloadLink {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	links := make([]*DownloadLink, 0)
+	for _, link := range c.DownloadLinks {
+		if link.InterfaceID != interfaceID || link.ServerID != serverID || link.ClientID != clientID {
+			continue
+		}
+		result := *link
+		links = append(links, &result)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links
+}
+
+// GetDownloadLink returns a link by ID
+func (c *Config) GetDownloadLink(id string) (*DownloadLink, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	link, ok := c.DownloadLinks[id]
+	if !ok {
+		return nil, fmt.Errorf("download link not found")
+	}
+	result := *link
+	return &result, nil
+}
+
+// AddDownloadLink stores a new link, and drops the expired ones
+func (c *Config) AddDownloadLink(link *DownloadLink) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.DownloadLinks[link.ID]; ok {
+		return fmt.Errorf("download link already exists")
+	}
+	now := time.Now()
+	for id, pending := range c.DownloadLinks {
+		if now.After(pending.ExpiresAt) {
+			delete(c.DownloadLinks, id)
+		}
+	}
+	c.DownloadLinks[link.ID] = link
+	return nil
+}
+
+// DeleteDownloadLink revokes a link
+func (c *Config) DeleteDownloadLink(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.DownloadLinks[id]; !ok {
+		return fmt.Errorf("download link not found")
+	}
+	delete(c.DownloadLinks, id)
+	return nil
+}
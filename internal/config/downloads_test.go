@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownloadLinkSingleUse(t *testing.T) {
+	cfg := &Config{DownloadKey: "key", DownloadLinks: map[string]*DownloadLink{}}
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	link := &DownloadLink{ID: "link", InterfaceID: "if", ServerID: "s", ClientID: "c", ExpiresAt: expiresAt}
+	signature, err := cfg.SignDownloadLink(link)
+	if err != nil {
+		t.Fatalf("SignDownloadLink() error = %v", err)
+	}
+	if err := cfg.AddDownloadLink(link); err != nil {
+		t.Fatalf("AddDownloadLink() error = %v", err)
+	}
+
+	// A changed expiry or signature is refused without using up the link
+	if _, err := cfg.TakeDownloadLink("link", expiresAt.Add(time.Hour).Unix(), signature); err == nil || err.Error() != "invalid signature" {
+		t.Errorf("Expected invalid signature for a changed expiry, got %v", err)
+	}
+	if _, err := cfg.TakeDownloadLink("link", expiresAt.Unix(), "x"+signature[1:]); err == nil || err.Error() != "invalid signature" {
+		t.Errorf("Expected invalid signature for a changed signature, got %v", err)
+	}
+
+	taken, err := cfg.TakeDownloadLink("link", expiresAt.Unix(), signature)
+	if err != nil || taken.ClientID != "c" {
+		t.Fatalf("TakeDownloadLink() = %v, %v", taken, err)
+	}
+	if _, err := cfg.TakeDownloadLink("link", expiresAt.Unix(), signature); err == nil || err.Error() != "download link not found" {
+		t.Errorf("Expected a used link to be refused, got %v", err)
+	}
+}
+
+func TestDownloadLinkExpiry(t *testing.T) {
+	cfg := &Config{DownloadKey: "key", DownloadLinks: map[string]*DownloadLink{}}
+	expired := &DownloadLink{ID: "old", ClientID: "c", ExpiresAt: time.Now().Add(-time.Minute).Truncate(time.Second)}
+	signature, _ := cfg.SignDownloadLink(expired)
+	cfg.DownloadLinks[expired.ID] = expired
+
+	if _, err := cfg.TakeDownloadLink("old", expired.ExpiresAt.Unix(), signature); err == nil || err.Error() != "download link expired" {
+		t.Errorf("Expected download link expired, got %v", err)
+	}
+	if len(cfg.DownloadLinks) != 0 {
+		t.Errorf("The expired link should be deleted")
+	}
+
+	// Adding a link drops the expired ones
+	cfg.DownloadLinks[expired.ID] = expired
+	cfg.AddDownloadLink(&DownloadLink{ID: "new", ClientID: "c", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, err := cfg.GetDownloadLink("old"); err == nil {
+		t.Errorf("The expired link should be dropped when adding a link")
+	}
+}
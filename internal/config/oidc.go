@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// OIDCConfig enables single sign-on with an OpenID Connect provider, next to the local login
+type OIDCConfig struct {
+	Enabled       bool              `json:"enabled"`
+	DisplayName   string            `json:"displayName"` // Shown on the login button
+	IssuerURL     string            `json:"issuerUrl"`
+	ClientID      string            `json:"clientId"`
+	ClientSecret  string            `json:"clientSecret"` // Empty for a public client
+	RedirectURL   string            `json:"redirectUrl"`  // <panel URL><apiPrefix>/service/oidc/callback
+	Scopes        []string          `json:"scopes"`       // Requested besides openid
+	UsernameClaim string            `json:"usernameClaim"`
+	GroupsClaim   string            `json:"groupsClaim"`
+	RoleMappings  []OIDCRoleMapping `json:"roleMappings"`
+	AutoProvision bool              `json:"autoProvision"` // Create the users on their first login
+}
+
+// OIDCRoleMapping grants a role to the members of a group of the provider,
+// globally or on one interface or server like a role binding
+type OIDCRoleMapping struct {
+	Group       string `json:"group"`
+	Role        string `json:"role"`
+	InterfaceID string `json:"interfaceId,omitempty"`
+	ServerID    string `json:"serverId,omitempty"`
+}
+
+// MapGroups returns the roles granted to the members of groups
+func (o OIDCConfig) MapGroups(groups []string) Access {
+	var access Access
+	for _, mapping := range o.RoleMappings {
+		member := false
+		for _, group := range groups {
+			if group == mapping.Group {
+				member = true
+				break
+			}
+		}
+		if !member {
+			continue
+		}
+		if mapping.InterfaceID == "" {
+			if RoleLevel(mapping.Role) > RoleLevel(access.Role) {
+				access.Role = mapping.Role
+			}
+			continue
+		}
+		access.Bindings = append(access.Bindings, RoleBinding{Role: mapping.Role, InterfaceID: mapping.InterfaceID, ServerID: mapping.ServerID})
+	}
+	return access
+}
+
+// ProvisionOIDCUser sets the roles of a user signing in with the provider, creating
+// the user if autoProvision is set. The subject of the provider is kept with the user,
+// so a local user or another account of the provider can't be taken over by name.
+// Without autoProvision, an admin links the users beforehand by setting their subject.
+func (c *Config) ProvisionOIDCUser(username, subject string, access Access, autoProvision bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if username == c.User {
+		return fmt.Errorf("user %s is the built-in user, it can only log in with its password", username)
+	}
+	user, ok := c.Users[username]
+	if !ok {
+		if !autoProvision {
+			return fmt.Errorf("user %s is not provisioned", username)
+		}
+		c.Users[username] = &User{CreatedAt: time.Now(), OIDCSubject: subject, Access: access}
+		return nil
+	}
+	if user.OIDCSubject == "" {
+		return fmt.Errorf("user %s is not linked to the provider", username)
+	}
+	if user.OIDCSubject != subject {
+		return fmt.Errorf("user %s belongs to another account", username)
+	}
+	user.Access = access
+	return nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PortalLink lets the end user of a client open the self-service portal of that
+// client only. Only the SHA-256 hash of the token is stored, the token itself is
+// shown once when the link is created.
+type PortalLink struct {
+	ID          string     `json:"id"`
+	Hash        string     `json:"hash,omitempty"`
+	InterfaceID string     `json:"interfaceId"`
+	ServerID    string     `json:"serverId"`
+	ClientID    string     `json:"clientId"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	OneTime     bool       `json:"oneTime"`              // The token is replaced by a portal session on first use
+	RedeemedAt  *time.Time `json:"redeemedAt,omitempty"` // When a one-time link was used
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// Expired tells if the link can't be used anymore
+func (l *PortalLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && now.After(*l.ExpiresAt)
+}
+
+// findPortalLink returns the link of a token, the caller holds c.mu
+func (c *Config) findPortalLink(token string) *PortalLink {
+	hash := HashAPIToken(token)
+	for _, link := range c.PortalLinks {
+		if subtle.ConstantTimeCompare([]byte(link.Hash), []byte(hash)) == 1 {
+			return link
+		}
+	}
+	return nil
+}
+
+// RedeemPortalLink opens a portal session with a link token and returns the token
+// of the session. The token of a one-time link is replaced by replacement, valid
+// for sessionTTL at most, so the link itself stops working.
+func (c *Config) RedeemPortalLink(token, replacement string, sessionTTL time.Duration) (*PortalLink, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	link := c.findPortalLink(token)
+	if link == nil {
+		return nil, "", fmt.Errorf("portal link not found")
+	}
+	now := time.Now()
+	if link.Expired(now) {
+		return nil, "", fmt.Errorf("portal link expired")
+	}
+	link.LastUsedAt = &now
+	if link.OneTime && link.RedeemedAt == nil {
+		expiresAt := now.Add(sessionTTL)
+		if link.ExpiresAt == nil || expiresAt.Before(*link.ExpiresAt) {
+			link.ExpiresAt = &expiresAt
+		}
+		link.Hash = HashAPIToken(replacement)
+		link.RedeemedAt = &now
+		token = replacement
+	}
+	result := *link
+	return &result, token, nil
+}
+
+// UsePortalLink finds the link of a portal session, checks it isn't expired and
+// records its use. The returned flag is set when the previous use is old enough
+// to be worth saving.
+func (c *Config) UsePortalLink(token string) (*PortalLink, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	link := c.findPortalLink(token)
+	if link == nil {
+		return nil, false, fmt.Errorf("portal link not found")
+	}
+	if link.OneTime && link.RedeemedAt == nil {
+		// Only RedeemPortalLink may use a one-time token
+		return nil, false, fmt.Errorf("portal link not redeemed")
+	}
+	now := time.Now()
+	if link.Expired(now) {
+		return nil, false, fmt.Errorf("portal link expired")
+	}
+	stale := link.LastUsedAt == nil || now.Sub(*link.LastUsedAt) > time.Minute
+	link.LastUsedAt = &now
+	result := *link
+	return &result, stale, nil
+}
+
+// ListPortalLinks returns the links of a client sorted by creation time and without their hash
+func (c *Config) ListPortalLinks(interfaceID, serverID, clientID string) []*PortalLink {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	links := make([]*PortalLink, 0)
+	for _, link := range c.PortalLinks {
+		if link.InterfaceID != interfaceID || link.ServerID != serverID || link.ClientID != clientID {
+			continue
+		}
+		result := *link
+		result.Hash = ""
+		links = append(links, &result)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links
+}
+
+// GetPortalLink returns a link by ID
+func (c *Config) GetPortalLink(id string) (*PortalLink, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	link, ok := c.PortalLinks[id]
+	if !ok {
+		return nil, fmt.Errorf("portal link not found")
+	}
+	result := *link
+	return &result, nil
+}
+
+// AddPortalLink stores a new link
+func (c *Config) AddPortalLink(link *PortalLink) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.PortalLinks[link.ID]; ok {
+		return fmt.Errorf("portal link already exists")
+	}
+	c.PortalLinks[link.ID] = link
+	return nil
+}
+
+// DeletePortalLink revokes a link
+func (c *Config) DeletePortalLink(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.PortalLinks[id]; !ok {
+		return fmt.Errorf("portal link not found")
+	}
+	delete(c.PortalLinks, id)
+	return nil
+}
+
+// DeleteClientPortalLinks revokes every link of a client and returns how many there were
+func (c *Config) DeleteClientPortalLinks(interfaceID, serverID, clientID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleted := 0
+	for id, link := range c.PortalLinks {
+		if link.InterfaceID == interfaceID && link.ServerID == serverID && link.ClientID == clientID {
+			delete(c.PortalLinks, id)
+			deleted++
+		}
+	}
+	return deleted
+}
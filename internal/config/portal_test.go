@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOneTimePortalLink(t *testing.T) {
+	cfg := &Config{PortalLinks: map[string]*PortalLink{}}
+	if err := cfg.AddPortalLink(&PortalLink{ID: "link", Hash: HashAPIToken("wgp_link"), ClientID: "c1", OneTime: true}); err != nil {
+		t.Fatalf("AddPortalLink() error = %v", err)
+	}
+
+	// The link token only opens a session, it can't be used as one
+	if _, _, err := cfg.UsePortalLink("wgp_link"); err == nil {
+		t.Errorf("An unredeemed one-time link should not be usable as a session")
+	}
+
+	link, session, err := cfg.RedeemPortalLink("wgp_link", "session", time.Hour)
+	if err != nil {
+		t.Fatalf("RedeemPortalLink() error = %v", err)
+	}
+	if session != "session" || link.RedeemedAt == nil {
+		t.Fatalf("Expected the link to be replaced by the session, got %q", session)
+	}
+	if link.ExpiresAt == nil || time.Until(*link.ExpiresAt) > time.Hour {
+		t.Errorf("Expected the session to expire within an hour, got %v", link.ExpiresAt)
+	}
+
+	if _, _, err := cfg.RedeemPortalLink("wgp_link", "other", time.Hour); err == nil {
+		t.Errorf("A one-time link should be refused the second time")
+	}
+	if used, _, err := cfg.UsePortalLink("session"); err != nil || used.ClientID != "c1" {
+		t.Errorf("UsePortalLink() = %v, %v", used, err)
+	}
+}
+
+func TestReusablePortalLink(t *testing.T) {
+	cfg := &Config{PortalLinks: map[string]*PortalLink{}}
+	past := time.Now().Add(-time.Minute)
+	cfg.AddPortalLink(&PortalLink{ID: "keep", Hash: HashAPIToken("wgp_keep"), ClientID: "c1"})
+	cfg.AddPortalLink(&PortalLink{ID: "old", Hash: HashAPIToken("wgp_old"), ClientID: "c2", ExpiresAt: &past})
+
+	for i := 0; i < 2; i++ {
+		_, session, err := cfg.RedeemPortalLink("wgp_keep", "unused", time.Hour)
+		if err != nil || session != "wgp_keep" {
+			t.Fatalf("RedeemPortalLink() = %q, %v", session, err)
+		}
+	}
+	if _, _, err := cfg.RedeemPortalLink("wgp_old", "unused", time.Hour); err == nil || err.Error() != "portal link expired" {
+		t.Errorf("Expected portal link expired error, got %v", err)
+	}
+
+	if deleted := cfg.DeleteClientPortalLinks("", "", "c1"); deleted != 1 {
+		t.Errorf("DeleteClientPortalLinks() = %d, want 1", deleted)
+	}
+	if _, _, err := cfg.UsePortalLink("wgp_keep"); err == nil {
+		t.Errorf("The link of a deleted client should be refused")
+	}
+}
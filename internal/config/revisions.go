@@ -0,0 +1,313 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// Revision is a saved version of the interfaces, with their servers and clients
+type Revision struct {
+	Number     int             `json:"number"`
+	Time       time.Time       `json:"time"`
+	Author     string          `json:"author"`
+	Message    string          `json:"message"`
+	Interfaces json.RawMessage `json:"interfaces,omitempty"`
+}
+
+// revisionAuthorSystem is the author of the revisions saved outside of API requests
+const revisionAuthorSystem = "system"
+
+// BeginRevision sets the author and message of the revisions saved until EndRevision.
+// Callers hold the apply lock, so the saves of other requests can't be attributed to them.
+func (c *Config) BeginRevision(author, message string) {
+	c.revMu.Lock()
+	defer c.revMu.Unlock()
+	c.revAuthor = author
+	c.revMessage = message
+}
+
+// EndRevision resets the author and message of the saved revisions
+func (c *Config) EndRevision() {
+	c.BeginRevision("", "")
+}
+
+// RecordStartupRevision records the loaded interfaces if they differ from the last revision
+func (c *Config) RecordStartupRevision() error {
+	c.mu.RLock()
+	data, err := json.Marshal(c.secrets.sealInterfaces(c.Interfaces))
+	c.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal interfaces:-> %v", err)
+	}
+
+	c.BeginRevision(revisionAuthorSystem, "Startup")
+	defer c.EndRevision()
+	return c.recordRevision(data)
+}
+
+// recordRevision saves the interfaces as a new revision if they changed since the last one
+func (c *Config) recordRevision(interfaces []byte) error {
+	c.revMu.Lock()
+	defer c.revMu.Unlock()
+
+	if c.revLast == nil {
+		numbers, err := c.revisionNumbers()
+		if err != nil {
+			return err
+		}
+		c.revLast = &Revision{}
+		if len(numbers) > 0 {
+			last, err := c.readRevision(numbers[len(numbers)-1])
+			if err != nil {
+				return err
+			}
+			c.revLast = last
+		}
+	}
+	if bytes.Equal(interfaces, c.revLast.Interfaces) {
+		return nil
+	}
+
+	revision := &Revision{
+		Number:     c.revLast.Number + 1,
+		Time:       time.Now(),
+		Author:     c.revAuthor,
+		Message:    c.revMessage,
+		Interfaces: interfaces,
+	}
+	if revision.Author == "" {
+		revision.Author = revisionAuthorSystem
+	}
+	data, err := json.MarshalIndent(revision, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal revision:-> %v", err)
+	}
+	if err := os.MkdirAll(c.RevisionsPath, 0700); err != nil {
+		return fmt.Errorf("failed to create revisions directory:-> %v", err)
+	}
+	if err := utils.WriteFileAtomic(c.revisionFile(revision.Number), data, 0600); err != nil {
+		return fmt.Errorf("failed to write revision %d:-> %v", revision.Number, err)
+	}
+	c.revLast = revision
+	logging.LogVerbose("Saved configuration revision %d by %s", revision.Number, revision.Author)
+
+	// Drop the oldest revisions beyond the limit
+	if c.RevisionsKeep > 0 {
+		numbers, err := c.revisionNumbers()
+		if err != nil {
+			return err
+		}
+		for len(numbers) > c.RevisionsKeep {
+			if err := os.Remove(c.revisionFile(numbers[0])); err != nil {
+				return fmt.Errorf("failed to remove revision %d:-> %v", numbers[0], err)
+			}
+			numbers = numbers[1:]
+		}
+	}
+	return nil
+}
+
+func (c *Config) revisionFile(number int) string {
+	return filepath.Join(c.RevisionsPath, fmt.Sprintf("%06d.json", number))
+}
+
+// revisionNumbers returns the numbers of the saved revisions in increasing order
+func (c *Config) revisionNumbers() ([]int, error) {
+	entries, err := os.ReadDir(c.RevisionsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revisions directory:-> %v", err)
+	}
+	var numbers []int
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if number, err := strconv.Atoi(name); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (c *Config) readRevision(number int) (*Revision, error) {
+	data, err := os.ReadFile(c.revisionFile(number))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("revision not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revision %d:-> %v", number, err)
+	}
+	var revision Revision
+	if err := json.Unmarshal(data, &revision); err != nil {
+		return nil, fmt.Errorf("failed to parse revision %d:-> %v", number, err)
+	}
+	// Compact the interfaces so they compare equal to json.Marshal output
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, revision.Interfaces); err != nil {
+		return nil, fmt.Errorf("failed to parse revision %d:-> %v", number, err)
+	}
+	revision.Interfaces = compacted.Bytes()
+	return &revision, nil
+}
+
+// ListRevisions returns the saved revisions without their interfaces, newest first
+func (c *Config) ListRevisions() ([]*Revision, error) {
+	c.revMu.Lock()
+	defer c.revMu.Unlock()
+
+	numbers, err := c.revisionNumbers()
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]*Revision, 0, len(numbers))
+	for i := len(numbers) - 1; i >= 0; i-- {
+		revision, err := c.readRevision(numbers[i])
+		if err != nil {
+			return nil, err
+		}
+		revision.Interfaces = nil
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a saved revision with its interfaces, their secrets decrypted
+func (c *Config) GetRevision(number int) (*Revision, error) {
+	c.revMu.Lock()
+	defer c.revMu.Unlock()
+	revision, err := c.readRevision(number)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.openRevision(revision); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// DecodeInterfaces returns the interfaces saved in the revision
+func (r *Revision) DecodeInterfaces() (map[string]*models.Interface, error) {
+	interfaces := make(map[string]*models.Interface)
+	if err := json.Unmarshal(r.Interfaces, &interfaces); err != nil {
+		return nil, fmt.Errorf("failed to parse interfaces of revision %d:-> %v", r.Number, err)
+	}
+	return interfaces, nil
+}
+
+// ReplaceInterfaces replaces all the interfaces, for rollbacks
+func (c *Config) ReplaceInterfaces(interfaces map[string]*models.Interface) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interfaces = interfaces
+}
+
+// ScrubPrivateKeys removes the private keys of the clients with the given IDs from
+// the saved revisions and from the configuration file kept by the SQLite migration,
+// so keys purged from the configuration don't come back with a rollback
+func (c *Config) ScrubPrivateKeys(clientIDs map[string]bool) error {
+	c.revMu.Lock()
+	defer c.revMu.Unlock()
+
+	numbers, err := c.revisionNumbers()
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		revision, err := c.readRevision(number)
+		if err != nil {
+			return err
+		}
+		interfaces, err := revision.DecodeInterfaces()
+		if err != nil {
+			return err
+		}
+		if !scrubInterfaces(interfaces, clientIDs) {
+			continue
+		}
+		if revision.Interfaces, err = json.Marshal(interfaces); err != nil {
+			return fmt.Errorf("failed to marshal revision %d:-> %v", number, err)
+		}
+		data, err := json.MarshalIndent(revision, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal revision %d:-> %v", number, err)
+		}
+		if err := utils.WriteFileAtomic(c.revisionFile(number), data, 0600); err != nil {
+			return fmt.Errorf("failed to write revision %d:-> %v", number, err)
+		}
+		logging.LogVerbose("Removed purged private keys from revision %d", number)
+	}
+	// The last revision is compared with the next saves
+	c.revLast = nil
+
+	return scrubBackup(preSQLiteBackup(c.ConfigPath), clientIDs)
+}
+
+// scrubInterfaces removes the private keys of the clients with the given IDs, and
+// tells if there was any
+func scrubInterfaces(interfaces map[string]*models.Interface, clientIDs map[string]bool) bool {
+	scrubbed := false
+	for _, iface := range interfaces {
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				if clientIDs[client.ID] && client.PrivateKey != nil {
+					client.PrivateKey = nil
+					scrubbed = true
+				}
+			}
+		}
+	}
+	return scrubbed
+}
+
+// scrubBackup removes the private keys of the clients with the given IDs from a
+// backup of the configuration file, keeping its other fields as they are
+func scrubBackup(path string, clientIDs map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s:-> %v", path, err)
+	}
+	var backup map[string]json.RawMessage
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return fmt.Errorf("failed to parse %s:-> %v", path, err)
+	}
+	if len(backup["interfaces"]) == 0 {
+		return nil
+	}
+	interfaces := make(map[string]*models.Interface)
+	if err := json.Unmarshal(backup["interfaces"], &interfaces); err != nil {
+		return fmt.Errorf("failed to parse interfaces of %s:-> %v", path, err)
+	}
+	if !scrubInterfaces(interfaces, clientIDs) {
+		return nil
+	}
+	if backup["interfaces"], err = json.Marshal(interfaces); err != nil {
+		return fmt.Errorf("failed to marshal interfaces of %s:-> %v", path, err)
+	}
+	if data, err = json.MarshalIndent(backup, "", "  "); err != nil {
+		return fmt.Errorf("failed to marshal %s:-> %v", path, err)
+	}
+	if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s:-> %v", path, err)
+	}
+	logging.LogVerbose("Removed purged private keys from %s", path)
+	return nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/models"
+)
+
+func TestRecordRevision(t *testing.T) {
+	cfg := &Config{RevisionsPath: t.TempDir(), RevisionsKeep: 2}
+	record := func(interfaces map[string]*models.Interface) {
+		data, err := json.Marshal(interfaces)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if err := cfg.recordRevision(data); err != nil {
+			t.Fatalf("recordRevision() error = %v", err)
+		}
+	}
+
+	cfg.BeginRevision("alice", "first")
+	record(map[string]*models.Interface{"a": {ID: "a", Ifname: "wg-a"}})
+	cfg.EndRevision()
+	// Unchanged interfaces don't make a revision
+	record(map[string]*models.Interface{"a": {ID: "a", Ifname: "wg-a"}})
+	record(map[string]*models.Interface{"a": {ID: "a", Ifname: "wg-b"}})
+	record(map[string]*models.Interface{})
+
+	revisions, err := cfg.ListRevisions()
+	if err != nil {
+		t.Fatalf("ListRevisions() error = %v", err)
+	}
+	// Only the 2 newest of the 3 revisions are kept
+	if len(revisions) != 2 || revisions[0].Number != 3 || revisions[1].Number != 2 {
+		t.Fatalf("Expected revisions 3 and 2, got %+v", revisions)
+	}
+	if revisions[0].Author != revisionAuthorSystem || revisions[0].Interfaces != nil {
+		t.Errorf("Unexpected listed revision %+v", revisions[0])
+	}
+
+	revision, err := cfg.GetRevision(2)
+	if err != nil {
+		t.Fatalf("GetRevision() error = %v", err)
+	}
+	interfaces, err := revision.DecodeInterfaces()
+	if err != nil || interfaces["a"] == nil || interfaces["a"].Ifname != "wg-b" {
+		t.Errorf("Unexpected interfaces of revision 2: %v, %v", interfaces, err)
+	}
+	if _, err := cfg.GetRevision(1); err == nil || err.Error() != "revision not found" {
+		t.Errorf("Expected pruned revision 1 to be missing, got %v", err)
+	}
+
+	// A fresh config continues the numbering of the saved revisions
+	reloaded := &Config{RevisionsPath: cfg.RevisionsPath}
+	if err := reloaded.recordRevision([]byte(`{}`)); err != nil {
+		t.Fatalf("recordRevision() error = %v", err)
+	}
+	if revisions, _ := reloaded.ListRevisions(); len(revisions) != 2 {
+		t.Errorf("Expected no new revision for unchanged interfaces, got %d revisions", len(revisions))
+	}
+}
+
+func TestScrubPrivateKeys(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{RevisionsPath: filepath.Join(dir, "revisions"), ConfigPath: filepath.Join(dir, "config.json")}
+	key1, key2 := "private-1", "private-2"
+	interfaces := map[string]*models.Interface{"a": {ID: "a", Ifname: "wg-a", Servers: []*models.Server{
+		{ID: "s1", Clients: []*models.Client{{ID: "c1", PrivateKey: &key1}, {ID: "c2", PrivateKey: &key2}}},
+	}}}
+	data, _ := json.Marshal(interfaces)
+	if err := cfg.recordRevision(data); err != nil {
+		t.Fatalf("recordRevision() error = %v", err)
+	}
+	backup, _ := json.Marshal(map[string]interface{}{"listenPort": 5000, "interfaces": interfaces})
+	if err := os.WriteFile(preSQLiteBackup(cfg.ConfigPath), backup, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.ScrubPrivateKeys(map[string]bool{"c1": true}); err != nil {
+		t.Fatalf("ScrubPrivateKeys() error = %v", err)
+	}
+	revision, err := cfg.GetRevision(1)
+	if err != nil {
+		t.Fatalf("GetRevision() error = %v", err)
+	}
+	for name, data := range map[string][]byte{"revision": revision.Interfaces, "backup": readFile(t, preSQLiteBackup(cfg.ConfigPath))} {
+		if strings.Contains(string(data), key1) || !strings.Contains(string(data), key2) {
+			t.Errorf("Expected only the key of c1 to be removed from the %s: %s", name, data)
+		}
+	}
+	if !strings.Contains(string(readFile(t, preSQLiteBackup(cfg.ConfigPath))), `"listenPort": 5000`) {
+		t.Error("The other settings of the backup should be kept")
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,576 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// SecretKeyEnv is the environment variable holding the secret key, it takes
+// precedence over the secretKeyFile option
+const SecretKeyEnv = "WG_PANEL_SECRET_KEY"
+
+// secretPrefix marks the values encrypted with the secret key
+const secretPrefix = "enc:"
+
+// previousKeySuffix is appended to the secret key file for the file keeping the
+// previous key while a rotation is not finished
+const previousKeySuffix = ".previous"
+
+// secretBox encrypts the private keys, preshared keys, session tokens, TOTP secrets,
+// download key, client secret of the provider and webhook secrets saved in the
+// configuration, the revisions and the database. The encryption is AES-256-GCM
+// with a nonce derived from the value, so a value always has the same ciphertext
+// and unchanged secrets don't show up as changes.
+type secretBox struct {
+	key      []byte
+	aead     cipher.AEAD
+	nonceKey []byte
+	previous *secretBox // Opens the secrets not yet encrypted with the key during a rotation
+}
+
+func newSecretBox(key []byte) (*secretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key must be 32 bytes, got %d", len(key))
+	}
+	// Separate keys for the encryption and the nonces
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("wg-panel secret encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{key: key, aead: aead, nonceKey: derive("wg-panel secret nonce")}, nil
+}
+
+// GenerateSecretKey returns a new random secret key, hex encoded
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate secret key:-> %v", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// ParseSecretKey decodes a hex encoded secret key
+func ParseSecretKey(value string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secret key must be 64 hex characters")
+	}
+	return key, nil
+}
+
+// secretKeyPath returns the path of the key file, empty if none is set. A relative
+// key file is in the directory of the configuration.
+func (c *Config) secretKeyPath() string {
+	if c.SecretKeyFile == "" || filepath.IsAbs(c.SecretKeyFile) {
+		return c.SecretKeyFile
+	}
+	return filepath.Join(filepath.Dir(c.ConfigPath), c.SecretKeyFile)
+}
+
+// readSecretKey returns the secret key of the environment or of the key file, or
+// nil when neither is set
+func (c *Config) readSecretKey() ([]byte, error) {
+	if value := os.Getenv(SecretKeyEnv); value != "" {
+		key, err := ParseSecretKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s:-> %v", SecretKeyEnv, err)
+		}
+		return key, nil
+	}
+	if c.SecretKeyFile == "" {
+		return nil, nil
+	}
+	path := c.secretKeyPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret key file:-> %v", err)
+	}
+	key, err := ParseSecretKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key file %s:-> %v", path, err)
+	}
+	return key, nil
+}
+
+// readPreviousSecretKey returns the key kept by an unfinished rotation, nil if there is none
+func (c *Config) readPreviousSecretKey() ([]byte, error) {
+	if c.SecretKeyFile == "" {
+		return nil, nil
+	}
+	path := c.secretKeyPath() + previousKeySuffix
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read previous secret key file:-> %v", err)
+	}
+	key, err := ParseSecretKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid previous secret key file %s:-> %v", path, err)
+	}
+	return key, nil
+}
+
+// seal encrypts a value, it is returned as is without a secret key
+func (b *secretBox) seal(value string) string {
+	if b == nil || value == "" {
+		return value
+	}
+	mac := hmac.New(sha256.New, b.nonceKey)
+	mac.Write([]byte(value))
+	nonce := mac.Sum(nil)[:b.aead.NonceSize()]
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return secretPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// open decrypts a sealed value, plaintext values are returned as is
+func (b *secretBox) open(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, secretPrefix)
+	if !ok {
+		return value, nil
+	}
+	if b == nil {
+		return "", fmt.Errorf("encrypted secret found but no secret key is set, use %s or secretKeyFile", SecretKeyEnv)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	nonce := sealed[:b.aead.NonceSize()]
+	plain, err := b.aead.Open(nil, nonce, sealed[b.aead.NonceSize():], nil)
+	if err != nil {
+		if b.previous != nil {
+			return b.previous.open(value)
+		}
+		return "", fmt.Errorf("failed to decrypt secret, wrong secret key?")
+	}
+	return string(plain), nil
+}
+
+// openValue decrypts a secret in place, and tells if it was stored in plaintext
+func (b *secretBox) openValue(value *string) (bool, error) {
+	if *value == "" {
+		return false, nil
+	}
+	if !strings.HasPrefix(*value, secretPrefix) {
+		return true, nil
+	}
+	opened, err := b.open(*value)
+	if err != nil {
+		return false, err
+	}
+	*value = opened
+	return false, nil
+}
+
+func (b *secretBox) sealPtr(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	sealed := b.seal(*value)
+	return &sealed
+}
+
+// sealInterfaces returns copies of the interfaces with their secrets encrypted,
+// the interfaces themselves without a secret key
+func (b *secretBox) sealInterfaces(interfaces map[string]*models.Interface) map[string]*models.Interface {
+	if b == nil {
+		return interfaces
+	}
+	result := make(map[string]*models.Interface, len(interfaces))
+	for id, iface := range interfaces {
+		ifaceCopy := *iface
+		ifaceCopy.PrivateKey = b.seal(iface.PrivateKey)
+		ifaceCopy.Servers = make([]*models.Server, len(iface.Servers))
+		for i, server := range iface.Servers {
+			serverCopy := *server
+			serverCopy.Clients = make([]*models.Client, len(server.Clients))
+			for j, client := range server.Clients {
+				clientCopy := *client
+				clientCopy.PrivateKey = b.sealPtr(client.PrivateKey)
+				clientCopy.PresharedKey = b.sealPtr(client.PresharedKey)
+				serverCopy.Clients[j] = &clientCopy
+			}
+			ifaceCopy.Servers[i] = &serverCopy
+		}
+		result[id] = &ifaceCopy
+	}
+	return result
+}
+
+// openInterfaces decrypts the secrets of the interfaces in place, and tells if
+// some of them were stored in plaintext
+func (b *secretBox) openInterfaces(interfaces map[string]*models.Interface) (bool, error) {
+	plaintext := false
+	open := func(value *string) error {
+		plain, err := b.openValue(value)
+		plaintext = plaintext || plain
+		return err
+	}
+	for _, iface := range interfaces {
+		if err := open(&iface.PrivateKey); err != nil {
+			return false, fmt.Errorf("interface %s:-> %v", iface.Ifname, err)
+		}
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				for _, value := range []*string{client.PrivateKey, client.PresharedKey} {
+					if value == nil {
+						continue
+					}
+					if err := open(value); err != nil {
+						return false, fmt.Errorf("client %s:-> %v", client.Name, err)
+					}
+				}
+			}
+		}
+	}
+	return plaintext, nil
+}
+
+// sealSessions returns the sessions keyed by their encrypted token
+func (b *secretBox) sealSessions(sessions map[string]*Session) map[string]*Session {
+	if b == nil {
+		return sessions
+	}
+	result := make(map[string]*Session, len(sessions))
+	for token, session := range sessions {
+		result[b.seal(token)] = session
+	}
+	return result
+}
+
+// openSessions returns the sessions keyed by their decrypted token, and tells if
+// some tokens were stored in plaintext
+func (b *secretBox) openSessions(sessions map[string]*Session) (map[string]*Session, bool, error) {
+	plaintext := false
+	result := make(map[string]*Session, len(sessions))
+	for token, session := range sessions {
+		if !strings.HasPrefix(token, secretPrefix) {
+			plaintext = true
+		}
+		opened, err := b.open(token)
+		if err != nil {
+			return nil, false, fmt.Errorf("session:-> %v", err)
+		}
+		result[opened] = session
+	}
+	return result, plaintext, nil
+}
+
+// sealTwoFactor returns a copy of a second factor with its TOTP secret encrypted
+func (b *secretBox) sealTwoFactor(twoFactor *TwoFactor) *TwoFactor {
+	if b == nil || twoFactor == nil {
+		return twoFactor
+	}
+	result := *twoFactor
+	result.Secret = b.seal(twoFactor.Secret)
+	return &result
+}
+
+// sealUsers returns copies of the users with their TOTP secret encrypted
+func (b *secretBox) sealUsers(users map[string]*User) map[string]*User {
+	if b == nil {
+		return users
+	}
+	result := make(map[string]*User, len(users))
+	for username, user := range users {
+		userCopy := *user
+		userCopy.TwoFactor = b.sealTwoFactor(user.TwoFactor)
+		result[username] = &userCopy
+	}
+	return result
+}
+
+// sealWebhooks returns copies of the webhooks with their secret encrypted
+func (b *secretBox) sealWebhooks(webhooks map[string]*Webhook) map[string]*Webhook {
+	if b == nil {
+		return webhooks
+	}
+	result := make(map[string]*Webhook, len(webhooks))
+	for id, webhook := range webhooks {
+		webhookCopy := *webhook
+		webhookCopy.Secret = b.seal(webhook.Secret)
+		result[id] = &webhookCopy
+	}
+	return result
+}
+
+// openSettings decrypts the TOTP secrets, the download key, the client secret of the
+// provider and the webhook secrets in place, and tells if some were stored in plaintext
+func (b *secretBox) openSettings(c *Config) (bool, error) {
+	plaintext := false
+	open := func(name string, value *string) error {
+		plain, err := b.openValue(value)
+		if err != nil {
+			return fmt.Errorf("%s:-> %v", name, err)
+		}
+		plaintext = plaintext || plain
+		return nil
+	}
+	if err := open("download key", &c.DownloadKey); err != nil {
+		return false, err
+	}
+	if err := open("OpenID Connect client secret", &c.OIDC.ClientSecret); err != nil {
+		return false, err
+	}
+	if c.TwoFactor != nil {
+		if err := open("second factor of user "+c.User, &c.TwoFactor.Secret); err != nil {
+			return false, err
+		}
+	}
+	for username, user := range c.Users {
+		if user.TwoFactor == nil {
+			continue
+		}
+		if err := open("second factor of user "+username, &user.TwoFactor.Secret); err != nil {
+			return false, err
+		}
+	}
+	for id, webhook := range c.Webhooks {
+		if err := open("webhook "+id, &webhook.Secret); err != nil {
+			return false, err
+		}
+	}
+	return plaintext, nil
+}
+
+// sealedConfig encodes the configuration with its secrets encrypted, its fields
+// hide the ones of the embedded configuration
+type sealedConfig struct {
+	*plainConfig
+	TwoFactor   *TwoFactor                   `json:"twoFactor,omitempty"`
+	Users       map[string]*User             `json:"users"`
+	DownloadKey string                       `json:"downloadKey"`
+	Interfaces  map[string]*models.Interface `json:"interfaces"`
+	Sessions    map[string]*Session          `json:"sessions"`
+	Webhooks    map[string]*Webhook          `json:"webhooks"`
+	OIDC        OIDCConfig                   `json:"oidc"`
+}
+
+// sealConfig returns the configuration to encode with its secrets encrypted
+func (b *secretBox) sealConfig(cfg *Config) *sealedConfig {
+	oidc := cfg.OIDC
+	oidc.ClientSecret = b.seal(cfg.OIDC.ClientSecret)
+	return &sealedConfig{
+		plainConfig: (*plainConfig)(cfg),
+		TwoFactor:   b.sealTwoFactor(cfg.TwoFactor),
+		Users:       b.sealUsers(cfg.Users),
+		DownloadKey: b.seal(cfg.DownloadKey),
+		Interfaces:  b.sealInterfaces(cfg.Interfaces),
+		Sessions:    b.sealSessions(cfg.Sessions),
+		Webhooks:    b.sealWebhooks(cfg.Webhooks),
+		OIDC:        oidc,
+	}
+}
+
+// sealedSettings encodes the settings with their secrets encrypted, without the
+// models kept by the storage
+type sealedSettings struct {
+	*sealedConfig
+	Interfaces    *struct{} `json:"interfaces,omitempty"`
+	Sessions      *struct{} `json:"sessions,omitempty"`
+	Users         *struct{} `json:"users,omitempty"`
+	APITokens     *struct{} `json:"apiTokens,omitempty"`
+	PortalLinks   *struct{} `json:"portalLinks,omitempty"`
+	DownloadLinks *struct{} `json:"downloadLinks,omitempty"`
+}
+
+// marshalConfig encodes the whole configuration, with its secrets encrypted when
+// a secret key is set
+func marshalConfig(cfg *Config) ([]byte, error) {
+	if cfg.secrets == nil {
+		return json.MarshalIndent(cfg, "", "  ")
+	}
+	return json.MarshalIndent(cfg.secrets.sealConfig(cfg), "", "  ")
+}
+
+// openSecrets decrypts the secrets of the loaded configuration with the secret key,
+// and saves it again if some secrets were still in plaintext
+func (c *Config) openSecrets() error {
+	key, err := c.readSecretKey()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if c.secrets, err = newSecretBox(key); err != nil {
+			return err
+		}
+		previous, err := c.readPreviousSecretKey()
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			if c.secrets.previous, err = newSecretBox(previous); err != nil {
+				return err
+			}
+		}
+	}
+
+	plainInterfaces, err := c.secrets.openInterfaces(c.Interfaces)
+	if err != nil {
+		return err
+	}
+	sessions, plainSessions, err := c.secrets.openSessions(c.Sessions)
+	if err != nil {
+		return err
+	}
+	c.Sessions = sessions
+	plainSettings, err := c.secrets.openSettings(c)
+	if err != nil {
+		return err
+	}
+
+	if c.secrets == nil {
+		return nil
+	}
+	if c.secrets.previous != nil {
+		logging.LogInfo("Finishing the rotation of the secret key")
+		if err := c.finishRotation(); err != nil {
+			return err
+		}
+	}
+	if !(plainInterfaces || plainSessions || plainSettings) {
+		return nil
+	}
+	logging.LogInfo("Encrypting the secrets stored in plaintext")
+	if err := c.resealRevisions(c.secrets, c.secrets); err != nil {
+		return err
+	}
+	return c.Save()
+}
+
+// RotateSecretKey encrypts the secrets of the configuration and of the revisions
+// with a new key. Secrets stored in plaintext are encrypted too. The previous key
+// is kept next to the key file until the revisions are encrypted with the new key,
+// so an interrupted rotation is finished on the next start.
+func (c *Config) RotateSecretKey(key []byte) error {
+	box, err := newSecretBox(key)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	previous := c.secrets
+	c.mu.Unlock()
+	if previous != nil {
+		if c.SecretKeyFile == "" {
+			return fmt.Errorf("a secret key file is needed to rotate the secret key")
+		}
+		data := []byte(hex.EncodeToString(previous.key) + "\n")
+		if err := utils.WriteFileAtomic(c.secretKeyPath()+previousKeySuffix, data, 0600); err != nil {
+			return fmt.Errorf("failed to keep the previous secret key:-> %v", err)
+		}
+		box.previous = previous
+	}
+
+	c.mu.Lock()
+	c.secrets = box
+	c.mu.Unlock()
+	if err := c.Save(); err != nil {
+		c.mu.Lock()
+		c.secrets = previous
+		c.mu.Unlock()
+		if previous != nil {
+			os.Remove(c.secretKeyPath() + previousKeySuffix)
+		}
+		return err
+	}
+	return c.finishRotation()
+}
+
+// finishRotation encrypts the revisions with the key, once the configuration is
+// saved with it, then forgets the previous key
+func (c *Config) finishRotation() error {
+	if err := c.resealRevisions(c.secrets, c.secrets); err != nil {
+		return err
+	}
+	if c.secrets.previous == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.secrets.previous = nil
+	c.mu.Unlock()
+	if err := os.Remove(c.secretKeyPath() + previousKeySuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the previous secret key:-> %v", err)
+	}
+	return nil
+}
+
+// resealRevisions encrypts the secrets of the saved revisions with another key
+func (c *Config) resealRevisions(from, to *secretBox) error {
+	c.revMu.Lock()
+	defer c.revMu.Unlock()
+
+	numbers, err := c.revisionNumbers()
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		revision, err := c.readRevision(number)
+		if err != nil {
+			return err
+		}
+		interfaces, err := revision.DecodeInterfaces()
+		if err != nil {
+			return err
+		}
+		if _, err := from.openInterfaces(interfaces); err != nil {
+			return fmt.Errorf("failed to decrypt revision %d:-> %v", number, err)
+		}
+		if revision.Interfaces, err = json.Marshal(to.sealInterfaces(interfaces)); err != nil {
+			return fmt.Errorf("failed to marshal revision %d:-> %v", number, err)
+		}
+		data, err := json.MarshalIndent(revision, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal revision %d:-> %v", number, err)
+		}
+		if err := utils.WriteFileAtomic(c.revisionFile(number), data, 0600); err != nil {
+			return fmt.Errorf("failed to write revision %d:-> %v", number, err)
+		}
+	}
+	// The last revision is compared with the next saves
+	c.revLast = nil
+	return nil
+}
+
+// openRevision decrypts the secrets of the interfaces of a revision
+func (c *Config) openRevision(revision *Revision) error {
+	if !bytes.Contains(revision.Interfaces, []byte(secretPrefix)) {
+		return nil
+	}
+	interfaces, err := revision.DecodeInterfaces()
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	box := c.secrets
+	c.mu.RUnlock()
+	if _, err := box.openInterfaces(interfaces); err != nil {
+		return fmt.Errorf("failed to decrypt revision %d:-> %v", revision.Number, err)
+	}
+	revision.Interfaces, err = json.Marshal(interfaces)
+	return err
+}
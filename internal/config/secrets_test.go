@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/models"
+)
+
+func TestSecrets_MigrateAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	oldKey, _ := GenerateSecretKey()
+	if err := os.WriteFile(filepath.Join(dir, "secret.key"), []byte(oldKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	psk := "preshared-plain"
+	initial := map[string]interface{}{
+		"serverId":      "abc123",
+		"user":          "admin",
+		"downloadKey":   "download-plain",
+		"secretKeyFile": "secret.key",
+		"twoFactor":     &TwoFactor{Secret: "totp-admin-plain", Enabled: true},
+		"users":         map[string]*User{"alice": {TwoFactor: &TwoFactor{Secret: "totp-alice-plain"}}},
+		"oidc":          OIDCConfig{ClientSecret: "oidc-plain"},
+		"webhooks":      map[string]*Webhook{"w1": {ID: "w1", Secret: "webhook-plain"}},
+		"interfaces": map[string]*models.Interface{
+			"if1": {ID: "if1", Ifname: "wg-a", PrivateKey: "iface-plain", Servers: []*models.Server{
+				{ID: "s1", Clients: []*models.Client{{ID: "c1", PresharedKey: &psk}}},
+			}},
+		},
+		"sessions": map[string]*Session{"token-plain": {Username: "admin"}},
+	}
+	data, _ := json.Marshal(initial)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plaintext secrets are encrypted on load
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	for _, plain := range []string{"iface-plain", "preshared-plain", "token-plain", "download-plain", "totp-admin-plain", "totp-alice-plain", "oidc-plain", "webhook-plain"} {
+		if strings.Contains(string(saved), plain) {
+			t.Errorf("Secret %s saved in plaintext", plain)
+		}
+	}
+	if cfg.GetInterface("if1").PrivateKey != "iface-plain" || cfg.GetSession("token-plain") == nil || cfg.DownloadKey != "download-plain" ||
+		cfg.TwoFactor.Secret != "totp-admin-plain" || cfg.Users["alice"].TwoFactor.Secret != "totp-alice-plain" ||
+		cfg.OIDC.ClientSecret != "oidc-plain" || cfg.Webhooks["w1"].Secret != "webhook-plain" {
+		t.Errorf("Secrets should stay decrypted in memory")
+	}
+
+	// Saving again gives the same file
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(saved) {
+		t.Errorf("Unchanged secrets should keep their ciphertext")
+	}
+
+	newKey, _ := GenerateSecretKey()
+	key, err := ParseSecretKey(newKey)
+	if err != nil {
+		t.Fatalf("ParseSecretKey() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.key"), []byte(newKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SecretKeyFile = "new.key"
+	if err := cfg.RotateSecretKey(key); err != nil {
+		t.Fatalf("RotateSecretKey() error = %v", err)
+	}
+
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	client, _ := reloaded.GetClient("if1", "s1", "c1")
+	if client == nil || client.PresharedKey == nil || *client.PresharedKey != psk {
+		t.Errorf("Preshared key not decrypted with the new key: %+v", client)
+	}
+	if reloaded.Users["alice"].TwoFactor.Secret != "totp-alice-plain" || reloaded.Webhooks["w1"].Secret != "webhook-plain" {
+		t.Errorf("Settings not decrypted with the new key")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.key"+previousKeySuffix)); !os.IsNotExist(err) {
+		t.Errorf("The previous key should be removed after the rotation, got %v", err)
+	}
+
+	// The previous key can't decrypt the rotated configuration
+	t.Setenv(SecretKeyEnv, oldKey)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "wrong secret key") {
+		t.Errorf("Expected a decryption error with the previous key, got %v", err)
+	}
+}
+
+func TestSecrets_InterruptedRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	oldKey, _ := GenerateSecretKey()
+	newKey, _ := GenerateSecretKey()
+	os.WriteFile(filepath.Join(dir, "old.key"), []byte(oldKey), 0600)
+	os.WriteFile(filepath.Join(dir, "new.key"), []byte(newKey), 0600)
+	data, _ := json.Marshal(map[string]interface{}{
+		"serverId":      "abc123",
+		"downloadKey":   "key",
+		"secretKeyFile": "old.key",
+		"interfaces": map[string]*models.Interface{
+			"if1": {ID: "if1", Ifname: "wg-a", PrivateKey: "iface-plain"},
+		},
+	})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	revisions, err := cfg.ListRevisions()
+	if err != nil || len(revisions) == 0 {
+		t.Fatalf("Expected a revision, got %v, %v", revisions, err)
+	}
+
+	// The process stops after saving the configuration with the new key
+	key, _ := ParseSecretKey(newKey)
+	box, _ := newSecretBox(key)
+	box.previous = cfg.secrets
+	os.WriteFile(filepath.Join(dir, "new.key"+previousKeySuffix), []byte(oldKey), 0600)
+	cfg.SecretKeyFile = "new.key"
+	cfg.secrets = box
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// The next start encrypts the revisions with the new key and forgets the previous one
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.key"+previousKeySuffix)); !os.IsNotExist(err) {
+		t.Errorf("The previous key should be removed, got %v", err)
+	}
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	revision, err := reloaded.GetRevision(revisions[len(revisions)-1].Number)
+	if err != nil || !strings.Contains(string(revision.Interfaces), "iface-plain") {
+		t.Errorf("The first revision should open with the new key: %v", err)
+	}
+}
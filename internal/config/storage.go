@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"wg-panel/internal/utils"
+)
+
+// Values of the storage option
+const (
+	StorageJSON   = "json"
+	StorageSQLite = "sqlite"
+)
+
+// Storage persists the configuration. The settings always stay in the configuration
+// file, the storage decides where the interfaces, sessions, users, API tokens, portal
+// links and download links go.
+type Storage interface {
+	// Load reads the stored interfaces, sessions, users, tokens and links into cfg
+	Load(cfg *Config) error
+	// Save writes the configuration, the caller holds cfg.mu for reading
+	Save(cfg *Config) error
+	Close() error
+}
+
+// NewStorage opens the storage selected by the configuration
+func NewStorage(cfg *Config) (Storage, error) {
+	switch cfg.Storage {
+	case "", StorageJSON:
+		return &jsonStorage{}, nil
+	case StorageSQLite:
+		return newSQLiteStorage(cfg.StoragePath)
+	default:
+		return nil, fmt.Errorf("unknown storage '%s', must be %s or %s", cfg.Storage, StorageJSON, StorageSQLite)
+	}
+}
+
+// jsonStorage keeps everything in the configuration file, rewritten on every save
+type jsonStorage struct{}
+
+func (s *jsonStorage) Load(cfg *Config) error {
+	// Already read with the settings
+	return nil
+}
+
+func (s *jsonStorage) Save(cfg *Config) error {
+	data, err := marshalConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config:-> %v", err)
+	}
+	return utils.WriteFileAtomic(cfg.ConfigPath, data, 0600)
+}
+
+func (s *jsonStorage) Close() error {
+	return nil
+}
+
+// plainConfig is Config without its methods
+type plainConfig Config
+
+// settingsOnly encodes the configuration without the models kept by the storage,
+// its fields hide the ones of the embedded configuration
+type settingsOnly struct {
+	*plainConfig
+	Interfaces    *struct{} `json:"interfaces,omitempty"`
+	Sessions      *struct{} `json:"sessions,omitempty"`
+	Users         *struct{} `json:"users,omitempty"`
+	APITokens     *struct{} `json:"apiTokens,omitempty"`
+	PortalLinks   *struct{} `json:"portalLinks,omitempty"`
+	DownloadLinks *struct{} `json:"downloadLinks,omitempty"`
+}
+
+// marshalSettings encodes the settings of the configuration, without the models,
+// with their secrets encrypted when a secret key is set
+func marshalSettings(cfg *Config) ([]byte, error) {
+	if cfg.secrets == nil {
+		return json.MarshalIndent(settingsOnly{plainConfig: (*plainConfig)(cfg)}, "", "  ")
+	}
+	return json.MarshalIndent(sealedSettings{sealedConfig: cfg.secrets.sealConfig(cfg)}, "", "  ")
+}
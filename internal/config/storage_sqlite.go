@@ -0,0 +1,455 @@
+package config
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS interfaces (id TEXT PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS servers (
+	interface_id TEXT NOT NULL, id TEXT NOT NULL, position INTEGER NOT NULL, data TEXT NOT NULL,
+	PRIMARY KEY (interface_id, id)
+);
+CREATE TABLE IF NOT EXISTS clients (
+	interface_id TEXT NOT NULL, server_id TEXT NOT NULL, id TEXT NOT NULL, position INTEGER NOT NULL, data TEXT NOT NULL,
+	PRIMARY KEY (interface_id, server_id, id)
+);
+CREATE TABLE IF NOT EXISTS sessions (token TEXT PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS users (username TEXT PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS api_tokens (id TEXT PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS portal_links (id TEXT PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS download_links (id TEXT PRIMARY KEY, data TEXT NOT NULL);
+`
+
+// sqliteRow is one stored model, keys are the primary key columns
+type sqliteRow struct {
+	keys     []string
+	position int
+	data     []byte
+}
+
+func (r sqliteRow) equal(other sqliteRow) bool {
+	return r.position == other.position && bytes.Equal(r.data, other.data)
+}
+
+// sqliteTable describes how the rows of a table are written
+type sqliteTable struct {
+	name       string
+	keyColumns []string
+	positioned bool
+}
+
+var sqliteTables = []sqliteTable{
+	{name: "interfaces", keyColumns: []string{"id"}},
+	{name: "servers", keyColumns: []string{"interface_id", "id"}, positioned: true},
+	{name: "clients", keyColumns: []string{"interface_id", "server_id", "id"}, positioned: true},
+	{name: "sessions", keyColumns: []string{"token"}},
+	{name: "users", keyColumns: []string{"username"}},
+	{name: "api_tokens", keyColumns: []string{"id"}},
+	{name: "portal_links", keyColumns: []string{"id"}},
+	{name: "download_links", keyColumns: []string{"id"}},
+}
+
+// sqliteStorage keeps one table per model. A save only writes the rows that
+// changed since the previous save, in one transaction.
+type sqliteStorage struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	rows      map[string]map[string]sqliteRow // Written rows by table and key
+	settings  []byte                          // Written configuration file
+	migrating bool
+}
+
+func newSQLiteStorage(path string) (*sqliteStorage, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s:-> %v", path, err)
+	}
+	// A single connection serializes the transactions
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create database schema:-> %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set database permissions:-> %v", err)
+	}
+	return &sqliteStorage{db: db, rows: make(map[string]map[string]sqliteRow)}, nil
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+// Load reads the models from the database. On the first start the models
+// read from the configuration file are migrated into the database instead.
+func (s *sqliteStorage) Load(cfg *Config) error {
+	var migrated string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = 'migrated'`).Scan(&migrated)
+	if err == sql.ErrNoRows {
+		return s.migrate(cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read database:-> %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interfaces := make(map[string]*models.Interface)
+	if err := s.loadTable("interfaces", func(row sqliteRow) error {
+		var iface models.Interface
+		if err := json.Unmarshal(row.data, &iface); err != nil {
+			return err
+		}
+		interfaces[row.keys[0]] = &iface
+		return nil
+	}); err != nil {
+		return err
+	}
+	servers := make(map[string]*models.Server)
+	if err := s.loadTable("servers", func(row sqliteRow) error {
+		var server models.Server
+		if err := json.Unmarshal(row.data, &server); err != nil {
+			return err
+		}
+		iface, ok := interfaces[row.keys[0]]
+		if !ok {
+			return fmt.Errorf("server %s belongs to missing interface %s", row.keys[1], row.keys[0])
+		}
+		iface.Servers = append(iface.Servers, &server)
+		servers[row.keys[0]+"/"+row.keys[1]] = &server
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := s.loadTable("clients", func(row sqliteRow) error {
+		var client models.Client
+		if err := json.Unmarshal(row.data, &client); err != nil {
+			return err
+		}
+		server, ok := servers[row.keys[0]+"/"+row.keys[1]]
+		if !ok {
+			return fmt.Errorf("client %s belongs to missing server %s", row.keys[2], row.keys[1])
+		}
+		server.Clients = append(server.Clients, &client)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sessions := make(map[string]*Session)
+	if err := s.loadTable("sessions", func(row sqliteRow) error {
+		var session Session
+		sessions[row.keys[0]] = &session
+		return json.Unmarshal(row.data, &session)
+	}); err != nil {
+		return err
+	}
+	users := make(map[string]*User)
+	if err := s.loadTable("users", func(row sqliteRow) error {
+		var user User
+		users[row.keys[0]] = &user
+		return json.Unmarshal(row.data, &user)
+	}); err != nil {
+		return err
+	}
+	apiTokens := make(map[string]*APIToken)
+	if err := s.loadTable("api_tokens", func(row sqliteRow) error {
+		var apiToken APIToken
+		apiTokens[row.keys[0]] = &apiToken
+		return json.Unmarshal(row.data, &apiToken)
+	}); err != nil {
+		return err
+	}
+	portalLinks := make(map[string]*PortalLink)
+	if err := s.loadTable("portal_links", func(row sqliteRow) error {
+		var link PortalLink
+		portalLinks[row.keys[0]] = &link
+		return json.Unmarshal(row.data, &link)
+	}); err != nil {
+		return err
+	}
+	downloadLinks := make(map[string]*DownloadLink)
+	if err := s.loadTable("download_links", func(row sqliteRow) error {
+		var link DownloadLink
+		downloadLinks[row.keys[0]] = &link
+		return json.Unmarshal(row.data, &link)
+	}); err != nil {
+		return err
+	}
+
+	cfg.Interfaces = interfaces
+	cfg.Sessions = sessions
+	cfg.Users = users
+	cfg.APITokens = apiTokens
+	cfg.PortalLinks = portalLinks
+	cfg.DownloadLinks = downloadLinks
+	return nil
+}
+
+// loadTable reads the rows of a table in order, and remembers them as written
+func (s *sqliteStorage) loadTable(name string, load func(row sqliteRow) error) error {
+	table := findSQLiteTable(name)
+	columns := joinColumns(table.keyColumns)
+	position := "0"
+	if table.positioned {
+		position = "position"
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT %s, %s, data FROM %s ORDER BY %s`, columns, position, name, orderColumns(table)))
+	if err != nil {
+		return fmt.Errorf("failed to read table %s:-> %v", name, err)
+	}
+	defer rows.Close()
+
+	written := make(map[string]sqliteRow)
+	for rows.Next() {
+		row := sqliteRow{keys: make([]string, len(table.keyColumns))}
+		dest := make([]interface{}, 0, len(table.keyColumns)+2)
+		for i := range row.keys {
+			dest = append(dest, &row.keys[i])
+		}
+		dest = append(dest, &row.position, &row.data)
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to read table %s:-> %v", name, err)
+		}
+		if err := load(row); err != nil {
+			return fmt.Errorf("failed to decode row of table %s:-> %v", name, err)
+		}
+		written[rowKey(row.keys)] = row
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read table %s:-> %v", name, err)
+	}
+	s.rows[name] = written
+	return nil
+}
+
+// preSQLiteBackup returns the path of the configuration file kept by the migration
+func preSQLiteBackup(configPath string) string {
+	return configPath + ".pre-sqlite"
+}
+
+// migrate writes the models of the configuration file into the empty database,
+// after keeping a backup of the file, which is then rewritten with the settings only
+func (s *sqliteStorage) migrate(cfg *Config) error {
+	logging.LogInfo("Migrating interfaces, sessions, users, tokens and links from %s into the database", cfg.ConfigPath)
+	if data, err := os.ReadFile(cfg.ConfigPath); err == nil {
+		backup := preSQLiteBackup(cfg.ConfigPath)
+		if err := utils.WriteFileAtomic(backup, data, 0600); err != nil {
+			return fmt.Errorf("failed to back up configuration file:-> %v", err)
+		}
+		logging.LogInfo("Kept the previous configuration file as %s", backup)
+	}
+
+	// The migration is marked in the transaction writing the models
+	s.migrating = true
+	defer func() { s.migrating = false }()
+	return s.Save(cfg)
+}
+
+// currentRows returns the rows of every table for the models of the configuration
+func currentRows(cfg *Config) (map[string]map[string]sqliteRow, error) {
+	result := make(map[string]map[string]sqliteRow)
+	for _, table := range sqliteTables {
+		result[table.name] = make(map[string]sqliteRow)
+	}
+	add := func(table string, position int, value interface{}, keys ...string) error {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s %s:-> %v", table, rowKey(keys), err)
+		}
+		result[table][rowKey(keys)] = sqliteRow{keys: keys, position: position, data: data}
+		return nil
+	}
+
+	for id, iface := range cfg.secrets.sealInterfaces(cfg.Interfaces) {
+		ifaceRow := *iface
+		ifaceRow.Servers = nil
+		if err := add("interfaces", 0, &ifaceRow, id); err != nil {
+			return nil, err
+		}
+		for serverPos, server := range iface.Servers {
+			serverRow := *server
+			serverRow.Clients = nil
+			if err := add("servers", serverPos, &serverRow, id, server.ID); err != nil {
+				return nil, err
+			}
+			for clientPos, client := range server.Clients {
+				if err := add("clients", clientPos, client, id, server.ID, client.ID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for token, session := range cfg.secrets.sealSessions(cfg.Sessions) {
+		if err := add("sessions", 0, session, token); err != nil {
+			return nil, err
+		}
+	}
+	for username, user := range cfg.secrets.sealUsers(cfg.Users) {
+		if err := add("users", 0, user, username); err != nil {
+			return nil, err
+		}
+	}
+	for id, apiToken := range cfg.APITokens {
+		if err := add("api_tokens", 0, apiToken, id); err != nil {
+			return nil, err
+		}
+	}
+	for id, link := range cfg.PortalLinks {
+		if err := add("portal_links", 0, link, id); err != nil {
+			return nil, err
+		}
+	}
+	for id, link := range cfg.DownloadLinks {
+		if err := add("download_links", 0, link, id); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Save writes the changed rows in one transaction, and the settings to the
+// configuration file if they changed
+func (s *sqliteStorage) Save(cfg *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := currentRows(cfg)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction:-> %v", err)
+	}
+	defer tx.Rollback()
+
+	changes := 0
+	for _, table := range sqliteTables {
+		written := s.rows[table.name]
+		for key, row := range current[table.name] {
+			if old, ok := written[key]; ok && old.equal(row) {
+				continue
+			}
+			if err := upsertRow(tx, table, row); err != nil {
+				return err
+			}
+			changes++
+		}
+		for key, row := range written {
+			if _, ok := current[table.name][key]; ok {
+				continue
+			}
+			if err := deleteRow(tx, table, row); err != nil {
+				return err
+			}
+			changes++
+		}
+	}
+	if s.migrating {
+		if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('migrated', 'true')`); err != nil {
+			return fmt.Errorf("failed to mark database as migrated:-> %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction:-> %v", err)
+	}
+	s.rows = current
+	if changes > 0 {
+		logging.LogVerbose("Saved %d changed rows to the database", changes)
+	}
+
+	settings, err := marshalSettings(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config:-> %v", err)
+	}
+	if bytes.Equal(settings, s.settings) {
+		return nil
+	}
+	if err := utils.WriteFileAtomic(cfg.ConfigPath, settings, 0600); err != nil {
+		return err
+	}
+	s.settings = settings
+	return nil
+}
+
+func upsertRow(tx *sql.Tx, table sqliteTable, row sqliteRow) error {
+	columns := append(append([]string{}, table.keyColumns...), "data")
+	args := make([]interface{}, 0, len(columns)+1)
+	for _, key := range row.keys {
+		args = append(args, key)
+	}
+	args = append(args, string(row.data))
+	if table.positioned {
+		columns = append(columns, "position")
+		args = append(args, row.position)
+	}
+	placeholders := "?"
+	for i := 1; i < len(columns); i++ {
+		placeholders += ", ?"
+	}
+	query := fmt.Sprintf(`INSERT OR REPLACE INTO %s (%s) VALUES (%s)`, table.name, joinColumns(columns), placeholders)
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to write %s %s:-> %v", table.name, rowKey(row.keys), err)
+	}
+	return nil
+}
+
+func deleteRow(tx *sql.Tx, table sqliteTable, row sqliteRow) error {
+	where := ""
+	args := make([]interface{}, 0, len(row.keys))
+	for i, column := range table.keyColumns {
+		if i > 0 {
+			where += " AND "
+		}
+		where += column + " = ?"
+		args = append(args, row.keys[i])
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s`, table.name, where), args...); err != nil {
+		return fmt.Errorf("failed to delete %s %s:-> %v", table.name, rowKey(row.keys), err)
+	}
+	return nil
+}
+
+func findSQLiteTable(name string) sqliteTable {
+	for _, table := range sqliteTables {
+		if table.name == name {
+			return table
+		}
+	}
+	panic("unknown table " + name)
+}
+
+func rowKey(keys []string) string {
+	return strings.Join(keys, "/")
+}
+
+func joinColumns(columns []string) string {
+	return strings.Join(columns, ", ")
+}
+
+// orderColumns keeps the servers and clients in the order of their slices
+func orderColumns(table sqliteTable) string {
+	if !table.positioned {
+		return joinColumns(table.keyColumns)
+	}
+	return joinColumns(table.keyColumns[:len(table.keyColumns)-1]) + ", position"
+}
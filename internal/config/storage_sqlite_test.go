@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/models"
+)
+
+func TestSQLiteStorage_MigrateAndReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	initial := map[string]interface{}{
+		"serverId": "abc123",
+		"user":     "admin",
+		"storage":  StorageSQLite,
+		"interfaces": map[string]*models.Interface{
+			"if1": {ID: "if1", Ifname: "wg-a", Servers: []*models.Server{
+				{ID: "s2", Name: "second", Clients: []*models.Client{{ID: "c1", Name: "one"}, {ID: "c0", Name: "zero"}}},
+				{ID: "s1", Name: "first"},
+			}},
+		},
+		"users": map[string]*User{"alice": {Access: Access{Role: RoleViewer}}},
+	}
+	data, _ := json.Marshal(initial)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".pre-sqlite"); err != nil {
+		t.Errorf("Expected a backup of the configuration file: %v", err)
+	}
+	settings, _ := os.ReadFile(path)
+	if strings.Contains(string(settings), `"interfaces"`) || strings.Contains(string(settings), `"users"`) {
+		t.Errorf("Configuration file should only keep the settings, got %s", settings)
+	}
+
+	// Remove a client and add a server, then reload from the database
+	iface := cfg.GetInterface("if1")
+	iface.Servers[0].Clients = iface.Servers[0].Clients[1:]
+	iface.Servers = append(iface.Servers, &models.Server{ID: "s3", Name: "third"})
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	cfg.Close()
+
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer reloaded.Close()
+
+	iface = reloaded.GetInterface("if1")
+	if iface == nil || iface.Ifname != "wg-a" {
+		t.Fatalf("Interface not reloaded: %+v", iface)
+	}
+	var names []string
+	for _, server := range iface.Servers {
+		names = append(names, server.Name)
+	}
+	if strings.Join(names, ",") != "second,first,third" {
+		t.Errorf("Servers should keep their order, got %v", names)
+	}
+	if clients := iface.Servers[0].Clients; len(clients) != 1 || clients[0].ID != "c0" {
+		t.Errorf("Expected only client c0, got %v", clients)
+	}
+	if access, ok := reloaded.GetUserAccess("alice"); !ok || access.Role != RoleViewer {
+		t.Errorf("User not reloaded: %+v, %v", access, ok)
+	}
+	if reloaded.WGPanelId != "abc123" {
+		t.Errorf("Settings not reloaded, serverId = %q", reloaded.WGPanelId)
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// APIToken is a long-lived bearer token for automation. Only the SHA-256 hash
+// of the token is stored, the token itself is shown once when it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash,omitempty"`
+	Owner      string     `json:"owner"` // User who created the token
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Access                // Scope of the token, within the roles of the owner when it was created
+}
+
+// HashAPIToken returns the hash stored for a token
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired tells if the token can't be used anymore
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+// Covers tells if the roles of a include every role of scope, so a user can
+// only create tokens with permissions they have
+func (a Access) Covers(scope Access) bool {
+	if scope.Role != "" && !a.Allowed(scope.Role, "", "") {
+		return false
+	}
+	for _, binding := range scope.Bindings {
+		if !a.Allowed(binding.Role, binding.InterfaceID, binding.ServerID) {
+			return false
+		}
+	}
+	return true
+}
+
+// UseAPIToken finds the token, checks it isn't expired and records its use.
+// The returned flag is set when the previous use is old enough to be worth saving.
+func (c *Config) UseAPIToken(token string) (*APIToken, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := HashAPIToken(token)
+	for _, apiToken := range c.APITokens {
+		if subtle.ConstantTimeCompare([]byte(apiToken.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		now := time.Now()
+		if apiToken.Expired(now) {
+			return nil, false, fmt.Errorf("token expired")
+		}
+		stale := apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > time.Minute
+		apiToken.LastUsedAt = &now
+		result := *apiToken
+		return &result, stale, nil
+	}
+	return nil, false, fmt.Errorf("token not found")
+}
+
+// ListAPITokens returns the tokens of a user, or of every user if owner is empty,
+// sorted by creation time and without their hash
+func (c *Config) ListAPITokens(owner string) []*APIToken {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tokens := make([]*APIToken, 0)
+	for _, apiToken := range c.APITokens {
+		if owner != "" && apiToken.Owner != owner {
+			continue
+		}
+		result := *apiToken
+		result.Hash = ""
+		tokens = append(tokens, &result)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// GetAPIToken returns a token by ID
+func (c *Config) GetAPIToken(id string) (*APIToken, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	apiToken, ok := c.APITokens[id]
+	if !ok {
+		return nil, fmt.Errorf("token not found")
+	}
+	result := *apiToken
+	return &result, nil
+}
+
+// AddAPIToken stores a new token
+func (c *Config) AddAPIToken(apiToken *APIToken) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.APITokens[apiToken.ID]; ok {
+		return fmt.Errorf("token already exists")
+	}
+	c.APITokens[apiToken.ID] = apiToken
+	return nil
+}
+
+// DeleteAPIToken revokes a token
+func (c *Config) DeleteAPIToken(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.APITokens[id]; !ok {
+		return fmt.Errorf("token not found")
+	}
+	delete(c.APITokens, id)
+	return nil
+}
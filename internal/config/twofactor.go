@@ -0,0 +1,211 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpPeriod        = 30
+	totpSkew          = 1 // Steps accepted before and after the current one, for clock drift
+	recoveryCodeCount = 10
+)
+
+// TwoFactor is the TOTP second factor of a user
+type TwoFactor struct {
+	Secret        string   `json:"secret"`                  // Base32 TOTP secret
+	Enabled       bool     `json:"enabled"`                 // False until the enrollment is confirmed with a code
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"` // SHA-256 hashes of the unused recovery codes
+	LastStep      int64    `json:"lastStep,omitempty"`      // Time step of the last accepted code, so codes can't be replayed
+}
+
+// Active tells if the second factor is required at login
+func (t *TwoFactor) Active() bool {
+	return t != nil && t.Enabled
+}
+
+// checkCode accepts a TOTP code of the current time step or a neighbouring one,
+// newer than the last accepted code
+func (t *TwoFactor) checkCode(code string, now time.Time) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= t.LastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(t.Secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			t.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// useRecoveryCode accepts an unused recovery code and removes it
+func (t *TwoFactor) useRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, stored := range t.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i], t.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes returns new recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code:-> %v", err)
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// twoFactorSlot returns where the second factor of a user is kept, nil if the user doesn't exist.
+// The caller holds c.mu.
+func (c *Config) twoFactorSlot(username string) **TwoFactor {
+	if username == c.User {
+		return &c.TwoFactor
+	}
+	if user, ok := c.Users[username]; ok {
+		return &user.TwoFactor
+	}
+	return nil
+}
+
+// TwoFactorStatus tells if a user has two-factor authentication enabled, with the number of unused recovery codes
+func (c *Config) TwoFactorStatus(username string) (bool, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	slot := c.twoFactorSlot(username)
+	if slot == nil || !(*slot).Active() {
+		return false, 0
+	}
+	return true, len((*slot).RecoveryCodes)
+}
+
+// BeginTwoFactor generates a new TOTP secret for a user, replacing an unconfirmed one.
+// Two-factor authentication stays off until the secret is confirmed with ConfirmTwoFactor.
+func (c *Config) BeginTwoFactor(username, issuer string) (*otp.Key, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.twoFactorSlot(username)
+	if slot == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if (*slot).Active() {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: username,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret:-> %v", err)
+	}
+	*slot = &TwoFactor{Secret: key.Secret()}
+	return key, nil
+}
+
+// ConfirmTwoFactor enables the pending secret of a user if the code matches it,
+// and returns the recovery codes, they are only shown this once
+func (c *Config) ConfirmTwoFactor(username, code string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.twoFactorSlot(username)
+	if slot == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	twoFactor := *slot
+	if twoFactor == nil || twoFactor.Enabled {
+		return nil, fmt.Errorf("no pending enrollment")
+	}
+	if !twoFactor.checkCode(code, time.Now()) {
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	twoFactor.Enabled = true
+	twoFactor.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// VerifyTwoFactor checks a TOTP code or an unused recovery code of a user,
+// each recovery code works once. Save the configuration afterwards to keep track of used codes.
+func (c *Config) VerifyTwoFactor(username, code string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.twoFactorSlot(username)
+	if slot == nil || !(*slot).Active() {
+		return false
+	}
+	return (*slot).checkCode(code, time.Now()) || (*slot).useRecoveryCode(code)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user
+func (c *Config) RegenerateRecoveryCodes(username string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.twoFactorSlot(username)
+	if slot == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if !(*slot).Active() {
+		return nil, fmt.Errorf("two-factor authentication not enabled")
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	(*slot).RecoveryCodes = hashes
+	return codes, nil
+}
+
+// DisableTwoFactor removes the second factor of a user
+func (c *Config) DisableTwoFactor(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.twoFactorSlot(username)
+	if slot == nil {
+		return fmt.Errorf("user not found")
+	}
+	*slot = nil
+	return nil
+}
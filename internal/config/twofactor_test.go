@@ -0,0 +1,81 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	cfg := &Config{User: "admin", Users: map[string]*User{}}
+
+	key, err := cfg.BeginTwoFactor("admin", "Panel")
+	if err != nil {
+		t.Fatalf("BeginTwoFactor() error = %v", err)
+	}
+	if enabled, _ := cfg.TwoFactorStatus("admin"); enabled {
+		t.Fatalf("Two-factor authentication should stay off until confirmed")
+	}
+	if _, err := cfg.ConfirmTwoFactor("admin", "000000"); err == nil || err.Error() != "invalid code" {
+		t.Errorf("Expected invalid code error, got %v", err)
+	}
+
+	code, _ := totp.GenerateCode(key.Secret(), time.Now())
+	recoveryCodes, err := cfg.ConfirmTwoFactor("admin", code)
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor() error = %v", err)
+	}
+	if enabled, left := cfg.TwoFactorStatus("admin"); !enabled || left != recoveryCodeCount {
+		t.Fatalf("TwoFactorStatus() = %v, %d", enabled, left)
+	}
+
+	// The confirmation code can't be replayed
+	if cfg.VerifyTwoFactor("admin", code) {
+		t.Errorf("A used code should be refused")
+	}
+
+	// Recovery codes work once
+	if !cfg.VerifyTwoFactor("admin", recoveryCodes[0]) {
+		t.Errorf("Recovery code should be accepted")
+	}
+	if cfg.VerifyTwoFactor("admin", recoveryCodes[0]) {
+		t.Errorf("A used recovery code should be refused")
+	}
+	if _, left := cfg.TwoFactorStatus("admin"); left != recoveryCodeCount-1 {
+		t.Errorf("Expected %d recovery codes left, got %d", recoveryCodeCount-1, left)
+	}
+
+	if _, err := cfg.BeginTwoFactor("admin", "Panel"); err == nil {
+		t.Errorf("Enrolling again should fail while enabled")
+	}
+	if err := cfg.DisableTwoFactor("admin"); err != nil {
+		t.Fatalf("DisableTwoFactor() error = %v", err)
+	}
+	if enabled, _ := cfg.TwoFactorStatus("admin"); enabled {
+		t.Errorf("Two-factor authentication should be off")
+	}
+}
+
+func TestTwoFactorCheckCodeSkew(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "Panel", AccountName: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tests := []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{-totpPeriod * time.Second, true},
+		{totpPeriod * time.Second, true},
+		{-3 * totpPeriod * time.Second, false},
+	}
+	for _, tt := range tests {
+		twoFactor := &TwoFactor{Secret: key.Secret(), Enabled: true}
+		code, _ := totp.GenerateCode(key.Secret(), now.Add(tt.offset))
+		if got := twoFactor.checkCode(code, now); got != tt.want {
+			t.Errorf("checkCode() with offset %v = %v, want %v", tt.offset, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Roles of the panel users, each role has the permissions of the previous ones:
+// viewers can read, operators can also create and toggle clients, admins can change everything
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// RoleLevel orders the roles, 0 is returned for no role or an unknown role
+func RoleLevel(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// RoleBinding grants a role on one interface, or on one server of it if ServerID is set
+type RoleBinding struct {
+	Role        string `json:"role"`
+	InterfaceID string `json:"interfaceId"`
+	ServerID    string `json:"serverId,omitempty"`
+}
+
+// Access is the set of roles granted to a user
+type Access struct {
+	Role     string        `json:"role,omitempty"` // Global role, on every interface
+	Bindings []RoleBinding `json:"bindings,omitempty"`
+}
+
+// RoleFor returns the highest role of the user on an interface, or on one of its servers
+// if serverID is set. An empty ifaceID asks for the global role.
+func (a Access) RoleFor(ifaceID, serverID string) string {
+	role := a.Role
+	if ifaceID == "" {
+		return role
+	}
+	for _, binding := range a.Bindings {
+		if binding.InterfaceID != ifaceID {
+			continue
+		}
+		if binding.ServerID != "" && binding.ServerID != serverID {
+			continue
+		}
+		if RoleLevel(binding.Role) > RoleLevel(role) {
+			role = binding.Role
+		}
+	}
+	return role
+}
+
+// Allowed tells if the user has at least the given role on the interface or server
+func (a Access) Allowed(role, ifaceID, serverID string) bool {
+	return RoleLevel(a.RoleFor(ifaceID, serverID)) >= RoleLevel(role)
+}
+
+// CanView tells if the user may see the interface or server. An interface is
+// visible as soon as one of its servers is, so server scoped users can reach it.
+func (a Access) CanView(ifaceID, serverID string) bool {
+	if a.Allowed(RoleViewer, ifaceID, serverID) {
+		return true
+	}
+	if serverID != "" {
+		return false
+	}
+	for _, binding := range a.Bindings {
+		if binding.InterfaceID == ifaceID && RoleLevel(binding.Role) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the roles of the access
+func (a Access) Validate() error {
+	if a.Role != "" && RoleLevel(a.Role) == 0 {
+		return fmt.Errorf("invalid role '%s'", a.Role)
+	}
+	for _, binding := range a.Bindings {
+		if RoleLevel(binding.Role) == 0 {
+			return fmt.Errorf("invalid role '%s'", binding.Role)
+		}
+		if binding.InterfaceID == "" {
+			return fmt.Errorf("role binding requires an interface ID")
+		}
+	}
+	return nil
+}
+
+// User is a panel account besides the built-in admin of the user/password options
+type User struct {
+	Password    string     `json:"password"` // bcrypt hash, empty for users of the OpenID Connect provider
+	CreatedAt   time.Time  `json:"createdAt"`
+	TwoFactor   *TwoFactor `json:"twoFactor,omitempty"`
+	OIDCSubject string     `json:"oidcSubject,omitempty"` // Set for users provisioned by the OpenID Connect provider
+	Access
+}
+
+// UserInfo is a user as shown by the API, without the password hash
+type UserInfo struct {
+	Username  string    `json:"username"`
+	BuiltIn   bool      `json:"builtIn"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	TwoFactor bool      `json:"twoFactor"`
+	SSO       bool      `json:"sso"`
+	Access
+}
+
+// GetUserAccess returns the roles of a user, false if the user doesn't exist.
+// The built-in user is always a global admin.
+func (c *Config) GetUserAccess(username string) (Access, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if username == c.User {
+		return Access{Role: RoleAdmin}, true
+	}
+	user, ok := c.Users[username]
+	if !ok {
+		return Access{}, false
+	}
+	return user.Access, true
+}
+
+// GetPasswordHash returns the bcrypt hash of the password of a user, false if the user doesn't exist
+func (c *Config) GetPasswordHash(username string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if username == c.User {
+		return c.Password, true
+	}
+	user, ok := c.Users[username]
+	if !ok {
+		return "", false
+	}
+	return user.Password, true
+}
+
+// SetPasswordHash replaces the password hash of a user
+func (c *Config) SetPasswordHash(username, hash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if username == c.User {
+		c.Password = hash
+		return nil
+	}
+	user, ok := c.Users[username]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	user.Password = hash
+	return nil
+}
+
+// ListUsers returns the built-in user and the other users, sorted by name
+func (c *Config) ListUsers() []UserInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	users := []UserInfo{{Username: c.User, BuiltIn: true, TwoFactor: c.TwoFactor.Active(), Access: Access{Role: RoleAdmin}}}
+	names := make([]string, 0, len(c.Users))
+	for name := range c.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		user := c.Users[name]
+		users = append(users, UserInfo{Username: name, CreatedAt: user.CreatedAt, TwoFactor: user.TwoFactor.Active(), SSO: user.OIDCSubject != "", Access: user.Access})
+	}
+	return users
+}
+
+// GetUser returns a user other than the built-in one
+func (c *Config) GetUser(username string) (*User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	user, ok := c.Users[username]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// AddUser adds a user, the name must not be used yet
+func (c *Config) AddUser(username string, user *User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Users[username]; ok || username == c.User {
+		return fmt.Errorf("user already exists")
+	}
+	c.Users[username] = user
+	return nil
+}
+
+// ReplaceUser replaces an existing user other than the built-in one
+func (c *Config) ReplaceUser(username string, user *User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Users[username]; !ok {
+		return fmt.Errorf("user not found")
+	}
+	c.Users[username] = user
+	return nil
+}
+
+// DeleteUser removes a user with its sessions and API tokens
+func (c *Config) DeleteUser(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Users[username]; !ok {
+		return fmt.Errorf("user not found")
+	}
+	delete(c.Users, username)
+	for token, session := range c.Sessions {
+		if session.Username == username {
+			delete(c.Sessions, token)
+		}
+	}
+	for id, apiToken := range c.APITokens {
+		if apiToken.Owner == username {
+			delete(c.APITokens, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import "testing"
+
+func TestAccess_RoleFor(t *testing.T) {
+	access := Access{
+		Role: RoleViewer,
+		Bindings: []RoleBinding{
+			{Role: RoleAdmin, InterfaceID: "if1", ServerID: "s1"},
+			{Role: RoleOperator, InterfaceID: "if2"},
+		},
+	}
+
+	testCases := []struct {
+		ifaceID  string
+		serverID string
+		expected string
+	}{
+		{"", "", RoleViewer},
+		{"if1", "", RoleViewer},
+		{"if1", "s1", RoleAdmin},
+		{"if1", "s2", RoleViewer},
+		{"if2", "", RoleOperator},
+		{"if2", "s9", RoleOperator},
+		{"if3", "s1", RoleViewer},
+	}
+	for _, tc := range testCases {
+		if got := access.RoleFor(tc.ifaceID, tc.serverID); got != tc.expected {
+			t.Errorf("RoleFor(%q, %q) = %q, want %q", tc.ifaceID, tc.serverID, got, tc.expected)
+		}
+	}
+
+	if access.Allowed(RoleOperator, "if1", "s2") {
+		t.Errorf("Viewer should not be allowed to operate on if1/s2")
+	}
+	if !access.Allowed(RoleOperator, "if1", "s1") {
+		t.Errorf("Server admin should be allowed to operate on if1/s1")
+	}
+}
+
+func TestAccess_CanView(t *testing.T) {
+	access := Access{Bindings: []RoleBinding{{Role: RoleOperator, InterfaceID: "if1", ServerID: "s1"}}}
+
+	if !access.CanView("if1", "") {
+		t.Errorf("Interface of a bound server should be visible")
+	}
+	if !access.CanView("if1", "s1") {
+		t.Errorf("Bound server should be visible")
+	}
+	if access.CanView("if1", "s2") {
+		t.Errorf("Other servers of the interface should not be visible")
+	}
+	if access.CanView("if2", "") {
+		t.Errorf("Unbound interface should not be visible")
+	}
+	if access.Allowed(RoleViewer, "", "") {
+		t.Errorf("Scoped user should have no global role")
+	}
+}
+
+func TestAccess_Covers(t *testing.T) {
+	owner := Access{Bindings: []RoleBinding{{Role: RoleOperator, InterfaceID: "if1"}}}
+
+	if !owner.Covers(Access{Bindings: []RoleBinding{{Role: RoleViewer, InterfaceID: "if1", ServerID: "s1"}}}) {
+		t.Errorf("Read-only token on a server of the interface should be covered")
+	}
+	if owner.Covers(Access{Bindings: []RoleBinding{{Role: RoleAdmin, InterfaceID: "if1"}}}) {
+		t.Errorf("Admin token should not be covered by an operator")
+	}
+	if owner.Covers(Access{Role: RoleViewer}) {
+		t.Errorf("Global token should not be covered by an interface binding")
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"wg-panel/internal/events"
+	"wg-panel/internal/logging"
+)
+
+// expiryWebhookID is the ID of the webhook replacing the one of the client expiry
+const expiryWebhookID = "client-expiry"
+
+// Webhook receives the events matching its filter as JSON POST requests, signed
+// with an HMAC-SHA256 of the body keyed with its secret
+type Webhook struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"` // Types or prefixes such as "client.", empty for every event
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Matches tells if the webhook receives the events of a type
+func (w *Webhook) Matches(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, t := range w.Events {
+		if t == eventType || (strings.HasSuffix(t, ".") && strings.HasPrefix(eventType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListWebhooks returns the webhooks sorted by creation time, without their secret
+func (c *Config) ListWebhooks() []*Webhook {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(c.Webhooks))
+	for _, webhook := range c.Webhooks {
+		result := *webhook
+		result.Secret = ""
+		webhooks = append(webhooks, &result)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks
+}
+
+// EnabledWebhooks returns the enabled webhooks with their secret
+func (c *Config) EnabledWebhooks() []*Webhook {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var webhooks []*Webhook
+	for _, webhook := range c.Webhooks {
+		if webhook.Enabled {
+			result := *webhook
+			webhooks = append(webhooks, &result)
+		}
+	}
+	return webhooks
+}
+
+// GetWebhook returns a webhook by ID, with its secret
+func (c *Config) GetWebhook(id string) (*Webhook, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	webhook, ok := c.Webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("webhook not found")
+	}
+	result := *webhook
+	return &result, nil
+}
+
+// AddWebhook stores a new webhook
+func (c *Config) AddWebhook(webhook *Webhook) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Webhooks[webhook.ID]; ok {
+		return fmt.Errorf("webhook already exists")
+	}
+	c.Webhooks[webhook.ID] = webhook
+	return nil
+}
+
+// UpdateWebhook replaces a webhook
+func (c *Config) UpdateWebhook(webhook *Webhook) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Webhooks[webhook.ID]; !ok {
+		return fmt.Errorf("webhook not found")
+	}
+	c.Webhooks[webhook.ID] = webhook
+	return nil
+}
+
+// DeleteWebhook removes a webhook
+func (c *Config) DeleteWebhook(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Webhooks[id]; !ok {
+		return fmt.Errorf("webhook not found")
+	}
+	delete(c.Webhooks, id)
+	return nil
+}
+
+// migrateExpiryWebhook turns the deprecated webhook of the client expiry into a
+// webhook of the expiry events, saved with the next change of the configuration
+func (c *Config) migrateExpiryWebhook() {
+	if c.ClientExpiry.WebhookURL == "" {
+		return
+	}
+	if _, ok := c.Webhooks[expiryWebhookID]; !ok {
+		c.Webhooks[expiryWebhookID] = &Webhook{
+			ID:        expiryWebhookID,
+			Name:      "Client expiry",
+			URL:       c.ClientExpiry.WebhookURL,
+			Events:    []string{events.ClientExpiring, events.ClientExpired},
+			Enabled:   true,
+			CreatedAt: time.Now(),
+		}
+		logging.LogInfo("Moved clientExpiry.webhookUrl to webhook %s, set its secret to sign the events", expiryWebhookID)
+	}
+	c.ClientExpiry.WebhookURL = ""
+}
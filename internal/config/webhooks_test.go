@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestWebhook_Matches(t *testing.T) {
+	webhook := &Webhook{Events: []string{"client.", "peer.offline"}}
+	for eventType, want := range map[string]bool{"client.created": true, "peer.offline": true, "peer.online": false, "client": false} {
+		if got := webhook.Matches(eventType); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", eventType, got, want)
+		}
+	}
+	if !(&Webhook{}).Matches("reconcile.drift") {
+		t.Error("A webhook without filter should receive every event")
+	}
+}
+
+func TestMigrateExpiryWebhook(t *testing.T) {
+	cfg := &Config{Webhooks: make(map[string]*Webhook), ClientExpiry: ClientExpiryConfig{WebhookURL: "http://127.0.0.1:8080/expiry"}}
+	cfg.migrateExpiryWebhook()
+
+	webhook, err := cfg.GetWebhook(expiryWebhookID)
+	if err != nil || webhook.URL != "http://127.0.0.1:8080/expiry" || !webhook.Enabled ||
+		!webhook.Matches("client.expiring") || !webhook.Matches("client.expired") || webhook.Matches("client.created") {
+		t.Fatalf("Unexpected migrated webhook %+v, %v", webhook, err)
+	}
+	if cfg.ClientExpiry.WebhookURL != "" {
+		t.Error("The deprecated webhook should be cleared")
+	}
+}
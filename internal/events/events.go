@@ -0,0 +1,134 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"wg-panel/internal/logging"
+)
+
+// Types of the events
+const (
+	PeerHandshake       = "peer.handshake"
+	PeerOnline          = "peer.online"
+	PeerOffline         = "peer.offline"
+	PeerEndpoint        = "peer.endpoint"
+	ConfigChanged       = "config.changed"
+	SNATPrefixChanged   = "snat.prefix-changed"
+	PseudoBridgeStarted = "pseudo-bridge.started"
+	PseudoBridgeStopped = "pseudo-bridge.stopped"
+	ClientCreated       = "client.created"
+	ClientDeleted       = "client.deleted"
+	ClientEnabled       = "client.enabled"
+	ClientDisabled      = "client.disabled"
+	ClientExpiring      = "client.expiring"
+	ClientExpired       = "client.expired"
+	ClientQuotaExceeded = "client.quota-exceeded"
+	ReconcileDrift      = "reconcile.drift"
+)
+
+// subscriberBuffer is the number of events a subscriber may lag behind before it is dropped
+const subscriberBuffer = 64
+
+// Event is something which happened to the peers, the configuration or the network
+type Event struct {
+	ID          uint64                 `json:"id"`
+	Type        string                 `json:"type"`
+	Time        time.Time              `json:"time"`
+	InterfaceID string                 `json:"interfaceId,omitempty"`
+	ServerID    string                 `json:"serverId,omitempty"`
+	ClientID    string                 `json:"clientId,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// Bus delivers the published events to the subscribers, and keeps the last ones
+// for the subscribers resuming after a disconnection
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	keep        int
+	recent      []Event
+	subscribers map[chan Event]struct{}
+}
+
+func NewBus(keep int) *Bus {
+	return &Bus{
+		nextID:      1,
+		keep:        keep,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Default is the bus of the panel, global so the packages doing the work only have to publish
+var Default = NewBus(256)
+
+// Publish sends an event to the subscribers of the default bus
+func Publish(event Event) {
+	Default.Publish(event)
+}
+
+// Subscribe subscribes to the default bus
+func Subscribe(afterID uint64) (*Subscription, []Event) {
+	return Default.Subscribe(afterID)
+}
+
+// Publish numbers the event and sends it to the subscribers. A subscriber too slow
+// to take it is dropped, its channel is closed so it can subscribe again.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event.ID = b.nextID
+	b.nextID++
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.recent = append(b.recent, event)
+	if len(b.recent) > b.keep {
+		b.recent = b.recent[len(b.recent)-b.keep:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logging.LogVerbose("Dropping a slow event subscriber")
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	C   <-chan Event // Closed when the subscriber is dropped or closed
+	bus *Bus
+	ch  chan Event
+}
+
+// Subscribe returns a subscription, and the kept events after afterID for a
+// subscriber resuming after a disconnection
+func (b *Bus) Subscribe(afterID uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []Event
+	for _, event := range b.recent {
+		if afterID > 0 && event.ID > afterID {
+			missed = append(missed, event)
+		}
+	}
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	return &Subscription{C: ch, bus: b, ch: ch}, missed
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subscribers[s.ch]; ok {
+		delete(s.bus.subscribers, s.ch)
+		close(s.ch)
+	}
+}
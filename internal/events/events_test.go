@@ -0,0 +1,37 @@
+package events
+
+import "testing"
+
+func TestBus(t *testing.T) {
+	bus := NewBus(2)
+	bus.Publish(Event{Type: PeerOnline})
+
+	subscription, missed := bus.Subscribe(0)
+	if len(missed) != 0 {
+		t.Errorf("A new subscriber should miss nothing, got %+v", missed)
+	}
+	bus.Publish(Event{Type: PeerOffline})
+	if event := <-subscription.C; event.ID != 2 || event.Type != PeerOffline || event.Time.IsZero() {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	// A resuming subscriber gets the kept events after its last one
+	bus.Publish(Event{Type: PeerHandshake})
+	_, missed = bus.Subscribe(1)
+	if len(missed) != 2 || missed[0].ID != 2 || missed[1].ID != 3 {
+		t.Errorf("Expected the events 2 and 3, got %+v", missed)
+	}
+
+	// A subscriber not reading is dropped instead of blocking the others
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish(Event{Type: PeerHandshake})
+	}
+	count := 0
+	for range subscription.C {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("Expected %d events before the drop, got %d", subscriberBuffer, count)
+	}
+	subscription.Close()
+}
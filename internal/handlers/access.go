@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"wg-panel/internal/config"
+	"wg-panel/internal/models"
+)
+
+// keysVisible tells if the user may see the private and preshared keys of the
+// clients of a server, viewers only see the public keys
+func keysVisible(access config.Access, ifId, serverId string) bool {
+	return access.Allowed(config.RoleOperator, ifId, serverId)
+}
+
+// visibleClient removes the private and preshared keys of a client the user may not see
+func visibleClient(access config.Access, ifId, serverId string, client *models.ClientFrontend) *models.ClientFrontend {
+	if client != nil && !keysVisible(access, ifId, serverId) {
+		client.PrivateKey = nil
+		client.PresharedKey = nil
+	}
+	return client
+}
+
+// visibleServer returns the server without the keys of its clients if the user may not see them
+func visibleServer(access config.Access, ifId string, server *models.Server) *models.Server {
+	if keysVisible(access, ifId, server.ID) {
+		return server
+	}
+	result := *server
+	result.Clients = make([]*models.Client, len(server.Clients))
+	for i, client := range server.Clients {
+		clientCopy := *client
+		clientCopy.PrivateKey = nil
+		clientCopy.PresharedKey = nil
+		result.Clients[i] = &clientCopy
+	}
+	return &result
+}
+
+// visibleServers returns the servers of the interface the user may see
+func visibleServers(access config.Access, ifId string, servers []*models.Server) []*models.Server {
+	result := make([]*models.Server, 0, len(servers))
+	for _, server := range servers {
+		if access.CanView(ifId, server.ID) {
+			result = append(result, visibleServer(access, ifId, server))
+		}
+	}
+	return result
+}
+
+// visibleInterface returns the interface with only the servers the user may see,
+// without the client keys below operator and without its private key below an
+// admin of the interface
+func visibleInterface(access config.Access, iface *models.Interface) *models.Interface {
+	if access.Allowed(config.RoleAdmin, iface.ID, "") {
+		return iface
+	}
+	result := *iface
+	result.PrivateKey = ""
+	result.Servers = visibleServers(access, iface.ID, iface.Servers)
+	return &result
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"wg-panel/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+type AuditHandler struct {
+	service *services.AuditService
+}
+
+func NewAuditHandler(service *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		service: service,
+	}
+}
+
+// ListAuditEntries returns one page of the audit log, newest first
+func (h *AuditHandler) ListAuditEntries(c *gin.Context) {
+	filter := services.AuditFilter{
+		Actor:       c.Query("actor"),
+		Action:      c.Query("action"),
+		InterfaceID: c.Query("interfaceId"),
+		ServerID:    c.Query("serverId"),
+		ClientID:    c.Query("clientId"),
+	}
+	for _, param := range []struct {
+		name  string
+		value **time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if str := c.Query(param.name); str != "" {
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ", must be RFC 3339"})
+				return
+			}
+			*param.value = &t
+		}
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultAuditPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxAuditPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize, must be between 1 and " + strconv.Itoa(maxAuditPageSize)})
+		return
+	}
+
+	entries, total, err := h.service.Query(filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":  entries,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (h *AuditHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.ListAuditEntries)
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"net/http"
 
+	"wg-panel/internal/middleware"
+	"wg-panel/internal/models"
 	"wg-panel/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -10,11 +12,20 @@ import (
 
 type ClientHandler struct {
 	service *services.ClientService
+	quota   *services.QuotaService
 }
 
-func NewClientHandler(service *services.ClientService) *ClientHandler {
+// ClientResponse is a created or updated client. On a server with ephemeral private
+// keys it has the configuration with the private key, which can't be read again.
+type ClientResponse struct {
+	*models.ClientFrontend
+	Config string `json:"config,omitempty"`
+}
+
+func NewClientHandler(service *services.ClientService, quotaService *services.QuotaService) *ClientHandler {
 	return &ClientHandler{
 		service: service,
+		quota:   quotaService,
 	}
 }
 
@@ -44,7 +55,7 @@ func (h *ClientHandler) CreateClient(c *gin.Context) {
 		return
 	}
 	client_frontend, _ := h.service.ToClientFrontend(ifId, serverId, client)
-	c.JSON(http.StatusCreated, client_frontend)
+	c.JSON(http.StatusCreated, ClientResponse{client_frontend, h.service.EphemeralConfig(ifId, serverId, client)})
 }
 
 func (h *ClientHandler) GetClient(c *gin.Context) {
@@ -58,7 +69,7 @@ func (h *ClientHandler) GetClient(c *gin.Context) {
 		return
 	}
 	client_frontend, _ := h.service.ToClientFrontend(ifId, serverId, client)
-	c.JSON(http.StatusOK, client_frontend)
+	c.JSON(http.StatusOK, visibleClient(middleware.GetAccess(c), ifId, serverId, client_frontend))
 }
 
 func (h *ClientHandler) GetServerClients(c *gin.Context) {
@@ -71,6 +82,10 @@ func (h *ClientHandler) GetServerClients(c *gin.Context) {
 		return
 	}
 
+	access := middleware.GetAccess(c)
+	for _, client := range clients {
+		visibleClient(access, ifId, serverId, &client.ClientFrontend)
+	}
 	c.JSON(http.StatusOK, clients)
 }
 
@@ -97,7 +112,7 @@ func (h *ClientHandler) UpdateClient(c *gin.Context) {
 		return
 	}
 	client_frontend, _ := h.service.ToClientFrontend(ifId, serverId, client)
-	c.JSON(http.StatusOK, client_frontend)
+	c.JSON(http.StatusOK, ClientResponse{client_frontend, h.service.EphemeralConfig(ifId, serverId, client)})
 }
 
 func (h *ClientHandler) DeleteClient(c *gin.Context) {
@@ -105,7 +120,13 @@ func (h *ClientHandler) DeleteClient(c *gin.Context) {
 	serverId := c.Param("serverId")
 	clientId := c.Param("clientId")
 
-	err := h.service.DeleteClient(ifId, serverId, clientId)
+	var plan *services.ChangePlan
+	var err error
+	if isDryRun(c) {
+		plan, err = h.service.PlanDeleteClient(ifId, serverId, clientId)
+	} else {
+		err = h.service.DeleteClient(ifId, serverId, clientId)
+	}
 	if err != nil {
 		if err.Error() == "interface not found" ||
 			err.Error() == "server not found" ||
@@ -117,6 +138,11 @@ func (h *ClientHandler) DeleteClient(c *gin.Context) {
 		return
 	}
 
+	if plan != nil {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -133,7 +159,13 @@ func (h *ClientHandler) SetClientEnabled(c *gin.Context) {
 		return
 	}
 
-	err := h.service.SetClientEnabled(ifId, serverId, clientId, req.Enabled)
+	var plan *services.ChangePlan
+	var err error
+	if isDryRun(c) {
+		plan, err = h.service.PlanSetClientEnabled(ifId, serverId, clientId, req.Enabled)
+	} else {
+		err = h.service.SetClientEnabled(ifId, serverId, clientId, req.Enabled)
+	}
 	if err != nil {
 		if err.Error() == "interface not found" ||
 			err.Error() == "server not found" ||
@@ -145,9 +177,68 @@ func (h *ClientHandler) SetClientEnabled(c *gin.Context) {
 		return
 	}
 
+	if plan != nil {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
+// ExtendClient sets the expiry date of a client, and enables it again if it expired
+func (h *ClientHandler) ExtendClient(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	var req services.ClientExtendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if isDryRun(c) {
+		plan, err := h.service.PlanExtendClient(ifId, serverId, clientId, req)
+		if err != nil {
+			h.extendError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
+	client, err := h.service.ExtendClient(ifId, serverId, clientId, req)
+	if err != nil {
+		h.extendError(c, err)
+		return
+	}
+	client_frontend, _ := h.service.ToClientFrontend(ifId, serverId, client)
+	c.JSON(http.StatusOK, client_frontend)
+}
+
+func (h *ClientHandler) extendError(c *gin.Context, err error) {
+	if err.Error() == "interface not found" ||
+		err.Error() == "server not found" ||
+		err.Error() == "client not found" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// GetClientUsage returns the traffic of a client in its current quota period
+func (h *ClientHandler) GetClientUsage(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	usage, err := h.quota.GetClientUsage(ifId, serverId, clientId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
 
 func (h *ClientHandler) GetClientConfig(c *gin.Context) {
 	ifId := c.Param("ifId")
@@ -170,5 +261,7 @@ func (h *ClientHandler) RegisterRoutes(router *gin.RouterGroup) {
 	router.PUT("/clients/:clientId", h.UpdateClient)
 	router.DELETE("/clients/:clientId", h.DeleteClient)
 	router.POST("/clients/:clientId/set-enable", h.SetClientEnabled)
+	router.POST("/clients/:clientId/extend", h.ExtendClient)
 	router.GET("/clients/:clientId/config", h.GetClientConfig)
+	router.GET("/clients/:clientId/usage", h.GetClientUsage)
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/services"
+	"wg-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDownloadLinkTTL = time.Hour
+	maxDownloadLinkTTL     = 7 * 24 * time.Hour
+)
+
+// DownloadHandler mints single-use, expiring download links for the
+// configuration of the clients, and serves the downloads
+type DownloadHandler struct {
+	cfg     *config.Config
+	service *services.ClientService
+	audit   *services.AuditService
+}
+
+func NewDownloadHandler(cfg *config.Config, service *services.ClientService, audit *services.AuditService) *DownloadHandler {
+	return &DownloadHandler{
+		cfg:     cfg,
+		service: service,
+		audit:   audit,
+	}
+}
+
+type DownloadLinkCreateRequest struct {
+	ExpiresIn int `json:"expiresIn"` // Seconds, one hour by default
+}
+
+// ListLinks returns the pending download links of a client
+func (h *DownloadHandler) ListLinks(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	if _, err := h.cfg.GetClient(ifId, serverId, clientId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.cfg.ListDownloadLinks(ifId, serverId, clientId))
+}
+
+// CreateLink mints a signed download link for the configuration of a client
+func (h *DownloadHandler) CreateLink(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	var req DownloadLinkCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ttl := defaultDownloadLinkTTL
+	if req.ExpiresIn != 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 || ttl > maxDownloadLinkTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expiresIn must be between 1 and %d seconds", int(maxDownloadLinkTTL.Seconds()))})
+		return
+	}
+	if _, err := h.cfg.GetClient(ifId, serverId, clientId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+		return
+	}
+
+	id, err := utils.GenerateRandomString("", 16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate link"})
+		return
+	}
+	now := time.Now()
+	link := &config.DownloadLink{
+		ID:          id,
+		InterfaceID: ifId,
+		ServerID:    serverId,
+		ClientID:    clientId,
+		CreatedBy:   c.GetString("username"),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl).Truncate(time.Second),
+	}
+	signature, err := h.cfg.SignDownloadLink(link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.AddDownloadLink(link); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(link.ExpiresAt.Unix(), 10))
+	query.Set("signature", signature)
+	logging.LogInfo("Created download link %s for client %s by %s", link.ID, clientId, link.CreatedBy)
+	c.JSON(http.StatusCreated, gin.H{"path": h.apiPath() + "/download/" + link.ID + "?" + query.Encode(), "info": link})
+}
+
+// RevokeLink deletes a pending download link of a client
+func (h *DownloadHandler) RevokeLink(c *gin.Context) {
+	id := c.Param("linkId")
+
+	link, err := h.cfg.GetDownloadLink(id)
+	if err != nil || link.InterfaceID != c.Param("ifId") || link.ServerID != c.Param("serverId") || link.ClientID != c.Param("clientId") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "download link not found"})
+		return
+	}
+	if err := h.cfg.DeleteDownloadLink(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Download returns the configuration of the client of a download link, without
+// authentication. The link is used up by the download, even if it fails.
+func (h *DownloadHandler) Download(c *gin.Context) {
+	id := c.Param("linkId")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expires parameter"})
+		return
+	}
+
+	link, err := h.cfg.TakeDownloadLink(id, expires, c.Query("signature"))
+	if err != nil {
+		switch err.Error() {
+		case "download link not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found or already used"})
+		case "download link expired":
+			h.save()
+			c.JSON(http.StatusGone, gin.H{"error": "Link expired"})
+		case "invalid signature":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	h.save()
+
+	client, err := h.service.GetClient(link.InterfaceID, link.ServerID, link.ClientID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	text, err := h.service.GetClientConfig(link.InterfaceID, link.ServerID, link.ClientID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+
+	entry := &services.AuditEntry{
+		Time:        time.Now(),
+		Actor:       "download:" + link.ID,
+		SourceIP:    c.ClientIP(),
+		Action:      "client.download",
+		InterfaceID: link.InterfaceID,
+		ServerID:    link.ServerID,
+		ClientID:    link.ClientID,
+		Target:      link.ID,
+	}
+	if err := h.audit.Record(entry); err != nil {
+		logging.LogError("Failed to record %s in the audit log: %v", entry.Action, err)
+	}
+	logging.LogInfo("Client %s downloaded with link %s from %s", link.ClientID, link.ID, c.ClientIP())
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", `attachment; filename="`+client.Name+`.conf"`)
+	c.Data(http.StatusOK, "text/plain", []byte(text))
+}
+
+// save persists a used up link
+func (h *DownloadHandler) save() {
+	if err := h.cfg.Save(); err != nil {
+		logging.LogError("Failed to save used download link: %v", err)
+	}
+}
+
+// apiPath returns the path of the API, as given to the frontend
+func (h *DownloadHandler) apiPath() string {
+	basePath := h.cfg.BasePath
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	return strings.TrimSuffix(basePath[:len(basePath)-1]+h.cfg.APIPrefix, "/")
+}
+
+// RegisterLinkRoutes registers the management of the download links, under the clients of a server
+func (h *DownloadHandler) RegisterLinkRoutes(router *gin.RouterGroup) {
+	router.GET("/clients/:clientId/download-links", h.ListLinks)
+	router.POST("/clients/:clientId/download-links", h.CreateLink)
+	router.DELETE("/clients/:clientId/download-links/:linkId", h.RevokeLink)
+}
+
+// RegisterRoutes registers the downloads, which the signature of the link authenticates
+func (h *DownloadHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/:linkId", h.Download)
+}
@@ -0,0 +1,8 @@
+package handlers
+
+import "github.com/gin-gonic/gin"
+
+// isDryRun tells if the request asks for the plan of a change instead of applying it
+func isDryRun(c *gin.Context) bool {
+	return c.Query("dryRun") == "true"
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventKeepAlive is the interval of the comments keeping idle streams open through proxies
+const eventKeepAlive = 15 * time.Second
+
+type EventHandler struct{}
+
+func NewEventHandler() *EventHandler {
+	return &EventHandler{}
+}
+
+// StreamEvents streams the events the user may see as Server-Sent Events. The
+// optional types parameter is a comma separated list of types or prefixes such as
+// "peer.". A reconnecting client gets the events it missed after its Last-Event-ID.
+func (h *EventHandler) StreamEvents(c *gin.Context) {
+	access := middleware.GetAccess(c)
+	var types []string
+	if str := c.Query("types"); str != "" {
+		types = strings.Split(str, ",")
+	}
+	var lastID uint64
+	if str := c.GetHeader("Last-Event-ID"); str != "" {
+		id, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		lastID = id
+	}
+
+	subscription, missed := events.Subscribe(lastID)
+	defer subscription.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range missed {
+		writeEvent(c, access, types, event)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-subscription.C:
+			if !ok {
+				// Too slow, the client reconnects and resumes after its last event
+				return
+			}
+			writeEvent(c, access, types, event)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes an event in the stream if it has one of the types and the user may see it
+func writeEvent(c *gin.Context, access config.Access, types []string, event events.Event) {
+	if !eventVisible(access, event) || !eventMatches(types, event.Type) {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
+// eventVisible tells if the user may see the interface or the server of an event.
+// Events of the host's network need a global role, and changes of the users, the
+// tokens or the revisions the admin role managing them.
+func eventVisible(access config.Access, event events.Event) bool {
+	if event.InterfaceID == "" && event.Type == events.ConfigChanged {
+		return access.Allowed(config.RoleAdmin, "", "")
+	}
+	if event.InterfaceID == "" {
+		return access.Allowed(config.RoleViewer, "", "")
+	}
+	return access.CanView(event.InterfaceID, event.ServerID)
+}
+
+func eventMatches(types []string, eventType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == eventType || (strings.HasSuffix(t, ".") && strings.HasPrefix(eventType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *EventHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.StreamEvents)
+}
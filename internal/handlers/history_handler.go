@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/middleware"
+	"wg-panel/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHistoryRange is the range of a series without since
+const defaultHistoryRange = 24 * time.Hour
+
+type HistoryHandler struct {
+	cfg     *config.Config
+	service *services.HistoryService
+}
+
+func NewHistoryHandler(cfg *config.Config, service *services.HistoryService) *HistoryHandler {
+	return &HistoryHandler{
+		cfg:     cfg,
+		service: service,
+	}
+}
+
+// GetHistory returns the traffic history of an interface, a server or a client,
+// by default for the last day
+func (h *HistoryHandler) GetHistory(c *gin.Context) {
+	filter := services.HistoryFilter{
+		InterfaceID: c.Param("ifId"),
+		ServerID:    c.Param("serverId"),
+		ClientID:    c.Param("clientId"),
+	}
+	until := time.Now()
+	since := until.Add(-defaultHistoryRange)
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if str := c.Query(param.name); str != "" {
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ", must be RFC 3339"})
+				return
+			}
+			*param.value = t
+		}
+	}
+	if !until.After(since) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until must be after since"})
+		return
+	}
+	resolution := c.Query("resolution")
+	if resolution != "" && !services.IsHistoryResolution(resolution) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution, must be minute, hour or day"})
+		return
+	}
+
+	// Users bound to some servers of the interface only see the traffic of these servers
+	access := middleware.GetAccess(c)
+	if filter.ServerID == "" && !access.Allowed(config.RoleViewer, filter.InterfaceID, "") {
+		servers, err := h.cfg.GetAllServers(filter.InterfaceID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+			return
+		}
+		filter.ServerIDs = []string{}
+		for _, server := range visibleServers(access, filter.InterfaceID, servers) {
+			filter.ServerIDs = append(filter.ServerIDs, server.ID)
+		}
+	}
+
+	series, err := h.service.Query(filter, resolution, since, until)
+	if err != nil {
+		if err.Error() == "interface not found" || err.Error() == "server not found" || err.Error() == "client not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
+
+// RegisterRoutes registers the history of the interfaces, servers and clients under the interfaces
+func (h *HistoryHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/:ifId/history", h.GetHistory)
+	router.GET("/:ifId/servers/:serverId/history", h.GetHistory)
+	router.GET("/:ifId/servers/:serverId/clients/:clientId/history", h.GetHistory)
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"net/http"
 
+	"wg-panel/internal/middleware"
+	"wg-panel/internal/models"
 	"wg-panel/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -47,11 +49,17 @@ func (h *InterfaceHandler) GetInterface(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, iface)
+	c.JSON(http.StatusOK, visibleInterface(middleware.GetAccess(c), iface))
 }
 
 func (h *InterfaceHandler) ListInterfaces(c *gin.Context) {
-	interfaces := h.service.GetAllInterfaces()
+	access := middleware.GetAccess(c)
+	interfaces := make([]*models.Interface, 0)
+	for _, iface := range h.service.GetAllInterfaces() {
+		if access.CanView(iface.ID, "") {
+			interfaces = append(interfaces, visibleInterface(access, iface))
+		}
+	}
 	c.JSON(http.StatusOK, interfaces)
 }
 
@@ -64,7 +72,13 @@ func (h *InterfaceHandler) UpdateInterface(c *gin.Context) {
 		return
 	}
 
-	iface, err := h.service.UpdateInterface(ifId, req)
+	var result interface{}
+	var err error
+	if isDryRun(c) {
+		result, err = h.service.PlanUpdateInterface(ifId, req)
+	} else {
+		result, err = h.service.UpdateInterface(ifId, req)
+	}
 	if err != nil {
 		if err.Error() == "interface not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -78,7 +92,7 @@ func (h *InterfaceHandler) UpdateInterface(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, iface)
+	c.JSON(http.StatusOK, result)
 }
 
 func (h *InterfaceHandler) SetInterfaceEnabled(c *gin.Context) {
@@ -92,7 +106,13 @@ func (h *InterfaceHandler) SetInterfaceEnabled(c *gin.Context) {
 		return
 	}
 
-	err := h.service.SetInterfaceEnabled(ifId, req.Enabled)
+	var plan *services.ChangePlan
+	var err error
+	if isDryRun(c) {
+		plan, err = h.service.PlanSetInterfaceEnabled(ifId, req.Enabled)
+	} else {
+		err = h.service.SetInterfaceEnabled(ifId, req.Enabled)
+	}
 	if err != nil {
 		if err.Error() == "interface not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Server or Interface not found"})
@@ -102,13 +122,24 @@ func (h *InterfaceHandler) SetInterfaceEnabled(c *gin.Context) {
 		return
 	}
 
+	if plan != nil {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
 func (h *InterfaceHandler) DeleteInterface(c *gin.Context) {
 	ifId := c.Param("ifId")
 
-	err := h.service.DeleteInterface(ifId)
+	var plan *services.ChangePlan
+	var err error
+	if isDryRun(c) {
+		plan, err = h.service.PlanDeleteInterface(ifId)
+	} else {
+		err = h.service.DeleteInterface(ifId)
+	}
 	if err != nil {
 		if err.Error() == "interface not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -118,6 +149,11 @@ func (h *InterfaceHandler) DeleteInterface(c *gin.Context) {
 		return
 	}
 
+	if plan != nil {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -134,6 +170,17 @@ func (h *InterfaceHandler) GetInterfaceClientsState(c *gin.Context) {
 		return
 	}
 
+	access := middleware.GetAccess(c)
+	for serverId, clients := range clientsState {
+		if !access.CanView(ifId, serverId) {
+			delete(clientsState, serverId)
+			continue
+		}
+		for _, client := range clients {
+			visibleClient(access, ifId, serverId, &client.ClientFrontend)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"state": clientsState, "timestamp": milliseconds})
 }
 
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsContentType is the version 0.0.4 of the Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type MetricsHandler struct {
+	cfg     *config.Config
+	service *services.MetricsService
+}
+
+func NewMetricsHandler(cfg *config.Config, service *services.MetricsService) *MetricsHandler {
+	return &MetricsHandler{
+		cfg:     cfg,
+		service: service,
+	}
+}
+
+// GetMetrics returns the metrics, with the bearer token of the configuration when it has one
+func (h *MetricsHandler) GetMetrics(c *gin.Context) {
+	if token := h.cfg.Metrics.Token; token != "" {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	h.service.WriteMetrics(&buf, time.Now())
+	c.Data(http.StatusOK, metricsContentType, buf.Bytes())
+}
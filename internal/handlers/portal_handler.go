@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/services"
+	"wg-panel/internal/utils"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gin-gonic/gin"
+)
+
+// PortalHandler manages the portal links of the clients, and serves the
+// self-service portal opened with them
+type PortalHandler struct {
+	cfg     *config.Config
+	service *services.ClientService
+}
+
+func NewPortalHandler(cfg *config.Config, service *services.ClientService) *PortalHandler {
+	return &PortalHandler{
+		cfg:     cfg,
+		service: service,
+	}
+}
+
+type PortalLinkCreateRequest struct {
+	OneTime   bool       `json:"oneTime"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// ListLinks returns the portal links of a client
+func (h *PortalHandler) ListLinks(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	if _, err := h.cfg.GetClient(ifId, serverId, clientId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.cfg.ListPortalLinks(ifId, serverId, clientId))
+}
+
+// CreateLink creates a portal link for a client, the token is only returned here
+func (h *PortalHandler) CreateLink(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	var req PortalLinkCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry is in the past"})
+		return
+	}
+	if _, err := h.cfg.GetClient(ifId, serverId, clientId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client, Server, or Interface not found"})
+		return
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate link"})
+		return
+	}
+	id, err := utils.GenerateRandomString("", 8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate link"})
+		return
+	}
+
+	link := &config.PortalLink{
+		ID:          id,
+		Hash:        config.HashAPIToken(token),
+		InterfaceID: ifId,
+		ServerID:    serverId,
+		ClientID:    clientId,
+		CreatedBy:   c.GetString("username"),
+		CreatedAt:   time.Now(),
+		ExpiresAt:   req.ExpiresAt,
+		OneTime:     req.OneTime,
+	}
+	if err := h.cfg.AddPortalLink(link); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Created portal link %s for client %s by %s", link.ID, clientId, link.CreatedBy)
+	result := *link
+	result.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{"token": token, "path": h.portalPath() + "#" + token, "info": result})
+}
+
+// RevokeLink deletes a portal link of a client
+func (h *PortalHandler) RevokeLink(c *gin.Context) {
+	id := c.Param("linkId")
+
+	link, err := h.cfg.GetPortalLink(id)
+	if err != nil || link.InterfaceID != c.Param("ifId") || link.ServerID != c.Param("serverId") || link.ClientID != c.Param("clientId") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "portal link not found"})
+		return
+	}
+	if err := h.cfg.DeletePortalLink(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Revoked portal link %s of client %s", id, link.ClientID)
+	c.Status(http.StatusNoContent)
+}
+
+// portalPath returns the path of the portal page
+func (h *PortalHandler) portalPath() string {
+	basePath := h.cfg.BasePath
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	return basePath + "portal"
+}
+
+// GetClient returns the name, addresses and WireGuard state of the client of the
+// portal session, without its keys
+func (h *PortalHandler) GetClient(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	client, err := h.service.GetClient(ifId, serverId, clientId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	frontend, err := h.service.ToClientFrontend(ifId, serverId, client)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	state, err := h.service.GetClientWGState(ifId, serverId, clientId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":      frontend.Name,
+		"enabled":   frontend.Enabled,
+		"ip":        frontend.IPv4,
+		"ipv6":      frontend.IPv6,
+		"publicKey": frontend.PublicKey,
+		"state":     state,
+	})
+}
+
+// GetConfig returns the wg-quick configuration of the client of the portal session
+func (h *PortalHandler) GetConfig(c *gin.Context) {
+	text, name, ok := h.clientConfig(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.conf"`)
+	c.Data(http.StatusOK, "text/plain", []byte(text))
+}
+
+// GetQRCode returns the wg-quick configuration of the client of the portal session as a QR code
+func (h *PortalHandler) GetQRCode(c *gin.Context) {
+	text, _, ok := h.clientConfig(c)
+	if !ok {
+		return
+	}
+	code, err := qr.Encode(text, qr.M, qr.Auto)
+	if err == nil {
+		code, err = barcode.Scale(code, 512, 512)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	var image bytes.Buffer
+	if err := png.Encode(&image, code); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", image.Bytes())
+}
+
+// clientConfig returns the configuration and the file name of the client of the portal session
+func (h *PortalHandler) clientConfig(c *gin.Context) (string, string, bool) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	client, err := h.service.GetClient(ifId, serverId, clientId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return "", "", false
+	}
+	text, err := h.service.GetClientConfig(ifId, serverId, clientId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return "", "", false
+	}
+	return text, client.Name, true
+}
+
+// RegenerateKeys replaces the keypair of the client of the portal session, the
+// previous configuration stops working. On a server with ephemeral private keys the
+// new configuration is only returned here.
+func (h *PortalHandler) RegenerateKeys(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+	clientId := c.Param("clientId")
+
+	client, err := h.service.RegenerateKeys(ifId, serverId, clientId)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logging.LogInfo("Regenerated keys of client %s from the portal", clientId)
+	c.JSON(http.StatusOK, gin.H{"publicKey": client.PublicKey, "config": h.service.EphemeralConfig(ifId, serverId, client)})
+}
+
+// RegisterLinkRoutes registers the management of the portal links, under the clients of a server
+func (h *PortalHandler) RegisterLinkRoutes(router *gin.RouterGroup) {
+	router.GET("/clients/:clientId/portal-links", h.ListLinks)
+	router.POST("/clients/:clientId/portal-links", h.CreateLink)
+	router.DELETE("/clients/:clientId/portal-links/:linkId", h.RevokeLink)
+}
+
+// RegisterRoutes registers the portal, its group must run RequirePortal
+func (h *PortalHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/client", h.GetClient)
+	router.GET("/config", h.GetConfig)
+	router.GET("/qr", h.GetQRCode)
+	router.POST("/regenerate-keys", h.RegenerateKeys)
+}
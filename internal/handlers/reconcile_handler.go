@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/middleware"
+	"wg-panel/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReconcileHandler struct {
+	cfg     *config.Config
+	service *services.ReconcileService
+}
+
+func NewReconcileHandler(cfg *config.Config, service *services.ReconcileService) *ReconcileHandler {
+	return &ReconcileHandler{
+		cfg:     cfg,
+		service: service,
+	}
+}
+
+// GetReconcileStatus returns the reconciliation settings and the report of the last run
+func (h *ReconcileHandler) GetReconcileStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":         h.cfg.Reconcile.Enabled,
+		"intervalSeconds": h.cfg.Reconcile.IntervalSeconds,
+		"autoRepair":      h.cfg.Reconcile.AutoRepair,
+		"lastReport":      h.service.LastReport(),
+	})
+}
+
+// RunReconcile checks for drift now, and repairs it with ?repair=true
+func (h *ReconcileHandler) RunReconcile(c *gin.Context) {
+	if !middleware.GetAccess(c).Allowed(config.RoleAdmin, "", "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	repair := c.Query("repair") == "true"
+	c.JSON(http.StatusOK, h.service.Run(repair))
+}
+
+func (h *ReconcileHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.GetReconcileStatus)
+	router.POST("", h.RunReconcile)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/services"
+	"wg-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RevisionHandler struct {
+	cfg     *config.Config
+	service *services.RevisionService
+}
+
+func NewRevisionHandler(cfg *config.Config, service *services.RevisionService) *RevisionHandler {
+	return &RevisionHandler{
+		cfg:     cfg,
+		service: service,
+	}
+}
+
+func (h *RevisionHandler) ListRevisions(c *gin.Context) {
+	revisions, err := h.cfg.ListRevisions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// GetRevision returns a revision with its interfaces, without their keys
+func (h *RevisionHandler) GetRevision(c *gin.Context) {
+	number, ok := revisionParam(c, c.Param("revision"))
+	if !ok {
+		return
+	}
+
+	revision, err := h.cfg.GetRevision(number)
+	if err != nil {
+		h.writeRevisionError(c, err)
+		return
+	}
+	interfaces, err := revision.DecodeInterfaces()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	revision.Interfaces, err = utils.RedactSecrets(interfaces)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffRevisions returns the diff between the revisions ?from= and ?to=
+func (h *RevisionHandler) DiffRevisions(c *gin.Context) {
+	from, ok := revisionParam(c, c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := revisionParam(c, c.Query("to"))
+	if !ok {
+		return
+	}
+
+	diff, err := h.service.Diff(from, to)
+	if err != nil {
+		h.writeRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "diff": diff})
+}
+
+// Rollback applies a revision again, or returns the change plan with ?dryRun=true
+func (h *RevisionHandler) Rollback(c *gin.Context) {
+	number, ok := revisionParam(c, c.Param("revision"))
+	if !ok {
+		return
+	}
+
+	if isDryRun(c) {
+		plan, err := h.service.PlanRollback(number)
+		if err != nil {
+			h.writeRevisionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
+	h.cfg.BeginRevision(c.GetString("username"), fmt.Sprintf("Rollback to revision %d", number))
+	defer h.cfg.EndRevision()
+	if err := h.service.Rollback(number); err != nil {
+		h.writeRevisionError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *RevisionHandler) writeRevisionError(c *gin.Context, err error) {
+	if err.Error() == "revision not found" {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func revisionParam(c *gin.Context, value string) (int, bool) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return 0, false
+	}
+	return number, true
+}
+
+func (h *RevisionHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.ListRevisions)
+	router.GET("/diff", h.DiffRevisions)
+	router.GET("/:revision", h.GetRevision)
+	router.POST("/:revision/rollback", h.Rollback)
+}
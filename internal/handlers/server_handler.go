@@ -3,7 +3,9 @@ package handlers
 import (
 	"net/http"
 
+	"wg-panel/internal/config"
 	"wg-panel/internal/logging"
+	"wg-panel/internal/middleware"
 	"wg-panel/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -66,7 +68,7 @@ func (h *ServerHandler) GetServer(c *gin.Context) {
 	}
 
 	logging.LogVerbose("Retrieved server %s (%s) for interface %s", server.Name, serverId, ifId)
-	c.JSON(http.StatusOK, server)
+	c.JSON(http.StatusOK, visibleServer(middleware.GetAccess(c), ifId, server))
 }
 
 func (h *ServerHandler) ListServers(c *gin.Context) {
@@ -78,7 +80,7 @@ func (h *ServerHandler) ListServers(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, servers)
+	c.JSON(http.StatusOK, visibleServers(middleware.GetAccess(c), ifId, servers))
 }
 
 func (h *ServerHandler) UpdateServer(c *gin.Context) {
@@ -93,18 +95,20 @@ func (h *ServerHandler) UpdateServer(c *gin.Context) {
 		return
 	}
 
+	if isDryRun(c) {
+		plan, err := h.service.PlanUpdateServer(ifId, serverId, req)
+		if err != nil {
+			h.writeUpdateServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	server, err := h.service.UpdateServer(ifId, serverId, req)
 	if err != nil {
 		logging.LogError("Failed to update server %s for interface %s: %v", serverId, ifId, err)
-		if err.Error() == "interface not found" || err.Error() == "server not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Server or Interface not found"})
-			return
-		}
-		if err.Error() == "network overlaps with existing server network in VRF" {
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.writeUpdateServerError(c, err)
 		return
 	}
 
@@ -112,12 +116,30 @@ func (h *ServerHandler) UpdateServer(c *gin.Context) {
 	c.JSON(http.StatusOK, server)
 }
 
+func (h *ServerHandler) writeUpdateServerError(c *gin.Context, err error) {
+	if err.Error() == "interface not found" || err.Error() == "server not found" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server or Interface not found"})
+		return
+	}
+	if err.Error() == "network overlaps with existing server network in VRF" {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
 func (h *ServerHandler) DeleteServer(c *gin.Context) {
 	ifId := c.Param("ifId")
 	serverId := c.Param("serverId")
 	logging.LogInfo("Deleting server %s for interface %s", serverId, ifId)
 
-	err := h.service.DeleteServer(ifId, serverId)
+	var plan *services.ChangePlan
+	var err error
+	if isDryRun(c) {
+		plan, err = h.service.PlanDeleteServer(ifId, serverId)
+	} else {
+		err = h.service.DeleteServer(ifId, serverId)
+	}
 	if err != nil {
 		logging.LogError("Failed to delete server %s for interface %s: %v", serverId, ifId, err)
 		if err.Error() == "interface not found" || err.Error() == "server not found" {
@@ -128,6 +150,11 @@ func (h *ServerHandler) DeleteServer(c *gin.Context) {
 		return
 	}
 
+	if plan != nil {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	logging.LogInfo("Successfully deleted server %s for interface %s", serverId, ifId)
 	c.Status(http.StatusNoContent)
 }
@@ -146,7 +173,13 @@ func (h *ServerHandler) SetServerEnabled(c *gin.Context) {
 		return
 	}
 
-	err := h.service.SetServerEnabled(ifId, serverId, req.Enabled, true)
+	var plan *services.ChangePlan
+	var err error
+	if isDryRun(c) {
+		plan, err = h.service.PlanSetServerEnabled(ifId, serverId, req.Enabled)
+	} else {
+		err = h.service.SetServerEnabled(ifId, serverId, req.Enabled, true)
+	}
 	if err != nil {
 		logging.LogError("Failed to set server %s enabled=%t for interface %s: %v", serverId, req.Enabled, ifId, err)
 		if err.Error() == "interface not found" || err.Error() == "server not found" {
@@ -157,10 +190,32 @@ func (h *ServerHandler) SetServerEnabled(c *gin.Context) {
 		return
 	}
 
+	if plan != nil {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
 	logging.LogInfo("Successfully set server %s enabled=%t for interface %s", serverId, req.Enabled, ifId)
 	c.Status(http.StatusNoContent)
 }
 
+// PurgePrivateKeys deletes the stored private keys of every client of a server
+func (h *ServerHandler) PurgePrivateKeys(c *gin.Context) {
+	ifId := c.Param("ifId")
+	serverId := c.Param("serverId")
+
+	purged, err := h.service.PurgePrivateKeys(ifId, serverId)
+	if err != nil {
+		if err.Error() == "interface not found" || err.Error() == "server not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Server or Interface not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
 func (h *ServerHandler) MoveServer(c *gin.Context) {
 	ifId := c.Param("ifId")
 	serverId := c.Param("serverId")
@@ -173,6 +228,11 @@ func (h *ServerHandler) MoveServer(c *gin.Context) {
 		return
 	}
 
+	if !middleware.GetAccess(c).Allowed(config.RoleAdmin, req.NewInterfaceId, "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
 	err := h.service.MoveServer(ifId, serverId, req.NewInterfaceId)
 	if err != nil {
 		if err.Error() == "source interface not found" ||
@@ -196,4 +256,5 @@ func (h *ServerHandler) RegisterRoutes(router *gin.RouterGroup) {
 	router.DELETE("/:serverId", h.DeleteServer)
 	router.POST("/:serverId/set-enable", h.SetServerEnabled)
 	router.POST("/:serverId/move", h.MoveServer)
+	router.POST("/:serverId/purge-private-keys", h.PurgePrivateKeys)
 }
@@ -27,7 +27,8 @@ func NewServiceHandler(cfg *config.Config, auth *middleware.AuthMiddleware) *Ser
 func (h *ServiceHandler) GetServiceConfig(c *gin.Context) {
 	response := map[string]interface{}{
 		"wireguardConfigPath": h.cfg.WireGuardConfigPath,
-		"user":                h.cfg.User,
+		"user":                c.GetString("username"),
+		"access":              middleware.GetAccess(c),
 		"listenIP":            h.cfg.ListenIP,
 		"listenPort":          h.cfg.ListenPort,
 		"siteUrlPrefix":       h.cfg.BasePath,
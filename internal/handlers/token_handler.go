@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/middleware"
+	"wg-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenHandler struct {
+	cfg *config.Config
+}
+
+func NewTokenHandler(cfg *config.Config) *TokenHandler {
+	return &TokenHandler{
+		cfg: cfg,
+	}
+}
+
+type TokenCreateRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	config.Access
+}
+
+// ListTokens returns the tokens of the user, global admins see every token
+func (h *TokenHandler) ListTokens(c *gin.Context) {
+	owner := c.GetString("username")
+	if middleware.GetAccess(c).Allowed(config.RoleAdmin, "", "") {
+		owner = ""
+	}
+	c.JSON(http.StatusOK, h.cfg.ListAPITokens(owner))
+}
+
+// CreateToken creates a token with a scope within the roles of the user, the token is only returned here
+func (h *TokenHandler) CreateToken(c *gin.Context) {
+	var req TokenCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" && len(req.Bindings) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token requires a role or role bindings"})
+		return
+	}
+	if err := validateAccess(h.cfg, req.Access); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !middleware.GetAccess(c).Covers(req.Access) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token scope exceeds your roles"})
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry is in the past"})
+		return
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	id, err := utils.GenerateRandomString("", 8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	apiToken := &config.APIToken{
+		ID:        id,
+		Name:      req.Name,
+		Hash:      config.HashAPIToken(token),
+		Owner:     c.GetString("username"),
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+		Access:    req.Access,
+	}
+	if err := h.cfg.AddAPIToken(apiToken); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Created API token %s (%s) for user %s", apiToken.Name, apiToken.ID, apiToken.Owner)
+	result := *apiToken
+	result.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{"token": token, "info": result})
+}
+
+// RevokeToken deletes a token of the user, global admins can revoke any token
+func (h *TokenHandler) RevokeToken(c *gin.Context) {
+	id := c.Param("tokenId")
+
+	apiToken, err := h.cfg.GetAPIToken(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if apiToken.Owner != c.GetString("username") && !middleware.GetAccess(c).Allowed(config.RoleAdmin, "", "") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+
+	if err := h.cfg.DeleteAPIToken(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Revoked API token %s (%s)", apiToken.Name, apiToken.ID)
+	c.Status(http.StatusNoContent)
+}
+
+func generateAPIToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes:-> %v", err)
+	}
+	return "wgp_" + hex.EncodeToString(bytes), nil
+}
+
+func (h *TokenHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.ListTokens)
+	router.POST("", h.CreateToken)
+	router.DELETE("/:tokenId", h.RevokeToken)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"net/http"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TwoFactorHandler lets the logged in user manage their TOTP second factor
+type TwoFactorHandler struct {
+	cfg *config.Config
+}
+
+func NewTwoFactorHandler(cfg *config.Config) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		cfg: cfg,
+	}
+}
+
+type TwoFactorEnrollRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP or recovery code
+}
+
+func (h *TwoFactorHandler) GetStatus(c *gin.Context) {
+	enabled, recoveryCodes := h.cfg.TwoFactorStatus(c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "recoveryCodesLeft": recoveryCodes})
+}
+
+// Enroll generates a secret and returns it with its otpauth URL and QR code,
+// two-factor authentication is enabled once a code is confirmed
+func (h *TwoFactorHandler) Enroll(c *gin.Context) {
+	username := c.GetString("username")
+	var req TwoFactorEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkPassword(username, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	key, err := h.cfg.BeginTwoFactor(username, h.cfg.WGPanelTitle)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	image, err := key.Image(256, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	var qrCode bytes.Buffer
+	if err := png.Encode(&qrCode, image); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": key.Secret(),
+		"url":    key.URL(),
+		"qrCode": "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode.Bytes()),
+	})
+}
+
+// Confirm enables two-factor authentication with a code of the enrolled secret, and returns the recovery codes
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	username := c.GetString("username")
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.cfg.ConfirmTwoFactor(username, req.Code)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "invalid code":
+			status = http.StatusBadRequest
+		case "no pending enrollment":
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Enabled two-factor authentication of user %s", username)
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, the current ones stop working
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	username := c.GetString("username")
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.cfg.VerifyTwoFactor(username, req.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, err := h.cfg.RegenerateRecoveryCodes(username)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// Disable turns two-factor authentication off, with the password and a code
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	username := c.GetString("username")
+	var req TwoFactorDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkPassword(username, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	}
+	if !h.cfg.VerifyTwoFactor(username, req.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := h.cfg.DisableTwoFactor(username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Disabled two-factor authentication of user %s", username)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TwoFactorHandler) checkPassword(username, password string) bool {
+	passwordHash, ok := h.cfg.GetPasswordHash(username)
+	return ok && bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+func (h *TwoFactorHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.GetStatus)
+	router.POST("/enroll", h.Enroll)
+	router.POST("/confirm", h.Confirm)
+	router.POST("/recovery-codes", h.RegenerateRecoveryCodes)
+	router.DELETE("", h.Disable)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserHandler struct {
+	cfg *config.Config
+}
+
+func NewUserHandler(cfg *config.Config) *UserHandler {
+	return &UserHandler{
+		cfg: cfg,
+	}
+}
+
+type UserCreateRequest struct {
+	Username    string `json:"username" binding:"required"`
+	Password    string `json:"password"`
+	OIDCSubject string `json:"oidcSubject"` // Links the user to an account of the OpenID Connect provider
+	config.Access
+}
+
+type UserUpdateRequest struct {
+	Password    *string               `json:"password"`
+	OIDCSubject *string               `json:"oidcSubject"` // Empty to unlink the user
+	Role        *string               `json:"role"`
+	Bindings    *[]config.RoleBinding `json:"bindings"`
+}
+
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, h.cfg.ListUsers())
+}
+
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req UserCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Password == "" && req.OIDCSubject == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A password or an OpenID Connect subject is required"})
+		return
+	}
+	if err := validateAccess(h.cfg, req.Access); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		passwordHash = string(hashedPassword)
+	}
+
+	user := &config.User{
+		Password:    passwordHash,
+		CreatedAt:   time.Now(),
+		OIDCSubject: req.OIDCSubject,
+		Access:      req.Access,
+	}
+	if err := h.cfg.AddUser(req.Username, user); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Created user %s", req.Username)
+	c.JSON(http.StatusCreated, config.UserInfo{Username: req.Username, CreatedAt: user.CreatedAt, SSO: user.OIDCSubject != "", Access: user.Access})
+}
+
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	username := c.Param("username")
+
+	var req UserUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.cfg.GetUser(username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := user.Access
+	if req.Role != nil {
+		access.Role = *req.Role
+	}
+	if req.Bindings != nil {
+		access.Bindings = *req.Bindings
+	}
+	if err := validateAccess(h.cfg, access); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	passwordHash := user.Password
+	if req.Password != nil {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		passwordHash = string(hashedPassword)
+	}
+	oidcSubject := user.OIDCSubject
+	if req.OIDCSubject != nil {
+		oidcSubject = *req.OIDCSubject
+	}
+	if passwordHash == "" && oidcSubject == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A password or an OpenID Connect subject is required"})
+		return
+	}
+
+	updated := &config.User{
+		Password:    passwordHash,
+		CreatedAt:   user.CreatedAt,
+		TwoFactor:   user.TwoFactor,
+		OIDCSubject: oidcSubject,
+		Access:      access,
+	}
+	if err := h.cfg.ReplaceUser(username, updated); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Updated user %s", username)
+	c.JSON(http.StatusOK, config.UserInfo{Username: username, CreatedAt: updated.CreatedAt, TwoFactor: updated.TwoFactor.Active(), SSO: updated.OIDCSubject != "", Access: updated.Access})
+}
+
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+
+	if err := h.cfg.DeleteUser(username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Deleted user %s", username)
+	c.Status(http.StatusNoContent)
+}
+
+// ResetTwoFactor disables two-factor authentication of a user who lost their device
+func (h *UserHandler) ResetTwoFactor(c *gin.Context) {
+	username := c.Param("username")
+
+	if err := h.cfg.DisableTwoFactor(username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Reset two-factor authentication of user %s", username)
+	c.Status(http.StatusNoContent)
+}
+
+// validateAccess checks the roles and that the bound interfaces and servers exist
+func validateAccess(cfg *config.Config, access config.Access) error {
+	if err := access.Validate(); err != nil {
+		return err
+	}
+	for _, binding := range access.Bindings {
+		if cfg.GetInterface(binding.InterfaceID) == nil {
+			return fmt.Errorf("interface %s not found", binding.InterfaceID)
+		}
+		if binding.ServerID != "" {
+			if _, err := cfg.GetServer(binding.InterfaceID, binding.ServerID); err != nil {
+				return fmt.Errorf("server %s not found in interface %s", binding.ServerID, binding.InterfaceID)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *UserHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.ListUsers)
+	router.POST("", h.CreateUser)
+	router.PUT("/:username", h.UpdateUser)
+	router.DELETE("/:username", h.DeleteUser)
+	router.DELETE("/:username/2fa", h.ResetTwoFactor)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/services"
+	"wg-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	cfg     *config.Config
+	service *services.WebhookService
+}
+
+func NewWebhookHandler(cfg *config.Config, webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		cfg:     cfg,
+		service: webhookService,
+	}
+}
+
+type WebhookRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	URL     string   `json:"url" binding:"required"`
+	Secret  string   `json:"secret"` // Generated on creation and kept on update when empty
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"` // Defaults to true on creation
+}
+
+// validate checks the URL and the event filter of the request
+func (r *WebhookRequest) validate() error {
+	target, err := url.Parse(r.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	for _, eventType := range r.Events {
+		if strings.TrimSpace(eventType) == "" {
+			return fmt.Errorf("event filter can't contain empty types")
+		}
+	}
+	return nil
+}
+
+// ListWebhooks returns the webhooks without their secret
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	c.JSON(http.StatusOK, h.cfg.ListWebhooks())
+}
+
+// CreateWebhook registers a webhook, the secret is only returned here
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		generated, err := generateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+		secret = generated
+	}
+	id, err := utils.GenerateRandomString("", 8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate webhook ID"})
+		return
+	}
+
+	webhook := &config.Webhook{
+		ID:        id,
+		Name:      req.Name,
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    req.Events,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		CreatedAt: time.Now(),
+	}
+	if err := h.cfg.AddWebhook(webhook); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Created webhook %s (%s) to %s", webhook.Name, webhook.ID, webhook.URL)
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// UpdateWebhook replaces the settings of a webhook, an empty secret keeps the current one
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	webhook, err := h.cfg.GetWebhook(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook.Name = req.Name
+	webhook.URL = req.URL
+	webhook.Events = req.Events
+	if req.Secret != "" {
+		webhook.Secret = req.Secret
+	}
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+	if err := h.cfg.UpdateWebhook(webhook); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Updated webhook %s (%s)", webhook.Name, webhook.ID)
+	webhook.Secret = ""
+	c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook removes a webhook, the deliveries in progress still finish
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id := c.Param("webhookId")
+	webhook, err := h.cfg.GetWebhook(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.DeleteWebhook(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	logging.LogInfo("Deleted webhook %s (%s)", webhook.Name, webhook.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// TestWebhook posts a test event to a webhook once, even if it is disabled
+func (h *WebhookHandler) TestWebhook(c *gin.Context) {
+	webhook, err := h.cfg.GetWebhook(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.Test(webhook); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivered": true})
+}
+
+func generateWebhookSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes:-> %v", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func (h *WebhookHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("", h.ListWebhooks)
+	router.POST("", h.CreateWebhook)
+	router.PUT("/:webhookId", h.UpdateWebhook)
+	router.DELETE("/:webhookId", h.DeleteWebhook)
+	router.POST("/:webhookId/test", h.TestWebhook)
+}
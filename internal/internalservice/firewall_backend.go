@@ -0,0 +1,48 @@
+package internalservice
+
+import (
+	"fmt"
+
+	"wg-panel/internal/utils"
+)
+
+// Values of the firewallBackend option
+const (
+	FirewallBackendIptables = "iptables"
+	FirewallBackendNftables = "nftables"
+)
+
+// FirewallBackend installs and removes the rules of server networks.
+// All rules of a server network are tagged with its comment string.
+type FirewallBackend interface {
+	// AddRules atomically replaces the rules tagged with comment in the tables
+	// ("nat", "filter") used by rules, so applying the same rules again
+	// doesn't create duplicates.
+	AddRules(comment string, version int, rules []utils.FirewallRule) error
+	// RemoveRules removes the rules tagged with comment, limited to the given
+	// tables ("nat", "filter") when tables is not empty.
+	RemoveRules(comment string, version int, tables []string) error
+	// CountRules returns the number of installed rules tagged with comment
+	// in each table ("nat", "filter").
+	CountRules(comment string, version int) (map[string]int, error)
+	// RemoveAllRules removes every rule created by this panel instance.
+	RemoveAllRules() error
+	// PostUpCommands renders rules as shell commands for the PostUp lines of
+	// the standalone wg-quick configuration.
+	PostUpCommands(rules []utils.FirewallRule) []string
+	// PreDownCommands renders shell commands removing the rules tagged with
+	// comment for the PreDown lines of the standalone wg-quick configuration.
+	PreDownCommands(comment string, version int) []string
+}
+
+// NewFirewallBackend creates the backend selected by the firewallBackend config option
+func NewFirewallBackend(name string, panelId string) (FirewallBackend, error) {
+	switch name {
+	case "", FirewallBackendIptables:
+		return newIptablesBackend(panelId), nil
+	case FirewallBackendNftables:
+		return newNftablesBackend(panelId), nil
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q, must be %q or %q", name, FirewallBackendIptables, FirewallBackendNftables)
+	}
+}
@@ -0,0 +1,43 @@
+package internalservice
+
+import "wg-panel/internal/utils"
+
+// iptablesBackend manages rules with iptables/ip6tables, identifying them by
+// the comment match attached to every rule.
+type iptablesBackend struct {
+	panelId string
+}
+
+func newIptablesBackend(panelId string) *iptablesBackend {
+	return &iptablesBackend{panelId: panelId}
+}
+
+// AddRules applies the rules of one IP version in a single iptables-restore transaction,
+// deleting the rules already tagged with comment in the same tables
+func (b *iptablesBackend) AddRules(comment string, version int, rules []utils.FirewallRule) error {
+	return utils.ReplaceRules(comment, version, rules)
+}
+
+func (b *iptablesBackend) RemoveRules(comment string, version int, tables []string) error {
+	var targetTable *[]string
+	if len(tables) > 0 {
+		targetTable = &tables
+	}
+	return utils.CleanupRules(comment, version, targetTable, false)
+}
+
+func (b *iptablesBackend) CountRules(comment string, version int) (map[string]int, error) {
+	return utils.CountRules(comment, version)
+}
+
+func (b *iptablesBackend) RemoveAllRules() error {
+	return utils.CleanupRules(b.panelId, 46, nil, true)
+}
+
+func (b *iptablesBackend) PostUpCommands(rules []utils.FirewallRule) []string {
+	return utils.GenerateRestoreRules(rules)
+}
+
+func (b *iptablesBackend) PreDownCommands(comment string, version int) []string {
+	return utils.GenerateCleanupRules(comment, version)
+}
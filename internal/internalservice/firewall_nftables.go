@@ -0,0 +1,189 @@
+package internalservice
+
+import (
+	"fmt"
+	"strings"
+
+	"wg-panel/internal/logging"
+	"wg-panel/internal/utils"
+)
+
+// nftablesBackend keeps all rules of this panel instance in one inet table.
+// nft only allows nat statements in nat chains and filter verdicts in filter
+// chains, so each comment string owns one base chain per hook, named
+// "<comment>-<hook>". Removing the rules of a server deletes its chains and
+// removing all rules deletes the table.
+//
+// Unlike iptables, an accept verdict in this table does not override a drop
+// verdict of another table hooked at forward.
+type nftablesBackend struct {
+	table string
+}
+
+// nftChain describes the base chain created for each iptables chain
+type nftChain struct {
+	hook     string
+	spec     string
+	nftTable string // iptables table the chain replaces
+}
+
+var nftChains = map[string]nftChain{
+	"POSTROUTING": {hook: "postrouting", spec: "type nat hook postrouting priority 100;", nftTable: "nat"},
+	"PREROUTING":  {hook: "prerouting", spec: "type nat hook prerouting priority -100;", nftTable: "nat"},
+	"FORWARD":     {hook: "forward", spec: "type filter hook forward priority 0;", nftTable: "filter"},
+}
+
+// nftChainOrder keeps generated scripts stable
+var nftChainOrder = []string{"POSTROUTING", "PREROUTING", "FORWARD"}
+
+func newNftablesBackend(panelId string) *nftablesBackend {
+	return &nftablesBackend{table: "wg-panel-" + panelId}
+}
+
+func (b *nftablesBackend) chainName(comment string, chain string) string {
+	return comment + "-" + nftChains[chain].hook
+}
+
+// AddRules replaces the content of the chains of the tables used by rules in one transaction,
+// so applying the same rules again doesn't duplicate them
+func (b *nftablesBackend) AddRules(comment string, version int, rules []utils.FirewallRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	script := strings.Join(b.addStatements(comment, rules), "\n") + "\n"
+	logging.LogInfo("Applying nftables rules for %s:\n%s", comment, script)
+	if _, err := utils.RunCommandWithInput(script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to apply nftables rules:-> %v", err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) RemoveRules(comment string, version int, tables []string) error {
+	script := strings.Join(b.removeStatements(comment, tables), "\n") + "\n"
+	logging.LogInfo("Removing nftables chains of %s", comment)
+	if _, err := utils.RunCommandWithInput(script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to remove nftables rules:-> %v", err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) CountRules(comment string, version int) (map[string]int, error) {
+	output, err := utils.RunCommandWithOutput("nft", "list", "table", "inet", b.table)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such file or directory") {
+			// The table is created with the first rule
+			return map[string]int{}, nil
+		}
+		return nil, fmt.Errorf("failed to list nftables table %s:-> %v", b.table, err)
+	}
+	return countNftRules(output, func(chain string) (string, bool) {
+		for name, c := range nftChains {
+			if chain == b.chainName(comment, name) {
+				return c.nftTable, true
+			}
+		}
+		return "", false
+	}), nil
+}
+
+func (b *nftablesBackend) RemoveAllRules() error {
+	// Adding the table first makes the deletion succeed when it doesn't exist
+	script := fmt.Sprintf("add table inet %q\ndelete table inet %q\n", b.table, b.table)
+	logging.LogInfo("Removing nftables table %s", b.table)
+	if _, err := utils.RunCommandWithInput(script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to remove nftables table %s:-> %v", b.table, err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) PostUpCommands(rules []utils.FirewallRule) (commands []string) {
+	if len(rules) == 0 {
+		return nil
+	}
+	for _, statement := range b.addStatements(rules[0].Comment, rules) {
+		commands = append(commands, utils.ShellquoteJoin("nft", statement))
+	}
+	return
+}
+
+func (b *nftablesBackend) PreDownCommands(comment string, version int) []string {
+	return []string{utils.ShellquoteJoin("nft", strings.Join(b.removeStatements(comment, nil), "; "))}
+}
+
+// addStatements creates the table and the chains of comment in the tables used by rules,
+// flushes the chains and adds the rules
+func (b *nftablesBackend) addStatements(comment string, rules []utils.FirewallRule) []string {
+	var tables []string
+	for _, rule := range rules {
+		if !containsString(tables, rule.Table) {
+			tables = append(tables, rule.Table)
+		}
+	}
+
+	statements := []string{fmt.Sprintf("add table inet %q", b.table)}
+	for _, chain := range nftChainOrder {
+		if !containsString(tables, nftChains[chain].nftTable) {
+			continue
+		}
+		name := b.chainName(comment, chain)
+		statements = append(statements,
+			fmt.Sprintf("add chain inet %q %q { %s }", b.table, name, nftChains[chain].spec),
+			fmt.Sprintf("flush chain inet %q %q", b.table, name),
+		)
+		for _, rule := range rules {
+			if rule.Chain == chain {
+				statements = append(statements, fmt.Sprintf("add rule inet %q %q %s", b.table, name, rule.NftRule()))
+			}
+		}
+	}
+	return statements
+}
+
+// removeStatements deletes the chains of comment in the given tables, or all of them if tables is empty.
+// Each chain is added and flushed first, so deleting a chain that doesn't exist succeeds.
+func (b *nftablesBackend) removeStatements(comment string, tables []string) []string {
+	statements := []string{fmt.Sprintf("add table inet %q", b.table)}
+	for _, chain := range nftChainOrder {
+		if len(tables) > 0 && !containsString(tables, nftChains[chain].nftTable) {
+			continue
+		}
+		name := b.chainName(comment, chain)
+		statements = append(statements,
+			fmt.Sprintf("add chain inet %q %q", b.table, name),
+			fmt.Sprintf("flush chain inet %q %q", b.table, name),
+			fmt.Sprintf("delete chain inet %q %q", b.table, name),
+		)
+	}
+	return statements
+}
+
+func containsString(slice []string, target string) bool {
+	for _, element := range slice {
+		if element == target {
+			return true
+		}
+	}
+	return false
+}
+
+// countNftRules counts the rules of "nft list table" output in each table, using tableOf
+// to map the chains of interest to the iptables table they replace
+func countNftRules(output string, tableOf func(chain string) (string, bool)) map[string]int {
+	counts := make(map[string]int)
+	table, inChain := "", false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "chain" && len(fields) >= 2:
+			table, inChain = tableOf(strings.Trim(fields[1], "\""))
+		case fields[0] == "}":
+			inChain = false
+		case inChain && strings.Contains(line, "comment \""):
+			counts[table]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,58 @@
+package internalservice
+
+import (
+	"strings"
+	"testing"
+
+	"wg-panel/internal/utils"
+)
+
+func TestNftablesBackend_AddStatements(t *testing.T) {
+	b := newNftablesBackend("abc")
+	rules := []utils.FirewallRule{
+		{Version: 4, Table: "filter", Chain: "FORWARD", InInterface: "wg-a", Source: "10.0.0.0/24", Target: "REJECT", Comment: "abc-v4"},
+	}
+
+	expected := []string{
+		`add table inet "wg-panel-abc"`,
+		`add chain inet "wg-panel-abc" "abc-v4-forward" { type filter hook forward priority 0; }`,
+		`flush chain inet "wg-panel-abc" "abc-v4-forward"`,
+		`add rule inet "wg-panel-abc" "abc-v4-forward" iifname "wg-a" ip saddr 10.0.0.0/24 reject comment "abc-v4"`,
+	}
+	if got := b.addStatements("abc-v4", rules); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("addStatements() = %q, want %q", got, expected)
+	}
+}
+
+func TestCountNftRules(t *testing.T) {
+	output := `table inet wg-panel-abc {
+	chain abc-v4-postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		ip saddr 10.0.0.0/24 masquerade comment "abc-v4"
+	}
+
+	chain abc-v4-forward {
+		type filter hook forward priority filter; policy accept;
+		iifname "wg-a" ip saddr 10.0.0.0/24 ip daddr 192.168.0.0/16 accept comment "abc-v4"
+		iifname "wg-a" ip saddr 10.0.0.0/24 reject comment "abc-v4"
+	}
+
+	chain abc-v6-forward {
+		type filter hook forward priority filter; policy accept;
+		iifname "wg-a" ip6 saddr fd00::/64 reject comment "abc-v6"
+	}
+}
+`
+	b := newNftablesBackend("abc")
+	counts := countNftRules(output, func(chain string) (string, bool) {
+		for name, c := range nftChains {
+			if chain == b.chainName("abc-v4", name) {
+				return c.nftTable, true
+			}
+		}
+		return "", false
+	})
+	if counts["nat"] != 1 || counts["filter"] != 2 {
+		t.Errorf("Expected 1 nat and 2 filter rules, got %v", counts)
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"wg-panel/internal/utils"
 )
 
-type FirewallService struct{}
+type FirewallService struct {
+	backend FirewallBackend
+}
 
-func NewFirewallService() *FirewallService {
-	return &FirewallService{}
+func NewFirewallService(backend FirewallBackend) *FirewallService {
+	return &FirewallService{backend: backend}
 }
 
 func (f *FirewallService) AddIpAndFwRules(interfaceName string, vrf *string, config *models.ServerNetworkConfig) error {
@@ -24,29 +26,19 @@ func (f *FirewallService) AddIpAndFwRules(interfaceName string, vrf *string, con
 
 	comment := config.CommentString
 
-	// Add IP address to interface (only if not already present)
-	if config.Network != nil {
-		if err := f.addIPAddressIfNotExists(interfaceName, config.Network.String()); err != nil {
-			return fmt.Errorf("failed to add IP address:-> %v", err)
-		}
+	if config.Network == nil {
+		return nil
 	}
 
-	// Add SNAT rules
-	if config.Snat != nil && config.Snat.Enabled {
-		if config.Snat.RoamingMasterInterface != nil && *config.Snat.RoamingMasterInterface != "" {
-			// If roaming is enabled, SNAT rules must managed by the roaming service
-		} else {
-			if err := f.AddSnatRules(vrf, config, comment); err != nil {
-				return fmt.Errorf("failed to add SNAT rules:-> %v", err)
-			}
-		}
+	// Add IP address to interface (only if not already present)
+	if err := f.addIPAddressIfNotExists(interfaceName, config.Network.String()); err != nil {
+		return fmt.Errorf("failed to add IP address:-> %v", err)
 	}
 
-	// Add routed networks firewall rules
-	if config.RoutedNetworksFirewall && len(config.RoutedNetworks) > 0 {
-		if err := f.addRoutedNetworksRules(interfaceName, config, comment); err != nil {
-			return fmt.Errorf("failed to add routed networks rules:-> %v", err)
-		}
+	// Apply the SNAT and routed networks rules in one transaction
+	rules := utils.ServerFirewallRules(interfaceName, vrf, config, config.Network.Version)
+	if err := f.backend.AddRules(comment, config.Network.Version, rules); err != nil {
+		return fmt.Errorf("failed to add firewall rules:-> %v", err)
 	}
 
 	return nil
@@ -67,8 +59,7 @@ func (f *FirewallService) RemoveIpAndFwRules(interfaceName string, config *model
 	}
 
 	// Remove firewall rules by comment
-	err := utils.CleanupRules(comment, config.Network.Version, nil, false)
-	if err != nil {
+	if err := f.RemoveFwRules(config.Network.Version, comment); err != nil {
 		logging.LogError("Failed to remove firewall rules: %v", err)
 	}
 }
@@ -78,66 +69,55 @@ func (f *FirewallService) AddSnatRules(vrf *string, config *models.ServerNetwork
 		return nil
 	}
 
-	isIPv4 := config.Network.Version == 4
-	iptablesCmd := "iptables"
-	if !isIPv4 {
-		iptablesCmd = "ip6tables"
-	}
 	if config.Snat.RoamingMasterInterface != nil && *config.Snat.RoamingMasterInterface != "" {
 		// If roaming is enabled, SNAT rules must managed by the roaming service
 		return fmt.Errorf("cannot add SNAT rules: roaming is enabled, rules must be managed by the roaming service")
 	}
 	// Generate SNAT rules using shared function
-	rules := utils.GenerateSNATRules(iptablesCmd, vrf, config, comment)
-
-	// Apply each rule
-	for _, rule := range rules {
-		ruleArgs := rule
-		// Remove the iptables command from the beginning since we pass it separately
-		if len(ruleArgs) > 0 && ruleArgs[0] == iptablesCmd {
-			ruleArgs = ruleArgs[1:]
-		}
-		if err := f.addIptablesRuleIfNotExists(iptablesCmd, ruleArgs); err != nil {
-			return fmt.Errorf("failed to add SNAT rule:-> %v", err)
-		}
+	rules := utils.SNATRules(vrf, config, comment)
+	if len(rules) == 0 {
+		// Nothing to replace the existing rules with, make sure stale ones don't stay
+		return f.RemoveSnatRules(config.Network.Version, comment)
+	}
+	if err := f.backend.AddRules(comment, config.Network.Version, rules); err != nil {
+		return fmt.Errorf("failed to add SNAT rule:-> %v", err)
 	}
 	return nil
 }
 
 func (f *FirewallService) RemoveSnatRules(af int, comment string) error {
-	if err := utils.CleanupRules(comment, af, &[]string{"nat"}, false); err != nil {
+	if err := f.backend.RemoveRules(comment, af, []string{"nat"}); err != nil {
 		return fmt.Errorf("failed to remove SNAT rules:-> %v", err)
 	}
 	return nil
 }
 
-func (f *FirewallService) addRoutedNetworksRules(interfaceDevice string, config *models.ServerNetworkConfig, comment string) error {
-	if config.Network == nil || len(config.RoutedNetworks) == 0 {
-		return nil
+// RemoveFwRules removes all firewall rules tagged with comment
+func (f *FirewallService) RemoveFwRules(af int, comment string) error {
+	if err := f.backend.RemoveRules(comment, af, nil); err != nil {
+		return fmt.Errorf("failed to remove firewall rules:-> %v", err)
 	}
+	return nil
+}
 
-	isIPv4 := config.Network.Version == 4
-	iptablesCmd := "iptables"
-	if !isIPv4 {
-		iptablesCmd = "ip6tables"
-	}
+// CountRules returns the number of installed rules tagged with comment in each table
+func (f *FirewallService) CountRules(af int, comment string) (map[string]int, error) {
+	return f.backend.CountRules(comment, af)
+}
 
-	// Generate routed networks rules using shared function
-	rules := utils.GenerateRoutedNetworksRules(iptablesCmd, interfaceDevice, config, comment)
+// RemoveAllRules removes every firewall rule created by this panel instance
+func (f *FirewallService) RemoveAllRules() error {
+	return f.backend.RemoveAllRules()
+}
 
-	// Apply each rule
-	for _, rule := range rules {
-		ruleArgs := rule
-		// Remove the iptables command from the beginning since we pass it separately
-		if len(ruleArgs) > 0 && ruleArgs[0] == iptablesCmd {
-			ruleArgs = ruleArgs[1:]
-		}
-		if err := f.addIptablesRuleIfNotExists(iptablesCmd, ruleArgs); err != nil {
-			return fmt.Errorf("failed to add routed network rule:-> %v", err)
-		}
-	}
+// PostUpCommands renders the firewall rules of a server network as PostUp commands
+func (f *FirewallService) PostUpCommands(rules []utils.FirewallRule) []string {
+	return f.backend.PostUpCommands(rules)
+}
 
-	return nil
+// PreDownCommands renders the removal of the firewall rules tagged with comment as PreDown commands
+func (f *FirewallService) PreDownCommands(comment string, version int) []string {
+	return f.backend.PreDownCommands(comment, version)
 }
 
 // addIPAddressIfNotExists adds an IP address to an interface only if it doesn't already exist
@@ -187,81 +167,3 @@ func (f *FirewallService) ipAddressExists(interfaceDevice, ipAddr string) (bool,
 	// Format: "192.168.1.1/24" -> we need to match this exactly
 	return strings.Contains(output, ipAddr), nil
 }
-
-// addIptablesRuleIfNotExists adds an iptables rule only if it doesn't already exist
-func (f *FirewallService) addIptablesRuleIfNotExists(iptablesCmd string, ruleArgs []string) error {
-	// Check if the rule already exists
-	if exists, err := f.iptablesRuleExists(iptablesCmd, ruleArgs); err != nil {
-		return fmt.Errorf("failed to check iptables rule existence:-> %v", err)
-	} else if exists {
-		// Rule already exists, skip addition
-		return nil
-	}
-
-	// Add the rule
-	logging.LogInfo("Adding firewall rule: %s %s", iptablesCmd, strings.Join(ruleArgs, " "))
-	if err := utils.RunCommand(iptablesCmd, ruleArgs...); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// iptablesRuleExists checks if an iptables rule already exists
-func (f *FirewallService) iptablesRuleExists(iptablesCmd string, ruleArgs []string) (bool, error) {
-	// Convert -A (append) to -C (check) to test if rule exists
-	checkArgs := make([]string, len(ruleArgs))
-	copy(checkArgs, ruleArgs)
-
-	// Find and replace -A with -C
-	for i, arg := range checkArgs {
-		if arg == "-A" && i+1 < len(checkArgs) {
-			checkArgs[i] = "-C"
-			break
-		}
-	}
-
-	// Handle -t (table) argument position for check command
-	// iptables -t nat -C POSTROUTING ... (correct)
-	// vs iptables -C -t nat POSTROUTING ... (incorrect)
-	var finalArgs []string
-	tableIdx := -1
-	for i, arg := range checkArgs {
-		if arg == "-t" && i+1 < len(checkArgs) {
-			tableIdx = i
-			break
-		}
-	}
-
-	if tableIdx >= 0 {
-		// Put table arguments first: iptables -t nat -C ...
-		finalArgs = append(finalArgs, checkArgs[tableIdx:tableIdx+2]...) // -t nat
-		// Add -C and the rest
-		for i, arg := range checkArgs {
-			if i != tableIdx && i != tableIdx+1 && arg != "-t" {
-				finalArgs = append(finalArgs, arg)
-			}
-		}
-	} else {
-		finalArgs = checkArgs
-	}
-
-	// Run the check command
-	err := utils.RunCommand(iptablesCmd, finalArgs...)
-	if err == nil {
-		// Rule exists (command succeeded)
-		return true, nil
-	}
-
-	// Check if the error is "rule does not exist" vs actual error
-	if cmdErr, ok := err.(*utils.CommandError); ok {
-		// Exit code 1 typically means "rule not found" for iptables -C
-		// Exit codes > 1 usually indicate actual errors
-		if cmdErr.ExitCode == 1 {
-			return false, nil
-		}
-	}
-
-	// Some other error occurred
-	return false, err
-}
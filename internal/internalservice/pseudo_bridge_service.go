@@ -5,7 +5,9 @@ import (
 	"net"
 	"sync"
 	"time"
+	"wg-panel/internal/events"
 	"wg-panel/internal/logging"
+	"wg-panel/internal/metrics"
 	"wg-panel/internal/models"
 	"wg-panel/internal/utils"
 
@@ -172,6 +174,7 @@ func (r *InterfaceResponder) mainLoop() {
 		}
 		close(r.stopCh)
 		logging.LogInfo("Pseudo-bridge Responder for %s stopped", r.interfaceName)
+		events.Publish(events.Event{Type: events.PseudoBridgeStopped, Data: map[string]interface{}{"interface": r.interfaceName}})
 	}()
 	logging.LogInfo("Pseudo-bridge Responder for %s starting", r.interfaceName)
 	for {
@@ -198,6 +201,7 @@ func (r *InterfaceResponder) mainLoop() {
 			}
 			packetSource = gopacket.NewPacketSource(handle, handle.LinkType())
 			logging.LogInfo("Pseudo-bridge Responder for %s started, listening ARP and NS", r.interfaceName)
+			events.Publish(events.Event{Type: events.PseudoBridgeStarted, Data: map[string]interface{}{"interface": r.interfaceName}})
 		} else if packetSource == nil {
 			packetSource = gopacket.NewPacketSource(handle, handle.LinkType())
 		} else {
@@ -400,6 +404,7 @@ func (r *InterfaceResponder) sendARPReply(requestPacket gopacket.Packet, request
 			logging.LogError("Failed to send ARP reply for %s on %s: %v", targetIP.String(), r.interfaceName, err)
 		} else {
 			logging.LogVerbose("Sent ARP reply for %s on %s (MAC: %s)", targetIP.String(), r.interfaceName, iface.HardwareAddr.String())
+			metrics.PseudoBridgeReplies.Inc(r.interfaceName, "arp")
 		}
 	}
 }
@@ -476,6 +481,7 @@ func (r *InterfaceResponder) sendNeighborAdvertisement(requestPacket gopacket.Pa
 			logging.LogError("Failed to send neighbor advertisement for %s on %s: %v", targetIP.String(), r.interfaceName, err)
 		} else {
 			logging.LogVerbose("Sent neighbor advertisement for %s on %s (MAC: %s)", targetIP.String(), r.interfaceName, iface.HardwareAddr.String())
+			metrics.PseudoBridgeReplies.Inc(r.interfaceName, "ns")
 		}
 	}
 }
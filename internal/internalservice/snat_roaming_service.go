@@ -5,7 +5,9 @@ import (
 	"sync"
 	"time"
 
+	"wg-panel/internal/events"
 	"wg-panel/internal/logging"
+	"wg-panel/internal/metrics"
 	"wg-panel/internal/models"
 	"wg-panel/internal/utils"
 
@@ -154,6 +156,7 @@ func (s *SNATRoamingService) mainLoop() {
 					if ipchanged {
 						logging.LogInfo("IP change detected for interface: %s, updating firewall rules", ifname)
 						listener.UpdateConfigsAndSyncFw(listener.configs, listener.vrfmaps, true)
+						events.Publish(listener.prefixEvent())
 					}
 				} else {
 					logging.LogVerbose("No SNAT roaming listener found for interface: %s, ignoring", ifname)
@@ -180,6 +183,7 @@ func (s *SNATRoamingService) mainLoop() {
 					if ipchanged {
 						logging.LogInfo("IP change detected for interface: %s, updating firewall rules", ifname)
 						listener.UpdateConfigsAndSyncFw(listener.configs, listener.vrfmaps, true)
+						events.Publish(listener.prefixEvent())
 					}
 				} else {
 					logging.LogVerbose("No SNAT roaming listener found for interface: %s", ifname)
@@ -298,10 +302,15 @@ func (l *InterfaceIPNetListener) UpdateConfigsAndSyncFw(configs map[string]*mode
 	for key, config := range toUpdate {
 		logging.LogVerbose("Updating SNAT roaming rules for %s on interface %s", key, l.interfaceName)
 		simulatedConfig, err := l.getSimulatedConfig(config)
-		if err != nil {
+		if err != nil || simulatedConfig == nil {
+			// Nothing can replace the rules of the old prefix, don't leave them behind
 			logging.LogError("Failed to calculate target_network: %v", err)
+			if err := l.fw.RemoveSnatRules(config.Network.Version, config.CommentString); err != nil {
+				logging.LogError("Failed to remove firewall rules: %v", err)
+			}
+			continue
 		}
-		l.fw.RemoveSnatRules(config.Network.Version, config.CommentString)
+		// AddSnatRules replaces the existing nat rules of the server in one transaction
 		var vrf *string
 		var ok bool
 		if vrf, ok = vrfmap[config.CommentString]; !ok {
@@ -311,6 +320,7 @@ func (l *InterfaceIPNetListener) UpdateConfigsAndSyncFw(configs map[string]*mode
 			logging.LogError("Failed to add firewall rules: %v", err)
 		} else {
 			logging.LogVerbose("Successfully updated SNAT roaming rules for %s on interface %s", key, l.interfaceName)
+			metrics.SNATRoamingRefreshes.Inc(l.interfaceName)
 		}
 	}
 	l.configs = configs
@@ -389,6 +399,18 @@ func (l *InterfaceIPNetListener) SyncIpFromIface() (changed bool) {
 	return changed
 }
 
+// prefixEvent tells the new primary networks of the roaming master interface
+func (l *InterfaceIPNetListener) prefixEvent() events.Event {
+	data := map[string]interface{}{"interface": l.interfaceName}
+	if l.ifIPs[4] != nil {
+		data["ipv4"] = l.ifIPs[4].String()
+	}
+	if l.ifIPs[6] != nil {
+		data["ipv6"] = l.ifIPs[6].String()
+	}
+	return events.Event{Type: events.SNATPrefixChanged, Data: data}
+}
+
 func (l *InterfaceIPNetListener) Stop() {
 	l.stopCh <- struct{}{}
 }
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Counters of the panel exposed on the metrics endpoint. They are global so the
+// packages doing the work only have to increment them.
+var (
+	PseudoBridgeReplies = NewCounterVec("wgpanel_pseudo_bridge_replies_total",
+		"ARP replies and neighbor advertisements sent by the pseudo-bridge", "interface", "type")
+	SNATRoamingRefreshes = NewCounterVec("wgpanel_snat_roaming_refreshes_total",
+		"Updates of the SNAT roaming rules of a server", "interface")
+	CommandFailures = NewCounterVec("wgpanel_command_failures_total",
+		"External commands which failed", "command")
+	HTTPRequestDuration = NewHistogramVec("wgpanel_http_request_duration_seconds",
+		"Latency of the HTTP requests", []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "method", "route", "status")
+)
+
+// collectors are written by WriteRegistered in the order they were created
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+type collector interface {
+	write(w io.Writer)
+}
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// WriteRegistered writes the counters and histograms of the package
+func WriteRegistered(w io.Writer) {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// CounterVec is a counter per combination of label values
+type CounterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\x00")]++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	WriteHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		WriteSample(w, c.name, Labels(c.labels, strings.Split(key, "\x00")), c.values[key])
+	}
+}
+
+// HistogramVec is a histogram per combination of label values
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, buckets: buckets, labels: labels, values: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe records a value in the histogram of the label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\x00")
+	v := h.values[key]
+	if v == nil {
+		v = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+			break
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	WriteHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		labels := Labels(h.labels, strings.Split(key, "\x00"))
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += v.counts[i]
+			WriteSample(w, h.name+"_bucket", append(labels, Label{"le", formatValue(bound)}), float64(cumulative))
+		}
+		WriteSample(w, h.name+"_bucket", append(labels, Label{"le", "+Inf"}), float64(v.count))
+		WriteSample(w, h.name+"_sum", labels, v.sum)
+		WriteSample(w, h.name+"_count", labels, float64(v.count))
+	}
+}
+
+// Label is a label of a sample
+type Label struct {
+	Name, Value string
+}
+
+// Labels pairs label names with their values
+func Labels(names, values []string) []Label {
+	labels := make([]Label, len(names))
+	for i, name := range names {
+		labels[i] = Label{name, values[i]}
+	}
+	return labels
+}
+
+// WriteHeader writes the help and the type of a metric in the Prometheus text format
+func WriteHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, metricType)
+}
+
+// WriteSample writes a sample in the Prometheus text format
+func WriteSample(w io.Writer, name string, labels []Label, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label.Name)
+			b.WriteString(`="`)
+			b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(label.Value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
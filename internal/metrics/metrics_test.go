@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterAndHistogram(t *testing.T) {
+	counter := &CounterVec{name: "test_total", help: "Test\ncounter", labels: []string{"name"}, values: map[string]float64{}}
+	counter.Inc(`a"b`)
+	counter.Inc(`a"b`)
+	counter.Inc("c")
+
+	var out strings.Builder
+	counter.write(&out)
+	want := "# HELP test_total Test\\ncounter\n# TYPE test_total counter\n" +
+		"test_total{name=\"a\\\"b\"} 2\n" +
+		"test_total{name=\"c\"} 1\n"
+	if out.String() != want {
+		t.Errorf("Counter written as\n%s\nwant\n%s", out.String(), want)
+	}
+
+	histogram := &HistogramVec{name: "test_seconds", help: "Test", buckets: []float64{0.1, 1}, labels: []string{"route"}, values: map[string]*histogram{}}
+	histogram.Observe(0.05, "/a")
+	histogram.Observe(0.5, "/a")
+	histogram.Observe(5, "/a")
+
+	out.Reset()
+	histogram.write(&out)
+	want = "# HELP test_seconds Test\n# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{route=\"/a\",le=\"0.1\"} 1\n" +
+		"test_seconds_bucket{route=\"/a\",le=\"1\"} 2\n" +
+		"test_seconds_bucket{route=\"/a\",le=\"+Inf\"} 3\n" +
+		"test_seconds_sum{route=\"/a\"} 5.55\n" +
+		"test_seconds_count{route=\"/a\"} 3\n"
+	if out.String() != want {
+		t.Errorf("Histogram written as\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	"wg-panel/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SerializeChanges runs requests that change the configuration one at a time,
+// so they are never applied while the reconciler repairs an interface
+func SerializeChanges(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet {
+			c.Next()
+			return
+		}
+		cfg.LockApply()
+		defer cfg.UnlockApply()
+		c.Next()
+	}
+}
@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/services"
+	"wg-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of audited models
+const (
+	auditInterface = "interface"
+	auditServer    = "server"
+	auditClient    = "client"
+	auditUser      = "user"
+	auditToken     = "token"
+	auditRevision  = "revision"
+	auditPortal    = "portal-link"
+	auditDownload  = "download-link"
+	auditWebhook   = "webhook"
+	auditLogins    = "login-attempts"
+)
+
+// auditTarget is the model changed by a request
+type auditTarget struct {
+	kind        string
+	interfaceID string
+	serverID    string
+	clientID    string
+	id          string // User name, token ID, revision number, link ID, webhook ID or locked out IP or user name
+	create      bool
+}
+
+func auditTargetOf(c *gin.Context) *auditTarget {
+	path := c.FullPath()
+	target := &auditTarget{
+		interfaceID: c.Param("ifId"),
+		serverID:    c.Param("serverId"),
+		clientID:    c.Param("clientId"),
+	}
+	switch {
+	case strings.Contains(path, "/service/users"):
+		target.kind = auditUser
+		target.id = c.Param("username")
+		target.create = target.id == ""
+	case strings.Contains(path, "/service/login-attempts"):
+		target.kind = auditLogins
+		target.id = LoginKeyIP + ":" + c.Query("ip")
+		if c.Query("ip") == "" {
+			target.id = LoginKeyUsername + ":" + c.Query("username")
+		}
+	case strings.Contains(path, "/service/2fa"):
+		// Two-factor authentication of the logged in user
+		target.kind = auditUser
+		target.id = c.GetString("username")
+	case strings.Contains(path, "/service/tokens"):
+		target.kind = auditToken
+		target.id = c.Param("tokenId")
+		target.create = target.id == ""
+	case strings.Contains(path, "/service/webhooks"):
+		target.kind = auditWebhook
+		target.id = c.Param("webhookId")
+		target.create = target.id == ""
+	case strings.Contains(path, "/revisions"):
+		target.kind = auditRevision
+		target.id = c.Param("revision")
+	case strings.Contains(path, "/portal-links"):
+		target.kind = auditPortal
+		target.id = c.Param("linkId")
+		target.create = target.id == ""
+	case strings.Contains(path, "/download-links"):
+		target.kind = auditDownload
+		target.id = c.Param("linkId")
+		target.create = target.id == ""
+	case strings.Contains(path, "/clients"), strings.Contains(path, "/portal/"):
+		// The portal only changes the client of its session
+		target.kind = auditClient
+		target.create = target.clientID == ""
+	case strings.Contains(path, "/servers"):
+		target.kind = auditServer
+		target.create = target.serverID == ""
+	default:
+		target.kind = auditInterface
+		target.create = target.interfaceID == ""
+	}
+	return target
+}
+
+func auditVerb(c *gin.Context, target *auditTarget) string {
+	path := c.FullPath()
+	switch {
+	case target.create:
+		return "create"
+	case strings.HasSuffix(path, "/set-enable"):
+		return "set-enable"
+	case strings.HasSuffix(path, "/move"):
+		return "move"
+	case strings.HasSuffix(path, "/rollback"):
+		return "rollback"
+	case strings.HasSuffix(path, "/service/login-attempts"):
+		return "clear"
+	case strings.HasSuffix(path, "/service/2fa/enroll"):
+		return "enroll-2fa"
+	case strings.HasSuffix(path, "/service/2fa/confirm"):
+		return "confirm-2fa"
+	case strings.HasSuffix(path, "/service/2fa/recovery-codes"):
+		return "regenerate-recovery-codes"
+	case strings.HasSuffix(path, "/service/2fa"):
+		return "disable-2fa"
+	case strings.HasSuffix(path, "/2fa"):
+		return "reset-2fa"
+	case strings.HasSuffix(path, "/regenerate-keys"):
+		return "regenerate-keys"
+	case strings.HasSuffix(path, "/purge-private-keys"):
+		return "purge-private-keys"
+	case strings.HasSuffix(path, "/extend"):
+		return "extend"
+	case strings.HasSuffix(path, "/test"):
+		return "test"
+	case c.Request.Method == http.MethodPut:
+		return "update"
+	case c.Request.Method == http.MethodDelete:
+		return "delete"
+	}
+	return strings.ToLower(c.Request.Method)
+}
+
+// model returns the current state of the target, nil if it doesn't exist
+func (t *auditTarget) model(cfg *config.Config) interface{} {
+	switch t.kind {
+	case auditInterface:
+		if iface := cfg.GetInterface(t.interfaceID); iface != nil {
+			return iface
+		}
+	case auditServer:
+		if server, err := cfg.GetServer(t.interfaceID, t.serverID); err == nil {
+			return server
+		}
+	case auditClient:
+		if client, err := cfg.GetClient(t.interfaceID, t.serverID, t.clientID); err == nil {
+			return client
+		}
+	case auditUser:
+		for _, user := range cfg.ListUsers() {
+			if user.Username == t.id {
+				return user
+			}
+		}
+	case auditToken:
+		if apiToken, err := cfg.GetAPIToken(t.id); err == nil {
+			return apiToken
+		}
+	case auditPortal:
+		if link, err := cfg.GetPortalLink(t.id); err == nil {
+			return link
+		}
+	case auditDownload:
+		if link, err := cfg.GetDownloadLink(t.id); err == nil {
+			return link
+		}
+	case auditWebhook:
+		if webhook, err := cfg.GetWebhook(t.id); err == nil {
+			return webhook
+		}
+	}
+	return nil
+}
+
+// childIDs lists the IDs of the models of the target kind next to the created one
+func (t *auditTarget) childIDs(cfg *config.Config) []string {
+	var ids []string
+	switch t.kind {
+	case auditInterface:
+		for id := range cfg.GetAllInterfaces() {
+			ids = append(ids, id)
+		}
+	case auditServer:
+		if servers, err := cfg.GetAllServers(t.interfaceID); err == nil {
+			for _, server := range servers {
+				ids = append(ids, server.ID)
+			}
+		}
+	case auditClient:
+		if clients, err := cfg.GetAllClients(t.interfaceID, t.serverID); err == nil {
+			for _, client := range clients {
+				ids = append(ids, client.ID)
+			}
+		}
+	case auditUser:
+		for _, user := range cfg.ListUsers() {
+			ids = append(ids, user.Username)
+		}
+	case auditToken:
+		for _, apiToken := range cfg.ListAPITokens("") {
+			ids = append(ids, apiToken.ID)
+		}
+	case auditPortal:
+		for _, link := range cfg.ListPortalLinks(t.interfaceID, t.serverID, t.clientID) {
+			ids = append(ids, link.ID)
+		}
+	case auditDownload:
+		for _, link := range cfg.ListDownloadLinks(t.interfaceID, t.serverID, t.clientID) {
+			ids = append(ids, link.ID)
+		}
+	case auditWebhook:
+		for _, webhook := range cfg.ListWebhooks() {
+			ids = append(ids, webhook.ID)
+		}
+	}
+	return ids
+}
+
+// setID sets the ID of the created model
+func (t *auditTarget) setID(id string) {
+	switch t.kind {
+	case auditInterface:
+		t.interfaceID = id
+	case auditServer:
+		t.serverID = id
+	case auditClient:
+		t.clientID = id
+	default:
+		t.id = id
+	}
+}
+
+// snapshot returns the target as indented JSON without secrets, nil if it doesn't exist
+func (t *auditTarget) snapshot(cfg *config.Config) json.RawMessage {
+	model := t.model(cfg)
+	if model == nil {
+		return nil
+	}
+	data, err := utils.RedactSecrets(model)
+	if err != nil {
+		logging.LogError("Failed to encode %s for the audit log: %v", t.kind, err)
+		return nil
+	}
+	return data
+}
+
+// AuditChanges records the successful changes of the requests in the audit log,
+// with the changed model before and after the change. It must run after RequireAuth.
+func AuditChanges(cfg *config.Config, audit *services.AuditService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Query("dryRun") == "true" {
+			c.Next()
+			return
+		}
+
+		target := auditTargetOf(c)
+		var before json.RawMessage
+		var existingIDs []string
+		if target.create {
+			existingIDs = target.childIDs(cfg)
+		} else {
+			before = target.snapshot(cfg)
+		}
+
+		c.Next()
+
+		if c.Writer.Status() >= 400 {
+			return
+		}
+		if target.create {
+			for _, id := range target.childIDs(cfg) {
+				if !containsString(existingIDs, id) {
+					target.setID(id)
+					break
+				}
+			}
+		}
+		after := target.snapshot(cfg)
+
+		entry := &services.AuditEntry{
+			Time:        time.Now(),
+			Actor:       c.GetString("username"),
+			TokenID:     c.GetString("tokenId"),
+			SourceIP:    c.ClientIP(),
+			Action:      target.kind + "." + auditVerb(c, target),
+			InterfaceID: target.interfaceID,
+			ServerID:    target.serverID,
+			ClientID:    target.clientID,
+			Target:      target.id,
+			Before:      before,
+			After:       after,
+			Diff:        utils.DiffLines(string(before), string(after), 3),
+		}
+		if err := audit.Record(entry); err != nil {
+			logging.LogError("Failed to record %s in the audit log: %v", entry.Action, err)
+		}
+	}
+}
+
+func containsString(slice []string, target string) bool {
+	for _, element := range slice {
+		if element == target {
+			return true
+		}
+	}
+	return false
+}
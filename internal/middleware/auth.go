@@ -3,25 +3,35 @@ package middleware
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthMiddleware struct {
-	cfg *config.Config
+	cfg     *config.Config
+	limiter *LoginLimiter
 }
 
 func NewAuthMiddleware(cfg *config.Config) *AuthMiddleware {
-	return &AuthMiddleware{cfg: cfg}
+	return &AuthMiddleware{cfg: cfg, limiter: NewLoginLimiter(cfg)}
 }
 
 func (a *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if header := c.GetHeader("Authorization"); header != "" {
+			a.requireToken(c, header)
+			return
+		}
+
 		cookie, err := c.Cookie("session_token")
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
@@ -44,10 +54,68 @@ func (a *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		// The user may have been deleted since the login
+		access, ok := a.cfg.GetUserAccess(session.Username)
+		if !ok {
+			a.cfg.DeleteSession(cookie)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			c.Abort()
+			return
+		}
+
 		// Update last seen
 		session.LastSeen = time.Now()
 
 		c.Set("username", session.Username)
+		c.Set("access", access)
+		c.Next()
+	}
+}
+
+// requireToken authenticates a request with an API token in the Authorization header
+func (a *AuthMiddleware) requireToken(c *gin.Context, header string) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header"})
+		c.Abort()
+		return
+	}
+
+	apiToken, stale, err := a.cfg.UseAPIToken(strings.TrimSpace(token))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
+
+	// The owner may have been deleted or lost roles since the token was created
+	ownerAccess, ok := a.cfg.GetUserAccess(apiToken.Owner)
+	if !ok || !ownerAccess.Covers(apiToken.Access) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token exceeds the roles of its owner"})
+		c.Abort()
+		return
+	}
+
+	if stale {
+		if err := a.cfg.Save(); err != nil {
+			logging.LogError("Failed to save last use of token %s: %v", apiToken.ID, err)
+		}
+	}
+
+	c.Set("username", apiToken.Owner)
+	c.Set("tokenId", apiToken.ID)
+	c.Set("access", apiToken.Access)
+	c.Next()
+}
+
+// RequireSession refuses API tokens, for the routes managing the login itself
+func (a *AuthMiddleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("tokenId") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not available with an API token"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -56,6 +124,7 @@ func (a *AuthMiddleware) Login(c *gin.Context) {
 	var loginReq struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Code     string `json:"code"` // TOTP or recovery code, when two-factor authentication is enabled
 	}
 
 	if err := c.ShouldBindJSON(&loginReq); err != nil {
@@ -63,28 +132,69 @@ func (a *AuthMiddleware) Login(c *gin.Context) {
 		return
 	}
 
+	// Slow down guessing, per source IP and per username
+	ip := c.ClientIP()
+	wait, release := a.limiter.Check(ip, loginReq.Username)
+	defer release()
+	if wait > 0 {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later", "retryAfter": retryAfter})
+		return
+	}
+
 	// Check credentials
-	if loginReq.Username != a.cfg.User {
+	passwordHash, ok := a.cfg.GetPasswordHash(loginReq.Username)
+	if !ok {
+		a.limiter.Failure(ip, loginReq.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(a.cfg.Password), []byte(loginReq.Password))
+	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(loginReq.Password))
 	if err != nil {
+		a.limiter.Failure(ip, loginReq.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	// Second step, the client sends the credentials again with the code
+	if enabled, _ := a.cfg.TwoFactorStatus(loginReq.Username); enabled {
+		if loginReq.Code == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor code required", "twoFactorRequired": true})
+			return
+		}
+		if !a.cfg.VerifyTwoFactor(loginReq.Username, loginReq.Code) {
+			a.limiter.Failure(ip, loginReq.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code", "twoFactorRequired": true})
+			return
+		}
+		// Keep the used code, so it can't be replayed after a restart
+		if err := a.cfg.Save(); err != nil {
+			logging.LogError("Failed to save two-factor state of %s: %v", loginReq.Username, err)
+		}
+	}
+
+	a.limiter.Success(ip, loginReq.Username)
+
+	if err := a.startSession(c, loginReq.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate session"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// startSession creates a session for an authenticated user and sets its cookie
+func (a *AuthMiddleware) startSession(c *gin.Context, username string) error {
 	// Generate session token
 	token, err := generateSessionToken()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate session"})
-		return
+		return err
 	}
 
 	// Create session
 	session := &config.Session{
-		Username:  loginReq.Username,
+		Username:  username,
 		CreatedAt: time.Now(),
 		LastSeen:  time.Now(),
 	}
@@ -93,8 +203,32 @@ func (a *AuthMiddleware) Login(c *gin.Context) {
 	a.cfg.CleanExpiredSessions()
 
 	// Set cookie
-	c.SetCookie("session_token", token, 24*3600, "/", "", false, true)
-	c.Status(http.StatusOK)
+	c.SetCookie("session_token", token, 24*3600, "/", "", a.cfg.TLS.Enabled(), true)
+	return nil
+}
+
+// ListLoginAttempts returns the counted login failures and the recent lockouts
+func (a *AuthMiddleware) ListLoginAttempts(c *gin.Context) {
+	attempts, lockouts := a.limiter.Status()
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts, "lockouts": lockouts})
+}
+
+// ClearLoginAttempts lifts the backoff or lockout of ?ip= or ?username=
+func (a *AuthMiddleware) ClearLoginAttempts(c *gin.Context) {
+	kind, key := LoginKeyIP, c.Query("ip")
+	if key == "" {
+		kind, key = LoginKeyUsername, c.Query("username")
+	}
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ip or username is required"})
+		return
+	}
+	if !a.limiter.Unlock(kind, key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no failed logins for this " + kind})
+		return
+	}
+	logging.LogInfo("User %s cleared the failed logins of %s %s", c.GetString("username"), kind, key)
+	c.Status(http.StatusNoContent)
 }
 
 func (a *AuthMiddleware) Logout(c *gin.Context) {
@@ -103,7 +237,7 @@ func (a *AuthMiddleware) Logout(c *gin.Context) {
 		a.cfg.DeleteSession(cookie)
 	}
 
-	c.SetCookie("session_token", "", -1, "/", "", false, true)
+	c.SetCookie("session_token", "", -1, "/", "", a.cfg.TLS.Enabled(), true)
 	c.Status(http.StatusNoContent)
 }
 
@@ -119,7 +253,13 @@ func (a *AuthMiddleware) ChangePassword(c *gin.Context) {
 	}
 
 	// Verify current password
-	err := bcrypt.CompareHashAndPassword([]byte(a.cfg.Password), []byte(passwordReq.CurrentPassword))
+	username := c.GetString("username")
+	passwordHash, ok := a.cfg.GetPasswordHash(username)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+		return
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(passwordReq.CurrentPassword))
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
 		return
@@ -132,7 +272,10 @@ func (a *AuthMiddleware) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	a.cfg.Password = string(hashedPassword)
+	if err := a.cfg.SetPasswordHash(username, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+		return
+	}
 	if err := a.cfg.Save(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
 		return
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"wg-panel/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// operatorRoutes are the changes operators may make, other changes need an admin
+var operatorRoutes = []string{
+	"/clients",
+	"/clients/:clientId/set-enable",
+	"/clients/:clientId/extend",
+	"/clients/:clientId/portal-links",
+	"/clients/:clientId/portal-links/:linkId",
+	"/clients/:clientId/download-links",
+	"/clients/:clientId/download-links/:linkId",
+}
+
+// GetAccess returns the roles of the user authenticated by RequireAuth
+func GetAccess(c *gin.Context) config.Access {
+	if access, ok := c.Get("access"); ok {
+		return access.(config.Access)
+	}
+	return config.Access{}
+}
+
+// RequireRole rejects users without the given global role, it must run after RequireAuth
+func (a *AuthMiddleware) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetAccess(c).Allowed(role, "", "") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Authorize checks the role of the user on the interface and server of the
+// request, it must run after RequireAuth. Reads need a viewer, creating,
+// toggling and extending clients, managing their portal and download links and
+// reading their configurations an operator and other changes an admin. Listing
+// the interfaces is left to the handler, which only returns the visible ones.
+func (a *AuthMiddleware) Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access := GetAccess(c)
+		ifId := c.Param("ifId")
+		serverId := c.Param("serverId")
+		path := c.FullPath()
+
+		allowed := false
+		switch {
+		case c.Request.Method == http.MethodGet && ifId == "":
+			allowed = true
+		case c.Request.Method == http.MethodGet && strings.HasSuffix(path, "/clients/:clientId/config"):
+			// The configuration holds the keys of the client
+			allowed = access.Allowed(config.RoleOperator, ifId, serverId)
+		case c.Request.Method == http.MethodGet:
+			allowed = access.CanView(ifId, serverId)
+		case isOperatorRoute(path):
+			allowed = access.Allowed(config.RoleOperator, ifId, serverId)
+		case strings.HasSuffix(path, "/move"):
+			// Moving a server changes the interface, not only the server
+			allowed = access.Allowed(config.RoleAdmin, ifId, "")
+		default:
+			allowed = access.Allowed(config.RoleAdmin, ifId, serverId)
+		}
+
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func isOperatorRoute(path string) bool {
+	for _, route := range operatorRoutes {
+		if strings.HasSuffix(path, route) {
+			return true
+		}
+	}
+	return false
+}
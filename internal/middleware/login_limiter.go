@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+)
+
+// Kinds of keys counted by the login limiter
+const (
+	LoginKeyIP       = "ip"
+	LoginKeyUsername = "username"
+)
+
+const (
+	maxLockoutEvents = 200
+	maxLoginAttempts = 10000 // Counted IPs and usernames, the least blocked are dropped beyond
+	inFlightWait     = time.Second
+)
+
+// LoginAttempts is the failure count of one source IP or username
+type LoginAttempts struct {
+	Kind         string    `json:"kind"`
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"lastFailure"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+	LockedOut    bool      `json:"lockedOut"` // Blocked by a lockout rather than by the backoff
+
+	inFlight bool // A login is being checked
+}
+
+// LockoutEvent records a source IP or username being locked out
+type LockoutEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// LoginLimiter slows down repeated failed logins with an exponential backoff,
+// and locks out the source IP or username after too many failures
+type LoginLimiter struct {
+	cfg *config.Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	attempts map[string]*LoginAttempts // By kind and key
+	events   []LockoutEvent            // Oldest first
+}
+
+func NewLoginLimiter(cfg *config.Config) *LoginLimiter {
+	return &LoginLimiter{
+		cfg:      cfg,
+		now:      time.Now,
+		attempts: make(map[string]*LoginAttempts),
+	}
+}
+
+// Check returns how long the IP or the username must wait before trying again, 0 if it may
+// try now. A login may try while no other login of the IP or the username is being checked,
+// so parallel guesses can't pass before their failures are counted. The attempt is reserved
+// until the returned function is called, once the login is over.
+func (l *LoginLimiter) Check(ip, username string) (time.Duration, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	var wait time.Duration
+	for _, attempts := range []*LoginAttempts{l.attempts[LoginKeyIP+" "+ip], l.attempts[LoginKeyUsername+" "+username]} {
+		if attempts == nil {
+			continue
+		}
+		if attempts.BlockedUntil.Sub(now) > wait {
+			wait = attempts.BlockedUntil.Sub(now)
+		}
+		if attempts.inFlight && wait < inFlightWait {
+			wait = inFlightWait
+		}
+	}
+	if wait > 0 {
+		return wait, func() {}
+	}
+
+	reserved := []*LoginAttempts{l.entry(LoginKeyIP, ip, now), l.entry(LoginKeyUsername, username, now)}
+	for _, attempts := range reserved {
+		attempts.inFlight = true
+	}
+	return 0, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, attempts := range reserved {
+			attempts.inFlight = false
+			if attempts.Failures == 0 && l.attempts[attempts.Kind+" "+attempts.Key] == attempts {
+				delete(l.attempts, attempts.Kind+" "+attempts.Key)
+			}
+		}
+	}
+}
+
+// Failure records a failed login of the IP and the username
+func (l *LoginLimiter) Failure(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	settings := l.cfg.LoginProtection
+	now := l.now()
+	logging.LogInfo("Failed login for user %s from %s", username, ip)
+
+	for _, key := range [][2]string{{LoginKeyIP, ip}, {LoginKeyUsername, username}} {
+		attempts := l.entry(key[0], key[1], now)
+		if attempts.LockedOut && !attempts.BlockedUntil.After(now) {
+			// The lockout is over, start counting again
+			attempts.Failures = 0
+			attempts.LockedOut = false
+		}
+		attempts.Failures++
+		attempts.LastFailure = now
+
+		if attempts.Failures >= settings.MaxFailures {
+			lockout := time.Duration(settings.LockoutSeconds) * time.Second
+			attempts.LockedOut = true
+			attempts.BlockedUntil = now.Add(lockout)
+			l.events = append(l.events, LockoutEvent{Time: now, Kind: key[0], Key: key[1], Failures: attempts.Failures, Until: attempts.BlockedUntil})
+			if len(l.events) > maxLockoutEvents {
+				l.events = l.events[len(l.events)-maxLockoutEvents:]
+			}
+			logging.LogError("Locked out %s %s for %v after %d failed logins", key[0], key[1], lockout, attempts.Failures)
+			continue
+		}
+
+		delay := time.Duration(settings.MaxDelaySeconds) * time.Second
+		if attempts.Failures <= 30 {
+			if backoff := time.Duration(settings.BaseDelaySeconds) * time.Second << (attempts.Failures - 1); backoff < delay {
+				delay = backoff
+			}
+		}
+		attempts.BlockedUntil = now.Add(delay)
+	}
+}
+
+// Success forgets the failures of the IP and the username
+func (l *LoginLimiter) Success(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, LoginKeyIP+" "+ip)
+	delete(l.attempts, LoginKeyUsername+" "+username)
+}
+
+// Unlock forgets the failures of an IP or a username, false if there are none
+func (l *LoginLimiter) Unlock(kind, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.attempts[kind+" "+key]; !ok {
+		return false
+	}
+	delete(l.attempts, kind+" "+key)
+	logging.LogInfo("Cleared failed logins of %s %s", kind, key)
+	return true
+}
+
+// Status returns the counted failures, most recent first, and the recent lockouts, newest first
+func (l *LoginLimiter) Status() ([]LoginAttempts, []LockoutEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(l.now())
+	attempts := make([]LoginAttempts, 0, len(l.attempts))
+	for _, a := range l.attempts {
+		if a.Failures > 0 {
+			attempts = append(attempts, *a)
+		}
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].LastFailure.After(attempts[j].LastFailure)
+	})
+	events := make([]LockoutEvent, 0, len(l.events))
+	for i := len(l.events) - 1; i >= 0; i-- {
+		events = append(events, l.events[i])
+	}
+	return attempts, events
+}
+
+// entry returns the counted attempts of an IP or a username, adding them if needed.
+// Beyond maxLoginAttempts, the entry blocked for the shortest time is dropped, so
+// guessing random usernames can't grow the map. The caller holds l.mu.
+func (l *LoginLimiter) entry(kind, key string, now time.Time) *LoginAttempts {
+	if attempts, ok := l.attempts[kind+" "+key]; ok {
+		return attempts
+	}
+	if len(l.attempts) >= maxLoginAttempts {
+		l.prune(now)
+	}
+	if len(l.attempts) >= maxLoginAttempts {
+		var dropKey string
+		var drop *LoginAttempts
+		for k, attempts := range l.attempts {
+			if !attempts.inFlight && (drop == nil || attempts.BlockedUntil.Before(drop.BlockedUntil)) {
+				dropKey, drop = k, attempts
+			}
+		}
+		if drop != nil {
+			delete(l.attempts, dropKey)
+		}
+	}
+	attempts := &LoginAttempts{Kind: kind, Key: key}
+	l.attempts[kind+" "+key] = attempts
+	return attempts
+}
+
+// prune forgets the failures that are old enough, the caller holds l.mu
+func (l *LoginLimiter) prune(now time.Time) {
+	reset := time.Duration(l.cfg.LoginProtection.ResetSeconds) * time.Second
+	for key, attempts := range l.attempts {
+		if !attempts.inFlight && !attempts.BlockedUntil.After(now) && now.Sub(attempts.LastFailure) > reset {
+			delete(l.attempts, key)
+		}
+	}
+}
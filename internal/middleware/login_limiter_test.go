@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+)
+
+func newTestLimiter(now *time.Time) *LoginLimiter {
+	cfg := &config.Config{LoginProtection: config.LoginProtectionConfig{
+		MaxFailures:      4,
+		BaseDelaySeconds: 1,
+		MaxDelaySeconds:  3,
+		LockoutSeconds:   60,
+		ResetSeconds:     600,
+	}}
+	limiter := NewLoginLimiter(cfg)
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestLoginLimiter_BackoffAndLockout(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTestLimiter(&now)
+
+	if wait, _ := limiter.Check("192.0.2.1", "admin"); wait != 0 {
+		t.Fatalf("Expected no wait before failures, got %v", wait)
+	}
+
+	// Backoff doubles and is capped by the maximum delay
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		limiter.Failure("192.0.2.1", "admin")
+		if wait, _ := limiter.Check("192.0.2.1", "admin"); wait != want {
+			t.Errorf("Expected wait %v, got %v", want, wait)
+		}
+		now = now.Add(want)
+	}
+
+	// Another IP guessing the same username is blocked too
+	limiter.Failure("198.51.100.7", "admin")
+	if wait, _ := limiter.Check("203.0.113.9", "admin"); wait != 60*time.Second {
+		t.Errorf("Expected the username to be locked out for 60s, got %v", wait)
+	}
+	attempts, lockouts := limiter.Status()
+	if len(lockouts) != 1 || lockouts[0].Kind != LoginKeyUsername || lockouts[0].Key != "admin" {
+		t.Errorf("Expected one lockout of the username, got %+v", lockouts)
+	}
+	if len(attempts) != 3 {
+		t.Errorf("Expected attempts of two IPs and one username, got %+v", attempts)
+	}
+
+	// An admin lifts the lockout
+	if !limiter.Unlock(LoginKeyUsername, "admin") {
+		t.Fatalf("Unlock() = false")
+	}
+	if wait, _ := limiter.Check("203.0.113.9", "admin"); wait != 0 {
+		t.Errorf("Expected no wait after unlock, got %v", wait)
+	}
+}
+
+func TestLoginLimiter_SuccessAndReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTestLimiter(&now)
+
+	limiter.Failure("192.0.2.1", "admin")
+	limiter.Success("192.0.2.1", "admin")
+	if attempts, _ := limiter.Status(); len(attempts) != 0 {
+		t.Errorf("Expected failures to be forgotten after a success, got %+v", attempts)
+	}
+
+	limiter.Failure("192.0.2.1", "bob")
+	now = now.Add(601 * time.Second)
+	if attempts, _ := limiter.Status(); len(attempts) != 0 {
+		t.Errorf("Expected old failures to be pruned, got %+v", attempts)
+	}
+}
+
+func TestLoginLimiter_InFlight(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTestLimiter(&now)
+
+	// A parallel guess waits for the login being checked
+	wait, release := limiter.Check("192.0.2.1", "admin")
+	if wait != 0 {
+		t.Fatalf("Expected no wait before failures, got %v", wait)
+	}
+	if wait, _ := limiter.Check("198.51.100.7", "admin"); wait != inFlightWait {
+		t.Errorf("Expected the username to be busy, got %v", wait)
+	}
+	if wait, _ := limiter.Check("192.0.2.1", "bob"); wait != inFlightWait {
+		t.Errorf("Expected the IP to be busy, got %v", wait)
+	}
+	limiter.Failure("192.0.2.1", "admin")
+	release()
+	if wait, _ := limiter.Check("198.51.100.7", "admin"); wait != time.Second {
+		t.Errorf("Expected the backoff of the failure, got %v", wait)
+	}
+
+	// A released attempt without failure isn't kept
+	_, release = limiter.Check("203.0.113.9", "carol")
+	release()
+	if _, ok := limiter.attempts[LoginKeyUsername+" carol"]; ok {
+		t.Error("Expected the attempt without failure to be forgotten")
+	}
+}
+
+func TestLoginLimiter_Capped(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTestLimiter(&now)
+
+	limiter.Failure("192.0.2.1", "admin")
+	limiter.Failure("192.0.2.1", "admin")
+	limiter.Failure("192.0.2.1", "admin")
+	limiter.Failure("192.0.2.1", "admin")
+	for i := 0; i < maxLoginAttempts; i++ {
+		limiter.Failure("198.51.100.7", fmt.Sprintf("random-%d", i))
+	}
+	if len(limiter.attempts) > maxLoginAttempts {
+		t.Errorf("Expected at most %d counted attempts, got %d", maxLoginAttempts, len(limiter.attempts))
+	}
+	if wait, _ := limiter.Check("203.0.113.9", "admin"); wait != 60*time.Second {
+		t.Errorf("Expected the lockout of the username to be kept, got %v", wait)
+	}
+}
@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcLoginTTL    = 10 * time.Minute
+)
+
+// oidcPending is a login started by the browser holding the state cookie
+type oidcPending struct {
+	verifier string
+	nonce    string
+	started  time.Time
+}
+
+// OIDCLogin signs users in with an OpenID Connect provider, with the authorization
+// code flow and PKCE. Their roles come from the groups claim at each login.
+type OIDCLogin struct {
+	cfg  *config.Config
+	auth *AuthMiddleware
+
+	mu       sync.Mutex
+	provider *oidc.Provider          // Discovered on the first login, so the panel starts while the provider is down
+	pending  map[string]*oidcPending // By state
+}
+
+func NewOIDCLogin(cfg *config.Config, auth *AuthMiddleware) (*OIDCLogin, error) {
+	settings := cfg.OIDC
+	if settings.Enabled {
+		if settings.IssuerURL == "" || settings.ClientID == "" || settings.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc.issuerUrl, oidc.clientId and oidc.redirectUrl are required")
+		}
+		for _, mapping := range settings.RoleMappings {
+			access := config.Access{Role: mapping.Role}
+			if mapping.InterfaceID != "" {
+				access = config.Access{Bindings: []config.RoleBinding{{Role: mapping.Role, InterfaceID: mapping.InterfaceID, ServerID: mapping.ServerID}}}
+			}
+			if mapping.Group == "" {
+				return nil, fmt.Errorf("oidc role mapping requires a group")
+			}
+			if err := access.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid oidc role mapping of group %s:-> %v", mapping.Group, err)
+			}
+		}
+	}
+	return &OIDCLogin{
+		cfg:     cfg,
+		auth:    auth,
+		pending: make(map[string]*oidcPending),
+	}, nil
+}
+
+// Info tells the login page if single sign-on is available
+func (o *OIDCLogin) Info(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": o.cfg.OIDC.Enabled, "displayName": o.cfg.OIDC.DisplayName})
+}
+
+// Login redirects the browser to the provider
+func (o *OIDCLogin) Login(c *gin.Context) {
+	if !o.cfg.OIDC.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+	provider, err := o.getProvider(c.Request.Context())
+	if err != nil {
+		logging.LogError("OpenID Connect discovery failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	state, err := generateSessionToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := generateSessionToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+	o.mu.Lock()
+	for key, pending := range o.pending {
+		if now.Sub(pending.started) > oidcLoginTTL {
+			delete(o.pending, key)
+		}
+	}
+	o.pending[state] = &oidcPending{verifier: verifier, nonce: nonce, started: now}
+	o.mu.Unlock()
+
+	// The state is bound to this browser, so a login can't be injected from another one
+	c.SetCookie(oidcStateCookie, state, int(oidcLoginTTL.Seconds()), "/", "", o.cfg.TLS.Enabled(), true)
+	c.Redirect(http.StatusFound, o.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)))
+}
+
+// Callback completes the login when the provider redirects back, then opens the panel
+func (o *OIDCLogin) Callback(c *gin.Context) {
+	if !o.cfg.OIDC.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Identity provider refused the login: %s %s", reason, c.Query("error_description"))})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/", "", o.cfg.TLS.Enabled(), true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+	o.mu.Lock()
+	pending := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if pending == nil || time.Since(pending.started) > oidcLoginTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired, try again"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	provider, err := o.getProvider(ctx)
+	if err != nil {
+		logging.LogError("OpenID Connect discovery failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+	token, err := o.oauth2Config(provider).Exchange(ctx, c.Query("code"), oauth2.VerifierOption(pending.verifier))
+	if err != nil {
+		logging.LogError("OpenID Connect code exchange failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to exchange authorization code"})
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider returned no ID token"})
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: o.cfg.OIDC.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		logging.LogError("OpenID Connect ID token rejected: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(pending.nonce)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token claims"})
+		return
+	}
+	if _, ok := claims[o.cfg.OIDC.GroupsClaim]; !ok {
+		// Some providers only return the groups from the userinfo endpoint
+		if userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token)); err == nil {
+			userInfo.Claims(&claims)
+		}
+	}
+
+	username, _ := claims[o.cfg.OIDC.UsernameClaim].(string)
+	if username == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("ID token has no %s claim", o.cfg.OIDC.UsernameClaim)})
+		return
+	}
+	access := o.cfg.OIDC.MapGroups(claimStrings(claims[o.cfg.OIDC.GroupsClaim]))
+	if access.Role == "" && len(access.Bindings) == 0 {
+		logging.LogInfo("Single sign-on of %s refused, no role is mapped to its groups", username)
+		c.JSON(http.StatusForbidden, gin.H{"error": "No role is granted to your groups"})
+		return
+	}
+	if err := o.cfg.ProvisionOIDCUser(username, idToken.Subject, access, o.cfg.OIDC.AutoProvision); err != nil {
+		logging.LogInfo("Single sign-on of %s refused: %v", username, err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err := o.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	if err := o.auth.startSession(c, username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate session"})
+		return
+	}
+	logging.LogInfo("User %s signed in with single sign-on", username)
+	c.Redirect(http.StatusFound, o.cfg.BasePath)
+}
+
+func (o *OIDCLogin) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, o.cfg.OIDC.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	o.provider = provider
+	return provider, nil
+}
+
+func (o *OIDCLogin) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.cfg.OIDC.ClientID,
+		ClientSecret: o.cfg.OIDC.ClientSecret,
+		RedirectURL:  o.cfg.OIDC.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, o.cfg.OIDC.Scopes...),
+	}
+}
+
+// claimStrings reads a claim holding a list of strings, or a single string
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mockIssuer is a minimal OpenID Connect provider checking PKCE
+type mockIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	username string
+	groups   []string
+
+	mu    sync.Mutex
+	codes map[string]url.Values // Authorization request by code
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := "code-" + query.Get("state")
+		m.mu.Lock()
+		m.codes[code] = query
+		m.mu.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+query.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		auth, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.idToken(t, auth.Get("client_id"), auth.Get("nonce")),
+		})
+	})
+	return m
+}
+
+func (m *mockIssuer) idToken(t *testing.T, clientID, nonce string) string {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"iss": m.URL, "sub": "sub-" + m.username, "aud": clientID, "nonce": nonce,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+		"preferred_username": m.username, "groups": m.groups,
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// signIn runs the browser side of the login and returns the final response of the panel
+func signIn(t *testing.T, router *gin.Engine, issuer *mockIssuer) *httptest.ResponseRecorder {
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("Login returned %d: %s", login.Code, login.Body.String())
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(login.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	req := httptest.NewRequest(http.MethodGet, "/callback?"+callback.RawQuery, nil)
+	for _, cookie := range login.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func hasCookie(rec *httptest.ResponseRecorder, name string) bool {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	dir := t.TempDir()
+	cfg := &config.Config{
+		ConfigPath:    filepath.Join(dir, "config.json"),
+		RevisionsPath: filepath.Join(dir, "revisions"),
+		BasePath:      "/",
+		User:          "admin",
+		Users:         map[string]*config.User{"local": {Access: config.Access{Role: config.RoleAdmin}}},
+		Sessions:      map[string]*config.Session{},
+		OIDC: config.OIDCConfig{
+			Enabled:       true,
+			IssuerURL:     issuer.URL,
+			ClientID:      "panel",
+			RedirectURL:   "http://panel.test/callback",
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+			AutoProvision: true,
+			RoleMappings: []config.OIDCRoleMapping{
+				{Group: "vpn-viewers", Role: config.RoleViewer},
+				{Group: "vpn-ops", Role: config.RoleOperator, InterfaceID: "if1"},
+			},
+		},
+	}
+	oidcLogin, err := NewOIDCLogin(cfg, NewAuthMiddleware(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/login", oidcLogin.Login)
+	router.GET("/callback", oidcLogin.Callback)
+
+	// A new user is provisioned with the roles of their groups
+	issuer.username, issuer.groups = "alice", []string{"vpn-viewers", "vpn-ops"}
+	rec := signIn(t, router, issuer)
+	if rec.Code != http.StatusFound || !hasCookie(rec, "session_token") {
+		t.Fatalf("Callback returned %d without a session: %s", rec.Code, rec.Body.String())
+	}
+	access, ok := cfg.GetUserAccess("alice")
+	if !ok || access.Role != config.RoleViewer || access.RoleFor("if1", "") != config.RoleOperator {
+		t.Errorf("Unexpected access of provisioned user: %+v, %v", access, ok)
+	}
+
+	// Users without a mapped group are refused
+	issuer.groups = []string{"other"}
+	if rec := signIn(t, router, issuer); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a mapped group, got %d", rec.Code)
+	}
+
+	// Local users can't be taken over by name
+	issuer.username, issuer.groups = "local", []string{"vpn-viewers"}
+	if rec := signIn(t, router, issuer); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a local user, got %d", rec.Code)
+	}
+	if access, _ := cfg.GetUserAccess("local"); access.Role != config.RoleAdmin {
+		t.Errorf("Local user should keep its roles, got %+v", access)
+	}
+
+	// The callback must come from the browser which started the login
+	req := httptest.NewRequest(http.MethodGet, "/callback?code=x&state=forged", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a forged state, got %d", rec.Code)
+	}
+}
+
+func TestOIDCLogin_WithoutAutoProvision(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	dir := t.TempDir()
+	cfg := &config.Config{
+		ConfigPath:    filepath.Join(dir, "config.json"),
+		RevisionsPath: filepath.Join(dir, "revisions"),
+		BasePath:      "/",
+		User:          "admin",
+		Users: map[string]*config.User{
+			"bob":   {OIDCSubject: "sub-bob"},
+			"carol": {Access: config.Access{Role: config.RoleAdmin}},
+		},
+		Sessions: map[string]*config.Session{},
+		OIDC: config.OIDCConfig{
+			Enabled:       true,
+			IssuerURL:     issuer.URL,
+			ClientID:      "panel",
+			RedirectURL:   "http://panel.test/callback",
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+			RoleMappings:  []config.OIDCRoleMapping{{Group: "vpn-viewers", Role: config.RoleViewer}},
+		},
+	}
+	oidcLogin, err := NewOIDCLogin(cfg, NewAuthMiddleware(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/login", oidcLogin.Login)
+	router.GET("/callback", oidcLogin.Callback)
+
+	// A user linked to the subject beforehand signs in with the roles of their groups
+	issuer.username, issuer.groups = "bob", []string{"vpn-viewers"}
+	if rec := signIn(t, router, issuer); rec.Code != http.StatusFound || !hasCookie(rec, "session_token") {
+		t.Fatalf("Callback returned %d without a session: %s", rec.Code, rec.Body.String())
+	}
+	if access, _ := cfg.GetUserAccess("bob"); access.Role != config.RoleViewer {
+		t.Errorf("Unexpected access of linked user: %+v", access)
+	}
+
+	// Unknown and unlinked users are refused
+	for _, username := range []string{"dave", "carol"} {
+		issuer.username = username
+		if rec := signIn(t, router, issuer); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s, got %d", username, rec.Code)
+		}
+	}
+	if _, ok := cfg.GetUserAccess("dave"); ok {
+		t.Error("A user shouldn't be created without autoProvision")
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// portalSessionTTL bounds the portal session opened with a one-time link
+const portalSessionTTL = 24 * time.Hour
+
+// PortalLogin opens a portal session with the token of a portal link
+func (a *AuthMiddleware) PortalLogin(c *gin.Context) {
+	var loginReq struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&loginReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	replacement, err := generateSessionToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	link, token, err := a.cfg.RedeemPortalLink(loginReq.Token, replacement, portalSessionTTL)
+	if err != nil {
+		logging.LogInfo("Refused portal login from %s: %v", c.ClientIP(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	if _, err := a.cfg.GetClient(link.InterfaceID, link.ServerID, link.ClientID); err != nil {
+		a.revokeOrphanPortalLink(link)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	if err := a.cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+
+	maxAge := 0 // Browser session
+	if link.ExpiresAt != nil {
+		maxAge = int(time.Until(*link.ExpiresAt).Seconds())
+	}
+	c.SetCookie("portal_token", token, maxAge, "/", "", a.cfg.TLS.Enabled(), true)
+	c.Status(http.StatusNoContent)
+}
+
+// PortalLogout forgets the portal session of the browser. A one-time link is revoked,
+// since it can't be opened again anyway.
+func (a *AuthMiddleware) PortalLogout(c *gin.Context) {
+	if token, err := c.Cookie("portal_token"); err == nil {
+		if link, _, err := a.cfg.UsePortalLink(token); err == nil && link.OneTime {
+			if err := a.cfg.DeletePortalLink(link.ID); err == nil {
+				if err := a.cfg.Save(); err != nil {
+					logging.LogError("Failed to save configuration: %v", err)
+				}
+			}
+		}
+	}
+	c.SetCookie("portal_token", "", -1, "/", "", a.cfg.TLS.Enabled(), true)
+	c.Status(http.StatusNoContent)
+}
+
+// RequirePortal authenticates the portal session of the request and scopes it to
+// the client of the link, which the handlers read from the ifId, serverId and
+// clientId parameters. The username is "portal:<link ID>" for the audit log.
+func (a *AuthMiddleware) RequirePortal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := c.Cookie("portal_token")
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		link, stale, err := a.cfg.UsePortalLink(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+			c.Abort()
+			return
+		}
+
+		// The client may have been deleted with its interface or server since the link was created
+		if _, err := a.cfg.GetClient(link.InterfaceID, link.ServerID, link.ClientID); err != nil {
+			a.revokeOrphanPortalLink(link)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+			c.Abort()
+			return
+		}
+
+		if stale {
+			if err := a.cfg.Save(); err != nil {
+				logging.LogError("Failed to save last use of portal link %s: %v", link.ID, err)
+			}
+		}
+
+		c.Params = append(c.Params,
+			gin.Param{Key: "ifId", Value: link.InterfaceID},
+			gin.Param{Key: "serverId", Value: link.ServerID},
+			gin.Param{Key: "clientId", Value: link.ClientID},
+		)
+		c.Set("username", "portal:"+link.ID)
+		c.Set("access", config.Access{})
+		c.Next()
+	}
+}
+
+// revokeOrphanPortalLink deletes a link whose client doesn't exist anymore
+func (a *AuthMiddleware) revokeOrphanPortalLink(link *config.PortalLink) {
+	if err := a.cfg.DeletePortalLink(link.ID); err != nil {
+		return
+	}
+	logging.LogInfo("Revoked portal link %s of deleted client %s", link.ID, link.ClientID)
+	if err := a.cfg.Save(); err != nil {
+		logging.LogError("Failed to save configuration: %v", err)
+	}
+}
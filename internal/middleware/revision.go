@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"wg-panel/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TagRevisions sets the author and message of the revisions saved by the requests.
+// The message comes from the X-Revision-Message header, or is the method and path.
+// It must run after SerializeChanges, so only one request tags the revisions at a time.
+func TagRevisions(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet {
+			c.Next()
+			return
+		}
+		message := c.GetHeader("X-Revision-Message")
+		if message == "" {
+			message = c.Request.Method + " " + c.Request.URL.Path
+		}
+		cfg.BeginRevision(c.GetString("username"), message)
+		defer cfg.EndRevision()
+		c.Next()
+	}
+}
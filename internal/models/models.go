@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"time"
@@ -76,6 +77,19 @@ type Interface struct {
 	Servers    []*Server `json:"servers,omitempty"`
 }
 
+// Copy returns a deep copy of the interface, including its servers and clients
+func (src *Interface) Copy() (*Interface, error) {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy interface %s:-> %v", src.Ifname, err)
+	}
+	dst := &Interface{}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return nil, fmt.Errorf("failed to copy interface %s:-> %v", src.Ifname, err)
+	}
+	return dst, nil
+}
+
 type Server struct {
 	ID        string               `json:"id"`
 	Name      string               `json:"name"`
@@ -84,33 +98,152 @@ type Server struct {
 	IPv4      *ServerNetworkConfig `json:"ipv4"`
 	IPv6      *ServerNetworkConfig `json:"ipv6"`
 	Keepalive *int                 `json:"keepalive"`
-	Clients   []*Client            `json:"clients,omitempty"`
+	// The private keys generated for the clients are returned once, never stored
+	EphemeralPrivateKeys bool `json:"ephemeralPrivateKeys,omitempty"`
+	// Default rate limits of the clients in kbit/s, 0 is unlimited
+	RateLimitUp   int       `json:"rateLimitUp,omitempty"`
+	RateLimitDown int       `json:"rateLimitDown,omitempty"`
+	Clients       []*Client `json:"clients,omitempty"`
 }
 
 type Client struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Enabled      bool      `json:"enabled"`
-	IPv4Offset   IPWrapper `json:"ipv4offset"`
-	IPv6Offset   IPWrapper `json:"ipv6offset"`
-	DNS          []string  `json:"dns"`
-	PrivateKey   *string   `json:"privateKey,omitempty"`
-	PublicKey    string    `json:"publicKey"`
-	PresharedKey *string   `json:"presharedKey,omitempty"`
-	Keepalive    *int      `json:"keepalive"`
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Enabled      bool         `json:"enabled"`
+	IPv4Offset   IPWrapper    `json:"ipv4offset"`
+	IPv6Offset   IPWrapper    `json:"ipv6offset"`
+	DNS          []string     `json:"dns"`
+	PrivateKey   *string      `json:"privateKey,omitempty"`
+	PublicKey    string       `json:"publicKey"`
+	PresharedKey *string      `json:"presharedKey,omitempty"`
+	Keepalive    *int         `json:"keepalive"`
+	ExpiresAt    *time.Time   `json:"expiresAt,omitempty"` // Disabled by the expiry loop afterwards
+	Quota        *ClientQuota `json:"quota,omitempty"`
+	// Rate limits in kbit/s of the traffic from and to the client, the ones of the server if nil, 0 is unlimited
+	RateLimitUp   *int `json:"rateLimitUp,omitempty"`
+	RateLimitDown *int `json:"rateLimitDown,omitempty"`
+}
+
+// RateLimits returns the rate limits of the client in kbit/s, 0 is unlimited
+func (c *Client) RateLimits(server *Server) (up, down int) {
+	up, down = server.RateLimitUp, server.RateLimitDown
+	if c.RateLimitUp != nil {
+		up = *c.RateLimitUp
+	}
+	if c.RateLimitDown != nil {
+		down = *c.RateLimitDown
+	}
+	return up, down
+}
+
+// Periods of a client quota
+const (
+	QuotaPeriodMonthly = "monthly"
+	QuotaPeriodRolling = "rolling"
+)
+
+// Actions taken when a client exceeds its quota
+const (
+	QuotaActionDisable  = "disable"
+	QuotaActionThrottle = "throttle"
+)
+
+// ClientQuota limits the traffic of a client over a monthly or rolling period. Rx is
+// the traffic received from the client, Tx the traffic sent to it, 0 is unlimited.
+type ClientQuota struct {
+	Period       string `json:"period"`
+	ResetDay     int    `json:"resetDay,omitempty"`    // Day of the month starting a monthly period, 1 to 28
+	RollingDays  int    `json:"rollingDays,omitempty"` // Length of a rolling period, 1 to 60
+	RxBytes      int64  `json:"rxBytes,omitempty"`
+	TxBytes      int64  `json:"txBytes,omitempty"`
+	TotalBytes   int64  `json:"totalBytes,omitempty"`
+	Action       string `json:"action"`
+	ThrottleKbit int    `json:"throttleKbit,omitempty"` // Rate in both directions for the throttle action
+}
+
+// Validate checks the quota and fills the defaults
+func (q *ClientQuota) Validate() error {
+	switch q.Period {
+	case "", QuotaPeriodMonthly:
+		q.Period = QuotaPeriodMonthly
+		if q.ResetDay == 0 {
+			q.ResetDay = 1
+		}
+		if q.ResetDay < 1 || q.ResetDay > 28 {
+			return fmt.Errorf("quota reset day must be between 1 and 28")
+		}
+		q.RollingDays = 0
+	case QuotaPeriodRolling:
+		if q.RollingDays == 0 {
+			q.RollingDays = 30
+		}
+		if q.RollingDays < 1 || q.RollingDays > 60 {
+			return fmt.Errorf("quota rolling days must be between 1 and 60")
+		}
+		q.ResetDay = 0
+	default:
+		return fmt.Errorf("quota period must be %s or %s", QuotaPeriodMonthly, QuotaPeriodRolling)
+	}
+	if q.RxBytes < 0 || q.TxBytes < 0 || q.TotalBytes < 0 {
+		return fmt.Errorf("quota bytes can't be negative")
+	}
+	if q.RxBytes == 0 && q.TxBytes == 0 && q.TotalBytes == 0 {
+		return fmt.Errorf("quota must limit rx, tx or total bytes")
+	}
+	switch q.Action {
+	case "", QuotaActionDisable:
+		q.Action = QuotaActionDisable
+		q.ThrottleKbit = 0
+	case QuotaActionThrottle:
+		if q.ThrottleKbit <= 0 {
+			return fmt.Errorf("quota throttle rate must be positive")
+		}
+	default:
+		return fmt.Errorf("quota action must be %s or %s", QuotaActionDisable, QuotaActionThrottle)
+	}
+	return nil
+}
+
+// PeriodStart returns the start of the period of the quota containing now
+func (q *ClientQuota) PeriodStart(now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if q.Period == QuotaPeriodRolling {
+		return today.AddDate(0, 0, 1-q.RollingDays)
+	}
+	start := time.Date(now.Year(), now.Month(), q.ResetDay, 0, 0, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// Exceeded tells if the traffic is over the quota
+func (q *ClientQuota) Exceeded(rx, tx int64) bool {
+	return (q.RxBytes > 0 && rx >= q.RxBytes) ||
+		(q.TxBytes > 0 && tx >= q.TxBytes) ||
+		(q.TotalBytes > 0 && rx+tx >= q.TotalBytes)
+}
+
+// Expired tells if the client has an expiry date before now
+func (c *Client) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
 }
 
 type ClientFrontend struct {
-	ID           string   `json:"id"`
-	Name         string   `json:"name"`
-	Enabled      bool     `json:"enabled"`
-	IPv4         net.IP   `json:"ip"`
-	IPv6         net.IP   `json:"ipv6"`
-	DNS          []string `json:"dns"`
-	PrivateKey   *string  `json:"privateKey,omitempty"`
-	PublicKey    string   `json:"publicKey"`
-	PresharedKey *string  `json:"presharedKey,omitempty"`
-	Keepalive    *int     `json:"keepalive"`
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Enabled       bool         `json:"enabled"`
+	IPv4          net.IP       `json:"ip"`
+	IPv6          net.IP       `json:"ipv6"`
+	DNS           []string     `json:"dns"`
+	PrivateKey    *string      `json:"privateKey,omitempty"`
+	PublicKey     string       `json:"publicKey"`
+	PresharedKey  *string      `json:"presharedKey,omitempty"`
+	Keepalive     *int         `json:"keepalive"`
+	ExpiresAt     *time.Time   `json:"expiresAt,omitempty"`
+	Quota         *ClientQuota `json:"quota,omitempty"`
+	RateLimitUp   *int         `json:"rateLimitUp,omitempty"`
+	RateLimitDown *int         `json:"rateLimitDown,omitempty"`
 }
 
 func (c *Client) ToClientFrontend(server *Server) (*ClientFrontend, error) {
@@ -118,14 +251,18 @@ func (c *Client) ToClientFrontend(server *Server) (*ClientFrontend, error) {
 		return nil, nil
 	}
 	clientFrontend := &ClientFrontend{
-		ID:           c.ID,
-		Name:         c.Name,
-		Enabled:      c.Enabled,
-		DNS:          c.DNS,
-		PrivateKey:   c.PrivateKey,
-		PublicKey:    c.PublicKey,
-		PresharedKey: c.PresharedKey,
-		Keepalive:    c.Keepalive,
+		ID:            c.ID,
+		Name:          c.Name,
+		Enabled:       c.Enabled,
+		DNS:           c.DNS,
+		PrivateKey:    c.PrivateKey,
+		PublicKey:     c.PublicKey,
+		PresharedKey:  c.PresharedKey,
+		Keepalive:     c.Keepalive,
+		ExpiresAt:     c.ExpiresAt,
+		Quota:         c.Quota,
+		RateLimitUp:   c.RateLimitUp,
+		RateLimitDown: c.RateLimitDown,
 	}
 	if server != nil {
 		v4, _ := c.GetIPv4(server.IPv4.Network)
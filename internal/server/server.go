@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,13 +16,16 @@ import (
 	"wg-panel/internal/handlers"
 	"wg-panel/internal/internalservice"
 	"wg-panel/internal/logging"
+	"wg-panel/internal/metrics"
 	"wg-panel/internal/middleware"
 	"wg-panel/internal/services"
-	"wg-panel/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
+//go:embed portal.html
+var portalHTML string
+
 type Server struct {
 	cfg        *config.Config
 	engine     *gin.Engine
@@ -36,12 +40,7 @@ func NewServer(cfg *config.Config, frontendFS embed.FS) *Server {
 }
 
 func (s *Server) Start(fw *internalservice.FirewallService, logLevel logging.LogLevel) error {
-	var listenAddr string
-	if strings.Contains(s.cfg.ListenIP, ":") {
-		listenAddr = fmt.Sprintf("[%s]:%d", s.cfg.ListenIP, s.cfg.ListenPort)
-	} else {
-		listenAddr = fmt.Sprintf("%s:%d", s.cfg.ListenIP, s.cfg.ListenPort)
-	}
+	listenAddr := listenAddress(s.cfg.ListenIP, s.cfg.ListenPort)
 
 	// Check if the address is already in use
 	listener, err := net.Listen("tcp", listenAddr)
@@ -49,41 +48,144 @@ func (s *Server) Start(fw *internalservice.FirewallService, logLevel logging.Log
 		return fmt.Errorf("address %s already in use or unavailable: %v", listenAddr, err)
 	}
 	listener.Close()
+
+	// Prepare the certificates before touching the interfaces
+	var secure *tlsSetup
+	if s.cfg.TLS.Enabled() {
+		if secure, err = s.setupTLS(); err != nil {
+			return fmt.Errorf("failed to set up TLS:-> %v", err)
+		}
+	}
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 	s.engine = gin.New()
+	// The client IP counts failed logins and is audited, only trust the configured proxies for it
+	if err := s.engine.SetTrustedProxies(s.cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trustedProxies:-> %v", err)
+	}
 	s.engine.Use(CustomLogger(logLevel), gin.Recovery())
 
 	// Setup services
-	wgService := services.NewWireGuardService(s.cfg.WireGuardConfigPath)
 	firewallService := fw
+	wgBackend, err := services.NewWireGuardBackend(s.cfg.WireGuardBackend, s.cfg.WireGuardConfigPath, firewallService)
+	if err != nil {
+		return err
+	}
+	wgService := services.NewWireGuardService(s.cfg.WireGuardConfigPath, wgBackend, firewallService)
 	startupService := services.NewStartupService(s.cfg, wgService, firewallService)
 
 	interfaceService := services.NewInterfaceService(s.cfg, wgService)
 	serverService := services.NewServerService(s.cfg, wgService, firewallService)
 	clientService := services.NewClientService(s.cfg, wgService)
+	reconcileService := services.NewReconcileService(s.cfg, wgService, firewallService, startupService)
+	auditService := services.NewAuditService(s.cfg.AuditLogPath)
+	revisionService := services.NewRevisionService(s.cfg, wgService, startupService)
+	quotaService := services.NewQuotaService(s.cfg, wgService, auditService)
+	wgService.SetThrottler(quotaService)
+	historyService, err := services.NewHistoryService(s.cfg, wgService)
+	if err != nil {
+		return fmt.Errorf("failed to open traffic history:-> %v", err)
+	}
+
+	if err := s.cfg.RecordStartupRevision(); err != nil {
+		logging.LogError("Warning: failed to record configuration revision: %v", err)
+	}
 
 	// Initialize interfaces and firewall rules during startup
-	if err := utils.CleanupRules(s.cfg.WGPanelId, 46, nil, true); err != nil {
+	if err := firewallService.RemoveAllRules(); err != nil {
 		logging.LogError("Warning: failed to cleanup orphaned rules: %v", err)
 	}
 	if err := startupService.InitializeInterfaces(); err != nil {
 		return fmt.Errorf("failed to initialize interfaces:-> %v", err)
 	}
+	reconcileService.Start()
+	services.NewExpiryService(s.cfg, wgService, auditService).Start()
+	quotaService.Start()
+	historyService.Start()
+	services.NewPeerMonitorService(s.cfg, wgService).Start()
+	webhookService := services.NewWebhookService(s.cfg)
+	webhookService.Start()
 
 	// Setup middleware
 	authMiddleware := middleware.NewAuthMiddleware(s.cfg)
+	oidcLogin, err := middleware.NewOIDCLogin(s.cfg, authMiddleware)
+	if err != nil {
+		return fmt.Errorf("invalid single sign-on configuration:-> %v", err)
+	}
 
 	// Setup handlers
 	serviceHandler := handlers.NewServiceHandler(s.cfg, authMiddleware)
 	interfaceHandler := handlers.NewInterfaceHandler(interfaceService)
 	serverHandler := handlers.NewServerHandler(serverService)
-	clientHandler := handlers.NewClientHandler(clientService)
+	clientHandler := handlers.NewClientHandler(clientService, quotaService)
+	reconcileHandler := handlers.NewReconcileHandler(s.cfg, reconcileService)
+	userHandler := handlers.NewUserHandler(s.cfg)
+	tokenHandler := handlers.NewTokenHandler(s.cfg)
+	webhookHandler := handlers.NewWebhookHandler(s.cfg, webhookService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	revisionHandler := handlers.NewRevisionHandler(s.cfg, revisionService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(s.cfg)
+	portalHandler := handlers.NewPortalHandler(s.cfg, clientService)
+	downloadHandler := handlers.NewDownloadHandler(s.cfg, clientService, auditService)
+	historyHandler := handlers.NewHistoryHandler(s.cfg, historyService)
+	eventHandler := handlers.NewEventHandler()
+	metricsHandler := handlers.NewMetricsHandler(s.cfg, services.NewMetricsService(s.cfg, wgService))
 
 	// Setup routes
-	s.setupRoutes(serviceHandler, interfaceHandler, serverHandler, clientHandler, authMiddleware)
+	s.setupRoutes(serviceHandler, interfaceHandler, serverHandler, clientHandler, reconcileHandler, userHandler, tokenHandler, webhookHandler, auditHandler, revisionHandler, twoFactorHandler, portalHandler, downloadHandler, historyHandler, eventHandler, auditService, authMiddleware, oidcLogin)
+	s.startMetrics(metricsHandler)
 	// Start server
-	return http.ListenAndServe(listenAddr, s.engine)
+	httpServer := &http.Server{Addr: listenAddr, Handler: s.engine}
+	if secure == nil {
+		return httpServer.ListenAndServe()
+	}
+	if secure.httpHandler != nil {
+		httpAddr := listenAddress(s.cfg.ListenIP, s.cfg.TLS.HTTPPort)
+		go func() {
+			logging.LogInfo("Serving plain HTTP on %s", httpAddr)
+			if err := http.ListenAndServe(httpAddr, secure.httpHandler); err != nil {
+				logging.LogError("Plain HTTP listener on %s stopped: %v", httpAddr, err)
+			}
+		}()
+	}
+	httpServer.TLSConfig = secure.config
+	return httpServer.ListenAndServeTLS("", "")
+}
+
+// startMetrics serves the metrics on their own listen address, or with their token
+// on the panel's listener
+func (s *Server) startMetrics(handler *handlers.MetricsHandler) {
+	if !s.cfg.Metrics.Enabled {
+		return
+	}
+	if addr := s.cfg.Metrics.ListenAddress; addr != "" {
+		engine := gin.New()
+		engine.Use(gin.Recovery())
+		engine.GET("/metrics", handler.GetMetrics)
+		go func() {
+			logging.LogInfo("Serving metrics on %s", addr)
+			if err := http.ListenAndServe(addr, engine); err != nil {
+				logging.LogError("Metrics listener on %s stopped: %v", addr, err)
+			}
+		}()
+		return
+	}
+	if s.cfg.Metrics.Token == "" {
+		logging.LogError("Warning: metrics not served, they need a token or their own listenAddress")
+		return
+	}
+	metricsPath := s.cfg.BasePath + "metrics"
+	if !strings.HasSuffix(s.cfg.BasePath, "/") {
+		metricsPath = s.cfg.BasePath + "/metrics"
+	}
+	s.engine.GET(metricsPath, handler.GetMetrics)
+}
+
+func listenAddress(ip string, port int) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("[%s]:%d", ip, port)
+	}
+	return fmt.Sprintf("%s:%d", ip, port)
 }
 
 func (s *Server) setupRoutes(
@@ -91,7 +193,20 @@ func (s *Server) setupRoutes(
 	interfaceHandler *handlers.InterfaceHandler,
 	serverHandler *handlers.ServerHandler,
 	clientHandler *handlers.ClientHandler,
+	reconcileHandler *handlers.ReconcileHandler,
+	userHandler *handlers.UserHandler,
+	tokenHandler *handlers.TokenHandler,
+	webhookHandler *handlers.WebhookHandler,
+	auditHandler *handlers.AuditHandler,
+	revisionHandler *handlers.RevisionHandler,
+	twoFactorHandler *handlers.TwoFactorHandler,
+	portalHandler *handlers.PortalHandler,
+	downloadHandler *handlers.DownloadHandler,
+	historyHandler *handlers.HistoryHandler,
+	eventHandler *handlers.EventHandler,
+	auditService *services.AuditService,
 	authMiddleware *middleware.AuthMiddleware,
+	oidcLogin *middleware.OIDCLogin,
 ) {
 	// API routes first to avoid conflicts
 	apiPath := s.cfg.BasePath + s.cfg.APIPrefix
@@ -104,13 +219,78 @@ func (s *Server) setupRoutes(
 	serviceGroup := api.Group("/service")
 	serviceHandler.RegisterRoutes(serviceGroup)
 
+	// User management, only for global admins
+	usersGroup := serviceGroup.Group("/users")
+	usersGroup.Use(authMiddleware.RequireAuth(), authMiddleware.RequireRole(config.RoleAdmin), middleware.AuditChanges(s.cfg, auditService))
+	userHandler.RegisterRoutes(usersGroup)
+
+	// API tokens of the logged in user, a token can't manage the tokens
+	tokensGroup := serviceGroup.Group("/tokens")
+	tokensGroup.Use(authMiddleware.RequireAuth(), authMiddleware.RequireSession(), middleware.AuditChanges(s.cfg, auditService))
+	tokenHandler.RegisterRoutes(tokensGroup)
+
+	// Outgoing webhooks of the events, only for global admins
+	webhooksGroup := serviceGroup.Group("/webhooks")
+	webhooksGroup.Use(authMiddleware.RequireAuth(), authMiddleware.RequireRole(config.RoleAdmin), middleware.AuditChanges(s.cfg, auditService))
+	webhookHandler.RegisterRoutes(webhooksGroup)
+
+	// Single sign-on with the OpenID Connect provider
+	oidcGroup := serviceGroup.Group("/oidc")
+	oidcGroup.GET("", oidcLogin.Info)
+	oidcGroup.GET("/login", oidcLogin.Login)
+	oidcGroup.GET("/callback", oidcLogin.Callback)
+
+	// Two-factor authentication of the logged in user
+	twoFactorGroup := serviceGroup.Group("/2fa")
+	twoFactorGroup.Use(authMiddleware.RequireAuth(), authMiddleware.RequireSession(), middleware.AuditChanges(s.cfg, auditService))
+	twoFactorHandler.RegisterRoutes(twoFactorGroup)
+
+	// Failed logins and lockouts, only for global admins
+	loginAttemptsGroup := serviceGroup.Group("/login-attempts")
+	loginAttemptsGroup.Use(authMiddleware.RequireAuth(), authMiddleware.RequireRole(config.RoleAdmin), middleware.AuditChanges(s.cfg, auditService))
+	loginAttemptsGroup.GET("", authMiddleware.ListLoginAttempts)
+	loginAttemptsGroup.DELETE("", authMiddleware.ClearLoginAttempts)
+
+	// Audit log of the configuration changes, only for global admins
+	auditGroup := serviceGroup.Group("/audit")
+	auditGroup.Use(authMiddleware.RequireAuth(), authMiddleware.RequireRole(config.RoleAdmin))
+	auditHandler.RegisterRoutes(auditGroup)
+
+	// Self-service portal of a client, opened with a portal link instead of a user
+	portalGroup := api.Group("/portal")
+	portalGroup.POST("/login", authMiddleware.PortalLogin)
+	portalGroup.POST("/logout", authMiddleware.PortalLogout)
+	portalSessionGroup := portalGroup.Group("")
+	portalSessionGroup.Use(authMiddleware.RequirePortal(), middleware.SerializeChanges(s.cfg), middleware.TagRevisions(s.cfg), middleware.AuditChanges(s.cfg, auditService))
+	portalHandler.RegisterRoutes(portalSessionGroup)
+
+	// Configuration downloads, authenticated by the signature of their link
+	downloadGroup := api.Group("/download")
+	downloadHandler.RegisterRoutes(downloadGroup)
+
 	// Protect all other routes with authentication
 	protected := api.Group("")
 	protected.Use(authMiddleware.RequireAuth())
 
+	// Reconciliation routes, they take the apply lock themselves
+	reconcileGroup := protected.Group("/reconcile")
+	reconcileGroup.Use(authMiddleware.RequireRole(config.RoleViewer))
+	reconcileHandler.RegisterRoutes(reconcileGroup)
+
+	// Event stream, filtered by the access of the user
+	eventsGroup := protected.Group("/events")
+	eventHandler.RegisterRoutes(eventsGroup)
+
+	// Configuration history, only for global admins
+	revisionsGroup := protected.Group("/revisions")
+	revisionsGroup.Use(authMiddleware.RequireRole(config.RoleAdmin), middleware.SerializeChanges(s.cfg), middleware.AuditChanges(s.cfg, auditService))
+	revisionHandler.RegisterRoutes(revisionsGroup)
+
 	// Interface routes
 	interfacesGroup := protected.Group("/interfaces")
+	interfacesGroup.Use(authMiddleware.Authorize(), middleware.SerializeChanges(s.cfg), middleware.TagRevisions(s.cfg), middleware.AuditChanges(s.cfg, auditService))
 	interfaceHandler.RegisterRoutes(interfacesGroup)
+	historyHandler.RegisterRoutes(interfacesGroup)
 
 	// Server routes (nested under interfaces)
 	interfacesGroup.Group("/:ifId/servers").Use(func(c *gin.Context) {
@@ -123,6 +303,8 @@ func (s *Server) setupRoutes(
 	// Client routes (nested under servers)
 	serversWithClientGroup := interfacesGroup.Group("/:ifId/servers/:serverId")
 	clientHandler.RegisterRoutes(serversWithClientGroup)
+	portalHandler.RegisterLinkRoutes(serversWithClientGroup)
+	downloadHandler.RegisterLinkRoutes(serversWithClientGroup)
 
 	// Static file serving (after API routes) using embedded filesystem
 	sitePrefix := s.cfg.BasePath
@@ -150,6 +332,16 @@ func (s *Server) setupRoutes(
 		})
 	}
 
+	// Page of the self-service portal, outside of the frontend build
+	portalPage := sitePrefix + "portal"
+	if !strings.HasSuffix(sitePrefix, "/") {
+		portalPage = sitePrefix + "/portal"
+	}
+	s.engine.GET(portalPage, func(c *gin.Context) {
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(s.injectRuntimeConfig(portalHTML)))
+	})
+
 	// Handle all other routes - serve static files
 	s.engine.NoRoute(func(c *gin.Context) {
 		requestPath := c.Request.URL.Path
@@ -266,6 +458,13 @@ func CustomLogger(level logging.LogLevel) gin.HandlerFunc {
 		path := c.Request.URL.Path
 		clientIP := c.ClientIP()
 
+		// The route keeps the ids out of the labels, static files have none
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequestDuration.Observe(latency.Seconds(), method, route, strconv.Itoa(status))
+
 		// Determine prefix from status/method
 		var reqlevel logging.LogLevel
 		switch {
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/utils"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSetup is what Start needs to serve HTTPS
+type tlsSetup struct {
+	config      *tls.Config
+	httpHandler http.Handler // Served on the plain HTTP port, nil when it isn't needed
+}
+
+// setupTLS prepares the certificates of the configured TLS mode
+func (s *Server) setupTLS() (*tlsSetup, error) {
+	t := s.cfg.TLS
+	setup := &tlsSetup{}
+
+	switch t.Mode {
+	case config.TLSModeFiles:
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("tls.certFile and tls.keyFile are required for the %s mode", t.Mode)
+		}
+		keyPair := &keyPairReloader{certFile: t.CertFile, keyFile: t.KeyFile}
+		if _, err := keyPair.GetCertificate(nil); err != nil {
+			return nil, err
+		}
+		setup.config = &tls.Config{GetCertificate: keyPair.GetCertificate}
+	case config.TLSModeSelfSigned:
+		cert, err := loadOrCreateSelfSigned(t.CertDir, s.selfSignedHosts())
+		if err != nil {
+			return nil, err
+		}
+		setup.config = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	case config.TLSModeACME:
+		manager, err := newACMEManager(t)
+		if err != nil {
+			return nil, err
+		}
+		setup.config = manager.TLSConfig()
+		// The HTTP-01 challenge needs the plain HTTP port, other requests are
+		// redirected only if asked, autocert would redirect to port 443 otherwise
+		fallback := http.NotFoundHandler()
+		if t.RedirectHTTP {
+			fallback = s.redirectHandler()
+		}
+		setup.httpHandler = manager.HTTPHandler(fallback)
+	default:
+		return nil, fmt.Errorf("unknown tls mode '%s', must be %s, %s, %s or %s", t.Mode, config.TLSModeOff, config.TLSModeFiles, config.TLSModeSelfSigned, config.TLSModeACME)
+	}
+
+	setup.config.MinVersion = tls.VersionTLS12
+	if t.RedirectHTTP && setup.httpHandler == nil {
+		setup.httpHandler = s.redirectHandler()
+	}
+	return setup, nil
+}
+
+// redirectHandler sends plain HTTP requests to the HTTPS listen port
+func (s *Server) redirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		}
+		if s.cfg.ListenPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(s.cfg.ListenPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// selfSignedHosts returns the names and addresses the self-signed certificate is valid for
+func (s *Server) selfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	if ip := net.ParseIP(s.cfg.ListenIP); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+// loadOrCreateSelfSigned loads the self-signed certificate of dir, or generates
+// a new one if it is missing or expires within 30 days
+func loadOrCreateSelfSigned(dir string, hosts []string) (*tls.Certificate, error) {
+	certPath := filepath.Join(dir, "self-signed.crt")
+	keyPath := filepath.Join(dir, "self-signed.key")
+
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Add(30*24*time.Hour).Before(leaf.NotAfter) {
+			return &cert, nil
+		}
+	}
+
+	logging.LogInfo("Generating self-signed certificate in %s", dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key:-> %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate serial:-> %v", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "WireGuard Panel"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate:-> %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate key:-> %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory %s:-> %v", dir, err)
+	}
+	if err := utils.WriteFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s:-> %v", keyPath, err)
+	}
+	if err := utils.WriteFileAtomic(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s:-> %v", certPath, err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load generated certificate:-> %v", err)
+	}
+	return &cert, nil
+}
+
+// keyPairReloader serves a certificate from files, loaded again when the
+// certificate file changes so renewed certificates are used without a restart
+type keyPairReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *keyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to read certificate %s:-> %v", r.certFile, err)
+	}
+	if r.cert != nil && info.ModTime().Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// The key may not be written yet, keep the previous certificate until it is
+			logging.LogError("Failed to reload certificate %s, keeping the previous one: %v", r.certFile, err)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate %s:-> %v", r.certFile, err)
+	}
+	logging.LogInfo("Loaded TLS certificate %s", r.certFile)
+	r.cert, r.modTime = &cert, info.ModTime()
+	return r.cert, nil
+}
+
+// newACMEManager returns the certificate manager for the ACME mode
+func newACMEManager(t config.TLSConfig) (*autocert.Manager, error) {
+	if len(t.ACME.Domains) == 0 {
+		return nil, fmt.Errorf("tls.acme.domains is required for the %s mode", t.Mode)
+	}
+
+	client := &acme.Client{DirectoryURL: t.ACME.DirectoryURL}
+	if t.ACME.CACertFile != "" {
+		data, err := os.ReadFile(t.ACME.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA certificate:-> %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", t.ACME.CACertFile)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(t.CertDir, "acme")),
+		HostPolicy: autocert.HostWhitelist(t.ACME.Domains...),
+		Email:      t.ACME.Email,
+		Client:     client,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wg-panel/internal/config"
+)
+
+func TestLoadOrCreateSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := loadOrCreateSelfSigned(dir, []string{"localhost", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("loadOrCreateSelfSigned() error = %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("192.0.2.1"); err != nil {
+		t.Errorf("Certificate should be valid for the IP address: %v", err)
+	}
+
+	// A valid certificate is reused
+	again, err := loadOrCreateSelfSigned(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatalf("loadOrCreateSelfSigned() error = %v", err)
+	}
+	if !bytes.Equal(cert.Certificate[0], again.Certificate[0]) {
+		t.Errorf("Expected the existing certificate to be reused")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port int
+		host string
+		want string
+	}{
+		{5000, "panel.example.com", "https://panel.example.com:5000/x?y=1"},
+		{5000, "panel.example.com:80", "https://panel.example.com:5000/x?y=1"},
+		{443, "panel.example.com:80", "https://panel.example.com/x?y=1"},
+		{443, "[2001:db8::1]:80", "https://[2001:db8::1]/x?y=1"},
+	}
+	for _, tt := range tests {
+		s := &Server{cfg: &config.Config{ListenPort: tt.port}}
+		req := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/x?y=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		s.redirectHandler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("port %d, host %s: got %d %s, want %s", tt.port, tt.host, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
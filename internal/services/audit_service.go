@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/events"
+	"wg-panel/internal/logging"
+)
+
+// AuditEntry records one change of the configuration
+type AuditEntry struct {
+	Time        time.Time       `json:"time"`
+	Actor       string          `json:"actor"`
+	TokenID     string          `json:"tokenId,omitempty"` // Set when the actor used an API token
+	SourceIP    string          `json:"sourceIp"`
+	Action      string          `json:"action"` // "<kind>.<verb>", for example "client.create"
+	InterfaceID string          `json:"interfaceId,omitempty"`
+	ServerID    string          `json:"serverId,omitempty"`
+	ClientID    string          `json:"clientId,omitempty"`
+	Target      string          `json:"target,omitempty"` // User name, token ID, revision number, link ID, webhook ID or "ip:" or "username:" and the unlocked key for the other kinds
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	Diff        string          `json:"diff,omitempty"`
+}
+
+// AuditFilter selects audit entries, empty fields match everything
+type AuditFilter struct {
+	Actor       string
+	Action      string // Exact action, or a kind prefix such as "client."
+	InterfaceID string
+	ServerID    string
+	ClientID    string
+	Since       *time.Time
+	Until       *time.Time
+}
+
+func (f AuditFilter) match(entry *AuditEntry) bool {
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action && !(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(entry.Action, f.Action)) {
+		return false
+	}
+	if f.InterfaceID != "" && entry.InterfaceID != f.InterfaceID {
+		return false
+	}
+	if f.ServerID != "" && entry.ServerID != f.ServerID {
+		return false
+	}
+	if f.ClientID != "" && entry.ClientID != f.ClientID {
+		return false
+	}
+	if f.Since != nil && entry.Time.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && entry.Time.After(*f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditService appends the changes to a JSON lines file, entries are never modified
+type AuditService struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewAuditService(path string) *AuditService {
+	return &AuditService{path: path}
+}
+
+// Record appends an entry to the audit log
+func (s *AuditService) Record(entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry:-> %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s:-> %v", s.path, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log %s:-> %v", s.path, err)
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	events.Publish(events.Event{
+		Type:        events.ConfigChanged,
+		Time:        entry.Time,
+		InterfaceID: entry.InterfaceID,
+		ServerID:    entry.ServerID,
+		ClientID:    entry.ClientID,
+		Data:        map[string]interface{}{"action": entry.Action, "actor": entry.Actor, "target": entry.Target},
+	})
+	if event, ok := clientLifecycleEvent(entry); ok {
+		events.Publish(event)
+	}
+	return nil
+}
+
+// clientLifecycleEvent returns the creation, deletion, enabling or disabling of a
+// client recorded with its states before and after the change
+func clientLifecycleEvent(entry *AuditEntry) (events.Event, bool) {
+	if entry.ClientID == "" || !strings.HasPrefix(entry.Action, "client.") {
+		return events.Event{}, false
+	}
+	var before, after struct {
+		Name    string `json:"name"`
+		Enabled bool   `json:"enabled"`
+	}
+	hasBefore := len(entry.Before) > 0 && json.Unmarshal(entry.Before, &before) == nil
+	hasAfter := len(entry.After) > 0 && json.Unmarshal(entry.After, &after) == nil
+
+	event := events.Event{
+		Time:        entry.Time,
+		InterfaceID: entry.InterfaceID,
+		ServerID:    entry.ServerID,
+		ClientID:    entry.ClientID,
+		Data:        map[string]interface{}{"clientName": after.Name, "actor": entry.Actor},
+	}
+	switch {
+	case !hasBefore && hasAfter:
+		event.Type = events.ClientCreated
+	case hasBefore && !hasAfter:
+		event.Type = events.ClientDeleted
+		event.Data["clientName"] = before.Name
+	case hasBefore && hasAfter && after.Enabled && !before.Enabled:
+		event.Type = events.ClientEnabled
+	case hasBefore && hasAfter && !after.Enabled && before.Enabled:
+		event.Type = events.ClientDisabled
+	default:
+		return events.Event{}, false
+	}
+	return event, true
+}
+
+// Query returns one page of the entries matching the filter, newest first,
+// with the number of matching entries
+func (s *AuditService) Query(filter AuditFilter, offset, limit int) ([]*AuditEntry, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return []*AuditEntry{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open audit log %s:-> %v", s.path, err)
+	}
+	defer file.Close()
+
+	var matched []*AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logging.LogError("Skipping malformed audit log line: %v", err)
+			continue
+		}
+		if filter.match(&entry) {
+			matched = append(matched, &entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read audit log %s:-> %v", s.path, err)
+	}
+
+	total := len(matched)
+	page := make([]*AuditEntry, 0, limit)
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, matched[i])
+	}
+	return page, total, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wg-panel/internal/events"
+)
+
+func TestAuditService_Query(t *testing.T) {
+	audit := NewAuditService(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	// An empty log has no entries
+	entries, total, err := audit.Query(AuditFilter{}, 0, 10)
+	if err != nil || total != 0 || len(entries) != 0 {
+		t.Fatalf("Expected empty log, got %d entries, total %d, err %v", len(entries), total, err)
+	}
+
+	start := time.Now()
+	for i, action := range []string{"interface.create", "server.create", "client.create", "client.set-enable", "client.delete"} {
+		entry := &AuditEntry{Time: start.Add(time.Duration(i) * time.Minute), Actor: "admin", Action: action, InterfaceID: "if1"}
+		if i >= 3 {
+			entry.Actor = "alice"
+		}
+		if err := audit.Record(entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	// Newest first, paginated
+	entries, total, err = audit.Query(AuditFilter{}, 1, 2)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if total != 5 || len(entries) != 2 || entries[0].Action != "client.set-enable" || entries[1].Action != "client.create" {
+		t.Errorf("Unexpected page: total %d, entries %v", total, entries)
+	}
+
+	// Kind prefix and actor filters
+	entries, total, _ = audit.Query(AuditFilter{Action: "client.", Actor: "admin"}, 0, 10)
+	if total != 1 || entries[0].Action != "client.create" {
+		t.Errorf("Expected only the client created by admin, got %d entries", total)
+	}
+
+	since := start.Add(150 * time.Second)
+	if _, total, _ = audit.Query(AuditFilter{Since: &since}, 0, 10); total != 2 {
+		t.Errorf("Expected 2 entries since %v, got %d", since, total)
+	}
+}
+
+func TestClientLifecycleEvent(t *testing.T) {
+	tests := []struct {
+		action, before, after string
+		want                  string
+	}{
+		{"client.create", "", `{"name":"laptop","enabled":true}`, events.ClientCreated},
+		{"client.delete", `{"name":"laptop","enabled":true}`, "", events.ClientDeleted},
+		{"client.set-enable", `{"name":"laptop","enabled":false}`, `{"name":"laptop","enabled":true}`, events.ClientEnabled},
+		{"client.update", `{"name":"laptop","enabled":true}`, `{"name":"laptop","enabled":false}`, events.ClientDisabled},
+		{"client.update", `{"name":"laptop","enabled":true}`, `{"name":"phone","enabled":true}`, ""},
+		{"client.expired", "", "", ""},
+		{"server.delete", `{"name":"office","enabled":true}`, "", ""},
+	}
+	for _, tt := range tests {
+		entry := &AuditEntry{Action: tt.action, InterfaceID: "if1", ServerID: "s1", ClientID: "c1"}
+		if tt.before != "" {
+			entry.Before = json.RawMessage(tt.before)
+		}
+		if tt.after != "" {
+			entry.After = json.RawMessage(tt.after)
+		}
+		event, ok := clientLifecycleEvent(entry)
+		if event.Type != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s from %q to %q gave %q, want %q", tt.action, tt.before, tt.after, event.Type, tt.want)
+		}
+		if ok && (event.ClientID != "c1" || event.Data["clientName"] == "") {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+}
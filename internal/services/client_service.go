@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
 	"wg-panel/internal/models"
 	"wg-panel/internal/utils"
 )
@@ -76,15 +78,30 @@ func (s *ClientService) CreateClient(interfaceID, serverID string, req ClientCre
 			return nil, fmt.Errorf("request validation failed:-> %v", err)
 		}
 	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("request validation failed:-> expiry date is in the past")
+	}
+	if req.Quota != nil {
+		if err := req.Quota.Validate(); err != nil {
+			return nil, fmt.Errorf("request validation failed:-> %v", err)
+		}
+	}
+	if err := validateRateLimits(req.RateLimitUp, req.RateLimitDown); err != nil {
+		return nil, fmt.Errorf("request validation failed:-> %v", err)
+	}
 
 	client := &models.Client{
-		ID:           s.cfg.GetAvailableClientID(iface.ID, serverID),
-		Name:         req.Name,
-		Enabled:      false, // Always start disabled
-		DNS:          req.DNS,
-		PublicKey:    publicKey,
-		PresharedKey: req.PresharedKey,
-		Keepalive:    req.Keepalive,
+		ID:            s.cfg.GetAvailableClientID(iface.ID, serverID),
+		Name:          req.Name,
+		Enabled:       false, // Always start disabled
+		DNS:           req.DNS,
+		PublicKey:     publicKey,
+		PresharedKey:  req.PresharedKey,
+		Keepalive:     req.Keepalive,
+		ExpiresAt:     req.ExpiresAt,
+		Quota:         req.Quota,
+		RateLimitUp:   req.RateLimitUp,
+		RateLimitDown: req.RateLimitDown,
 	}
 
 	if privateKey != "" {
@@ -105,6 +122,7 @@ func (s *ClientService) CreateClient(interfaceID, serverID string, req ClientCre
 	}
 
 	// Add client to server
+	result := withholdPrivateKey(server, client)
 	server.Clients = append(server.Clients, client)
 	s.cfg.SetInterface(interfaceID, iface)
 
@@ -112,7 +130,7 @@ func (s *ClientService) CreateClient(interfaceID, serverID string, req ClientCre
 		return nil, fmt.Errorf("failed to save configuration:-> %v", err)
 	}
 
-	return client, nil
+	return result, nil
 }
 
 func (s *ClientService) GetClient(interfaceID, serverID, clientID string) (*models.Client, error) {
@@ -208,6 +226,24 @@ func (s *ClientService) UpdateClient(interfaceID, serverID, clientID string, req
 		client.Keepalive = req.Keepalive
 		needsWGSync = true
 	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.Equal(timeOrZero(client.ExpiresAt)) && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("request validation failed:-> expiry date is in the past")
+	}
+	client.ExpiresAt = req.ExpiresAt
+	if req.Quota != nil {
+		if err := req.Quota.Validate(); err != nil {
+			return nil, fmt.Errorf("request validation failed:-> %v", err)
+		}
+	}
+	client.Quota = req.Quota
+	if err := validateRateLimits(req.RateLimitUp, req.RateLimitDown); err != nil {
+		return nil, fmt.Errorf("request validation failed:-> %v", err)
+	}
+	if !equalIntPtr(req.RateLimitUp, client.RateLimitUp) || !equalIntPtr(req.RateLimitDown, client.RateLimitDown) {
+		client.RateLimitUp = req.RateLimitUp
+		client.RateLimitDown = req.RateLimitDown
+		needsWGSync = true
+	}
 
 	for _, dns := range req.DNS {
 		if err := utils.ValidateIPorDomain(dns); err != nil {
@@ -264,6 +300,7 @@ func (s *ClientService) UpdateClient(interfaceID, serverID, clientID string, req
 		}
 	}
 
+	result := withholdPrivateKey(server, client)
 	s.cfg.SetInterface(interfaceID, iface)
 	if err := s.cfg.Save(); err != nil {
 		return nil, fmt.Errorf("failed to save configuration:-> %v", err)
@@ -276,7 +313,7 @@ func (s *ClientService) UpdateClient(interfaceID, serverID, clientID string, req
 		}
 	}
 
-	return client, nil
+	return result, nil
 }
 
 func (s *ClientService) SetClientEnabled(interfaceID, serverID, clientID string, enabled bool) error {
@@ -298,6 +335,9 @@ func (s *ClientService) SetClientEnabled(interfaceID, serverID, clientID string,
 	if client.Enabled == enabled {
 		return nil // Already in desired state
 	}
+	if enabled && client.Expired(time.Now()) {
+		return fmt.Errorf("client has expired, extend it to enable it again")
+	}
 
 	client.Enabled = enabled
 	s.cfg.SetInterface(interfaceID, iface)
@@ -315,6 +355,91 @@ func (s *ClientService) SetClientEnabled(interfaceID, serverID, clientID string,
 	return nil
 }
 
+// PlanSetClientEnabled returns what SetClientEnabled would change, without applying it
+func (s *ClientService) PlanSetClientEnabled(interfaceID, serverID, clientID string, enabled bool) (*ChangePlan, error) {
+	if _, err := s.cfg.GetClient(interfaceID, serverID, clientID); err != nil {
+		return nil, err
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		server, _ := findServer(after[interfaceID], serverID)
+		for _, client := range server.Clients {
+			if client.ID == clientID {
+				client.Enabled = enabled
+			}
+		}
+		return nil
+	})
+}
+
+// ExtendClient sets the expiry date of a client. A client disabled after its
+// previous expiry date is enabled again.
+func (s *ClientService) ExtendClient(interfaceID, serverID, clientID string, req ClientExtendRequest) (*models.Client, error) {
+	iface := s.cfg.GetInterface(interfaceID)
+	if iface == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+
+	server, err := s.cfg.GetServer(interfaceID, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.cfg.GetClient(interfaceID, serverID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt, err := req.expiresAt(client, now)
+	if err != nil {
+		return nil, err
+	}
+
+	reenable := !client.Enabled && client.Expired(now)
+	client.ExpiresAt = &expiresAt
+	if reenable {
+		client.Enabled = true
+	}
+	s.cfg.SetInterface(interfaceID, iface)
+	if err := s.cfg.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save configuration:-> %v", err)
+	}
+	logging.LogInfo("Extended client %s until %s", client.Name, expiresAt.Format(time.RFC3339))
+
+	if reenable && server.Enabled {
+		if err := s.wg.SyncToConfAndInterface(iface); err != nil {
+			return nil, fmt.Errorf("failed to sync WireGuard configuration:-> %v", err)
+		}
+	}
+
+	return client, nil
+}
+
+// PlanExtendClient returns what ExtendClient would change, without applying it
+func (s *ClientService) PlanExtendClient(interfaceID, serverID, clientID string, req ClientExtendRequest) (*ChangePlan, error) {
+	client, err := s.cfg.GetClient(interfaceID, serverID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt, err := req.expiresAt(client, now)
+	if err != nil {
+		return nil, err
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		server, _ := findServer(after[interfaceID], serverID)
+		for _, client := range server.Clients {
+			if client.ID == clientID {
+				if !client.Enabled && client.Expired(now) {
+					client.Enabled = true
+				}
+				client.ExpiresAt = &expiresAt
+			}
+		}
+		return nil
+	})
+}
+
 func (s *ClientService) DeleteClient(interfaceID, serverID, clientID string) error {
 	iface := s.cfg.GetInterface(interfaceID)
 	if iface == nil {
@@ -342,9 +467,27 @@ func (s *ClientService) DeleteClient(interfaceID, serverID, clientID string) err
 	}
 
 	s.cfg.SetInterface(interfaceID, iface)
+	s.cfg.DeleteClientPortalLinks(interfaceID, serverID, clientID)
 	return s.cfg.Save()
 }
 
+// PlanDeleteClient returns what DeleteClient would change, without applying it
+func (s *ClientService) PlanDeleteClient(interfaceID, serverID, clientID string) (*ChangePlan, error) {
+	if _, err := s.cfg.GetClient(interfaceID, serverID, clientID); err != nil {
+		return nil, err
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		server, _ := findServer(after[interfaceID], serverID)
+		for i, client := range server.Clients {
+			if client.ID == clientID {
+				server.Clients = append(server.Clients[:i], server.Clients[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+}
+
 func (s *ClientService) GetClientConfig(interfaceID, serverID, clientID string) (string, error) {
 	iface := s.cfg.GetInterface(interfaceID)
 	if iface == nil {
@@ -364,6 +507,76 @@ func (s *ClientService) GetClientConfig(interfaceID, serverID, clientID string)
 	return s.generateClientConfig(iface, server, client), nil
 }
 
+// RegenerateKeys replaces the keypair of a client with a new one generated by the panel,
+// the preshared key is kept. With ephemeral private keys, only the returned client has
+// the private key.
+func (s *ClientService) RegenerateKeys(interfaceID, serverID, clientID string) (*models.Client, error) {
+	iface := s.cfg.GetInterface(interfaceID)
+	if iface == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+
+	server, err := s.cfg.GetServer(interfaceID, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.cfg.GetClient(interfaceID, serverID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, publicKey, err := utils.GenerateWGKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate keypair:-> %v", err)
+	}
+	client.PrivateKey = &privateKey
+	client.PublicKey = publicKey
+
+	result := withholdPrivateKey(server, client)
+	s.cfg.SetInterface(interfaceID, iface)
+	if err := s.cfg.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save configuration:-> %v", err)
+	}
+
+	if server.Enabled {
+		if err := s.wg.SyncToConfAndInterface(iface); err != nil {
+			return nil, fmt.Errorf("failed to sync WireGuard configuration:-> %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// EphemeralConfig returns the configuration of a client returned by CreateClient,
+// UpdateClient or RegenerateKeys when its server has ephemeral private keys, since
+// the private key can't be read again. Otherwise it returns an empty string.
+func (s *ClientService) EphemeralConfig(interfaceID, serverID string, client *models.Client) string {
+	iface := s.cfg.GetInterface(interfaceID)
+	if iface == nil {
+		return ""
+	}
+
+	server, err := s.cfg.GetServer(interfaceID, serverID)
+	if err != nil || !server.EphemeralPrivateKeys || client.PrivateKey == nil {
+		return ""
+	}
+
+	return s.generateClientConfig(iface, server, client)
+}
+
+// withholdPrivateKey removes the private key of a client of a server with ephemeral
+// private keys before it is saved, and returns a copy of the client keeping the key
+// for the response. Otherwise the client itself is returned.
+func withholdPrivateKey(server *models.Server, client *models.Client) *models.Client {
+	if !server.EphemeralPrivateKeys || client.PrivateKey == nil {
+		return client
+	}
+	result := *client
+	client.PrivateKey = nil
+	return &result
+}
+
 func (s *ClientService) GetClientWGState(interfaceID, serverID, clientID string) (*models.WGState, error) {
 	iface := s.cfg.GetInterface(interfaceID)
 	if iface == nil {
@@ -616,25 +829,81 @@ func (s *ClientService) generateClientConfig(iface *models.Interface, server *mo
 
 // Request types
 type ClientCreateRequest struct {
-	Name         string   `json:"name" binding:"required"`
-	IP           *string  `json:"ip"`
-	IPv6         *string  `json:"ipv6"`
-	DNS          []string `json:"dns"`
-	PrivateKey   *string  `json:"privateKey"`
-	PublicKey    *string  `json:"publicKey"`
-	PresharedKey *string  `json:"presharedKey"`
-	Keepalive    *int     `json:"keepalive"`
+	Name          string              `json:"name" binding:"required"`
+	IP            *string             `json:"ip"`
+	IPv6          *string             `json:"ipv6"`
+	DNS           []string            `json:"dns"`
+	PrivateKey    *string             `json:"privateKey"`
+	PublicKey     *string             `json:"publicKey"`
+	PresharedKey  *string             `json:"presharedKey"`
+	Keepalive     *int                `json:"keepalive"`
+	ExpiresAt     *time.Time          `json:"expiresAt"`     // Optional, no expiry if nil
+	Quota         *models.ClientQuota `json:"quota"`         // Optional, unlimited if nil
+	RateLimitUp   *int                `json:"rateLimitUp"`   // In kbit/s, the server default if nil
+	RateLimitDown *int                `json:"rateLimitDown"` // In kbit/s, the server default if nil
 }
 
 type ClientUpdateRequest struct {
-	Name         string   `json:"name"`
-	IP           *string  `json:"ip"`
-	IPv6         *string  `json:"ipv6"`
-	DNS          []string `json:"dns"`
-	PrivateKey   *string  `json:"privateKey"`
-	PublicKey    *string  `json:"publicKey"`
-	PresharedKey *string  `json:"presharedKey"`
-	Keepalive    *int     `json:"keepalive"`
+	Name          string              `json:"name"`
+	IP            *string             `json:"ip"`
+	IPv6          *string             `json:"ipv6"`
+	DNS           []string            `json:"dns"`
+	PrivateKey    *string             `json:"privateKey"`
+	PublicKey     *string             `json:"publicKey"`
+	PresharedKey  *string             `json:"presharedKey"`
+	Keepalive     *int                `json:"keepalive"`
+	ExpiresAt     *time.Time          `json:"expiresAt"`     // Optional, no expiry if nil
+	Quota         *models.ClientQuota `json:"quota"`         // Optional, unlimited if nil
+	RateLimitUp   *int                `json:"rateLimitUp"`   // In kbit/s, the server default if nil
+	RateLimitDown *int                `json:"rateLimitDown"` // In kbit/s, the server default if nil
+}
+
+// ClientExtendRequest sets the expiry date of a client, either to ExpiresAt or
+// ExtendBy seconds after the later of now and the current expiry date
+type ClientExtendRequest struct {
+	ExpiresAt *time.Time `json:"expiresAt"`
+	ExtendBy  int        `json:"extendBy"`
+}
+
+func (r ClientExtendRequest) expiresAt(client *models.Client, now time.Time) (time.Time, error) {
+	if (r.ExpiresAt == nil) == (r.ExtendBy == 0) {
+		return time.Time{}, fmt.Errorf("request validation failed:-> exactly one of expiresAt or extendBy must be set")
+	}
+	if r.ExpiresAt != nil {
+		if !r.ExpiresAt.After(now) {
+			return time.Time{}, fmt.Errorf("request validation failed:-> expiry date is in the past")
+		}
+		return *r.ExpiresAt, nil
+	}
+	if r.ExtendBy < 0 {
+		return time.Time{}, fmt.Errorf("request validation failed:-> extendBy must be positive")
+	}
+	from := now
+	if client.ExpiresAt != nil && client.ExpiresAt.After(now) {
+		from = *client.ExpiresAt
+	}
+	return from.Add(time.Duration(r.ExtendBy) * time.Second), nil
+}
+
+// validateRateLimits checks that the rate limits of a request aren't negative
+func validateRateLimits(limits ...*int) error {
+	for _, limit := range limits {
+		if limit != nil && *limit < 0 {
+			return fmt.Errorf("rate limit can't be negative")
+		}
+	}
+	return nil
+}
+
+func equalIntPtr(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
 }
 
 type ClientWithState struct {
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+
+	"wg-panel/internal/models"
+)
+
+func TestWithholdPrivateKey(t *testing.T) {
+	key := "private"
+
+	// The key is kept without ephemeral private keys
+	client := &models.Client{PrivateKey: &key}
+	if result := withholdPrivateKey(&models.Server{}, client); result != client || client.PrivateKey == nil {
+		t.Fatalf("Expected the client to keep its private key")
+	}
+
+	// The stored client loses the key, the returned copy has it
+	client = &models.Client{Name: "phone", PrivateKey: &key}
+	result := withholdPrivateKey(&models.Server{EphemeralPrivateKeys: true}, client)
+	if client.PrivateKey != nil {
+		t.Errorf("Expected the stored client to have no private key")
+	}
+	if result == client || result.PrivateKey == nil || *result.PrivateKey != key || result.Name != "phone" {
+		t.Errorf("Expected a copy of the client with the private key, got %+v", result)
+	}
+
+	// Clients without a private key are returned as is
+	client = &models.Client{}
+	if result := withholdPrivateKey(&models.Server{EphemeralPrivateKeys: true}, client); result != client {
+		t.Errorf("Expected the client itself without a private key")
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+)
+
+// ExpiryEventExpired is the audit action of the clients disabled at their expiry date
+const ExpiryEventExpired = "client.expired"
+
+// ExpiryService periodically disables the clients past their expiry date, and
+// publishes warnings about the clients expiring soon
+type ExpiryService struct {
+	cfg     *config.Config
+	wg      *WireGuardService
+	audit   *AuditService
+	publish func(events.Event)
+
+	mu     sync.Mutex
+	warned map[string]time.Time // Expiry date each client was warned about
+}
+
+func NewExpiryService(cfg *config.Config, wgService *WireGuardService, auditService *AuditService) *ExpiryService {
+	return &ExpiryService{
+		cfg:     cfg,
+		wg:      wgService,
+		audit:   auditService,
+		publish: events.Publish,
+		warned:  make(map[string]time.Time),
+	}
+}
+
+// Start runs the expiry loop in the background
+func (s *ExpiryService) Start() {
+	interval := time.Duration(s.cfg.ClientExpiry.IntervalSeconds) * time.Second
+	logging.LogVerbose("Starting client expiry loop every %v", interval)
+
+	go func() {
+		s.Run(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.Run(now)
+		}
+	}()
+}
+
+// Run disables the clients expired at now and publishes the due warnings
+func (s *ExpiryService) Run(now time.Time) {
+	for _, event := range s.disableExpired(now) {
+		entry := &AuditEntry{
+			Time:        now,
+			Actor:       "system",
+			Action:      ExpiryEventExpired,
+			InterfaceID: event.InterfaceID,
+			ServerID:    event.ServerID,
+			ClientID:    event.ClientID,
+		}
+		if err := s.audit.Record(entry); err != nil {
+			logging.LogError("Failed to record expiry of client %s: %v", event.Data["clientName"], err)
+		}
+		s.publish(event)
+	}
+
+	for _, event := range s.dueWarnings(now) {
+		s.publish(event)
+	}
+}
+
+// disableExpired disables the enabled clients past their expiry date and removes their peers
+func (s *ExpiryService) disableExpired(now time.Time) []events.Event {
+	s.cfg.LockApply()
+	defer s.cfg.UnlockApply()
+
+	var expired []events.Event
+	var changed []*models.Interface
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		needsSync := false
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				if !client.Enabled || !client.Expired(now) {
+					continue
+				}
+				client.Enabled = false
+				needsSync = needsSync || server.Enabled
+				expired = append(expired, newExpiryEvent(events.ClientExpired, now, iface, server, client))
+				logging.LogInfo("Client %s of server %s expired at %s, disabled", client.Name, server.Name, client.ExpiresAt.Format(time.RFC3339))
+			}
+		}
+		if needsSync {
+			changed = append(changed, iface)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	s.cfg.BeginRevision("system", "Client expiry")
+	defer s.cfg.EndRevision()
+	if err := s.cfg.Save(); err != nil {
+		logging.LogError("Failed to save configuration with expired clients: %v", err)
+	}
+	for _, iface := range changed {
+		if err := s.wg.SyncToConfAndInterface(iface); err != nil {
+			logging.LogError("Failed to remove expired peers of interface %s: %v", iface.Ifname, err)
+		}
+	}
+	return expired
+}
+
+// dueWarnings returns the warnings of the enabled clients expiring within the
+// warning time that weren't warned about their current expiry date
+func (s *ExpiryService) dueWarnings(now time.Time) []events.Event {
+	warnBefore := time.Duration(s.cfg.ClientExpiry.WarnBeforeSeconds) * time.Second
+	if warnBefore <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var warnings []events.Event
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				if !client.Enabled || client.ExpiresAt == nil || !client.Expired(now.Add(warnBefore)) {
+					continue
+				}
+				key := fmt.Sprintf("%s/%s/%s", iface.ID, server.ID, client.ID)
+				if warned, ok := s.warned[key]; ok && warned.Equal(*client.ExpiresAt) {
+					continue
+				}
+				s.warned[key] = *client.ExpiresAt
+				warnings = append(warnings, newExpiryEvent(events.ClientExpiring, now, iface, server, client))
+			}
+		}
+	}
+	return warnings
+}
+
+func newExpiryEvent(eventType string, now time.Time, iface *models.Interface, server *models.Server, client *models.Client) events.Event {
+	return events.Event{
+		Type:        eventType,
+		Time:        now,
+		InterfaceID: iface.ID,
+		ServerID:    server.ID,
+		ClientID:    client.ID,
+		Data:        map[string]interface{}{"clientName": client.Name, "expiresAt": *client.ExpiresAt},
+	}
+}
+
+// sortedInterfaces returns the interfaces ordered by ID
+func sortedInterfaces(interfaces map[string]*models.Interface) []*models.Interface {
+	result := make([]*models.Interface, 0, len(interfaces))
+	for _, iface := range interfaces {
+		result = append(result, iface)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/models"
+)
+
+func TestExpiryService_Run(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	past, soon, later := now.Add(-time.Minute), now.Add(time.Hour), now.Add(72*time.Hour)
+	// The server is disabled, so no peer is synced
+	server := &models.Server{ID: "s1", Clients: []*models.Client{
+		{ID: "c1", Name: "expired", Enabled: true, ExpiresAt: &past},
+		{ID: "c2", Name: "soon", Enabled: true, ExpiresAt: &soon},
+		{ID: "c3", Name: "later", Enabled: true, ExpiresAt: &later},
+		{ID: "c4", Name: "forever", Enabled: true},
+	}}
+	cfg := &config.Config{
+		ConfigPath:    filepath.Join(dir, "config.json"),
+		RevisionsPath: filepath.Join(dir, "revisions"),
+		Interfaces:    map[string]*models.Interface{"if1": {ID: "if1", Servers: []*models.Server{server}}},
+		ClientExpiry:  config.ClientExpiryConfig{WarnBeforeSeconds: 86400},
+	}
+	audit := NewAuditService(filepath.Join(dir, "audit.jsonl"))
+	expiry := NewExpiryService(cfg, nil, audit)
+	var published []events.Event
+	expiry.publish = func(event events.Event) {
+		published = append(published, event)
+	}
+
+	expiry.Run(now)
+	expiry.Run(now.Add(time.Second))
+
+	enabled := map[string]bool{}
+	for _, client := range server.Clients {
+		enabled[client.Name] = client.Enabled
+	}
+	if enabled["expired"] || !enabled["soon"] || !enabled["later"] || !enabled["forever"] {
+		t.Errorf("Only the expired client should be disabled, got %v", enabled)
+	}
+
+	// The warning is only published once for the same expiry date
+	if len(published) != 2 || published[0].Type != events.ClientExpired || published[0].ClientID != "c1" ||
+		published[1].Type != events.ClientExpiring || published[1].ClientID != "c2" || published[1].Data["clientName"] != "soon" {
+		t.Fatalf("Unexpected events %+v", published)
+	}
+
+	entries, _, err := audit.Query(AuditFilter{Action: ExpiryEventExpired}, 0, 10)
+	if err != nil || len(entries) != 1 || entries[0].ClientID != "c1" || entries[0].Actor != "system" {
+		t.Errorf("Expected the expiry of c1 in the audit log, got %+v, %v", entries, err)
+	}
+}
+
+func TestClientExtendRequest(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	// Extended from the current expiry date, or from now once it passed
+	got, err := ClientExtendRequest{ExtendBy: 60}.expiresAt(&models.Client{ExpiresAt: &future}, now)
+	if err != nil || !got.Equal(future.Add(time.Minute)) {
+		t.Errorf("Expected %v, got %v, %v", future.Add(time.Minute), got, err)
+	}
+	got, err = ClientExtendRequest{ExtendBy: 60}.expiresAt(&models.Client{ExpiresAt: &past}, now)
+	if err != nil || !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected %v, got %v, %v", now.Add(time.Minute), got, err)
+	}
+
+	for _, req := range []ClientExtendRequest{{}, {ExpiresAt: &future, ExtendBy: 60}, {ExpiresAt: &past}, {ExtendBy: -1}} {
+		if _, err := req.expiresAt(&models.Client{}, now); err == nil {
+			t.Errorf("Expected an error for %+v", req)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Resolutions of the traffic history
+const (
+	HistoryResolutionMinute = "minute"
+	HistoryResolutionHour   = "hour"
+	HistoryResolutionDay    = "day"
+)
+
+// onlineHandshakeAge is the age of the last handshake under which a peer counts as online
+const onlineHandshakeAge = 3 * time.Minute
+
+// historyResolutions are written on each sample, from the finest
+var historyResolutions = []string{HistoryResolutionMinute, HistoryResolutionHour, HistoryResolutionDay}
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	resolution TEXT NOT NULL, time INTEGER NOT NULL,
+	interface_id TEXT NOT NULL, server_id TEXT NOT NULL, client_id TEXT NOT NULL,
+	rx INTEGER NOT NULL, tx INTEGER NOT NULL,
+	samples INTEGER NOT NULL, online INTEGER NOT NULL, last_handshake INTEGER NOT NULL,
+	PRIMARY KEY (resolution, interface_id, server_id, client_id, time)
+);
+CREATE INDEX IF NOT EXISTS samples_time ON samples (resolution, time);
+CREATE TABLE IF NOT EXISTS counters (
+	interface_id TEXT NOT NULL, server_id TEXT NOT NULL, client_id TEXT NOT NULL,
+	rx INTEGER NOT NULL, tx INTEGER NOT NULL,
+	PRIMARY KEY (interface_id, server_id, client_id)
+);
+`
+
+// HistoryPoint is the traffic of a client, or the sum of the traffic of the clients
+// of a server or an interface, during one period of the resolution
+type HistoryPoint struct {
+	Time          time.Time  `json:"time"` // Start of the period
+	Rx            int64      `json:"rx"`
+	Tx            int64      `json:"tx"`
+	Samples       int64      `json:"samples"`       // Peers sampled during the period, once per sample
+	Online        int64      `json:"online"`        // Samples of the peers with a recent handshake
+	LastHandshake *time.Time `json:"lastHandshake"` // Latest handshake seen during the period
+}
+
+// HistorySeries is the traffic history of a client, a server or an interface
+type HistorySeries struct {
+	Resolution string         `json:"resolution"`
+	Since      time.Time      `json:"since"`
+	Until      time.Time      `json:"until"`
+	Points     []HistoryPoint `json:"points"`
+}
+
+// HistoryFilter selects the clients of a series, an empty server or client selects all of them
+type HistoryFilter struct {
+	InterfaceID string
+	ServerID    string
+	ClientID    string
+	ServerIDs   []string // Servers of the interface summed without a server, nil is all of them
+}
+
+// IsHistoryResolution tells if a resolution of the traffic history exists
+func IsHistoryResolution(resolution string) bool {
+	for _, r := range historyResolutions {
+		if r == resolution {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryService periodically samples the traffic and the handshakes of the peers
+// into a SQLite database. Each sample is added to the periods per minute, hour and
+// day containing it, and the finer periods are deleted after their retention.
+type HistoryService struct {
+	cfg *config.Config
+	wg  *WireGuardService
+	db  *sql.DB
+
+	mu       sync.Mutex
+	counters map[peerKey]TrafficCounter // Counters of the peers at the last sample
+}
+
+// peerKey identifies the peer of a client
+type peerKey struct {
+	InterfaceID, ServerID, ClientID string
+}
+
+func NewHistoryService(cfg *config.Config, wgService *WireGuardService) (*HistoryService, error) {
+	path := cfg.TrafficHistory.Path
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory:-> %v", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database %s:-> %v", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create history schema:-> %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set history database permissions:-> %v", err)
+	}
+
+	s := &HistoryService{cfg: cfg, wg: wgService, db: db, counters: make(map[peerKey]TrafficCounter)}
+	rows, err := db.Query(`SELECT interface_id, server_id, client_id, rx, tx FROM counters`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read history counters:-> %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var peer peerKey
+		var counter TrafficCounter
+		if err := rows.Scan(&peer.InterfaceID, &peer.ServerID, &peer.ClientID, &counter.Rx, &counter.Tx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to read history counters:-> %v", err)
+		}
+		s.counters[peer] = counter
+	}
+	return s, rows.Err()
+}
+
+// Close closes the database
+func (s *HistoryService) Close() error {
+	return s.db.Close()
+}
+
+// Start runs the sampler in the background
+func (s *HistoryService) Start() {
+	interval := time.Duration(s.cfg.TrafficHistory.IntervalSeconds) * time.Second
+	logging.LogVerbose("Starting traffic history sampler every %v", interval)
+
+	go func() {
+		s.Sample(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.Sample(now)
+		}
+	}()
+}
+
+// Sample records the traffic since the last sample and the handshakes of the peers
+func (s *HistoryService) Sample(now time.Time) {
+	if err := s.sample(now); err != nil {
+		logging.LogError("Failed to record traffic history: %v", err)
+	}
+}
+
+func (s *HistoryService) sample(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO samples
+		(resolution, time, interface_id, server_id, client_id, rx, tx, samples, online, last_handshake)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT (resolution, interface_id, server_id, client_id, time) DO UPDATE SET
+		rx = rx + excluded.rx, tx = tx + excluded.tx, samples = samples + 1,
+		online = online + excluded.online, last_handshake = MAX(last_handshake, excluded.last_handshake)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	counters := make(map[peerKey]TrafficCounter)
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		if !iface.Enabled {
+			continue
+		}
+		stats, err := s.wg.GetPeerStats(iface.Ifname)
+		if err != nil {
+			// Keep the last counters, dropping them would record the whole transfer next time
+			logging.LogVerbose("Skipping the traffic history of interface %s: %v", iface.Ifname, err)
+			for peer, counter := range s.counters {
+				if peer.InterfaceID == iface.ID {
+					counters[peer] = counter
+				}
+			}
+			continue
+		}
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				state, ok := stats[client.PublicKey]
+				if !ok || !server.Enabled || !client.Enabled {
+					continue
+				}
+				peer := peerKey{iface.ID, server.ID, client.ID}
+				current := TrafficCounter{Rx: int64OrZero(state.TransferRx), Tx: int64OrZero(state.TransferTx)}
+				last := s.counters[peer]
+				rx, txBytes := counterDelta(last.Rx, last.Tx, current.Rx, current.Tx)
+				counters[peer] = current
+
+				online, handshake := 0, int64(0)
+				if state.LatestHandshake != nil {
+					handshake = state.LatestHandshake.Unix()
+					if now.Sub(*state.LatestHandshake) < onlineHandshakeAge {
+						online = 1
+					}
+				}
+				for _, resolution := range historyResolutions {
+					if _, err := insert.Exec(resolution, periodStart(resolution, now).Unix(), iface.ID, server.ID, client.ID,
+						rx, txBytes, online, handshake); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	// Only the running peers are kept, the others start again from zero
+	if _, err := tx.Exec(`DELETE FROM counters`); err != nil {
+		return err
+	}
+	for peer, counter := range counters {
+		if _, err := tx.Exec(`INSERT INTO counters (interface_id, server_id, client_id, rx, tx) VALUES (?, ?, ?, ?, ?)`,
+			peer.InterfaceID, peer.ServerID, peer.ClientID, counter.Rx, counter.Tx); err != nil {
+			return err
+		}
+	}
+
+	retention := map[string]time.Time{
+		HistoryResolutionMinute: now.Add(-time.Duration(s.cfg.TrafficHistory.MinuteHours) * time.Hour),
+		HistoryResolutionHour:   now.AddDate(0, 0, -s.cfg.TrafficHistory.HourDays),
+	}
+	if s.cfg.TrafficHistory.DayDays > 0 {
+		retention[HistoryResolutionDay] = now.AddDate(0, 0, -s.cfg.TrafficHistory.DayDays)
+	}
+	for resolution, before := range retention {
+		if _, err := tx.Exec(`DELETE FROM samples WHERE resolution = ? AND time < ?`, resolution, before.Unix()); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.counters = counters
+	return nil
+}
+
+// Query returns the traffic of the clients selected by the filter between since and
+// until. Without a resolution, the finest one still kept for since is used.
+func (s *HistoryService) Query(filter HistoryFilter, resolution string, since, until time.Time) (*HistorySeries, error) {
+	if filter.ClientID != "" {
+		if _, err := s.cfg.GetClient(filter.InterfaceID, filter.ServerID, filter.ClientID); err != nil {
+			return nil, err
+		}
+	} else if filter.ServerID != "" {
+		if _, err := s.cfg.GetServer(filter.InterfaceID, filter.ServerID); err != nil {
+			return nil, err
+		}
+	} else if s.cfg.GetInterface(filter.InterfaceID) == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+
+	if resolution == "" {
+		resolution = HistoryResolutionDay
+		if !since.Before(time.Now().AddDate(0, 0, -s.cfg.TrafficHistory.HourDays)) {
+			resolution = HistoryResolutionHour
+		}
+		if !since.Before(time.Now().Add(-time.Duration(s.cfg.TrafficHistory.MinuteHours) * time.Hour)) {
+			resolution = HistoryResolutionMinute
+		}
+	}
+
+	query := `SELECT time, SUM(rx), SUM(tx), SUM(samples), SUM(online), MAX(last_handshake) FROM samples
+		WHERE resolution = ? AND interface_id = ? AND time >= ? AND time < ?`
+	args := []interface{}{resolution, filter.InterfaceID, periodStart(resolution, since).Unix(), until.Unix()}
+	if filter.ServerID != "" {
+		query += ` AND server_id = ?`
+		args = append(args, filter.ServerID)
+	} else if filter.ServerIDs != nil {
+		query += ` AND server_id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(filter.ServerIDs)), ",") + ")"
+		for _, id := range filter.ServerIDs {
+			args = append(args, id)
+		}
+	}
+	if filter.ClientID != "" {
+		query += ` AND client_id = ?`
+		args = append(args, filter.ClientID)
+	}
+	query += ` GROUP BY time ORDER BY time`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query traffic history:-> %v", err)
+	}
+	defer rows.Close()
+
+	series := &HistorySeries{Resolution: resolution, Since: since, Until: until, Points: []HistoryPoint{}}
+	for rows.Next() {
+		var point HistoryPoint
+		var start, handshake int64
+		if err := rows.Scan(&start, &point.Rx, &point.Tx, &point.Samples, &point.Online, &handshake); err != nil {
+			return nil, fmt.Errorf("failed to read traffic history:-> %v", err)
+		}
+		point.Time = time.Unix(start, 0)
+		if handshake > 0 {
+			t := time.Unix(handshake, 0)
+			point.LastHandshake = &t
+		}
+		series.Points = append(series.Points, point)
+	}
+	return series, rows.Err()
+}
+
+// periodStart returns the start of the period of a resolution containing t, the
+// days start at midnight in local time
+func periodStart(resolution string, t time.Time) time.Time {
+	switch resolution {
+	case HistoryResolutionMinute:
+		return t.Truncate(time.Minute)
+	case HistoryResolutionHour:
+		return t.Truncate(time.Hour)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/models"
+)
+
+func TestHistoryService_Sample(t *testing.T) {
+	dir := t.TempDir()
+	backend := &statsBackend{stats: map[string]*models.WGState{}}
+	wg := NewWireGuardService(dir, backend, nil)
+	first := &models.Client{ID: "c1", Enabled: true, PublicKey: "pk1"}
+	second := &models.Client{ID: "c2", Enabled: true, PublicKey: "pk2"}
+	cfg := &config.Config{
+		Interfaces: map[string]*models.Interface{"if1": {ID: "if1", Ifname: "wghistorytest", Enabled: true, Servers: []*models.Server{
+			{ID: "s1", Enabled: true, Clients: []*models.Client{first}},
+			{ID: "s2", Enabled: true, Clients: []*models.Client{second}},
+		}}},
+		TrafficHistory: config.TrafficHistoryConfig{Path: filepath.Join(dir, "history.db"), MinuteHours: 24, HourDays: 31},
+	}
+	service, err := NewHistoryService(cfg, wg)
+	if err != nil {
+		t.Fatalf("NewHistoryService() failed: %v", err)
+	}
+
+	start := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	backend.setTransfer("pk1", 100, 10)
+	backend.setTransfer("pk2", 1000, 100)
+	service.Sample(start)
+	backend.setTransfer("pk1", 300, 30)
+	handshake := start.Add(time.Minute)
+	backend.stats["pk1"].LatestHandshake = &handshake
+	service.Sample(start.Add(time.Minute))
+
+	// The counters are kept across a failed sample and restarts of the panel
+	backend.err = errors.New("netlink error")
+	service.Sample(start.Add(2 * time.Minute))
+	backend.err = nil
+	service.Close()
+	if service, err = NewHistoryService(cfg, wg); err != nil {
+		t.Fatalf("NewHistoryService() failed: %v", err)
+	}
+	defer service.Close()
+	backend.setTransfer("pk1", 400, 40)
+	service.Sample(start.Add(3 * time.Minute))
+
+	// Then the interface restarts
+	backend.setTransfer("pk1", 50, 5)
+	service.Sample(start.Add(time.Hour))
+
+	until := start.Add(2 * time.Hour)
+	series, err := service.Query(HistoryFilter{InterfaceID: "if1", ServerID: "s1", ClientID: "c1"}, "", start, until)
+	if err != nil || series.Resolution != HistoryResolutionMinute || len(series.Points) != 4 {
+		t.Fatalf("Unexpected series %+v, %v", series, err)
+	}
+	if p := series.Points[1]; p.Rx != 200 || p.Tx != 20 || p.Online != 1 || p.LastHandshake == nil || !p.LastHandshake.Equal(handshake) {
+		t.Errorf("Unexpected second point %+v", p)
+	}
+	if p := series.Points[2]; p.Rx != 100 || p.Tx != 10 {
+		t.Errorf("Unexpected point after the failed sample %+v", p)
+	}
+	if p := series.Points[3]; p.Rx != 50 || p.Tx != 5 || p.Online != 0 {
+		t.Errorf("Unexpected point after the restart %+v", p)
+	}
+
+	// The hours sum the minutes, the interface sums its servers
+	series, err = service.Query(HistoryFilter{InterfaceID: "if1"}, HistoryResolutionHour, start, until)
+	if err != nil || len(series.Points) != 2 {
+		t.Fatalf("Unexpected series %+v, %v", series, err)
+	}
+	if p := series.Points[0]; !p.Time.Equal(start) || p.Rx != 1400 || p.Tx != 140 || p.Samples != 6 {
+		t.Errorf("Unexpected first hour %+v", p)
+	}
+	series, _ = service.Query(HistoryFilter{InterfaceID: "if1", ServerIDs: []string{"s2"}}, HistoryResolutionHour, start, until)
+	if len(series.Points) != 2 || series.Points[0].Rx != 1000 {
+		t.Errorf("Expected only the traffic of s2, got %+v", series)
+	}
+
+	if _, err := service.Query(HistoryFilter{InterfaceID: "if1", ServerID: "s1", ClientID: "c9"}, "", start, until); err == nil || err.Error() != "client not found" {
+		t.Errorf("Expected client not found, got %v", err)
+	}
+}
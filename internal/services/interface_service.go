@@ -129,7 +129,7 @@ func (s *InterfaceService) SetInterfaceEnabled(id string, enabled bool) error {
 	if err := s.wg.SyncToConf(iface); err != nil {
 		return fmt.Errorf("failed to sync WireGuard configuration:-> %v", err)
 	}
-	if err := s.wg.SyncToInterface(iface.Ifname, enabled, iface.PrivateKey); err != nil {
+	if err := s.wg.SyncToInterface(iface.Ifname, enabled, iface); err != nil {
 		return fmt.Errorf("failed to apply WireGuard configuration:-> %v", err)
 	}
 
@@ -143,6 +143,17 @@ func (s *InterfaceService) SetInterfaceEnabled(id string, enabled bool) error {
 	return nil
 }
 
+// PlanSetInterfaceEnabled returns what SetInterfaceEnabled would change, without applying it
+func (s *InterfaceService) PlanSetInterfaceEnabled(id string, enabled bool) (*ChangePlan, error) {
+	if s.cfg.GetInterface(id) == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		after[id].Enabled = enabled
+		return nil
+	})
+}
+
 func (s *InterfaceService) GetInterface(id string) (*models.Interface, error) {
 	iface := s.cfg.GetInterface(id)
 	if iface == nil {
@@ -164,22 +175,99 @@ func (s *InterfaceService) UpdateInterface(id string, req InterfaceUpdateRequest
 	if iface == nil {
 		return nil, fmt.Errorf("interface not found")
 	}
-	needsWGReCreateOldName := ""
-	needsWGRegeneration := false
-	needsMTUUpdate := false
-	needsVRFUpdate := false
+	update, err := s.updateInterfaceFields(id, iface, req)
+	if err != nil {
+		return nil, err
+	}
+	needsWGReCreateOldName := update.oldIfname
+	needsWGRegeneration := update.regenerate
+	needsMTUUpdate := update.mtu
+	needsVRFUpdate := update.vrf
+
+	if needsWGReCreateOldName == "" {
+		// Apply system changes
+		if needsWGRegeneration {
+			if err := s.wg.SyncToConf(iface); err != nil {
+				return nil, fmt.Errorf("failed to regenerate WireGuard configuration:-> %v", err)
+			}
+		}
+
+		if needsMTUUpdate {
+			if err := s.wg.SetInterfaceMTU(iface.Ifname, iface.MTU); err != nil {
+				return nil, fmt.Errorf("failed to update MTU:-> %v", err)
+			}
+		}
+	} else {
+		// Remove old interface
+		if err := s.wg.SyncToInterface(needsWGReCreateOldName, false, iface); err != nil {
+			return nil, fmt.Errorf("failed to bring down old WireGuard interface:-> %v", err)
+		}
+		if err := s.wg.RemoveConfig(needsWGReCreateOldName); err != nil {
+			return nil, fmt.Errorf("failed to remove old WireGuard interface:-> %v", err)
+		}
+		// Create new interface
+		if err := s.wg.SyncToConf(iface); err != nil {
+			return nil, fmt.Errorf("failed to create new WireGuard interface:-> %v", err)
+		}
+	}
+	if iface.Enabled {
+		if err := s.wg.SyncToInterface(iface.Ifname, true, iface); err != nil {
+			return nil, fmt.Errorf("failed to bring up new WireGuard interface:-> %v", err)
+		}
+
+		// Update VRF binding if needed and interface is up
+		if needsVRFUpdate {
+			vrfName := ""
+			if iface.VRFName != nil {
+				vrfName = *iface.VRFName
+			}
+			if err := utils.SetInterfaceVRF(iface.Ifname, vrfName); err != nil {
+				return nil, fmt.Errorf("failed to update VRF binding:-> %v", err)
+			}
+		}
+	}
+
+	if err := s.cfg.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save configuration:-> %v", err)
+	}
+
+	return s.sanitizeInterface(iface), nil
+}
+
+// PlanUpdateInterface returns what UpdateInterface would change, without applying it
+func (s *InterfaceService) PlanUpdateInterface(id string, req InterfaceUpdateRequest) (*ChangePlan, error) {
+	if s.cfg.GetInterface(id) == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		_, err := s.updateInterfaceFields(id, after[id], req)
+		return err
+	})
+}
+
+// interfaceUpdate tells which changes made by updateInterfaceFields must be applied to the system
+type interfaceUpdate struct {
+	oldIfname  string // set when the interface is renamed
+	regenerate bool
+	mtu        bool
+	vrf        bool
+}
+
+// updateInterfaceFields validates req and applies it to iface, without touching the system
+func (s *InterfaceService) updateInterfaceFields(id string, iface *models.Interface, req InterfaceUpdateRequest) (interfaceUpdate, error) {
+	var update interfaceUpdate
 
 	// Validate VRF if specified
 	if req.VRFName != nil && *req.VRFName != "" {
 		if err := utils.CheckVRFExists(*req.VRFName); err != nil {
-			return nil, err
+			return update, err
 		}
 	} else if req.VRFName != nil && *req.VRFName == "" {
 		req.VRFName = nil
 	}
 	if req.FwMark != nil && *req.FwMark != "" {
 		if err := utils.IsValidFWMark(*req.FwMark); err != nil {
-			return nil, err
+			return update, err
 		}
 	} else if req.FwMark != nil && *req.FwMark == "" {
 		req.FwMark = nil
@@ -188,55 +276,55 @@ func (s *InterfaceService) UpdateInterface(id string, req InterfaceUpdateRequest
 	// Update fields
 	if req.Ifname != "" && req.Ifname != iface.Ifname {
 		if err := utils.IsValidIfname(s.cfg.WgIfPrefix, req.Ifname); err != nil {
-			return nil, err
+			return update, err
 		}
 		// Check if new ifname already exists in configuration
 		for _, otherIface := range s.cfg.GetAllInterfaces() {
 			if otherIface.ID != id && otherIface.Ifname == req.Ifname {
-				return nil, fmt.Errorf("interface with ifname '%s' already exists", req.Ifname)
+				return update, fmt.Errorf("interface with ifname '%s' already exists", req.Ifname)
 			}
 		}
 		// Check if ifname is available in OS and filesystem
 		if err := s.CheckIfNameAvailable(req.Ifname); err != nil {
-			return nil, err
+			return update, err
 		}
-		needsWGReCreateOldName = iface.Ifname
+		update.oldIfname = iface.Ifname
 		iface.Ifname = req.Ifname
-		needsWGRegeneration = true
+		update.regenerate = true
 	}
 
 	if !utils.StringPointerEqual(req.VRFName, iface.VRFName, true) {
 		// Validate VRF if specified
 		if req.VRFName != nil && *req.VRFName != "" {
 			if err := utils.CheckVRFExists(*req.VRFName); err != nil {
-				return nil, err
+				return update, err
 			}
 		}
 
 		// Check for network overlaps when changing VRF
 		for _, server := range iface.Servers {
 			if err := s.cfg.CheckNetworkOverlapsInVRF(req.VRFName, nil, nil, server.GetNetwork(4)); err != nil {
-				return nil, err
+				return update, err
 			}
 			if err := s.cfg.CheckNetworkOverlapsInVRF(req.VRFName, nil, nil, server.GetNetwork(6)); err != nil {
-				return nil, err
+				return update, err
 			}
 		}
 
 		iface.VRFName = req.VRFName
-		needsWGRegeneration = true
-		needsVRFUpdate = true
+		update.regenerate = true
+		update.vrf = true
 	}
 
 	if !utils.StringPointerEqual(req.FwMark, iface.FwMark, true) {
 		iface.FwMark = req.FwMark
-		needsWGRegeneration = true
+		update.regenerate = true
 	}
 
 	if req.Endpoint != "" && req.Endpoint != iface.Endpoint {
 		// Validate endpoint
 		if newendpoint, err := s.ValidateEndpoint(req.Endpoint); err != nil {
-			return nil, err
+			return update, err
 		} else {
 			req.Endpoint = newendpoint
 		}
@@ -246,76 +334,29 @@ func (s *InterfaceService) UpdateInterface(id string, req InterfaceUpdateRequest
 	if req.Port > 0 && req.Port != iface.Port {
 		// Check if UDP port is available
 		if err := s.CheckUDPPortAvailable(req.Port); err != nil {
-			return nil, err
+			return update, err
 		}
 		iface.Port = req.Port
-		needsWGRegeneration = true
+		update.regenerate = true
 	}
 
 	if req.MTU > 0 && req.MTU != iface.MTU {
 		iface.MTU = req.MTU
-		needsWGRegeneration = true
-		needsMTUUpdate = true
+		update.regenerate = true
+		update.mtu = true
 	}
 
 	if req.PrivateKey != "" && req.PrivateKey != iface.PrivateKey {
 		publicKey, err := utils.PrivToPublic(req.PrivateKey)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate public key:-> %v", err)
+			return update, fmt.Errorf("failed to generate public key:-> %v", err)
 		}
 		iface.PrivateKey = req.PrivateKey
 		iface.PublicKey = publicKey
-		needsWGRegeneration = true
+		update.regenerate = true
 	}
 
-	if needsWGReCreateOldName == "" {
-		// Apply system changes
-		if needsWGRegeneration {
-			if err := s.wg.SyncToConf(iface); err != nil {
-				return nil, fmt.Errorf("failed to regenerate WireGuard configuration:-> %v", err)
-			}
-		}
-
-		if needsMTUUpdate {
-			if err := s.wg.SetInterfaceMTU(iface.Ifname, iface.MTU); err != nil {
-				return nil, fmt.Errorf("failed to update MTU:-> %v", err)
-			}
-		}
-	} else {
-		// Remove old interface
-		if err := s.wg.SyncToInterface(needsWGReCreateOldName, false, iface.PrivateKey); err != nil {
-			return nil, fmt.Errorf("failed to bring down old WireGuard interface:-> %v", err)
-		}
-		if err := s.wg.RemoveConfig(needsWGReCreateOldName); err != nil {
-			return nil, fmt.Errorf("failed to remove old WireGuard interface:-> %v", err)
-		}
-		// Create new interface
-		if err := s.wg.SyncToConf(iface); err != nil {
-			return nil, fmt.Errorf("failed to create new WireGuard interface:-> %v", err)
-		}
-	}
-	if iface.Enabled {
-		if err := s.wg.SyncToInterface(iface.Ifname, true, iface.PrivateKey); err != nil {
-			return nil, fmt.Errorf("failed to bring up new WireGuard interface:-> %v", err)
-		}
-
-		// Update VRF binding if needed and interface is up
-		if needsVRFUpdate {
-			vrfName := ""
-			if iface.VRFName != nil {
-				vrfName = *iface.VRFName
-			}
-			if err := utils.SetInterfaceVRF(iface.Ifname, vrfName); err != nil {
-				return nil, fmt.Errorf("failed to update VRF binding:-> %v", err)
-			}
-		}
-	}
-
-	if err := s.cfg.Save(); err != nil {
-		return nil, fmt.Errorf("failed to save configuration:-> %v", err)
-	}
-
-	return s.sanitizeInterface(iface), nil
+	return update, nil
 }
 
 func (s *InterfaceService) DeleteInterface(id string) error {
@@ -325,7 +366,7 @@ func (s *InterfaceService) DeleteInterface(id string) error {
 		return fmt.Errorf("interface not found")
 	}
 
-	if err := s.wg.SyncToInterface(iface.Ifname, false, iface.PrivateKey); err != nil {
+	if err := s.wg.SyncToInterface(iface.Ifname, false, iface); err != nil {
 		return fmt.Errorf("failed to delete WireGuard interface:-> %v", err)
 	}
 	// Remove WireGuard interface
@@ -337,6 +378,17 @@ func (s *InterfaceService) DeleteInterface(id string) error {
 	return s.cfg.Save()
 }
 
+// PlanDeleteInterface returns what DeleteInterface would change, without applying it
+func (s *InterfaceService) PlanDeleteInterface(id string) (*ChangePlan, error) {
+	if s.cfg.GetInterface(id) == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		delete(after, id)
+		return nil
+	})
+}
+
 func (s *InterfaceService) sanitizeInterface(iface *models.Interface) *models.Interface {
 	// Create a copy without the private key
 	result := *iface
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/metrics"
+	"wg-panel/internal/models"
+)
+
+// MetricsService writes the state of the peers and of the configuration in the
+// Prometheus text format, read on each scrape
+type MetricsService struct {
+	cfg *config.Config
+	wg  *WireGuardService
+}
+
+func NewMetricsService(cfg *config.Config, wgService *WireGuardService) *MetricsService {
+	return &MetricsService{
+		cfg: cfg,
+		wg:  wgService,
+	}
+}
+
+// peerSample is a value of a metric of a peer
+type peerSample struct {
+	labels []metrics.Label
+	value  float64
+}
+
+// WriteMetrics writes the metrics of the peers, the counts of the configuration
+// and the counters of the metrics package
+func (s *MetricsService) WriteMetrics(w io.Writer, now time.Time) {
+	var rx, tx, handshakeAge, enabled []peerSample
+	counts := map[string][2]int{} // Disabled and enabled per kind
+	count := func(kind string, isEnabled bool) {
+		c := counts[kind]
+		if isEnabled {
+			c[1]++
+		} else {
+			c[0]++
+		}
+		counts[kind] = c
+	}
+
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		count("interfaces", iface.Enabled)
+		stats := map[string]*models.WGState{}
+		if iface.Enabled {
+			if peers, err := s.wg.GetPeerStats(iface.Ifname); err == nil {
+				stats = peers
+			}
+		}
+		for _, server := range iface.Servers {
+			count("servers", server.Enabled)
+			for _, client := range server.Clients {
+				count("clients", client.Enabled)
+				labels := metrics.Labels([]string{"interface", "server", "client", "client_id"},
+					[]string{iface.Ifname, server.Name, client.Name, client.ID})
+				enabled = append(enabled, peerSample{labels, boolValue(client.Enabled)})
+
+				state, ok := stats[client.PublicKey]
+				if !ok || !server.Enabled || !client.Enabled {
+					continue
+				}
+				rx = append(rx, peerSample{labels, float64(int64OrZero(state.TransferRx))})
+				tx = append(tx, peerSample{labels, float64(int64OrZero(state.TransferTx))})
+				if state.LatestHandshake != nil {
+					handshakeAge = append(handshakeAge, peerSample{labels, now.Sub(*state.LatestHandshake).Seconds()})
+				}
+			}
+		}
+	}
+
+	for _, family := range []struct {
+		name, help, metricType string
+		samples                []peerSample
+	}{
+		{"wgpanel_peer_receive_bytes_total", "Bytes received from the peer of a client", "counter", rx},
+		{"wgpanel_peer_transmit_bytes_total", "Bytes sent to the peer of a client", "counter", tx},
+		{"wgpanel_peer_last_handshake_age_seconds", "Time since the last handshake of the peer of a client", "gauge", handshakeAge},
+		{"wgpanel_peer_enabled", "Whether a client is enabled", "gauge", enabled},
+	} {
+		metrics.WriteHeader(w, family.name, family.help, family.metricType)
+		for _, sample := range family.samples {
+			metrics.WriteSample(w, family.name, sample.labels, sample.value)
+		}
+	}
+
+	for _, kind := range []string{"interfaces", "servers", "clients"} {
+		name := "wgpanel_" + kind
+		metrics.WriteHeader(w, name, "Configured "+kind, "gauge")
+		for i, c := range counts[kind] {
+			metrics.WriteSample(w, name, []metrics.Label{{Name: "enabled", Value: strconv.FormatBool(i == 1)}}, float64(c))
+		}
+	}
+
+	metrics.WriteRegistered(w)
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
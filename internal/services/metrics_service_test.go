@@ -0,0 +1,49 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/models"
+)
+
+func TestMetricsService_WriteMetrics(t *testing.T) {
+	backend := &statsBackend{stats: map[string]*models.WGState{}}
+	wg := NewWireGuardService(t.TempDir(), backend, nil)
+	cfg := &config.Config{
+		Interfaces: map[string]*models.Interface{"if1": {ID: "if1", Ifname: "wg0", Enabled: true, Servers: []*models.Server{
+			{ID: "s1", Name: "office", Enabled: true, Clients: []*models.Client{
+				{ID: "c1", Name: "laptop", Enabled: true, PublicKey: "pk1"},
+				{ID: "c2", Name: "phone", Enabled: false, PublicKey: "pk2"},
+			}},
+		}}},
+	}
+	now := time.Now()
+	backend.setTransfer("pk1", 1500, 300)
+	handshake := now.Add(-42 * time.Second)
+	backend.stats["pk1"].LatestHandshake = &handshake
+
+	var out strings.Builder
+	NewMetricsService(cfg, wg).WriteMetrics(&out, now)
+	laptop := `{interface="wg0",server="office",client="laptop",client_id="c1"}`
+	for _, line := range []string{
+		"wgpanel_peer_receive_bytes_total" + laptop + " 1500\n",
+		"wgpanel_peer_transmit_bytes_total" + laptop + " 300\n",
+		"wgpanel_peer_last_handshake_age_seconds" + laptop + " 42\n",
+		"wgpanel_peer_enabled" + laptop + " 1\n",
+		`wgpanel_peer_enabled{interface="wg0",server="office",client="phone",client_id="c2"} 0` + "\n",
+		`wgpanel_clients{enabled="true"} 1` + "\n",
+		`wgpanel_clients{enabled="false"} 1` + "\n",
+		`wgpanel_interfaces{enabled="true"} 1` + "\n",
+		"# TYPE wgpanel_command_failures_total counter\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Metrics miss %q in\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `wgpanel_peer_receive_bytes_total{interface="wg0",server="office",client="phone"`) {
+		t.Errorf("Disabled clients should have no traffic metrics")
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+)
+
+// PeerMonitorService polls the peers and publishes their handshakes, their online
+// and offline transitions and their endpoint changes
+type PeerMonitorService struct {
+	cfg     *config.Config
+	wg      *WireGuardService
+	publish func(events.Event)
+	peers   map[peerKey]*peerStatus
+	started bool
+}
+
+// peerStatus is the state of a peer at the last poll
+type peerStatus struct {
+	clientName string
+	handshake  time.Time
+	online     bool
+	endpoint   string
+}
+
+func NewPeerMonitorService(cfg *config.Config, wgService *WireGuardService) *PeerMonitorService {
+	return &PeerMonitorService{
+		cfg:     cfg,
+		wg:      wgService,
+		publish: events.Publish,
+		peers:   make(map[peerKey]*peerStatus),
+	}
+}
+
+// Start polls the peers in the background
+func (s *PeerMonitorService) Start() {
+	interval := time.Duration(s.cfg.Events.PollSeconds) * time.Second
+	logging.LogVerbose("Starting peer monitor every %v", interval)
+
+	go func() {
+		s.Run(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.Run(now)
+		}
+	}()
+}
+
+// Run compares the peers with the last poll. The first poll only records them.
+func (s *PeerMonitorService) Run(now time.Time) {
+	seen := make(map[peerKey]bool)
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		if !iface.Enabled {
+			continue
+		}
+		stats, err := s.wg.GetPeerStats(iface.Ifname)
+		if err != nil {
+			// Keep the last state, the peers would otherwise go offline and come back online
+			logging.LogVerbose("Skipping the peers of interface %s: %v", iface.Ifname, err)
+			for key := range s.peers {
+				if key.InterfaceID == iface.ID {
+					seen[key] = true
+				}
+			}
+			continue
+		}
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				state, ok := stats[client.PublicKey]
+				if !ok || !server.Enabled || !client.Enabled {
+					continue
+				}
+				key := peerKey{iface.ID, server.ID, client.ID}
+				seen[key] = true
+				last := s.peers[key]
+				if last == nil {
+					last = &peerStatus{}
+				}
+				current := &peerStatus{clientName: client.Name}
+				if state.LatestHandshake != nil {
+					current.handshake = *state.LatestHandshake
+					current.online = now.Sub(current.handshake) < onlineHandshakeAge
+				}
+				if state.Endpoint != nil {
+					current.endpoint = *state.Endpoint
+				}
+				s.peers[key] = current
+				if s.started {
+					s.compare(iface, server, client, last, current)
+				}
+			}
+		}
+	}
+
+	// Peers removed from their interface, disabled or deleted, go offline
+	for key, last := range s.peers {
+		if seen[key] {
+			continue
+		}
+		delete(s.peers, key)
+		if s.started && last.online {
+			s.publish(events.Event{Type: events.PeerOffline, InterfaceID: key.InterfaceID, ServerID: key.ServerID, ClientID: key.ClientID,
+				Data: map[string]interface{}{"clientName": last.clientName, "reason": "removed"}})
+		}
+	}
+	s.started = true
+}
+
+// compare publishes the changes of a peer since the last poll
+func (s *PeerMonitorService) compare(iface *models.Interface, server *models.Server, client *models.Client, last, current *peerStatus) {
+	event := func(eventType string, data map[string]interface{}) {
+		data["clientName"] = client.Name
+		s.publish(events.Event{Type: eventType, InterfaceID: iface.ID, ServerID: server.ID, ClientID: client.ID, Data: data})
+	}
+
+	if current.handshake.After(last.handshake) {
+		event(events.PeerHandshake, map[string]interface{}{"latestHandshake": current.handshake})
+	}
+	if current.online && !last.online {
+		event(events.PeerOnline, map[string]interface{}{"endpoint": current.endpoint})
+	} else if !current.online && last.online {
+		event(events.PeerOffline, map[string]interface{}{"latestHandshake": current.handshake})
+	}
+	if current.endpoint != "" && last.endpoint != "" && current.endpoint != last.endpoint {
+		event(events.PeerEndpoint, map[string]interface{}{"endpoint": current.endpoint, "previous": last.endpoint})
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/models"
+)
+
+func TestPeerMonitorService_Run(t *testing.T) {
+	backend := &statsBackend{stats: map[string]*models.WGState{}}
+	wg := NewWireGuardService(t.TempDir(), backend, nil)
+	client := &models.Client{ID: "c1", Name: "laptop", Enabled: true, PublicKey: "pk1"}
+	cfg := &config.Config{
+		Interfaces: map[string]*models.Interface{"if1": {ID: "if1", Ifname: "wgmonitortest", Enabled: true, Servers: []*models.Server{
+			{ID: "s1", Enabled: true, Clients: []*models.Client{client}},
+		}}},
+	}
+	service := NewPeerMonitorService(cfg, wg)
+	var types []string
+	service.publish = func(event events.Event) {
+		if event.InterfaceID != "if1" || event.ServerID != "s1" || event.ClientID != "c1" {
+			t.Errorf("Unexpected peer in %+v", event)
+		}
+		types = append(types, event.Type)
+	}
+	setPeer := func(handshake time.Time, endpoint string) {
+		backend.setTransfer("pk1", 0, 0)
+		backend.stats["pk1"].LatestHandshake = &handshake
+		backend.stats["pk1"].Endpoint = &endpoint
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(types, want) {
+			t.Errorf("Published %v, want %v", types, want)
+		}
+		types = nil
+	}
+
+	// The first poll only records the peers
+	now := time.Now()
+	setPeer(now.Add(-time.Hour), "192.0.2.1:51820")
+	service.Run(now)
+	expect()
+
+	setPeer(now, "192.0.2.1:51820")
+	service.Run(now.Add(5 * time.Second))
+	expect(events.PeerHandshake, events.PeerOnline)
+
+	setPeer(now, "198.51.100.7:40000")
+	service.Run(now.Add(10 * time.Second))
+	expect(events.PeerEndpoint)
+
+	// A failed read keeps the peers as they were
+	backend.err = errors.New("no such device")
+	service.Run(now.Add(20 * time.Second))
+	expect()
+	backend.err = nil
+	service.Run(now.Add(30 * time.Second))
+	expect()
+
+	service.Run(now.Add(5 * time.Minute))
+	expect(events.PeerOffline)
+
+	// A disabled client's peer goes offline with it
+	setPeer(now.Add(5*time.Minute), "198.51.100.7:40000")
+	service.Run(now.Add(5 * time.Minute))
+	expect(events.PeerHandshake, events.PeerOnline)
+	client.Enabled = false
+	service.Run(now.Add(6 * time.Minute))
+	expect(events.PeerOffline)
+}
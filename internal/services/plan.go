@@ -0,0 +1,186 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// ListDiff lists the entries added and removed by a change
+type ListDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+func (d ListDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// InterfacePlan describes what a change does to one WireGuard interface
+type InterfacePlan struct {
+	InterfaceID   string   `json:"interfaceId"`
+	Ifname        string   `json:"ifname"`
+	Action        string   `json:"action"` // "create", "delete", "rename", "up", "down" or "update"
+	ConfDiff      string   `json:"confDiff"`
+	Addresses     ListDiff `json:"addresses"`
+	FirewallRules ListDiff `json:"firewallRules"`
+}
+
+// ChangePlan describes the effect of a change on the running system, returned by dry runs.
+// It compares the state before and after the change, so rules and addresses that are
+// removed and added again by the change don't appear.
+type ChangePlan struct {
+	Interfaces   []InterfacePlan `json:"interfaces"`
+	PseudoBridge ListDiff        `json:"pseudoBridge"`
+	SnatRoaming  ListDiff        `json:"snatRoaming"`
+}
+
+// interfaceState is the part of the system state derived from one interface
+type interfaceState struct {
+	ifname    string
+	running   bool
+	conf      string // Without the keys, the plans are returned to the callers
+	addresses []string
+	rules     []string
+}
+
+func (s *WireGuardService) interfaceState(iface *models.Interface) *interfaceState {
+	state := &interfaceState{
+		ifname:  iface.Ifname,
+		running: iface.Enabled,
+		conf:    s.GenerateRedactedConf(iface),
+	}
+	if !state.running {
+		return state
+	}
+	state.addresses = interfaceAddresses(iface)
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		for _, network := range []*models.ServerNetworkConfig{server.IPv4, server.IPv6} {
+			if network == nil || !network.Enabled || network.Network == nil {
+				continue
+			}
+			for _, rule := range utils.ServerFirewallRules(iface.Ifname, iface.VRFName, network, network.Network.Version) {
+				state.rules = append(state.rules, strings.Join(rule.IptablesArgs(), " "))
+			}
+		}
+	}
+	return state
+}
+
+// PlanChange compares the system state needed by two versions of the interfaces.
+// Firewall rules are shown as iptables commands whatever the firewall backend is.
+func (s *WireGuardService) PlanChange(before, after map[string]*models.Interface) *ChangePlan {
+	plan := &ChangePlan{Interfaces: []InterfacePlan{}}
+
+	ids := make([]string, 0, len(before)+len(after))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	empty := &interfaceState{}
+	for _, id := range ids {
+		oldState, newState := empty, empty
+		ifacePlan := InterfacePlan{InterfaceID: id}
+		if iface, ok := before[id]; ok {
+			oldState = s.interfaceState(iface)
+			ifacePlan.Ifname = iface.Ifname
+		}
+		if iface, ok := after[id]; ok {
+			newState = s.interfaceState(iface)
+			ifacePlan.Ifname = iface.Ifname
+		}
+
+		switch {
+		case oldState == empty:
+			ifacePlan.Action = "create"
+		case newState == empty:
+			ifacePlan.Action = "delete"
+		case oldState.ifname != newState.ifname:
+			ifacePlan.Action = "rename"
+		case !oldState.running && newState.running:
+			ifacePlan.Action = "up"
+		case oldState.running && !newState.running:
+			ifacePlan.Action = "down"
+		default:
+			ifacePlan.Action = "update"
+		}
+		ifacePlan.ConfDiff = utils.DiffLines(oldState.conf, newState.conf, 3)
+		ifacePlan.Addresses = diffLists(oldState.addresses, newState.addresses)
+		ifacePlan.FirewallRules = diffLists(oldState.rules, newState.rules)
+
+		if ifacePlan.Action == "update" && ifacePlan.ConfDiff == "" && ifacePlan.Addresses.empty() && ifacePlan.FirewallRules.empty() {
+			continue
+		}
+		plan.Interfaces = append(plan.Interfaces, ifacePlan)
+	}
+
+	oldPbs, oldSrs := config.InternalServiceEntries(before)
+	newPbs, newSrs := config.InternalServiceEntries(after)
+	plan.PseudoBridge = diffLists(oldPbs, newPbs)
+	plan.SnatRoaming = diffLists(oldSrs, newSrs)
+	return plan
+}
+
+// diffLists returns the entries of after missing in before and the entries of before missing in after
+func diffLists(before, after []string) ListDiff {
+	diff := ListDiff{Added: []string{}, Removed: []string{}}
+	for _, entry := range after {
+		if !stringInSlice(entry, before) {
+			diff.Added = append(diff.Added, entry)
+		}
+	}
+	for _, entry := range before {
+		if !stringInSlice(entry, after) {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+	return diff
+}
+
+// copyInterfaces returns a deep copy of the interfaces, so a change can be simulated on it
+func copyInterfaces(interfaces map[string]*models.Interface) (map[string]*models.Interface, error) {
+	result := make(map[string]*models.Interface, len(interfaces))
+	for id, iface := range interfaces {
+		ifaceCopy, err := iface.Copy()
+		if err != nil {
+			return nil, err
+		}
+		result[id] = ifaceCopy
+	}
+	return result, nil
+}
+
+// planSimulated plans the change made by simulate on a copy of the configured interfaces
+func planSimulated(cfg *config.Config, wg *WireGuardService, simulate func(after map[string]*models.Interface) error) (*ChangePlan, error) {
+	before := cfg.GetAllInterfaces()
+	after, err := copyInterfaces(before)
+	if err != nil {
+		return nil, err
+	}
+	if err := simulate(after); err != nil {
+		return nil, err
+	}
+	return wg.PlanChange(before, after), nil
+}
+
+// findServer returns the server with the given ID of iface
+func findServer(iface *models.Interface, serverID string) (*models.Server, int) {
+	for i, server := range iface.Servers {
+		if server.ID == serverID {
+			return server, i
+		}
+	}
+	return nil, -1
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/internalservice"
+	"wg-panel/internal/models"
+)
+
+func TestPlanChange_RedactsKeys(t *testing.T) {
+	network, _ := models.ParseCIDRAf(4, "10.0.0.0/24")
+	newInterface := func(privateKey, presharedKey string, port int) *models.Interface {
+		return &models.Interface{ID: "if1", Ifname: "wgplantest", Enabled: true, Port: port, PrivateKey: privateKey, Servers: []*models.Server{
+			{ID: "s1", Enabled: true, IPv4: &models.ServerNetworkConfig{Enabled: true, Network: network}, Clients: []*models.Client{
+				{ID: "c1", Enabled: true, PublicKey: "client-public", PresharedKey: &presharedKey, IPv4Offset: models.IPWrapper{0, 0, 0, 2}},
+			}},
+		}}
+	}
+	before := map[string]*models.Interface{"if1": newInterface("old-private", "old-preshared", 51820)}
+	after := map[string]*models.Interface{"if1": newInterface("new-private", "new-preshared", 51821)}
+
+	backend, _ := internalservice.NewFirewallBackend(internalservice.FirewallBackendIptables, "test")
+	wg := NewWireGuardService(t.TempDir(), &statsBackend{}, internalservice.NewFirewallService(backend))
+	plan := wg.PlanChange(before, after)
+	if len(plan.Interfaces) != 1 || !strings.Contains(plan.Interfaces[0].ConfDiff, "ListenPort = 51821") {
+		t.Fatalf("Expected the port change in the plan, got %+v", plan.Interfaces)
+	}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("Failed to encode the plan: %v", err)
+	}
+	for _, key := range []string{"old-private", "new-private", "old-preshared", "new-preshared"} {
+		if strings.Contains(string(data), key) {
+			t.Errorf("The plan contains the key %q: %s", key, data)
+		}
+	}
+}
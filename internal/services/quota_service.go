@@ -0,0 +1,407 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// Audit actions of the traffic quota events
+const (
+	QuotaEventExceeded = "client.quota-exceeded"
+	QuotaEventReset    = "client.quota-reset"
+)
+
+// usageKeepDays is how long the daily counters are kept, enough for the longest period
+const usageKeepDays = 62
+
+// TrafficCounter counts the bytes received from and sent to a client
+type TrafficCounter struct {
+	Rx int64 `json:"rx"`
+	Tx int64 `json:"tx"`
+}
+
+// clientUsage is the saved traffic of a client
+type clientUsage struct {
+	Days     map[string]*TrafficCounter `json:"days"`     // By local date
+	LastRx   int64                      `json:"lastRx"`   // Counters of the peer at the last poll
+	LastTx   int64                      `json:"lastTx"`   // 0 when the peer wasn't running
+	Enforced string                     `json:"enforced"` // Quota action applied to the client, empty if none
+}
+
+// DailyUsage is the traffic of a client on a day
+type DailyUsage struct {
+	Date string `json:"date"`
+	TrafficCounter
+}
+
+// ClientUsage is the traffic of a client in its current quota period
+type ClientUsage struct {
+	PeriodStart time.Time           `json:"periodStart"`
+	RxBytes     int64               `json:"rxBytes"`
+	TxBytes     int64               `json:"txBytes"`
+	Quota       *models.ClientQuota `json:"quota"`
+	Enforced    string              `json:"enforced"`
+	Days        []DailyUsage        `json:"days"`
+}
+
+// QuotaService periodically counts the traffic of the clients from the counters of
+// their peers, and disables or throttles the clients over their quota. The counters
+// are saved, so the traffic survives restarts of the interfaces and of the panel.
+type QuotaService struct {
+	cfg   *config.Config
+	wg    *WireGuardService
+	audit *AuditService
+
+	mu    sync.Mutex
+	usage map[string]*clientUsage
+	saved []byte // Last saved usage
+}
+
+func NewQuotaService(cfg *config.Config, wgService *WireGuardService, auditService *AuditService) *QuotaService {
+	s := &QuotaService{
+		cfg:   cfg,
+		wg:    wgService,
+		audit: auditService,
+		usage: make(map[string]*clientUsage),
+	}
+	if err := s.load(); err != nil {
+		logging.LogError("Failed to load traffic usage, counting from zero: %v", err)
+	}
+	return s
+}
+
+// Start runs the quota loop in the background
+func (s *QuotaService) Start() {
+	interval := time.Duration(s.cfg.TrafficQuota.IntervalSeconds) * time.Second
+	logging.LogVerbose("Starting traffic quota loop every %v", interval)
+
+	go func() {
+		s.Run(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.Run(now)
+		}
+	}()
+}
+
+// quotaTransition is a client whose enforced quota action changed
+type quotaTransition struct {
+	iface  *models.Interface
+	server *models.Server
+	client *models.Client
+	action string // Applied action, empty when lifted
+}
+
+// Run counts the traffic since the last run and enforces the quotas at now
+func (s *QuotaService) Run(now time.Time) {
+	s.cfg.LockApply()
+	transitions := s.collect(now)
+	s.enforce(now, transitions)
+	s.applyRateLimits()
+	s.cfg.UnlockApply()
+
+	if err := s.save(); err != nil {
+		logging.LogError("Failed to save traffic usage: %v", err)
+	}
+	for _, t := range transitions {
+		entry := &AuditEntry{
+			Time:        now,
+			Actor:       "system",
+			Action:      utils.If(t.action == "", QuotaEventReset, QuotaEventExceeded),
+			InterfaceID: t.iface.ID,
+			ServerID:    t.server.ID,
+			ClientID:    t.client.ID,
+		}
+		if err := s.audit.Record(entry); err != nil {
+			logging.LogError("Failed to record quota of client %s: %v", t.client.Name, err)
+		}
+		if t.action != "" {
+			events.Publish(events.Event{
+				Type:        events.ClientQuotaExceeded,
+				Time:        now,
+				InterfaceID: t.iface.ID,
+				ServerID:    t.server.ID,
+				ClientID:    t.client.ID,
+				Data:        map[string]interface{}{"clientName": t.client.Name, "action": t.action},
+			})
+		}
+	}
+}
+
+// collect adds the traffic of the peers to the counters, and returns the clients
+// whose quota state changed
+func (s *QuotaService) collect(now time.Time) []*quotaTransition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	today := now.Format("2006-01-02")
+	oldest := now.AddDate(0, 0, -usageKeepDays).Format("2006-01-02")
+	seen := make(map[string]bool)
+	var transitions []*quotaTransition
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		stats := map[string]*models.WGState{}
+		if iface.Enabled {
+			peers, err := s.wg.GetPeerStats(iface.Ifname)
+			if err != nil {
+				// Keep the last counters, zeroing them would count the whole transfer again
+				logging.LogVerbose("Skipping the traffic of interface %s: %v", iface.Ifname, err)
+				for _, server := range iface.Servers {
+					for _, client := range server.Clients {
+						seen[usageKey(iface.ID, server.ID, client.ID)] = true
+					}
+				}
+				continue
+			}
+			stats = peers
+		}
+		for _, server := range iface.Servers {
+			for _, client := range server.Clients {
+				key := usageKey(iface.ID, server.ID, client.ID)
+				seen[key] = true
+				usage := s.usage[key]
+				if usage == nil {
+					usage = &clientUsage{Days: make(map[string]*TrafficCounter)}
+					s.usage[key] = usage
+				}
+
+				var rx, tx int64
+				if state, ok := stats[client.PublicKey]; ok && server.Enabled && client.Enabled {
+					rx, tx = int64OrZero(state.TransferRx), int64OrZero(state.TransferTx)
+				}
+				usage.count(today, rx, tx)
+				for date := range usage.Days {
+					if date < oldest {
+						delete(usage.Days, date)
+					}
+				}
+
+				action := ""
+				if client.Quota != nil {
+					periodRx, periodTx := usage.since(client.Quota.PeriodStart(now))
+					if client.Quota.Exceeded(periodRx, periodTx) {
+						action = client.Quota.Action
+					}
+				}
+				if action != usage.Enforced {
+					transitions = append(transitions, &quotaTransition{iface: iface, server: server, client: client, action: action})
+				}
+			}
+		}
+	}
+
+	// Forget the deleted clients
+	for key := range s.usage {
+		if !seen[key] {
+			delete(s.usage, key)
+		}
+	}
+	return transitions
+}
+
+// enforce applies the quota actions of the transitions, the client is only disabled
+// or enabled when the action changes, so an operator can still enable a client over
+// its quota until the next period
+func (s *QuotaService) enforce(now time.Time, transitions []*quotaTransition) {
+	if len(transitions) == 0 {
+		return
+	}
+
+	modified := false
+	changed := make(map[*models.Interface]bool)
+	s.mu.Lock()
+	for _, t := range transitions {
+		usage := s.usage[usageKey(t.iface.ID, t.server.ID, t.client.ID)]
+		previous := usage.Enforced
+		usage.Enforced = t.action
+
+		switch {
+		case t.action == models.QuotaActionDisable && t.client.Enabled:
+			t.client.Enabled = false
+			modified = true
+			changed[t.iface] = changed[t.iface] || t.server.Enabled
+			logging.LogInfo("Client %s of server %s is over its traffic quota, disabled", t.client.Name, t.server.Name)
+		case previous == models.QuotaActionDisable && !t.client.Enabled && !t.client.Expired(now):
+			t.client.Enabled = true
+			modified = true
+			changed[t.iface] = changed[t.iface] || t.server.Enabled
+			logging.LogInfo("Client %s of server %s is back under its traffic quota, enabled", t.client.Name, t.server.Name)
+		default:
+			logging.LogInfo("Traffic quota action of client %s of server %s is now %q", t.client.Name, t.server.Name, t.action)
+		}
+	}
+	s.mu.Unlock()
+	if !modified {
+		return
+	}
+
+	s.cfg.BeginRevision("system", "Traffic quota")
+	defer s.cfg.EndRevision()
+	if err := s.cfg.Save(); err != nil {
+		logging.LogError("Failed to save configuration with the traffic quotas: %v", err)
+	}
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		if !changed[iface] {
+			continue
+		}
+		if err := s.wg.SyncToConfAndInterface(iface); err != nil {
+			logging.LogError("Failed to sync peers of interface %s: %v", iface.Ifname, err)
+		}
+	}
+}
+
+// ThrottleKbit returns the throttle rate of a client over its quota, 0 if it isn't throttled
+func (s *QuotaService) ThrottleKbit(interfaceID, serverID string, client *models.Client) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usage[usageKey(interfaceID, serverID, client.ID)]
+	if usage == nil || usage.Enforced != models.QuotaActionThrottle || client.Quota == nil {
+		return 0
+	}
+	return client.Quota.ThrottleKbit
+}
+
+// applyRateLimits updates the shaping of the running interfaces with the throttles,
+// and restores it on the interfaces restarted outside of the panel
+func (s *QuotaService) applyRateLimits() {
+	for _, iface := range sortedInterfaces(s.cfg.GetAllInterfaces()) {
+		if !iface.Enabled {
+			continue
+		}
+		if err := s.wg.ApplyRateLimits(iface); err != nil {
+			logging.LogError("%v", err)
+		}
+	}
+}
+
+// GetClientUsage returns the traffic of a client in its current quota period,
+// the calendar month without a quota
+func (s *QuotaService) GetClientUsage(interfaceID, serverID, clientID string) (*ClientUsage, error) {
+	if s.cfg.GetInterface(interfaceID) == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+	client, err := s.cfg.GetClient(interfaceID, serverID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	quota := client.Quota
+	if quota == nil {
+		quota = &models.ClientQuota{Period: models.QuotaPeriodMonthly, ResetDay: 1}
+	}
+	start := quota.PeriodStart(time.Now())
+	result := &ClientUsage{PeriodStart: start, Quota: client.Quota, Days: []DailyUsage{}}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := s.usage[usageKey(interfaceID, serverID, clientID)]
+	if usage == nil {
+		return result, nil
+	}
+	result.RxBytes, result.TxBytes = usage.since(start)
+	result.Enforced = usage.Enforced
+	for date, counter := range usage.Days {
+		result.Days = append(result.Days, DailyUsage{Date: date, TrafficCounter: *counter})
+	}
+	sort.Slice(result.Days, func(i, j int) bool { return result.Days[i].Date < result.Days[j].Date })
+	return result, nil
+}
+
+func (u *clientUsage) add(date string, rx, tx int64) {
+	if rx == 0 && tx == 0 {
+		return
+	}
+	counter := u.Days[date]
+	if counter == nil {
+		counter = &TrafficCounter{}
+		u.Days[date] = counter
+	}
+	counter.Rx += rx
+	counter.Tx += tx
+}
+
+// since sums the traffic from the day of start
+func (u *clientUsage) since(start time.Time) (rx, tx int64) {
+	from := start.Format("2006-01-02")
+	for date, counter := range u.Days {
+		if date >= from {
+			rx += counter.Rx
+			tx += counter.Tx
+		}
+	}
+	return rx, tx
+}
+
+// count adds the traffic since the last reading of the peer counters
+func (u *clientUsage) count(date string, rx, tx int64) {
+	deltaRx, deltaTx := counterDelta(u.LastRx, u.LastTx, rx, tx)
+	u.add(date, deltaRx, deltaTx)
+	u.LastRx, u.LastTx = rx, tx
+}
+
+// counterDelta returns the traffic between two readings of the counters of a peer,
+// which start again from zero when the peer or the interface is recreated
+func counterDelta(lastRx, lastTx, rx, tx int64) (int64, int64) {
+	if rx < lastRx || tx < lastTx {
+		return rx, tx
+	}
+	return rx - lastRx, tx - lastTx
+}
+
+func int64OrZero(value *int64) int64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+func usageKey(interfaceID, serverID, clientID string) string {
+	return fmt.Sprintf("%s/%s/%s", interfaceID, serverID, clientID)
+}
+
+func (s *QuotaService) load() error {
+	data, err := os.ReadFile(s.cfg.TrafficQuota.UsagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.usage); err != nil {
+		return err
+	}
+	s.saved = data
+	for _, usage := range s.usage {
+		if usage.Days == nil {
+			usage.Days = make(map[string]*TrafficCounter)
+		}
+	}
+	return nil
+}
+
+func (s *QuotaService) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	if err != nil || bytes.Equal(data, s.saved) {
+		return err
+	}
+	if err := utils.WriteFileAtomic(s.cfg.TrafficQuota.UsagePath, data, 0600); err != nil {
+		return err
+	}
+	s.saved = data
+	return nil
+}
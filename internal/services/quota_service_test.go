@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/models"
+)
+
+// statsBackend is a backend whose peers have the given counters
+type statsBackend struct {
+	stats map[string]*models.WGState
+	err   error // Returned instead of the counters when set
+}
+
+func (b *statsBackend) Up(string, *models.Interface) error   { return nil }
+func (b *statsBackend) Sync(string, *models.Interface) error { return nil }
+func (b *statsBackend) Down(string, *models.Interface) error { return nil }
+func (b *statsBackend) PublicKey(string) (string, error)     { return "", nil }
+func (b *statsBackend) SetMTU(string, int) error             { return nil }
+func (b *statsBackend) GetPeerStats(string) (map[string]*models.WGState, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.stats, nil
+}
+
+func (b *statsBackend) setTransfer(publicKey string, rx, tx int64) {
+	b.stats[publicKey] = &models.WGState{TransferRx: &rx, TransferTx: &tx}
+}
+
+func TestQuotaService_Run(t *testing.T) {
+	dir := t.TempDir()
+	backend := &statsBackend{stats: map[string]*models.WGState{}}
+	wg := NewWireGuardService(dir, backend, nil)
+	quota := &models.ClientQuota{Period: models.QuotaPeriodMonthly, ResetDay: 1, TotalBytes: 1000, Action: models.QuotaActionDisable}
+	limited := &models.Client{ID: "c1", Name: "limited", Enabled: true, PublicKey: "pk1", Quota: quota}
+	free := &models.Client{ID: "c2", Name: "free", Enabled: true, PublicKey: "pk2"}
+	server := &models.Server{ID: "s1", Enabled: true, Clients: []*models.Client{limited, free}}
+	cfg := &config.Config{
+		ConfigPath:    filepath.Join(dir, "config.json"),
+		RevisionsPath: filepath.Join(dir, "revisions"),
+		Interfaces:    map[string]*models.Interface{"if1": {ID: "if1", Ifname: "wgquotatest", Enabled: true, Servers: []*models.Server{server}}},
+		TrafficQuota:  config.TrafficQuotaConfig{UsagePath: filepath.Join(dir, "usage.json")},
+	}
+	audit := NewAuditService(filepath.Join(dir, "audit.jsonl"))
+	service := NewQuotaService(cfg, wg, audit)
+
+	now := time.Now()
+	backend.setTransfer("pk1", 300, 200)
+	backend.setTransfer("pk2", 5000, 5000)
+	service.Run(now)
+	if !limited.Enabled {
+		t.Fatalf("Client under its quota should stay enabled")
+	}
+
+	// A failed poll doesn't count the transfer of the next one again
+	backend.err = errors.New("netlink error")
+	service.Run(now.Add(30 * time.Second))
+	backend.err = nil
+	service.Run(now.Add(45 * time.Second))
+	if usage, _ := service.GetClientUsage("if1", "s1", "c1"); !limited.Enabled || usage.RxBytes != 300 || usage.TxBytes != 200 {
+		t.Fatalf("Expected the usage to be unchanged by the failed poll, got %+v", usage)
+	}
+
+	// The interface restarted, its counters start again from zero
+	backend.setTransfer("pk1", 400, 100)
+	service.Run(now.Add(time.Minute))
+	if limited.Enabled || !free.Enabled {
+		t.Fatalf("Only the client over its quota should be disabled")
+	}
+	usage, err := service.GetClientUsage("if1", "s1", "c1")
+	if err != nil || usage.RxBytes != 700 || usage.TxBytes != 300 || usage.Enforced != models.QuotaActionDisable {
+		t.Errorf("Unexpected usage %+v, %v", usage, err)
+	}
+
+	// The counters are kept across restarts of the panel
+	delete(backend.stats, "pk1")
+	reloaded := NewQuotaService(cfg, wg, audit)
+	if usage, _ := reloaded.GetClientUsage("if1", "s1", "c1"); usage.RxBytes != 700 || usage.TxBytes != 300 {
+		t.Errorf("Usage not reloaded, got %+v", usage)
+	}
+
+	// The next period enables the client again
+	reloaded.Run(quota.PeriodStart(now).AddDate(0, 1, 0).Add(time.Minute))
+	if !limited.Enabled {
+		t.Errorf("Client should be enabled again in a new period")
+	}
+
+	entries, _, err := audit.Query(AuditFilter{ClientID: "c1"}, 0, 10)
+	if err != nil || len(entries) != 2 || entries[0].Action != QuotaEventReset || entries[1].Action != QuotaEventExceeded {
+		t.Errorf("Expected the quota exceeded then reset in the audit log, got %+v, %v", entries, err)
+	}
+}
+
+func TestClientQuota_PeriodStart(t *testing.T) {
+	now := time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		quota models.ClientQuota
+		want  time.Time
+	}{
+		{models.ClientQuota{Period: models.QuotaPeriodMonthly, ResetDay: 1}, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{models.ClientQuota{Period: models.QuotaPeriodMonthly, ResetDay: 15}, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{models.ClientQuota{Period: models.QuotaPeriodRolling, RollingDays: 30}, time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		if got := tc.quota.PeriodStart(now); !got.Equal(tc.want) {
+			t.Errorf("PeriodStart() of %+v = %v, want %v", tc.quota, got, tc.want)
+		}
+	}
+}
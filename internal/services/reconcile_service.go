@@ -0,0 +1,304 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/internalservice"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// Kinds of drift reported by the reconciler
+const (
+	DriftLink     = "link"
+	DriftAddress  = "address"
+	DriftPeer     = "peer"
+	DriftFirewall = "firewall"
+)
+
+// Drift is a difference between the configuration and the running state
+type Drift struct {
+	InterfaceID string `json:"interfaceId"`
+	Ifname      string `json:"ifname"`
+	ServerID    string `json:"serverId,omitempty"`
+	Kind        string `json:"kind"`
+	Detail      string `json:"detail"`
+}
+
+// ReconcileReport is the result of one reconciliation run
+type ReconcileReport struct {
+	CheckedAt    time.Time `json:"checkedAt"`
+	Drifts       []Drift   `json:"drifts"`
+	Repaired     []string  `json:"repaired"` // IDs of the repaired interfaces
+	RepairErrors []string  `json:"repairErrors"`
+}
+
+// ReconcileService periodically compares the links, addresses, peers and firewall
+// rules of the enabled interfaces with the configuration, and repairs the drift
+// by applying the configuration of the drifted interfaces again.
+type ReconcileService struct {
+	cfg     *config.Config
+	wg      *WireGuardService
+	fw      *internalservice.FirewallService
+	startup *StartupService
+
+	mu   sync.RWMutex
+	last *ReconcileReport
+}
+
+func NewReconcileService(cfg *config.Config, wgService *WireGuardService, firewallService *internalservice.FirewallService, startupService *StartupService) *ReconcileService {
+	return &ReconcileService{
+		cfg:     cfg,
+		wg:      wgService,
+		fw:      firewallService,
+		startup: startupService,
+	}
+}
+
+// Start runs the reconciliation loop in the background if it is enabled
+func (s *ReconcileService) Start() {
+	if !s.cfg.Reconcile.Enabled {
+		return
+	}
+	interval := time.Duration(s.cfg.Reconcile.IntervalSeconds) * time.Second
+	logging.LogInfo("Starting reconciliation loop every %v (auto repair: %v)", interval, s.cfg.Reconcile.AutoRepair)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.Run(s.cfg.Reconcile.AutoRepair)
+		}
+	}()
+}
+
+// LastReport returns the report of the last run, nil if nothing ran yet
+func (s *ReconcileService) LastReport() *ReconcileReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last
+}
+
+// Run compares the running state with the configuration, and applies the
+// configuration of the drifted interfaces again if repair is set
+func (s *ReconcileService) Run(repair bool) *ReconcileReport {
+	s.cfg.LockApply()
+	defer s.cfg.UnlockApply()
+
+	report := &ReconcileReport{
+		CheckedAt:    time.Now(),
+		Drifts:       []Drift{},
+		Repaired:     []string{},
+		RepairErrors: []string{},
+	}
+
+	interfaces := s.cfg.GetAllInterfaces()
+	ids := make([]string, 0, len(interfaces))
+	for id := range interfaces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var drifted []*models.Interface
+	for _, id := range ids {
+		drifts := s.checkInterface(interfaces[id])
+		if len(drifts) > 0 {
+			report.Drifts = append(report.Drifts, drifts...)
+			drifted = append(drifted, interfaces[id])
+		}
+	}
+
+	for _, drift := range report.Drifts {
+		logging.LogInfo("Drift on interface %s: %s: %s", drift.Ifname, drift.Kind, drift.Detail)
+	}
+
+	if repair && len(drifted) > 0 {
+		for _, iface := range drifted {
+			logging.LogInfo("Repairing interface %s", iface.Ifname)
+			if err := s.startup.initializeInterface(iface); err != nil {
+				logging.LogError("Failed to repair interface %s: %v", iface.Ifname, err)
+				report.RepairErrors = append(report.RepairErrors, fmt.Sprintf("%s: %v", iface.Ifname, err))
+				continue
+			}
+			report.Repaired = append(report.Repaired, iface.ID)
+		}
+		s.cfg.SyncToInternalService()
+	}
+
+	s.publishDrift(report, drifted)
+
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	return report
+}
+
+// publishDrift publishes the drift of the interfaces, unless it is the same as at
+// the last run so a drift left unrepaired isn't published on every run
+func (s *ReconcileService) publishDrift(report *ReconcileReport, drifted []*models.Interface) {
+	last := s.LastReport()
+	for _, iface := range drifted {
+		drifts := driftsOf(report, iface.ID)
+		if last != nil && reflect.DeepEqual(drifts, driftsOf(last, iface.ID)) {
+			continue
+		}
+		repaired := false
+		for _, id := range report.Repaired {
+			repaired = repaired || id == iface.ID
+		}
+		events.Publish(events.Event{
+			Type:        events.ReconcileDrift,
+			Time:        report.CheckedAt,
+			InterfaceID: iface.ID,
+			Data: map[string]interface{}{
+				"ifname":   iface.Ifname,
+				"drifts":   drifts,
+				"repaired": repaired,
+			},
+		})
+	}
+}
+
+// driftsOf returns the drift of an interface in a report
+func driftsOf(report *ReconcileReport, interfaceID string) []Drift {
+	var drifts []Drift
+	for _, drift := range report.Drifts {
+		if drift.InterfaceID == interfaceID {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts
+}
+
+// checkInterface returns the drift of an interface that should be running
+func (s *ReconcileService) checkInterface(iface *models.Interface) []Drift {
+	var drifts []Drift
+	addDrift := func(serverID string, kind string, format string, a ...interface{}) {
+		drifts = append(drifts, Drift{
+			InterfaceID: iface.ID,
+			Ifname:      iface.Ifname,
+			ServerID:    serverID,
+			Kind:        kind,
+			Detail:      fmt.Sprintf(format, a...),
+		})
+	}
+
+	// Same condition as the startup initialization
+	if !iface.Enabled {
+		return nil
+	}
+	hasEnabledServers := false
+	for _, server := range iface.Servers {
+		if server.Enabled {
+			hasEnabledServers = true
+			break
+		}
+	}
+	if !hasEnabledServers {
+		return nil
+	}
+
+	// Link
+	if err := utils.IsIfExists(iface.Ifname); err != nil {
+		addDrift("", DriftLink, "interface is missing")
+		return drifts
+	}
+	wgPubkey, err := utils.PrivToPublic(iface.PrivateKey)
+	if err != nil {
+		addDrift("", DriftLink, "failed to derive public key: %v", err)
+		return drifts
+	}
+	if !s.wg.isTargetWgInterface(iface.Ifname, wgPubkey) {
+		addDrift("", DriftLink, "interface is not the configured WireGuard interface (public key mismatch)")
+		return drifts
+	}
+
+	// Addresses and firewall rules
+	liveAddrs, err := utils.GetInterfaceCIDRs(iface.Ifname)
+	if err != nil {
+		addDrift("", DriftAddress, "failed to list addresses: %v", err)
+	}
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		for _, network := range []*models.ServerNetworkConfig{server.IPv4, server.IPv6} {
+			if network == nil || !network.Enabled || network.Network == nil {
+				continue
+			}
+			if liveAddrs != nil && !stringInSlice(network.Network.String(), liveAddrs) {
+				addDrift(server.ID, DriftAddress, "address %s is missing", network.Network.String())
+			}
+
+			version := network.Network.Version
+			live, err := s.fw.CountRules(version, network.CommentString)
+			if err != nil {
+				addDrift(server.ID, DriftFirewall, "failed to list IPv%d rules: %v", version, err)
+				continue
+			}
+			desired := make(map[string]int)
+			for _, rule := range utils.ServerFirewallRules(iface.Ifname, iface.VRFName, network, version) {
+				desired[rule.Table]++
+			}
+			for _, table := range []string{"nat", "filter"} {
+				if table == "nat" && network.Snat != nil && network.Snat.RoamingMasterInterface != nil && *network.Snat.RoamingMasterInterface != "" {
+					// SNAT rules of roaming servers are managed by the roaming service
+					continue
+				}
+				if live[table] != desired[table] {
+					addDrift(server.ID, DriftFirewall, "%d of %d IPv%d rules installed in table %s", live[table], desired[table], version, table)
+				}
+			}
+		}
+	}
+
+	// Peers
+	stats, err := s.wg.GetPeerStats(iface.Ifname)
+	if err != nil {
+		addDrift("", DriftPeer, "failed to list peers: %v", err)
+		return drifts
+	}
+	desiredPeers := make(map[string]bool)
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		for _, client := range server.Clients {
+			if !client.Enabled {
+				continue
+			}
+			desiredPeers[client.PublicKey] = true
+			if _, ok := stats[client.PublicKey]; !ok {
+				addDrift(server.ID, DriftPeer, "peer of client %s (%s) is missing", client.Name, client.PublicKey)
+			}
+		}
+	}
+	livePeers := make([]string, 0, len(stats))
+	for pubkey := range stats {
+		livePeers = append(livePeers, pubkey)
+	}
+	sort.Strings(livePeers)
+	for _, pubkey := range livePeers {
+		if !desiredPeers[pubkey] {
+			addDrift("", DriftPeer, "unexpected peer %s", pubkey)
+		}
+	}
+
+	return drifts
+}
+
+func stringInSlice(target string, slice []string) bool {
+	for _, element := range slice {
+		if element == target {
+			return true
+		}
+	}
+	return false
+}
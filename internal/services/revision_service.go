@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// RevisionService diffs the saved revisions of the configuration and rolls back to them
+type RevisionService struct {
+	cfg     *config.Config
+	wg      *WireGuardService
+	startup *StartupService
+}
+
+func NewRevisionService(cfg *config.Config, wgService *WireGuardService, startupService *StartupService) *RevisionService {
+	return &RevisionService{
+		cfg:     cfg,
+		wg:      wgService,
+		startup: startupService,
+	}
+}
+
+// Diff returns the diff of the interfaces of two revisions, without their keys
+func (s *RevisionService) Diff(from, to int) (string, error) {
+	texts := make([]string, 2)
+	for i, number := range []int{from, to} {
+		revision, err := s.cfg.GetRevision(number)
+		if err != nil {
+			return "", err
+		}
+		interfaces, err := revision.DecodeInterfaces()
+		if err != nil {
+			return "", err
+		}
+		data, err := utils.RedactSecrets(interfaces)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode revision %d:-> %v", number, err)
+		}
+		texts[i] = string(data)
+	}
+	return utils.DiffLines(texts[0], texts[1], 3), nil
+}
+
+// PlanRollback returns what Rollback would change, without applying it
+func (s *RevisionService) PlanRollback(number int) (*ChangePlan, error) {
+	revision, err := s.cfg.GetRevision(number)
+	if err != nil {
+		return nil, err
+	}
+	target, err := revision.DecodeInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	return s.wg.PlanChange(s.cfg.GetAllInterfaces(), target), nil
+}
+
+// Rollback applies the interfaces of a revision again. The interfaces that differ
+// from the revision are brought down and removed, then the interfaces of the
+// revision are brought up the same way as at startup. The result is saved as a new revision.
+func (s *RevisionService) Rollback(number int) error {
+	revision, err := s.cfg.GetRevision(number)
+	if err != nil {
+		return err
+	}
+	target, err := revision.DecodeInterfaces()
+	if err != nil {
+		return err
+	}
+	current := s.cfg.GetAllInterfaces()
+	logging.LogInfo("Rolling back configuration to revision %d", number)
+
+	// Bring down the interfaces that change
+	for id, iface := range current {
+		if sameInterface(iface, target[id]) {
+			continue
+		}
+		if err := s.wg.SyncToInterface(iface.Ifname, false, iface); err != nil {
+			return fmt.Errorf("failed to bring down interface %s:-> %v", iface.Ifname, err)
+		}
+		if err := s.wg.RemoveConfig(iface.Ifname); err != nil {
+			return fmt.Errorf("failed to remove WireGuard config of %s:-> %v", iface.Ifname, err)
+		}
+	}
+
+	s.cfg.ReplaceInterfaces(target)
+
+	// Bring up the interfaces of the revision, going on if one fails so the others still run
+	var failures []string
+	for id, iface := range target {
+		if sameInterface(current[id], iface) {
+			continue
+		}
+		if err := s.wg.SyncToConf(iface); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", iface.Ifname, err))
+			continue
+		}
+		if err := s.startup.initializeInterface(iface); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", iface.Ifname, err))
+		}
+	}
+	s.cfg.SyncToInternalService()
+
+	if err := s.cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration:-> %v", err)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("rolled back to revision %d, but failed to apply interfaces:-> %s", number, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// sameInterface tells if two versions of an interface are identical, nil being a missing interface
+func sameInterface(a, b *models.Interface) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
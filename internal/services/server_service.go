@@ -89,11 +89,11 @@ func (s *ServerService) UpdateServer(interfaceID, serverID string, req ServerCre
 		return nil, err
 	}
 	if newserver.IPv4 != nil {
-		_ = utils.CleanupRules(newserver.IPv4.CommentString, 4, nil, false)
+		_ = s.fw.RemoveFwRules(4, newserver.IPv4.CommentString)
 
 	}
 	if newserver.IPv6 != nil {
-		_ = utils.CleanupRules(newserver.IPv6.CommentString, 6, nil, false)
+		_ = s.fw.RemoveFwRules(6, newserver.IPv6.CommentString)
 	}
 
 	s.SetServerEnabled(interfaceID, serverID, false, !enabledState)
@@ -112,6 +112,77 @@ func (s *ServerService) UpdateServer(interfaceID, serverID string, req ServerCre
 	return server, nil
 }
 
+// PurgePrivateKeys deletes the stored private keys of the clients of a server, also
+// from the saved revisions, and returns how many were deleted. The configurations
+// of these clients only have a placeholder for the key afterwards.
+func (s *ServerService) PurgePrivateKeys(interfaceID, serverID string) (int, error) {
+	iface := s.cfg.GetInterface(interfaceID)
+	if iface == nil {
+		return 0, fmt.Errorf("interface not found")
+	}
+
+	server, err := s.cfg.GetServer(interfaceID, serverID)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	clientIDs := make(map[string]bool)
+	for _, client := range server.Clients {
+		clientIDs[client.ID] = true
+		if client.PrivateKey != nil {
+			client.PrivateKey = nil
+			purged++
+		}
+	}
+
+	if purged > 0 {
+		s.cfg.SetInterface(interfaceID, iface)
+		if err := s.cfg.Save(); err != nil {
+			return 0, fmt.Errorf("failed to save configuration:-> %v", err)
+		}
+	}
+	// The keys purged earlier may still be in the revisions
+	if err := s.cfg.ScrubPrivateKeys(clientIDs); err != nil {
+		return purged, fmt.Errorf("failed to remove the private keys from the revisions:-> %v", err)
+	}
+	logging.LogInfo("Purged the private keys of %d clients of server %s", purged, server.Name)
+	return purged, nil
+}
+
+// PlanUpdateServer returns what UpdateServer would change, without applying it
+func (s *ServerService) PlanUpdateServer(interfaceID, serverID string, req ServerCreateRequest) (*ChangePlan, error) {
+	iface := s.cfg.GetInterface(interfaceID)
+	if iface == nil {
+		return nil, fmt.Errorf("interface not found")
+	}
+	server, err := s.cfg.GetServer(interfaceID, serverID)
+	if err != nil {
+		return nil, err
+	}
+	newserver, err := s.validateAndGenerateServerConfig(iface, &req, server)
+	if err != nil {
+		return nil, err
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		_, i := findServer(after[interfaceID], serverID)
+		after[interfaceID].Servers[i] = newserver
+		return nil
+	})
+}
+
+// PlanSetServerEnabled returns what SetServerEnabled would change, without applying it
+func (s *ServerService) PlanSetServerEnabled(interfaceID, serverID string, enabled bool) (*ChangePlan, error) {
+	if _, err := s.cfg.GetServer(interfaceID, serverID); err != nil {
+		return nil, err
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		server, _ := findServer(after[interfaceID], serverID)
+		server.Enabled = enabled
+		return nil
+	})
+}
+
 func (s *ServerService) SetServerEnabled(interfaceID, serverID string, enabled bool, syncServiceAndConfig bool) error {
 	logging.LogInfo("Setting server %s enabled=%t for interface %s", serverID, enabled, interfaceID)
 	iface := s.cfg.GetInterface(interfaceID)
@@ -213,6 +284,19 @@ func (s *ServerService) DeleteServer(interfaceID, serverID string) error {
 	return s.cfg.Save()
 }
 
+// PlanDeleteServer returns what DeleteServer would change, without applying it
+func (s *ServerService) PlanDeleteServer(interfaceID, serverID string) (*ChangePlan, error) {
+	if _, err := s.cfg.GetServer(interfaceID, serverID); err != nil {
+		return nil, err
+	}
+	return planSimulated(s.cfg, s.wg, func(after map[string]*models.Interface) error {
+		iface := after[interfaceID]
+		_, i := findServer(iface, serverID)
+		iface.Servers = append(iface.Servers[:i], iface.Servers[i+1:]...)
+		return nil
+	})
+}
+
 func (s *ServerService) MoveServer(interfaceID, serverID, newInterfaceID string) error {
 	// Get source interface and server
 	srcIface := s.cfg.GetInterface(interfaceID)
@@ -338,6 +422,10 @@ func (s *ServerService) validateAndGenerateServerConfig(iface *models.Interface,
 		return nil, fmt.Errorf("IPv6 Enabled but network is nil")
 	}
 
+	if err := validateRateLimits(req.RateLimitUp, req.RateLimitDown); err != nil {
+		return nil, err
+	}
+
 	var server *models.Server
 	prefix := s.cfg.WGPanelId + "-"
 	CommentString, _ := utils.GenerateRandomString(prefix, 12)
@@ -356,6 +444,15 @@ func (s *ServerService) validateAndGenerateServerConfig(iface *models.Interface,
 			Keepalive: req.Keepalive,
 			Clients:   []*models.Client{},
 		}
+		if req.EphemeralPrivateKeys != nil {
+			server.EphemeralPrivateKeys = *req.EphemeralPrivateKeys
+		}
+		if req.RateLimitUp != nil {
+			server.RateLimitUp = *req.RateLimitUp
+		}
+		if req.RateLimitDown != nil {
+			server.RateLimitDown = *req.RateLimitDown
+		}
 
 	} else {
 		server = &models.Server{}
@@ -386,6 +483,15 @@ func (s *ServerService) validateAndGenerateServerConfig(iface *models.Interface,
 		server.Name = req.Name
 		server.DNS = req.DNS
 		server.Keepalive = req.Keepalive
+		if req.EphemeralPrivateKeys != nil {
+			server.EphemeralPrivateKeys = *req.EphemeralPrivateKeys
+		}
+		if req.RateLimitUp != nil {
+			server.RateLimitUp = *req.RateLimitUp
+		}
+		if req.RateLimitDown != nil {
+			server.RateLimitDown = *req.RateLimitDown
+		}
 		ipv4CommentString = utils.If(server.IPv4 == nil, ipv4CommentString, server.IPv4.CommentString)
 		ipv6CommentString = utils.If(server.IPv6 == nil, ipv6CommentString, server.IPv6.CommentString)
 		oldv4 = utils.If(server.IPv4 == nil, nil, server.IPv4.Network)
@@ -718,11 +824,14 @@ func (s *ServerService) prepareNetworkConfig(af int, req *ServerNetworkConfigReq
 
 // Request types
 type ServerCreateRequest struct {
-	Name      string                      `json:"name" binding:"required"`
-	DNS       []string                    `json:"dns"`
-	Keepalive *int                        `json:"keepalive"`
-	IPv4      *ServerNetworkConfigRequest `json:"ipv4"`
-	IPv6      *ServerNetworkConfigRequest `json:"ipv6"`
+	Name                 string                      `json:"name" binding:"required"`
+	DNS                  []string                    `json:"dns"`
+	Keepalive            *int                        `json:"keepalive"`
+	IPv4                 *ServerNetworkConfigRequest `json:"ipv4"`
+	IPv6                 *ServerNetworkConfigRequest `json:"ipv6"`
+	EphemeralPrivateKeys *bool                       `json:"ephemeralPrivateKeys"` // Unchanged on update if nil
+	RateLimitUp          *int                        `json:"rateLimitUp"`          // Default of the clients in kbit/s, unchanged on update if nil
+	RateLimitDown        *int                        `json:"rateLimitDown"`        // Default of the clients in kbit/s, unchanged on update if nil
 }
 
 type ServerNetworkConfigRequest struct {
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+	"wg-panel/internal/logging"
+)
+
+// WebhookEventTest is the type of the event sent to test a webhook
+const WebhookEventTest = "webhook.test"
+
+// Headers of the webhook requests
+const (
+	WebhookHeaderEvent     = "X-WGPanel-Event"
+	WebhookHeaderDelivery  = "X-WGPanel-Delivery"
+	WebhookHeaderSignature = "X-WGPanel-Signature" // "sha256=" and the hex HMAC-SHA256 of the body
+)
+
+// WebhookService posts the events of the bus to the webhooks whose filter matches
+// them, retrying the failed deliveries with an exponential backoff. Deliveries
+// run concurrently, so a receiver should order the events by their ID.
+type WebhookService struct {
+	cfg      *config.Config
+	http     *http.Client
+	attempts int
+	backoff  time.Duration // Before the second attempt, doubled before each next one
+}
+
+func NewWebhookService(cfg *config.Config) *WebhookService {
+	return &WebhookService{
+		cfg:      cfg,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		attempts: 5,
+		backoff:  5 * time.Second,
+	}
+}
+
+// Start delivers the events in the background
+func (s *WebhookService) Start() {
+	logging.LogVerbose("Starting webhook delivery")
+
+	go func() {
+		var lastID uint64
+		for {
+			subscription, missed := events.Subscribe(lastID)
+			for _, event := range missed {
+				lastID = event.ID
+				s.Dispatch(event)
+			}
+			for event := range subscription.C {
+				lastID = event.ID
+				s.Dispatch(event)
+			}
+			// Dropped for being too slow, subscribe again after the last event
+		}
+	}()
+}
+
+// Dispatch starts the delivery of an event to the enabled webhooks matching it
+func (s *WebhookService) Dispatch(event events.Event) {
+	for _, webhook := range s.cfg.EnabledWebhooks() {
+		if webhook.Matches(event.Type) {
+			go s.deliver(webhook, event)
+		}
+	}
+}
+
+// deliver posts an event to a webhook until it succeeds or the attempts run out
+func (s *WebhookService) deliver(webhook *config.Webhook, event events.Event) {
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		err := s.post(webhook, event)
+		if err == nil {
+			return
+		}
+		if attempt >= s.attempts {
+			logging.LogError("Failed to deliver event %d (%s) to webhook %s after %d attempts: %v", event.ID, event.Type, webhook.Name, attempt, err)
+			return
+		}
+		logging.LogVerbose("Failed to deliver event %d (%s) to webhook %s, retrying in %v: %v", event.ID, event.Type, webhook.Name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Test posts a test event to a webhook once, and returns the delivery error
+func (s *WebhookService) Test(webhook *config.Webhook) error {
+	return s.post(webhook, events.Event{
+		Type: WebhookEventTest,
+		Time: time.Now(),
+		Data: map[string]interface{}{"webhookId": webhook.ID, "webhookName": webhook.Name},
+	})
+}
+
+// post makes one delivery attempt, a response without a 2xx status is an error
+func (s *WebhookService) post(webhook *config.Webhook, event events.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event:-> %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request:-> %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wg-panel-webhook")
+	req.Header.Set(WebhookHeaderEvent, event.Type)
+	req.Header.Set(WebhookHeaderDelivery, fmt.Sprintf("%s-%d", webhook.ID, event.ID))
+	req.Header.Set(WebhookHeaderSignature, "sha256="+SignWebhook(webhook.Secret, body))
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post:-> %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of a body keyed with the secret of a webhook
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
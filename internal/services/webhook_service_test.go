@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/events"
+)
+
+func TestWebhookService_Dispatch(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan delivery, 10)
+	var mu sync.Mutex
+	failures := 2
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		deliveries <- delivery{r.Header, body}
+	}))
+	defer receiver.Close()
+
+	cfg := &config.Config{Webhooks: map[string]*config.Webhook{
+		"w1":       {ID: "w1", Name: "clients", URL: receiver.URL, Secret: "s3cret", Events: []string{"client."}, Enabled: true},
+		"disabled": {ID: "disabled", Name: "disabled", URL: receiver.URL, Enabled: false},
+	}}
+	service := NewWebhookService(cfg)
+	service.backoff = time.Millisecond
+
+	// Only the matching event is delivered, after two failed attempts
+	service.Dispatch(events.Event{ID: 7, Type: events.PeerOnline})
+	service.Dispatch(events.Event{ID: 8, Type: events.ClientCreated, ClientID: "c1"})
+	var got delivery
+	select {
+	case got = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The event wasn't delivered")
+	}
+	if got.header.Get(WebhookHeaderEvent) != events.ClientCreated || got.header.Get(WebhookHeaderDelivery) != "w1-8" {
+		t.Errorf("Unexpected headers %v", got.header)
+	}
+	if got.header.Get(WebhookHeaderSignature) != "sha256="+SignWebhook("s3cret", got.body) {
+		t.Errorf("Signature %q doesn't match the body", got.header.Get(WebhookHeaderSignature))
+	}
+	var event events.Event
+	if err := json.Unmarshal(got.body, &event); err != nil || event.ID != 8 || event.ClientID != "c1" {
+		t.Errorf("Unexpected body %s: %v", got.body, err)
+	}
+	select {
+	case extra := <-deliveries:
+		t.Errorf("Unexpected delivery %s", extra.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A test is a single attempt
+	mu.Lock()
+	failures = 1
+	mu.Unlock()
+	webhook, _ := cfg.GetWebhook("w1")
+	if err := service.Test(webhook); err == nil {
+		t.Error("Expected the failed test delivery to be reported")
+	}
+	if err := service.Test(webhook); err != nil {
+		t.Errorf("Test() error = %v", err)
+	}
+}
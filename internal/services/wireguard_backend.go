@@ -0,0 +1,175 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"wg-panel/internal/config"
+	"wg-panel/internal/internalservice"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// WireGuardBackend applies generated interface configurations to the kernel.
+// ifname is passed separately from iface so an interface can be torn down
+// under its old name while being renamed.
+type WireGuardBackend interface {
+	// Up creates the interface and applies the full configuration, including
+	// addresses, VRF binding and firewall rules of the enabled servers.
+	Up(ifname string, iface *models.Interface) error
+	// Sync updates keys and peers of an existing interface without
+	// interrupting sessions of unchanged peers.
+	Sync(ifname string, iface *models.Interface) error
+	// Down removes the firewall rules of the enabled servers and deletes the interface.
+	Down(ifname string, iface *models.Interface) error
+	// PublicKey returns the public key of a WireGuard interface, or an error
+	// if the interface is not a WireGuard interface.
+	PublicKey(ifname string) (string, error)
+	SetMTU(ifname string, mtu int) error
+	GetPeerStats(ifname string) (map[string]*models.WGState, error)
+}
+
+// NewWireGuardBackend creates the backend selected by the wireguardBackend config option
+func NewWireGuardBackend(name string, configPath string, fw *internalservice.FirewallService) (WireGuardBackend, error) {
+	switch name {
+	case "", config.WireGuardBackendWgQuick:
+		return &wgQuickBackend{configPath: configPath}, nil
+	case config.WireGuardBackendNetlink:
+		return newNetlinkBackend(fw), nil
+	default:
+		return nil, fmt.Errorf("unknown WireGuard backend %q, must be %q or %q", name, config.WireGuardBackendWgQuick, config.WireGuardBackendNetlink)
+	}
+}
+
+// wgQuickBackend drives interfaces with wg-quick and wg, using the standalone
+// configuration files written by SyncToConf.
+type wgQuickBackend struct {
+	configPath string
+}
+
+func (b *wgQuickBackend) configFile(ifname string) string {
+	return filepath.Join(b.configPath, fmt.Sprintf("%s.conf", ifname))
+}
+
+func (b *wgQuickBackend) Up(ifname string, iface *models.Interface) error {
+	if err := utils.RunCommand("wg-quick", "up", b.configFile(ifname)); err != nil {
+		return fmt.Errorf("failed to bring up interface with wg-quick:-> %v", err)
+	}
+	return nil
+}
+
+func (b *wgQuickBackend) Sync(ifname string, iface *models.Interface) error {
+	// Use Go native approach: first strip config, then sync
+	strippedConfig, err := b.stripWgQuickConfig(b.configFile(ifname))
+	if err != nil {
+		return fmt.Errorf("failed to strip config:-> %v", err)
+	}
+	return b.syncConfToInterface(ifname, strippedConfig)
+}
+
+func (b *wgQuickBackend) Down(ifname string, iface *models.Interface) error {
+	if err := utils.RunCommand("wg-quick", "down", b.configFile(ifname)); err != nil {
+		return fmt.Errorf("failed to disable interface:-> %v", err)
+	}
+	return nil
+}
+
+func (b *wgQuickBackend) PublicKey(ifname string) (string, error) {
+	output, err := utils.RunCommandWithOutput("wg", "show", ifname, "public-key")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func (b *wgQuickBackend) SetMTU(ifname string, mtu int) error {
+	if err := utils.RunCommand("ip", "link", "set", "dev", ifname, "mtu", fmt.Sprintf("%d", mtu)); err != nil {
+		return fmt.Errorf("failed to set MTU:-> %v", err)
+	}
+	return nil
+}
+
+func (b *wgQuickBackend) GetPeerStats(interfaceName string) (map[string]*models.WGState, error) {
+	output, err := utils.RunCommandWithOutput("wg", "show", interfaceName, "dump")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer stats:-> %v", err)
+	}
+
+	stats := make(map[string]*models.WGState)
+	lines := strings.Split(output, "\n")
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, "\t")
+		if len(parts) < 8 {
+			continue
+		}
+
+		publicKey := parts[0]
+		if publicKey == "" {
+			continue
+		}
+
+		state := &models.WGState{}
+
+		// Parse endpoint
+		if parts[2] != "(none)" && parts[2] != "" {
+			endpoint := parts[2]
+			state.Endpoint = &endpoint
+		}
+
+		// Parse latest handshake
+		if parts[4] != "0" && parts[4] != "" {
+			if timestamp := parseUnixTimestamp(parts[4]); timestamp != nil {
+				state.LatestHandshake = timestamp
+			}
+		}
+
+		// Parse transfer stats
+		if parts[5] != "0" && parts[5] != "" {
+			if rx := parseInt64(parts[5]); rx != nil {
+				state.TransferRx = rx
+			}
+		}
+
+		if parts[6] != "0" && parts[6] != "" {
+			if tx := parseInt64(parts[6]); tx != nil {
+				state.TransferTx = tx
+			}
+		}
+
+		stats[publicKey] = state
+	}
+
+	return stats, nil
+}
+
+// stripWgQuickConfig executes wg-quick strip and returns the stripped configuration
+func (b *wgQuickBackend) stripWgQuickConfig(configFile string) (string, error) {
+	output, err := utils.RunCommandWithOutput("wg-quick", "strip", configFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to strip config file %s:-> %v", configFile, err)
+	}
+	return output, nil
+}
+
+// syncConfToInterface applies the stripped configuration to the interface using wg syncconf
+func (b *wgQuickBackend) syncConfToInterface(interfaceName, strippedConfig string) error {
+	cmd := exec.Command("wg", "syncconf", interfaceName, "/dev/stdin")
+	cmd.Stdin = strings.NewReader(strippedConfig)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to sync config to interface %s:-> %v, stderr: %s", interfaceName, err, stderr.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/internalservice"
+	"wg-panel/internal/logging"
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// netlinkBackend drives interfaces through rtnetlink and the WireGuard
+// generic netlink API, without depending on wg-quick or wg being installed.
+type netlinkBackend struct {
+	fw *internalservice.FirewallService
+
+	mu     sync.Mutex
+	client *wgctrl.Client
+}
+
+func newNetlinkBackend(fw *internalservice.FirewallService) *netlinkBackend {
+	return &netlinkBackend{fw: fw}
+}
+
+// wgClient lazily opens the wgctrl client, so hosts without the kernel module
+// only fail when an interface is actually used
+func (b *netlinkBackend) wgClient() (*wgctrl.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client == nil {
+		client, err := wgctrl.New()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open WireGuard netlink client:-> %v", err)
+		}
+		b.client = client
+	}
+	return b.client, nil
+}
+
+func (b *netlinkBackend) Up(ifname string, iface *models.Interface) (err error) {
+	link := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{Name: ifname},
+		LinkType:  "wireguard",
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("failed to create WireGuard interface %s:-> %v", ifname, err)
+	}
+	// Same as wg-quick: never leave a half configured interface behind
+	defer func() {
+		if err != nil {
+			if delErr := netlink.LinkDel(link); delErr != nil {
+				logging.LogError("Failed to remove half configured interface %s: %v", ifname, delErr)
+			}
+		}
+	}()
+
+	if err := b.Sync(ifname, iface); err != nil {
+		return err
+	}
+
+	for _, address := range interfaceAddresses(iface) {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return fmt.Errorf("failed to parse address %s:-> %v", address, err)
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %s to %s:-> %v", address, ifname, err)
+		}
+	}
+
+	if iface.MTU > 0 {
+		if err := netlink.LinkSetMTU(link, iface.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU:-> %v", err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set interface %s up:-> %v", ifname, err)
+	}
+
+	// Equivalent of the PostUp commands in the generated configuration file
+	if iface.VRFName != nil && *iface.VRFName != "" {
+		if err := utils.SetInterfaceVRF(ifname, *iface.VRFName); err != nil {
+			return err
+		}
+	}
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		if err := b.fw.AddIpAndFwRules(ifname, iface.VRFName, server.IPv4); err != nil {
+			return fmt.Errorf("failed to add IPv4 firewall rules for server %s:-> %v", server.Name, err)
+		}
+		if err := b.fw.AddIpAndFwRules(ifname, iface.VRFName, server.IPv6); err != nil {
+			return fmt.Errorf("failed to add IPv6 firewall rules for server %s:-> %v", server.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *netlinkBackend) Sync(ifname string, iface *models.Interface) error {
+	client, err := b.wgClient()
+	if err != nil {
+		return err
+	}
+	device, err := client.Device(ifname)
+	if err != nil {
+		return fmt.Errorf("failed to get WireGuard device %s:-> %v", ifname, err)
+	}
+
+	privateKey, err := wgtypes.ParseKey(iface.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key:-> %v", err)
+	}
+	fwMark, err := parseFwMark(iface.FwMark)
+	if err != nil {
+		return err
+	}
+	port := iface.Port
+
+	peers, err := desiredPeerConfigs(iface)
+	if err != nil {
+		return err
+	}
+	// Like wg syncconf, only remove peers that are gone and update the others in place
+	desired := make(map[wgtypes.Key]bool)
+	for _, peer := range peers {
+		desired[peer.PublicKey] = true
+	}
+	for _, peer := range device.Peers {
+		if !desired[peer.PublicKey] {
+			peers = append(peers, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+		}
+	}
+
+	cfg := wgtypes.Config{
+		PrivateKey:   &privateKey,
+		ListenPort:   &port,
+		FirewallMark: &fwMark,
+		Peers:        peers,
+	}
+	if err := client.ConfigureDevice(ifname, cfg); err != nil {
+		return fmt.Errorf("failed to configure WireGuard device %s:-> %v", ifname, err)
+	}
+	return nil
+}
+
+func (b *netlinkBackend) Down(ifname string, iface *models.Interface) error {
+	// Equivalent of the PreDown commands in the generated configuration file
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		if server.IPv4 != nil && server.IPv4.Enabled && server.IPv4.CommentString != "" {
+			if err := b.fw.RemoveFwRules(4, server.IPv4.CommentString); err != nil {
+				logging.LogError("Failed to remove IPv4 firewall rules for server %s: %v", server.Name, err)
+			}
+		}
+		if server.IPv6 != nil && server.IPv6.Enabled && server.IPv6.CommentString != "" {
+			if err := b.fw.RemoveFwRules(6, server.IPv6.CommentString); err != nil {
+				logging.LogError("Failed to remove IPv6 firewall rules for server %s: %v", server.Name, err)
+			}
+		}
+	}
+
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s:-> %v", ifname, err)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %s:-> %v", ifname, err)
+	}
+	return nil
+}
+
+func (b *netlinkBackend) PublicKey(ifname string) (string, error) {
+	client, err := b.wgClient()
+	if err != nil {
+		return "", err
+	}
+	device, err := client.Device(ifname)
+	if err != nil {
+		return "", fmt.Errorf("failed to get WireGuard device %s:-> %v", ifname, err)
+	}
+	return device.PublicKey.String(), nil
+}
+
+func (b *netlinkBackend) SetMTU(ifname string, mtu int) error {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s:-> %v", ifname, err)
+	}
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return fmt.Errorf("failed to set MTU:-> %v", err)
+	}
+	return nil
+}
+
+func (b *netlinkBackend) GetPeerStats(interfaceName string) (map[string]*models.WGState, error) {
+	client, err := b.wgClient()
+	if err != nil {
+		return nil, err
+	}
+	device, err := client.Device(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer stats:-> %v", err)
+	}
+
+	// Keep the semantics of the wg show dump parser: zero values are reported as nil
+	stats := make(map[string]*models.WGState)
+	for _, peer := range device.Peers {
+		state := &models.WGState{}
+		if peer.Endpoint != nil {
+			endpoint := peer.Endpoint.String()
+			state.Endpoint = &endpoint
+		}
+		if !peer.LastHandshakeTime.IsZero() && peer.LastHandshakeTime.Unix() != 0 {
+			handshake := time.Unix(peer.LastHandshakeTime.Unix(), 0)
+			state.LatestHandshake = &handshake
+		}
+		if peer.ReceiveBytes != 0 {
+			rx := peer.ReceiveBytes
+			state.TransferRx = &rx
+		}
+		if peer.TransmitBytes != 0 {
+			tx := peer.TransmitBytes
+			state.TransferTx = &tx
+		}
+		stats[peer.PublicKey.String()] = state
+	}
+	return stats, nil
+}
+
+// desiredPeerConfigs converts the enabled clients of the enabled servers to peer configs
+func desiredPeerConfigs(iface *models.Interface) ([]wgtypes.PeerConfig, error) {
+	var zeroKey wgtypes.Key
+	peers := make([]wgtypes.PeerConfig, 0)
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		for _, client := range server.Clients {
+			if !client.Enabled {
+				continue
+			}
+			publicKey, err := wgtypes.ParseKey(client.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key of client %s:-> %v", client.Name, err)
+			}
+			presharedKey := zeroKey
+			if client.PresharedKey != nil && *client.PresharedKey != "" {
+				if presharedKey, err = wgtypes.ParseKey(*client.PresharedKey); err != nil {
+					return nil, fmt.Errorf("failed to parse preshared key of client %s:-> %v", client.Name, err)
+				}
+			}
+			var keepalive time.Duration
+			if client.Keepalive != nil && *client.Keepalive > 0 {
+				keepalive = time.Duration(*client.Keepalive) * time.Second
+			}
+			allowedIPs := make([]net.IPNet, 0)
+			for _, cidr := range calculateAllowedIPs(client, server) {
+				_, ipNet, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse allowed IP %s:-> %v", cidr, err)
+				}
+				allowedIPs = append(allowedIPs, *ipNet)
+			}
+			peers = append(peers, wgtypes.PeerConfig{
+				PublicKey:                   publicKey,
+				PresharedKey:                &presharedKey,
+				PersistentKeepaliveInterval: &keepalive,
+				ReplaceAllowedIPs:           true,
+				AllowedIPs:                  allowedIPs,
+			})
+		}
+	}
+	return peers, nil
+}
+
+// interfaceAddresses returns the addresses of the enabled servers, same as the Address line of the config file
+func interfaceAddresses(iface *models.Interface) []string {
+	addresses := make([]string, 0)
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		if server.IPv4 != nil && server.IPv4.Enabled && server.IPv4.Network != nil {
+			addresses = append(addresses, server.IPv4.Network.String())
+		}
+		if server.IPv6 != nil && server.IPv6.Enabled && server.IPv6.Network != nil {
+			addresses = append(addresses, server.IPv6.Network.String())
+		}
+	}
+	return addresses
+}
+
+func parseFwMark(fwMark *string) (int, error) {
+	if fwMark == nil || *fwMark == "" || *fwMark == "off" {
+		return 0, nil
+	}
+	var mark uint64
+	var err error
+	if strings.HasPrefix(*fwMark, "0x") {
+		mark, err = strconv.ParseUint(*fwMark, 0, 32)
+	} else {
+		mark, err = strconv.ParseUint(*fwMark, 10, 32)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid firewall mark %s:-> %v", *fwMark, err)
+	}
+	return int(mark), nil
+}
@@ -1,15 +1,15 @@
 package services
 
 import (
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"wg-panel/internal/internalservice"
 	"wg-panel/internal/logging"
 	"wg-panel/internal/models"
 	"wg-panel/internal/utils"
@@ -17,21 +17,43 @@ import (
 
 type WireGuardService struct {
 	configPath string
+	backend    WireGuardBackend
+	fw         *internalservice.FirewallService
+
+	tcMu      sync.Mutex
+	tcScript  map[string]string // Applied tc batch per interface name
+	throttler ClientThrottler
+}
+
+// ClientThrottler caps the rate of clients below their configured rate limits
+type ClientThrottler interface {
+	// ThrottleKbit returns the rate in kbit/s of a throttled client, 0 if it isn't throttled
+	ThrottleKbit(interfaceID, serverID string, client *models.Client) int
 }
 
-func NewWireGuardService(configPath string) *WireGuardService {
+func NewWireGuardService(configPath string, backend WireGuardBackend, fw *internalservice.FirewallService) *WireGuardService {
 	return &WireGuardService{
 		configPath: configPath,
+		backend:    backend,
+		fw:         fw,
+		tcScript:   make(map[string]string),
 	}
 }
 
+// SetThrottler sets the source of the client throttles added to the rate limits
+func (s *WireGuardService) SetThrottler(throttler ClientThrottler) {
+	s.tcMu.Lock()
+	defer s.tcMu.Unlock()
+	s.throttler = throttler
+}
+
 func (s *WireGuardService) SyncToConfAndInterface(iface *models.Interface) error {
 	// Generate standalone configuration with firewall rules
 	if err := s.SyncToConf(iface); err != nil {
 		return err
 	}
-	// Apply configuration using wg-quick or wg syncconf
-	return s.SyncToInterface(iface.Ifname, iface.Enabled, iface.PrivateKey)
+	// Apply configuration using the configured backend
+	return s.SyncToInterface(iface.Ifname, iface.Enabled, iface)
 }
 
 func (s *WireGuardService) SyncToConf(iface *models.Interface) error {
@@ -48,6 +70,20 @@ func (s *WireGuardService) SyncToConf(iface *models.Interface) error {
 	return nil
 }
 
+// GenerateRedactedConf returns the configuration of GenerateConf with the private and
+// preshared keys replaced, so it can be shown in plans
+func (s *WireGuardService) GenerateRedactedConf(iface *models.Interface) string {
+	lines := strings.Split(s.GenerateConf(iface), "\n")
+	for i, line := range lines {
+		for _, key := range []string{"PrivateKey", "PresharedKey"} {
+			if strings.HasPrefix(line, key+" = ") {
+				lines[i] = key + " = <redacted>"
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (s *WireGuardService) GenerateConf(iface *models.Interface) string {
 	var config strings.Builder
 
@@ -60,19 +96,7 @@ func (s *WireGuardService) GenerateConf(iface *models.Interface) string {
 	}
 
 	// Add IP addresses from enabled servers
-	addresses := make([]string, 0)
-	for _, server := range iface.Servers {
-		if !server.Enabled {
-			continue
-		}
-		if server.IPv4 != nil && server.IPv4.Enabled && server.IPv4.Network != nil {
-			addresses = append(addresses, server.IPv4.Network.String())
-		}
-		if server.IPv6 != nil && server.IPv6.Enabled && server.IPv6.Network != nil {
-			addresses = append(addresses, server.IPv6.Network.String())
-		}
-	}
-
+	addresses := interfaceAddresses(iface)
 	if len(addresses) > 0 {
 		config.WriteString(fmt.Sprintf("Address = %s\n", strings.Join(addresses, ", ")))
 	}
@@ -111,7 +135,7 @@ func (s *WireGuardService) GenerateConf(iface *models.Interface) string {
 			}
 
 			// Calculate AllowedIPs
-			allowedIPs := s.calculateAllowedIPs(client, server)
+			allowedIPs := calculateAllowedIPs(client, server)
 			if len(allowedIPs) > 0 {
 				config.WriteString(fmt.Sprintf("AllowedIPs = %s\n", strings.Join(allowedIPs, ", ")))
 			}
@@ -127,7 +151,7 @@ func (s *WireGuardService) GenerateConf(iface *models.Interface) string {
 	return config.String()
 }
 
-func (s *WireGuardService) calculateAllowedIPs(client *models.Client, server *models.Server) []string {
+func calculateAllowedIPs(client *models.Client, server *models.Server) []string {
 	allowedIPs := make([]string, 0)
 
 	if server.IPv4 != nil && server.IPv4.Enabled && client.IPv4Offset != nil {
@@ -145,25 +169,26 @@ func (s *WireGuardService) calculateAllowedIPs(client *models.Client, server *mo
 	return allowedIPs
 }
 
-func (s *WireGuardService) SyncToInterface(ifname string, enabled bool, checkWgKey string) (err error) {
-	configFile := filepath.Join(s.configPath, fmt.Sprintf("%s.conf", ifname))
+// SyncToInterface brings the interface named ifname up, in sync or down.
+// iface is checked against the running interface by its public key.
+func (s *WireGuardService) SyncToInterface(ifname string, enabled bool, iface *models.Interface) (err error) {
 	wgPubkey := ""
-	if checkWgKey != "" {
-		wgPubkey, err = utils.PrivToPublic(checkWgKey)
+	if iface.PrivateKey != "" {
+		wgPubkey, err = utils.PrivToPublic(iface.PrivateKey)
 		if err != nil {
 			return fmt.Errorf("failed to derive public key from private key:-> %v", err)
 		}
 	}
 
 	// Check if interface exists
-	interfaceExists := utils.RunCommand("ip", "link", "show", ifname) == nil
+	interfaceExists := utils.IsIfExists(ifname) == nil
 
 	if enabled {
 		if !interfaceExists {
-			// Situation 1: Interface not found and enable=true, wg-quick up normally
+			// Situation 1: Interface not found and enable=true, bring it up normally
 			logging.LogInfo("Bringing up WireGuard interface %s", ifname)
-			if err := utils.RunCommand("wg-quick", "up", configFile); err != nil {
-				return fmt.Errorf("failed to bring up interface with wg-quick:-> %v", err)
+			if err := s.backend.Up(ifname, iface); err != nil {
+				return err
 			}
 		} else {
 			// Situation 2: Interface exists, check if it's a WireGuard interface and public key
@@ -175,14 +200,8 @@ func (s *WireGuardService) SyncToInterface(ifname string, enabled bool, checkWgK
 				}
 			}
 
-			// Use Go native approach: first strip config, then sync
-			strippedConfig, err := s.stripWgQuickConfig(configFile)
-			if err != nil {
-				return fmt.Errorf("failed to strip config:-> %v", err)
-			}
-
 			logging.LogInfo("Syncing configuration to WireGuard interface %s", ifname)
-			if err := s.syncConfToInterface(ifname, strippedConfig); err != nil {
+			if err := s.backend.Sync(ifname, iface); err != nil {
 				return fmt.Errorf("failed to sync interface configuration:-> %v", err)
 			}
 		}
@@ -201,17 +220,87 @@ func (s *WireGuardService) SyncToInterface(ifname string, enabled bool, checkWgK
 				}
 			}
 
-			// Use wg-quick down to properly disable the interface
 			logging.LogInfo("Bringing down WireGuard interface %s", ifname)
-			if err := utils.RunCommand("wg-quick", "down", configFile); err != nil {
-				return fmt.Errorf("failed to disable interface:-> %v", err)
+			if err := s.backend.Down(ifname, iface); err != nil {
+				return err
 			}
+			s.RemoveRateLimits(ifname)
 		}
 	}
 
+	if enabled {
+		return s.ApplyRateLimits(iface)
+	}
 	return nil
 }
 
+// RateLimits returns the rate limits of the enabled clients of an interface, a
+// throttled client gets the lower of its limits and its throttle
+func (s *WireGuardService) RateLimits(iface *models.Interface) []utils.RateLimit {
+	var limits []utils.RateLimit
+	for _, server := range iface.Servers {
+		if !server.Enabled {
+			continue
+		}
+		for _, client := range server.Clients {
+			if !client.Enabled {
+				continue
+			}
+			up, down := client.RateLimits(server)
+			if s.throttler != nil {
+				if throttle := s.throttler.ThrottleKbit(iface.ID, server.ID, client); throttle > 0 {
+					up = utils.If(up == 0 || throttle < up, throttle, up)
+					down = utils.If(down == 0 || throttle < down, throttle, down)
+				}
+			}
+			addresses := calculateAllowedIPs(client, server)
+			if (up == 0 && down == 0) || len(addresses) == 0 {
+				continue
+			}
+			limits = append(limits, utils.RateLimit{Addresses: addresses, UploadKbit: up, DownloadKbit: down})
+		}
+	}
+	return limits
+}
+
+// ApplyRateLimits shapes the clients of a running interface with tc. The qdiscs
+// are only replaced when the limits changed or were lost with a restart of the
+// interface, so it is cheap to call again.
+func (s *WireGuardService) ApplyRateLimits(iface *models.Interface) error {
+	s.tcMu.Lock()
+	defer s.tcMu.Unlock()
+
+	limits := s.RateLimits(iface)
+	script := utils.TrafficControlScript(iface.Ifname, limits)
+	applied, known := s.tcScript[iface.Ifname]
+	if known && script == applied && (script == "" || utils.HasRateLimits(iface.Ifname)) {
+		return nil
+	}
+	// Shaping left by a previous run is removed
+	if !known && script == "" && !utils.HasRateLimits(iface.Ifname) {
+		s.tcScript[iface.Ifname] = ""
+		return nil
+	}
+	if err := utils.ApplyRateLimits(iface.Ifname, limits); err != nil {
+		delete(s.tcScript, iface.Ifname)
+		return err
+	}
+	logging.LogInfo("Applied rate limits of %d clients to interface %s", len(limits), iface.Ifname)
+	s.tcScript[iface.Ifname] = script
+	return nil
+}
+
+// RemoveRateLimits removes the shaping of an interface
+func (s *WireGuardService) RemoveRateLimits(ifname string) {
+	s.tcMu.Lock()
+	defer s.tcMu.Unlock()
+
+	if applied, known := s.tcScript[ifname]; known && applied != "" {
+		utils.RemoveRateLimits(ifname)
+	}
+	delete(s.tcScript, ifname)
+}
+
 func (s *WireGuardService) RemoveConfig(ifname string) error {
 	logging.LogInfo("Removing WireGuard configuration for interface %s", ifname)
 	configFile := filepath.Join(s.configPath, fmt.Sprintf("%s.conf", ifname))
@@ -228,72 +317,15 @@ func (s *WireGuardService) RemoveConfig(ifname string) error {
 func (s *WireGuardService) SetInterfaceMTU(ifname string, mtu int) error {
 
 	// Check if interface exists before trying to set MTU
-	if err := utils.RunCommand("ip", "link", "show", ifname); err != nil {
-		return fmt.Errorf("interface %s does not exist:-> %v", ifname, err)
+	if err := utils.IsIfExists(ifname); err != nil {
+		return err
 	}
 
-	if err := utils.RunCommand("ip", "link", "set", "dev", ifname, "mtu", fmt.Sprintf("%d", mtu)); err != nil {
-		return fmt.Errorf("failed to set MTU:-> %v", err)
-	}
-	return nil
+	return s.backend.SetMTU(ifname, mtu)
 }
 
 func (s *WireGuardService) GetPeerStats(interfaceName string) (map[string]*models.WGState, error) {
-	output, err := utils.RunCommandWithOutput("wg", "show", interfaceName, "dump")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get peer stats:-> %v", err)
-	}
-
-	stats := make(map[string]*models.WGState)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Split(line, "\t")
-		if len(parts) < 8 {
-			continue
-		}
-
-		publicKey := parts[0]
-		if publicKey == "" {
-			continue
-		}
-
-		state := &models.WGState{}
-
-		// Parse endpoint
-		if parts[2] != "(none)" && parts[2] != "" {
-			endpoint := parts[2]
-			state.Endpoint = &endpoint
-		}
-
-		// Parse latest handshake
-		if parts[4] != "0" && parts[4] != "" {
-			if timestamp := parseUnixTimestamp(parts[4]); timestamp != nil {
-				state.LatestHandshake = timestamp
-			}
-		}
-
-		// Parse transfer stats
-		if parts[5] != "0" && parts[5] != "" {
-			if rx := parseInt64(parts[5]); rx != nil {
-				state.TransferRx = rx
-			}
-		}
-
-		if parts[6] != "0" && parts[6] != "" {
-			if tx := parseInt64(parts[6]); tx != nil {
-				state.TransferTx = tx
-			}
-		}
-
-		stats[publicKey] = state
-	}
-
-	return stats, nil
+	return s.backend.GetPeerStats(interfaceName)
 }
 
 func parseUnixTimestamp(ts string) *time.Time {
@@ -316,8 +348,7 @@ func parseInt64(s string) *int64 {
 // isTargetWgInterface checks if the given interface is a WireGuard interface
 // If key is provided, also checks if the public key matches
 func (s *WireGuardService) isTargetWgInterface(ifname string, key string) bool {
-	// First check if it's a WireGuard interface
-	err := utils.RunCommand("wg", "show", ifname)
+	currentPubKey, err := s.backend.PublicKey(ifname)
 	if err != nil {
 		return false
 	}
@@ -327,13 +358,6 @@ func (s *WireGuardService) isTargetWgInterface(ifname string, key string) bool {
 		return true
 	}
 
-	// Get the interface's public key and compare
-	output, err := utils.RunCommandWithOutput("wg", "show", ifname, "public-key")
-	if err != nil {
-		return false
-	}
-
-	currentPubKey := strings.TrimSpace(output)
 	return currentPubKey == key
 }
 
@@ -357,16 +381,12 @@ func (s *WireGuardService) generatePostUpCommands(iface *models.Interface, ifnam
 
 		// IPv4 firewall rules
 		if server.IPv4 != nil && server.IPv4.Enabled {
-			for _, innerSlice := range utils.GenerateServerFirewallRules(ifacename, iface.VRFName, server.IPv4, 4) {
-				commands = append(commands, utils.ShellquoteJoin(innerSlice...))
-			}
+			commands = append(commands, s.fw.PostUpCommands(utils.ServerFirewallRules(ifacename, iface.VRFName, server.IPv4, 4))...)
 		}
 
 		// IPv6 firewall rules
 		if server.IPv6 != nil && server.IPv6.Enabled {
-			for _, innerSlice := range utils.GenerateServerFirewallRules(ifacename, iface.VRFName, server.IPv6, 6) {
-				commands = append(commands, utils.ShellquoteJoin(innerSlice...))
-			}
+			commands = append(commands, s.fw.PostUpCommands(utils.ServerFirewallRules(ifacename, iface.VRFName, server.IPv6, 6))...)
 		}
 	}
 
@@ -383,12 +403,12 @@ func (s *WireGuardService) generatePreDownCommands(iface *models.Interface) (com
 
 		// Remove IPv4 firewall rules
 		if server.IPv4 != nil && server.IPv4.Enabled && server.IPv4.CommentString != "" {
-			commands = append(commands, utils.GenerateCleanupRules(server.IPv4.CommentString, 4)...)
+			commands = append(commands, s.fw.PreDownCommands(server.IPv4.CommentString, 4)...)
 		}
 
 		// Remove IPv6 firewall rules
 		if server.IPv6 != nil && server.IPv6.Enabled && server.IPv6.CommentString != "" {
-			commands = append(commands, utils.GenerateCleanupRules(server.IPv6.CommentString, 6)...)
+			commands = append(commands, s.fw.PreDownCommands(server.IPv6.CommentString, 6)...)
 		}
 	}
 
@@ -399,27 +419,3 @@ func (s *WireGuardService) generatePreDownCommands(iface *models.Interface) (com
 	// Create a script that executes all commands (ignore errors on cleanup)
 	return
 }
-
-// stripWgQuickConfig executes wg-quick strip and returns the stripped configuration
-func (s *WireGuardService) stripWgQuickConfig(configFile string) (string, error) {
-	output, err := utils.RunCommandWithOutput("wg-quick", "strip", configFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to strip config file %s:-> %v", configFile, err)
-	}
-	return output, nil
-}
-
-// syncConfToInterface applies the stripped configuration to the interface using wg syncconf
-func (s *WireGuardService) syncConfToInterface(interfaceName, strippedConfig string) error {
-	cmd := exec.Command("wg", "syncconf", interfaceName, "/dev/stdin")
-	cmd.Stdin = strings.NewReader(strippedConfig)
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to sync config to interface %s:-> %v, stderr: %s", interfaceName, err, stderr.String())
-	}
-
-	return nil
-}
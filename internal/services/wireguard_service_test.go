@@ -0,0 +1,52 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"wg-panel/internal/models"
+	"wg-panel/internal/utils"
+)
+
+// fixedThrottler throttles every client to the same rate
+type fixedThrottler int
+
+func (t fixedThrottler) ThrottleKbit(string, string, *models.Client) int { return int(t) }
+
+func TestWireGuardService_RateLimits(t *testing.T) {
+	network, _ := models.ParseCIDRAf(4, "10.0.0.0/24")
+	offset := func(last byte) models.IPWrapper { return models.IPWrapper{0, 0, 0, last} }
+	unlimited, capped := 0, 2000
+	server := &models.Server{
+		ID: "s1", Enabled: true, RateLimitUp: 1000, RateLimitDown: 5000,
+		IPv4: &models.ServerNetworkConfig{Enabled: true, Network: network},
+		Clients: []*models.Client{
+			{ID: "c2", Enabled: true, IPv4Offset: offset(2)},
+			{ID: "c3", Enabled: true, IPv4Offset: offset(3), RateLimitDown: &capped},
+			{ID: "c4", Enabled: true, IPv4Offset: offset(4), RateLimitUp: &unlimited, RateLimitDown: &unlimited},
+			{ID: "c5", Enabled: false, IPv4Offset: offset(5)},
+		},
+	}
+	iface := &models.Interface{ID: "if1", Servers: []*models.Server{server}}
+	wg := NewWireGuardService(t.TempDir(), &statsBackend{}, nil)
+
+	// The clients inherit the limits of the server, disabled clients have none
+	want := []utils.RateLimit{
+		{Addresses: []string{"10.0.0.2/32"}, UploadKbit: 1000, DownloadKbit: 5000},
+		{Addresses: []string{"10.0.0.3/32"}, UploadKbit: 1000, DownloadKbit: 2000},
+	}
+	if got := wg.RateLimits(iface); !reflect.DeepEqual(got, want) {
+		t.Errorf("RateLimits() = %+v, want %+v", got, want)
+	}
+
+	// A throttle only lowers the limits
+	wg.SetThrottler(fixedThrottler(1500))
+	want = []utils.RateLimit{
+		{Addresses: []string{"10.0.0.2/32"}, UploadKbit: 1000, DownloadKbit: 1500},
+		{Addresses: []string{"10.0.0.3/32"}, UploadKbit: 1000, DownloadKbit: 1500},
+		{Addresses: []string{"10.0.0.4/32"}, UploadKbit: 1500, DownloadKbit: 1500},
+	}
+	if got := wg.RateLimits(iface); !reflect.DeepEqual(got, want) {
+		t.Errorf("RateLimits() with a throttle = %+v, want %+v", got, want)
+	}
+}
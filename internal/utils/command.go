@@ -3,10 +3,12 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 	"time"
 	"wg-panel/internal/logging"
+	"wg-panel/internal/metrics"
 )
 
 // CommandError represents a detailed command execution error
@@ -61,9 +63,19 @@ func RunCommand(name string, args ...string) error {
 
 // RunCommandWithOutput executes a command and returns both output and detailed errors
 func RunCommandWithOutput(name string, args ...string) (string, error) {
+	return runCommand(nil, name, args...)
+}
+
+// RunCommandWithInput executes a command with input written to its stdin
+func RunCommandWithInput(input string, name string, args ...string) (string, error) {
+	return runCommand(strings.NewReader(input), name, args...)
+}
+
+func runCommand(stdin io.Reader, name string, args ...string) (string, error) {
 	start := time.Now()
 
 	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -93,6 +105,7 @@ func RunCommandWithOutput(name string, args ...string) (string, error) {
 			cmdErr.ExitCode = -1
 		}
 
+		metrics.CommandFailures.Inc(name)
 		return stdoutStr, cmdErr
 	}
 
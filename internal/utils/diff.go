@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"strings"
+)
+
+// DiffLines returns a line diff of two texts. Removed lines start with "- ",
+// added lines with "+ " and unchanged lines with "  ". Only unchanged lines
+// within context lines of a change are kept, skipped lines are replaced by "...".
+// An empty string is returned when the texts are equal.
+func DiffLines(before, after string, context int) string {
+	if before == after {
+		return ""
+	}
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// Longest common subsequence table, lcs[i][j] covers a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+
+	// Keep the unchanged lines close to a change
+	keep := make([]bool, len(lines))
+	for n, line := range lines {
+		if strings.HasPrefix(line, "  ") {
+			continue
+		}
+		for k := n - context; k <= n+context; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+	var buf strings.Builder
+	skipped := false
+	for n, line := range lines {
+		if !keep[n] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			buf.WriteString("...\n")
+			skipped = false
+		}
+		buf.WriteString(line + "\n")
+	}
+	if skipped {
+		buf.WriteString("...\n")
+	}
+	return buf.String()
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,21 @@
+package utils
+
+import "testing"
+
+func TestDiffLines(t *testing.T) {
+	before := "[Interface]\nPrivateKey = a\nListenPort = 51820\n\n[Peer]\nPublicKey = b\n"
+	after := "[Interface]\nPrivateKey = a\nListenPort = 51821\n\n[Peer]\nPublicKey = b\n"
+
+	expected := "  PrivateKey = a\n- ListenPort = 51820\n+ ListenPort = 51821\n  \n...\n"
+	if got := DiffLines(before, after, 1); got != "...\n"+expected {
+		t.Errorf("DiffLines() = %q, want %q", got, "...\n"+expected)
+	}
+
+	if got := DiffLines(before, before, 1); got != "" {
+		t.Errorf("Expected no diff for equal texts, got %q", got)
+	}
+
+	if got := DiffLines("", "a\n", 3); got != "+ a\n" {
+		t.Errorf("DiffLines() = %q, want %q", got, "+ a\n")
+	}
+}
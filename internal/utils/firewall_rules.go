@@ -8,41 +8,134 @@ import (
 	"wg-panel/internal/models"
 )
 
-func GenerateServerFirewallRules(interfaceName string, vrf *string, config *models.ServerNetworkConfig, version int) [][]string {
+// FirewallRule is a backend independent description of a rule generated for a server network.
+// It is rendered to iptables arguments by IptablesArgs and to an nft rule by NftRule.
+type FirewallRule struct {
+	Version        int
+	Table          string // "nat" or "filter"
+	Chain          string // "POSTROUTING", "PREROUTING" or "FORWARD"
+	InInterface    string
+	OutInterface   string
+	Source         string
+	Destination    string
+	NotDestination string
+	Target         string // "MASQUERADE", "SNAT", "NETMAP", "ACCEPT" or "REJECT"
+	To             string // SNAT source address or NETMAP network
+	Comment        string
+}
+
+// IptablesArgs renders the rule as a full iptables/ip6tables command line appending the rule
+func (r FirewallRule) IptablesArgs() []string {
+	args := []string{If(r.Version == 6, "ip6tables", "iptables")}
+	if r.Table != "filter" {
+		args = append(args, "-t", r.Table)
+	}
+	return append(args, r.IptablesRuleSpec()...)
+}
+
+// IptablesRuleSpec renders the rule as an "-A <chain> ..." line of an iptables-restore table section
+func (r FirewallRule) IptablesRuleSpec() []string {
+	args := []string{"-A", r.Chain}
+	args = append(args, r.matchArgs()...)
+	args = append(args, "-j", r.Target)
+	switch r.Target {
+	case "SNAT":
+		args = append(args, "--to-source", r.To)
+	case "NETMAP":
+		args = append(args, "--to", r.To)
+	}
+	return append(args, "-m", "comment", "--comment", r.Comment)
+}
+
+func (r FirewallRule) matchArgs() (args []string) {
+	if r.InInterface != "" {
+		args = append(args, "-i", r.InInterface)
+	}
+	if r.Source != "" {
+		args = append(args, "-s", r.Source)
+	}
+	if r.Destination != "" {
+		args = append(args, "-d", r.Destination)
+	}
+	if r.NotDestination != "" {
+		args = append(args, "!", "-d", r.NotDestination)
+	}
+	if r.OutInterface != "" {
+		args = append(args, "-o", r.OutInterface)
+	}
+	return
+}
+
+// NftRule renders the rule as an nft rule expression for a table of the inet family
+func (r FirewallRule) NftRule() string {
+	family := If(r.Version == 6, "ip6", "ip")
+	var parts []string
+	if r.InInterface != "" {
+		parts = append(parts, fmt.Sprintf("iifname %q", r.InInterface))
+	}
+	if r.OutInterface != "" {
+		parts = append(parts, fmt.Sprintf("oifname %q", r.OutInterface))
+	}
+	if r.Source != "" {
+		parts = append(parts, family+" saddr "+r.Source)
+	}
+	if r.Destination != "" {
+		parts = append(parts, family+" daddr "+r.Destination)
+	}
+	if r.NotDestination != "" {
+		parts = append(parts, family+" daddr != "+r.NotDestination)
+	}
+	switch r.Target {
+	case "MASQUERADE":
+		parts = append(parts, "masquerade")
+	case "SNAT":
+		parts = append(parts, "snat "+family+" to "+r.To)
+	case "NETMAP":
+		// NETMAP keeps the host part, which nft expresses as a prefix mapping
+		parts = append(parts, If(r.Chain == "PREROUTING", "dnat ", "snat ")+family+" prefix to "+r.To)
+	case "ACCEPT":
+		parts = append(parts, "accept")
+	case "REJECT":
+		parts = append(parts, "reject")
+	}
+	parts = append(parts, fmt.Sprintf("comment %q", r.Comment))
+	return strings.Join(parts, " ")
+}
+
+// ServerFirewallRules returns the SNAT and routed network rules of a server network.
+// SNAT rules of roaming servers are left out, they are managed by the roaming service.
+func ServerFirewallRules(interfaceName string, vrf *string, config *models.ServerNetworkConfig, version int) []FirewallRule {
 	if config == nil || !config.Enabled {
-		return [][]string{}
+		return []FirewallRule{}
 	}
 
-	var rules [][]string
+	var rules []FirewallRule
 	comment := config.CommentString
-	iptablesCmd := "iptables"
-	if version == 6 {
-		iptablesCmd = "ip6tables"
-	}
 
 	// Add SNAT rules
 	if config.Snat != nil && config.Snat.Enabled {
 		if config.Snat.RoamingMasterInterface != nil && *config.Snat.RoamingMasterInterface != "" {
 			// If roaming is enabled, SNAT rules must managed by the roaming service
 		} else {
-			rules = append(rules, GenerateSNATRules(iptablesCmd, vrf, config, comment)...)
+			rules = append(rules, SNATRules(vrf, config, comment)...)
 		}
 	}
 
 	// Add routed networks firewall rules
 	if config.RoutedNetworksFirewall && len(config.RoutedNetworks) > 0 {
-		rules = append(rules, GenerateRoutedNetworksRules(iptablesCmd, interfaceName, config, comment)...)
+		rules = append(rules, RoutedNetworksRules(interfaceName, config, comment)...)
 	}
 
 	return rules
 }
 
-func GenerateSNATRules(iptablesCmd string, vrf *string, config *models.ServerNetworkConfig, comment string) [][]string {
+func SNATRules(vrf *string, config *models.ServerNetworkConfig, comment string) []FirewallRule {
 	if config.Network == nil || config.Snat == nil {
-		return [][]string{}
+		return []FirewallRule{}
 	}
 
-	var rules [][]string
+	var rules []FirewallRule
+	version := config.Network.Version
 	sourceNet := config.Network.NetworkStr()
 	excludedNet := sourceNet
 	if config.Snat.SnatExcludedNetwork != nil {
@@ -53,82 +146,79 @@ func GenerateSNATRules(iptablesCmd string, vrf *string, config *models.ServerNet
 		}
 	}
 
-	// ✅ slices instead of strings
-	var destExclusion []string
-	if excludedNet != "" {
-		destExclusion = []string{"!", "-d", excludedNet}
-	}
-
-	var vrfi []string
-	if vrf != nil && *vrf != "" {
-		vrfi = []string{"-i", *vrf}
-	}
-	var vrfo []string
-	if vrf != nil && *vrf != "" {
-		vrfo = []string{"-o", *vrf}
+	vrfName := ""
+	if vrf != nil {
+		vrfName = *vrf
 	}
 
 	if config.Snat.RoamingMasterInterface != nil && *config.Snat.RoamingMasterInterface != "" {
 		// If roaming is enabled, SNAT rules must be managed by the roaming service
-		return [][]string{}
+		return []FirewallRule{}
+	}
+
+	postrouting := FirewallRule{
+		Version:        version,
+		Table:          "nat",
+		Chain:          "POSTROUTING",
+		Source:         sourceNet,
+		NotDestination: excludedNet,
+		OutInterface:   vrfName,
+		Comment:        comment,
 	}
 
 	if config.Snat.SnatIPNet == nil {
 		// MASQUERADE mode
-		rule := []string{iptablesCmd, "-t", "nat", "-A", "POSTROUTING", "-s", sourceNet}
-		rule = append(rule, destExclusion...)
-		rule = append(rule, vrfo...)
-		rule = append(rule, "-j", "MASQUERADE", "-m", "comment", "--comment", comment)
-		rules = append(rules, rule)
+		postrouting.Target = "MASQUERADE"
+		rules = append(rules, postrouting)
 
 	} else if config.Network.Version == 4 {
 		if config.Snat.SnatIPNet.Masklen() != 32 {
-			return [][]string{}
+			return []FirewallRule{}
 		} else if config.Snat.SnatIPNet.EqualZero(4) {
-			return [][]string{}
+			return []FirewallRule{}
 		}
-		rule := []string{iptablesCmd, "-t", "nat", "-A", "POSTROUTING", "-s", sourceNet}
-		rule = append(rule, destExclusion...)
-		rule = append(rule, vrfo...)
-		rule = append(rule, "-j", "SNAT", "--to-source", config.Snat.SnatIPNet.IP.String(), "-m", "comment", "--comment", comment)
-		rules = append(rules, rule)
+		postrouting.Target = "SNAT"
+		postrouting.To = config.Snat.SnatIPNet.IP.String()
+		rules = append(rules, postrouting)
 
 	} else if config.Network.Version == 6 && config.Snat.SnatIPNet.Masklen() == 128 {
 		if config.Snat.SnatIPNet.EqualZero(6) {
-			return [][]string{}
+			return []FirewallRule{}
 		}
-		rule := []string{iptablesCmd, "-t", "nat", "-A", "POSTROUTING", "-s", sourceNet}
-		rule = append(rule, destExclusion...)
-		rule = append(rule, vrfo...)
-		rule = append(rule, "-j", "SNAT", "--to-source", config.Snat.SnatIPNet.IP.String(), "-m", "comment", "--comment", comment)
-		rules = append(rules, rule)
+		postrouting.Target = "SNAT"
+		postrouting.To = config.Snat.SnatIPNet.IP.String()
+		rules = append(rules, postrouting)
 
 	} else {
 		// IPv6 NETMAP mode
 		serverNetwork := config.Network.NetworkStr()
 		targetNetwork := config.Snat.SnatIPNet.NetworkStr()
 
-		rule1 := []string{iptablesCmd, "-t", "nat", "-A", "POSTROUTING", "-s", serverNetwork}
-		rule1 = append(rule1, destExclusion...)
-		rule1 = append(rule1, vrfo...)
-		rule1 = append(rule1, "-j", "NETMAP", "--to", targetNetwork, "-m", "comment", "--comment", comment)
-		rules = append(rules, rule1)
-
-		rule2 := []string{iptablesCmd, "-t", "nat", "-A", "PREROUTING", "-d", targetNetwork}
-		rule2 = append(rule2, vrfi...)
-		rule2 = append(rule2, "-j", "NETMAP", "--to", serverNetwork, "-m", "comment", "--comment", comment)
-		rules = append(rules, rule2)
+		postrouting.Target = "NETMAP"
+		postrouting.To = targetNetwork
+		rules = append(rules, postrouting)
+
+		rules = append(rules, FirewallRule{
+			Version:     version,
+			Table:       "nat",
+			Chain:       "PREROUTING",
+			Destination: targetNetwork,
+			InInterface: vrfName,
+			Target:      "NETMAP",
+			To:          serverNetwork,
+			Comment:     comment,
+		})
 	}
 
 	return rules
 }
 
-func GenerateRoutedNetworksRules(iptablesCmd string, ifname string, config *models.ServerNetworkConfig, comment string) [][]string {
+func RoutedNetworksRules(ifname string, config *models.ServerNetworkConfig, comment string) []FirewallRule {
 	if config.Network == nil || len(config.RoutedNetworks) == 0 {
-		return [][]string{}
+		return []FirewallRule{}
 	}
 
-	var rules [][]string
+	var rules []FirewallRule
 	sourceNet := config.Network.NetworkStr()
 
 	// Check if we have an "allow all" network
@@ -141,19 +231,46 @@ func GenerateRoutedNetworksRules(iptablesCmd string, ifname string, config *mode
 		}
 	}
 
+	forward := FirewallRule{
+		Version:     config.Network.Version,
+		Table:       "filter",
+		Chain:       "FORWARD",
+		InInterface: ifname,
+		Source:      sourceNet,
+		Comment:     comment,
+	}
+
 	// Add specific allow rules for each routed network
 	for _, routedNet := range config.RoutedNetworks {
-		rules = append(rules, []string{iptablesCmd, "-A", "FORWARD", "-i", ifname, "-s", sourceNet, "-d", routedNet.NetworkStr(), "-j", "ACCEPT", "-m", "comment", "--comment", comment})
+		rule := forward
+		rule.Destination = routedNet.NetworkStr()
+		rule.Target = "ACCEPT"
+		rules = append(rules, rule)
 	}
 
 	// Add deny rule for other destinations if no allow-all
 	if !hasAllowAll {
-		rules = append(rules, []string{iptablesCmd, "-A", "FORWARD", "-i", ifname, "-s", sourceNet, "-j", "REJECT", "-m", "comment", "--comment", comment})
+		rule := forward
+		rule.Target = "REJECT"
+		rules = append(rules, rule)
 	}
 
 	return rules
 }
 
+// GenerateRestoreRules returns a shell command appending the rules of one server network
+// in one iptables-restore transaction
+func GenerateRestoreRules(rules []FirewallRule) []string {
+	if len(rules) == 0 {
+		return nil
+	}
+	iptablesCmd := If(rules[0].Version == 6, "ip6tables", "iptables")
+	lines := strings.Split(strings.TrimSuffix(restorePayload(nil, rules), "\n"), "\n")
+	return []string{ShellquoteJoin(append([]string{"printf", `%s\n`}, lines...)...) + " | " + iptablesCmd + "-restore --noflush"}
+}
+
+// GenerateCleanupRules returns a shell command removing all rules tagged with comment in one iptables-restore transaction.
+// The comment must be followed by a space or end the line, like in parseSavedRules, so other comments starting with it are kept.
 func GenerateCleanupRules(comment string, version int) []string {
 	iptablesCmd := "iptables"
 	if version == 6 {
@@ -162,49 +279,35 @@ func GenerateCleanupRules(comment string, version int) []string {
 
 	return []string{
 		fmt.Sprintf(
-			`%s-save | awk -v c="-m comment --comment %s" '/^\*/{t=substr($1,2);next} c && index($0,c){sub(/^-A /,"",$0);system("%s -t " t " -D " $0)}'`,
+			`%s-save | awk -v c="-m comment --comment %s " '/^\*/||/^COMMIT/{print;next} /^-A /&&index($0" ",c){sub(/^-A /,"-D ");print}' | %s-restore --noflush`,
 			iptablesCmd, comment, iptablesCmd,
 		),
 	}
 }
 
-func CleanupRules(comment string, version int, targetTable *[]string, matchPrefix bool) error {
-	if comment == "" {
-		return fmt.Errorf("cleanFirewallRuleByComment: comment can't be empty")
-	}
-
-	logging.LogInfo("Cleaning up firewall rules with comment: %s (version: %d)", comment, version)
-	if version == 46 {
-		err4 := CleanupRules(comment, 4, targetTable, matchPrefix)
-		err6 := CleanupRules(comment, 6, targetTable, matchPrefix)
-		if err4 != nil && err6 != nil {
-			return fmt.Errorf("err4:-> %v, err6:-> %v", err4, err6)
-		} else if err4 != nil {
-			return err4
-		} else if err6 != nil {
-			return err6
-		}
-	}
-	iptablesCmd := "iptables"
-	if version == 6 {
-		iptablesCmd = "ip6tables"
-	}
-	currentRules, err := RunCommandWithOutput(fmt.Sprintf("%s-save", iptablesCmd))
-	if err != nil {
-		return err
-	}
+// savedRule is a rule line of iptables-save output
+type savedRule struct {
+	table string
+	chain string
+	spec  string // rule line without the leading "-A "
+}
 
-	var commands [][]string
+// parseSavedRules returns the rules of iptables-save output tagged with comment.
+// Only rules of the given tables are returned when tables is not empty.
+// With matchPrefix, every comment starting with comment matches.
+func parseSavedRules(saveOutput string, comment string, tables []string, matchPrefix bool) []savedRule {
+	var rules []savedRule
 	currentTable := ""
-	for _, rule := range strings.Split(currentRules, "\n") {
+	for _, rule := range strings.Split(saveOutput, "\n") {
 		if len(rule) > 1 && rule[0] == '*' {
 			currentTable = rule[1:]
 			continue
 		}
-		if targetTable != nil && len(*targetTable) > 0 {
-			if !stringInSlice(currentTable, *targetTable) {
-				continue
-			}
+		if !strings.HasPrefix(rule, "-A ") {
+			continue
+		}
+		if len(tables) > 0 && !stringInSlice(currentTable, tables) {
+			continue
 		}
 		match := false
 		if matchPrefix {
@@ -220,24 +323,140 @@ func CleanupRules(comment string, version int, targetTable *[]string, matchPrefi
 			}
 		}
 		if match {
-			args := []string{"-t", currentTable, "-D"}
-			args = append(args, strings.Fields(rule[3:])...)
-			commands = append(commands, args)
+			rules = append(rules, savedRule{
+				table: currentTable,
+				chain: strings.Fields(rule)[1],
+				spec:  rule[3:],
+			})
 		}
 	}
+	return rules
+}
 
-	for _, arg := range commands {
-		logging.LogInfo("Removing firewall rule: %s %s", iptablesCmd, strings.Join(arg, " "))
-		_, err = RunCommandWithOutput(iptablesCmd, arg...)
+// restorePayload renders an iptables-restore --noflush input deleting the saved rules and appending rules.
+// Tables are written in a stable order, deletions of a table come before its additions.
+func restorePayload(deletes []savedRule, adds []FirewallRule) string {
+	var tables []string
+	lines := make(map[string][]string)
+	addLine := func(table string, line string) {
+		if _, ok := lines[table]; !ok {
+			tables = append(tables, table)
+		}
+		lines[table] = append(lines[table], line)
+	}
+	for _, rule := range deletes {
+		addLine(rule.table, "-D "+rule.spec)
+	}
+	for _, rule := range adds {
+		addLine(rule.Table, strings.Join(rule.IptablesRuleSpec(), " "))
 	}
 
-	if len(commands) > 0 {
-		logging.LogInfo("Cleaned up %d firewall rules with comment: %s", len(commands), comment)
-	} else {
+	var buf strings.Builder
+	for _, table := range tables {
+		buf.WriteString("*" + table + "\n")
+		for _, line := range lines[table] {
+			buf.WriteString(line + "\n")
+		}
+		buf.WriteString("COMMIT\n")
+	}
+	return buf.String()
+}
+
+// replacePayload renders an iptables-restore --noflush input replacing the rules tagged with comment
+// in the tables used by rules. Applying it again gives the same result, the rules are never duplicated.
+func replacePayload(saveOutput string, comment string, rules []FirewallRule) string {
+	var tables []string
+	for _, rule := range rules {
+		if !stringInSlice(rule.Table, tables) {
+			tables = append(tables, rule.Table)
+		}
+	}
+	return restorePayload(parseSavedRules(saveOutput, comment, tables, false), rules)
+}
+
+func iptablesRestore(iptablesCmd string, payload string) error {
+	if _, err := RunCommandWithInput(payload, iptablesCmd+"-restore", "--noflush"); err != nil {
+		return fmt.Errorf("failed to apply rules with %s-restore:-> %v", iptablesCmd, err)
+	}
+	return nil
+}
+
+// ReplaceRules atomically replaces the rules tagged with comment in the tables used by rules
+func ReplaceRules(comment string, version int, rules []FirewallRule) error {
+	if comment == "" {
+		return fmt.Errorf("ReplaceRules: comment can't be empty")
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	iptablesCmd := If(version == 6, "ip6tables", "iptables")
+	currentRules, err := RunCommandWithOutput(iptablesCmd + "-save")
+	if err != nil {
+		return err
+	}
+
+	payload := replacePayload(currentRules, comment, rules)
+	logging.LogInfo("Applying %d firewall rules with comment %s:\n%s", len(rules), comment, payload)
+	return iptablesRestore(iptablesCmd, payload)
+}
+
+// CountRules returns the number of installed rules tagged with comment in each table
+func CountRules(comment string, version int) (map[string]int, error) {
+	iptablesCmd := If(version == 6, "ip6tables", "iptables")
+	currentRules, err := RunCommandWithOutput(iptablesCmd + "-save")
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, rule := range parseSavedRules(currentRules, comment, nil, false) {
+		counts[rule.table]++
+	}
+	return counts, nil
+}
+
+func CleanupRules(comment string, version int, targetTable *[]string, matchPrefix bool) error {
+	if comment == "" {
+		return fmt.Errorf("cleanFirewallRuleByComment: comment can't be empty")
+	}
+
+	logging.LogInfo("Cleaning up firewall rules with comment: %s (version: %d)", comment, version)
+	if version == 46 {
+		err4 := CleanupRules(comment, 4, targetTable, matchPrefix)
+		err6 := CleanupRules(comment, 6, targetTable, matchPrefix)
+		if err4 != nil && err6 != nil {
+			return fmt.Errorf("err4:-> %v, err6:-> %v", err4, err6)
+		} else if err4 != nil {
+			return err4
+		}
+		return err6
+	}
+	iptablesCmd := "iptables"
+	if version == 6 {
+		iptablesCmd = "ip6tables"
+	}
+	currentRules, err := RunCommandWithOutput(fmt.Sprintf("%s-save", iptablesCmd))
+	if err != nil {
+		return err
+	}
+
+	var tables []string
+	if targetTable != nil {
+		tables = *targetTable
+	}
+	matched := parseSavedRules(currentRules, comment, tables, matchPrefix)
+	if len(matched) == 0 {
 		logging.LogInfo("No firewall rules found to clean up with comment: %s", comment)
+		return nil
 	}
 
-	return err
+	for _, rule := range matched {
+		logging.LogInfo("Removing firewall rule: %s -t %s -D %s", iptablesCmd, rule.table, rule.spec)
+	}
+	if err := iptablesRestore(iptablesCmd, restorePayload(matched, nil)); err != nil {
+		return err
+	}
+	logging.LogInfo("Cleaned up %d firewall rules with comment: %s", len(matched), comment)
+	return nil
 }
 
 func stringInSlice(target string, slice []string) bool {
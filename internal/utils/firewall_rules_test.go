@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFirewallRule_Render(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rule     FirewallRule
+		iptables string
+		nft      string
+	}{
+		{
+			name:     "IPv4 masquerade",
+			rule:     FirewallRule{Version: 4, Table: "nat", Chain: "POSTROUTING", Source: "10.0.0.0/24", NotDestination: "10.0.0.0/24", Target: "MASQUERADE", Comment: "abc-v4"},
+			iptables: "iptables -t nat -A POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -j MASQUERADE -m comment --comment abc-v4",
+			nft:      `ip saddr 10.0.0.0/24 ip daddr != 10.0.0.0/24 masquerade comment "abc-v4"`,
+		},
+		{
+			name:     "IPv4 SNAT in VRF",
+			rule:     FirewallRule{Version: 4, Table: "nat", Chain: "POSTROUTING", Source: "10.0.0.0/24", OutInterface: "vrf-a", Target: "SNAT", To: "192.0.2.1", Comment: "abc-v4"},
+			iptables: "iptables -t nat -A POSTROUTING -s 10.0.0.0/24 -o vrf-a -j SNAT --to-source 192.0.2.1 -m comment --comment abc-v4",
+			nft:      `oifname "vrf-a" ip saddr 10.0.0.0/24 snat ip to 192.0.2.1 comment "abc-v4"`,
+		},
+		{
+			name:     "IPv6 NETMAP prerouting",
+			rule:     FirewallRule{Version: 6, Table: "nat", Chain: "PREROUTING", Destination: "2001:db8:1::/64", Target: "NETMAP", To: "fd00::/64", Comment: "abc-v6"},
+			iptables: "ip6tables -t nat -A PREROUTING -d 2001:db8:1::/64 -j NETMAP --to fd00::/64 -m comment --comment abc-v6",
+			nft:      `ip6 daddr 2001:db8:1::/64 dnat ip6 prefix to fd00::/64 comment "abc-v6"`,
+		},
+		{
+			name:     "Routed network reject",
+			rule:     FirewallRule{Version: 4, Table: "filter", Chain: "FORWARD", InInterface: "wg-a", Source: "10.0.0.0/24", Target: "REJECT", Comment: "abc-v4"},
+			iptables: "iptables -A FORWARD -i wg-a -s 10.0.0.0/24 -j REJECT -m comment --comment abc-v4",
+			nft:      `iifname "wg-a" ip saddr 10.0.0.0/24 reject comment "abc-v4"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := strings.Join(tc.rule.IptablesArgs(), " "); got != tc.iptables {
+				t.Errorf("IptablesArgs() = %q, want %q", got, tc.iptables)
+			}
+			if got := tc.rule.NftRule(); got != tc.nft {
+				t.Errorf("NftRule() = %q, want %q", got, tc.nft)
+			}
+		})
+	}
+}
+
+const testSaveOutput = `# Generated by iptables-save
+*nat
+:PREROUTING ACCEPT [0:0]
+:POSTROUTING ACCEPT [0:0]
+-A POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -m comment --comment abc-v4 -j MASQUERADE
+-A POSTROUTING -s 10.1.0.0/24 -m comment --comment abc-v4x -j MASQUERADE
+COMMIT
+*filter
+:FORWARD ACCEPT [0:0]
+-A FORWARD -s 10.0.0.0/24 -i wg-a -m comment --comment abc-v4 -j REJECT --reject-with icmp-port-unreachable
+COMMIT
+`
+
+func TestReplacePayload(t *testing.T) {
+	rules := []FirewallRule{
+		{Version: 4, Table: "nat", Chain: "POSTROUTING", Source: "10.0.0.0/24", NotDestination: "10.0.0.0/24", Target: "MASQUERADE", Comment: "abc-v4"},
+	}
+
+	// Only rules with the exact comment in the tables used by rules are replaced
+	expected := "*nat\n" +
+		"-D POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -m comment --comment abc-v4 -j MASQUERADE\n" +
+		"-A POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -j MASQUERADE -m comment --comment abc-v4\n" +
+		"COMMIT\n"
+	if got := replacePayload(testSaveOutput, "abc-v4", rules); got != expected {
+		t.Errorf("replacePayload() = %q, want %q", got, expected)
+	}
+
+	// Nothing installed yet, the rules are only appended
+	expected = "*nat\n" +
+		"-A POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -j MASQUERADE -m comment --comment abc-v4\n" +
+		"COMMIT\n"
+	if got := replacePayload("", "abc-v4", rules); got != expected {
+		t.Errorf("replacePayload() = %q, want %q", got, expected)
+	}
+}
+
+func TestCleanupPayload(t *testing.T) {
+	expected := "*nat\n" +
+		"-D POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -m comment --comment abc-v4 -j MASQUERADE\n" +
+		"COMMIT\n" +
+		"*filter\n" +
+		"-D FORWARD -s 10.0.0.0/24 -i wg-a -m comment --comment abc-v4 -j REJECT --reject-with icmp-port-unreachable\n" +
+		"COMMIT\n"
+	if got := restorePayload(parseSavedRules(testSaveOutput, "abc-v4", nil, false), nil); got != expected {
+		t.Errorf("restorePayload() = %q, want %q", got, expected)
+	}
+
+	// Prefix matching also removes the rules of other comments starting with the prefix
+	if got := parseSavedRules(testSaveOutput, "abc", nil, true); len(got) != 3 {
+		t.Errorf("Expected 3 rules matching prefix, got %d", len(got))
+	}
+
+	if got := parseSavedRules(testSaveOutput, "abc-v4", []string{"filter"}, false); len(got) != 1 || got[0].chain != "FORWARD" {
+		t.Errorf("Expected only the FORWARD rule, got %v", got)
+	}
+}
+
+// runWithoutIptables runs a generated wg-quick command with iptables-save reading
+// saveOutput and iptables-restore printing its input
+func runWithoutIptables(t *testing.T, command string, saveOutput string) string {
+	t.Helper()
+	command = strings.ReplaceAll(command, "iptables-save", "cat")
+	command = strings.ReplaceAll(command, "iptables-restore --noflush", "cat")
+	output, err := RunCommandWithInput(saveOutput, "sh", "-c", command)
+	if err != nil {
+		t.Fatalf("Failed to run %s: %v", command, err)
+	}
+	return output
+}
+
+func TestGenerateCleanupRules(t *testing.T) {
+	expected := "*nat\n" +
+		"-D POSTROUTING -s 10.0.0.0/24 ! -d 10.0.0.0/24 -m comment --comment abc-v4 -j MASQUERADE\n" +
+		"COMMIT\n" +
+		"*filter\n" +
+		"-D FORWARD -s 10.0.0.0/24 -i wg-a -m comment --comment abc-v4 -j REJECT --reject-with icmp-port-unreachable\n" +
+		"COMMIT\n"
+	commands := GenerateCleanupRules("abc-v4", 4)
+	if len(commands) != 1 {
+		t.Fatalf("Expected one command, got %v", commands)
+	}
+	if got := runWithoutIptables(t, commands[0], testSaveOutput); got != expected {
+		t.Errorf("Cleanup gave %q, want %q", got, expected)
+	}
+}
+
+func TestGenerateRestoreRules(t *testing.T) {
+	rules := []FirewallRule{
+		{Version: 4, Table: "nat", Chain: "POSTROUTING", Source: "10.0.0.0/24", NotDestination: "10.0.0.0/24", Target: "MASQUERADE", Comment: "abc-v4"},
+		{Version: 4, Table: "filter", Chain: "FORWARD", InInterface: "%i", Source: "10.0.0.0/24", Target: "REJECT", Comment: "abc-v4"},
+	}
+	commands := GenerateRestoreRules(rules)
+	if len(commands) != 1 || !strings.HasSuffix(commands[0], "| iptables-restore --noflush") {
+		t.Fatalf("Expected one iptables-restore command, got %v", commands)
+	}
+	if got := runWithoutIptables(t, commands[0], ""); got != restorePayload(nil, rules) {
+		t.Errorf("Restore input %q, want %q", got, restorePayload(nil, rules))
+	}
+}
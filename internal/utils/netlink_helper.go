@@ -128,6 +128,27 @@ func GetInterfaceIPs(ifname string) ([]net.IP, []net.IP, error) {
 	return ipv4s, ipv6s, nil
 }
 
+// GetInterfaceCIDRs returns the addresses of an interface in CIDR notation, like "10.0.0.1/24"
+func GetInterfaceCIDRs(ifname string) ([]string, error) {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %v:-> %w", ifname, err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addrs from %v :-> %w", ifname, err)
+	}
+
+	cidrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.IPNet != nil {
+			cidrs = append(cidrs, addr.IPNet.String())
+		}
+	}
+	return cidrs, nil
+}
+
 // === Sorting helpers ===
 
 func better(a, b netlink.Addr) bool {
@@ -382,6 +403,23 @@ func CleanupWireGuardInterface(ifname string) error {
 	return nil
 }
 
+// DeleteWireGuardLink removes a WireGuard interface through netlink if it exists
+func DeleteWireGuardLink(ifname string) error {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		// Interface doesn't exist, nothing to clean up
+		return nil
+	}
+	if link.Type() != "wireguard" {
+		// Not a WireGuard interface, skip
+		return nil
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %q:-> %w", ifname, err)
+	}
+	return nil
+}
+
 // manualInterfaceCleanup manually removes a WireGuard interface
 func manualInterfaceCleanup(ifname string) error {
 	// Delete the interface
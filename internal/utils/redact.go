@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// secretFields are the JSON fields holding keys, password hashes and TOTP secrets
+var secretFields = []string{"privateKey", "presharedKey", "password", "hash", "secret", "recoveryCodes"}
+
+// RedactSecrets encodes value as indented JSON with the secret fields replaced,
+// so it can be shown in diffs and logs
+func RedactSecrets(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode:-> %v", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("failed to decode:-> %v", err)
+	}
+	redact(generic)
+	return json.MarshalIndent(generic, "", "  ")
+}
+
+func redact(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			for _, secret := range secretFields {
+				if key == secret && field != nil && field != "" {
+					v[key] = "<redacted>"
+				}
+			}
+			redact(v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			redact(item)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// RateLimit limits the traffic of the addresses of a client on a WireGuard interface
+type RateLimit struct {
+	Addresses    []string // CIDRs of the client
+	DownloadKbit int      // Traffic sent to the addresses, 0 is unlimited
+	UploadKbit   int      // Traffic received from the addresses, 0 is unlimited
+}
+
+// IfbName returns the name of the ifb device shaping the uploads of an interface,
+// derived from its name to stay within the 15 characters of an interface name
+func IfbName(ifname string) string {
+	return fmt.Sprintf("ifb%08x", crc32.ChecksumIEEE([]byte(ifname)))
+}
+
+// TrafficControlScript renders the limits as a tc batch. Downloads are shaped by an
+// htb class per limit on the interface. Uploads are redirected from the ingress of
+// the interface to its ifb device, and shaped there the same way.
+func TrafficControlScript(ifname string, limits []RateLimit) string {
+	var downloads, uploads strings.Builder
+	ifb := IfbName(ifname)
+	for i, limit := range limits {
+		classID := fmt.Sprintf("1:%x", i+0x10)
+		if limit.DownloadKbit > 0 {
+			writeShapingClass(&downloads, ifname, classID, "dst", limit.DownloadKbit, limit.Addresses)
+		}
+		if limit.UploadKbit > 0 {
+			writeShapingClass(&uploads, ifb, classID, "src", limit.UploadKbit, limit.Addresses)
+		}
+	}
+
+	var script strings.Builder
+	if downloads.Len() > 0 {
+		fmt.Fprintf(&script, "qdisc add dev %s root handle 1: htb\n", ifname)
+		script.WriteString(downloads.String())
+	}
+	if uploads.Len() > 0 {
+		fmt.Fprintf(&script, "qdisc add dev %s handle ffff: ingress\n", ifname)
+		fmt.Fprintf(&script, "filter add dev %s parent ffff: protocol all prio 1 u32 match u32 0 0 action mirred egress redirect dev %s\n", ifname, ifb)
+		fmt.Fprintf(&script, "qdisc add dev %s root handle 1: htb\n", ifb)
+		script.WriteString(uploads.String())
+	}
+	return script.String()
+}
+
+// writeShapingClass adds an htb class with an fq_codel leaf, and the filters
+// sending the traffic of the addresses to it
+func writeShapingClass(script *strings.Builder, dev, classID, direction string, kbit int, addresses []string) {
+	fmt.Fprintf(script, "class add dev %s parent 1: classid %s htb rate %dkbit\n", dev, classID, kbit)
+	fmt.Fprintf(script, "qdisc add dev %s parent %s fq_codel\n", dev, classID)
+	for _, address := range addresses {
+		if strings.Contains(address, ":") {
+			fmt.Fprintf(script, "filter add dev %s parent 1: protocol ipv6 prio 2 u32 match ip6 %s %s flowid %s\n", dev, direction, address, classID)
+		} else {
+			fmt.Fprintf(script, "filter add dev %s parent 1: protocol ip prio 1 u32 match ip %s %s flowid %s\n", dev, direction, address, classID)
+		}
+	}
+}
+
+// ApplyRateLimits replaces the qdiscs of an interface and of its ifb device with
+// the limits, no limit only removes them
+func ApplyRateLimits(ifname string, limits []RateLimit) error {
+	RemoveRateLimits(ifname)
+
+	script := TrafficControlScript(ifname, limits)
+	if script == "" {
+		return nil
+	}
+	if strings.Contains(script, "ingress") {
+		ifb := IfbName(ifname)
+		if err := RunCommand("ip", "link", "add", "name", ifb, "type", "ifb"); err != nil {
+			return fmt.Errorf("failed to create %s for the rate limits of %s:-> %v", ifb, ifname, err)
+		}
+		if err := RunCommand("ip", "link", "set", "dev", ifb, "up"); err != nil {
+			return fmt.Errorf("failed to bring up %s:-> %v", ifb, err)
+		}
+	}
+	if _, err := RunCommandWithInput(script, "tc", "-batch", "-"); err != nil {
+		return fmt.Errorf("failed to apply rate limits to %s:-> %v", ifname, err)
+	}
+	return nil
+}
+
+// RemoveRateLimits removes the qdiscs of an interface and its ifb device
+func RemoveRateLimits(ifname string) {
+	RunCommandIgnoreError("tc", "qdisc", "del", "dev", ifname, "root")
+	RunCommandIgnoreError("tc", "qdisc", "del", "dev", ifname, "ingress")
+	if IsIfExists(IfbName(ifname)) == nil {
+		RunCommandIgnoreError("ip", "link", "del", "dev", IfbName(ifname))
+	}
+}
+
+// HasRateLimits tells if the qdiscs of ApplyRateLimits are on the interface, they
+// are lost when the interface is recreated
+func HasRateLimits(ifname string) bool {
+	output, err := RunCommandWithOutput("tc", "qdisc", "show", "dev", ifname)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(output, "htb 1:") || strings.Contains(output, "ingress ffff:")
+}
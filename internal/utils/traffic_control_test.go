@@ -0,0 +1,36 @@
+package utils
+
+import "testing"
+
+func TestTrafficControlScript(t *testing.T) {
+	limits := []RateLimit{
+		{Addresses: []string{"10.0.0.2/32", "fd00::2/128"}, DownloadKbit: 1000, UploadKbit: 500},
+		{Addresses: []string{"10.0.0.3/32"}, DownloadKbit: 2000},
+	}
+	ifb := IfbName("wg0")
+	want := "qdisc add dev wg0 root handle 1: htb\n" +
+		"class add dev wg0 parent 1: classid 1:10 htb rate 1000kbit\n" +
+		"qdisc add dev wg0 parent 1:10 fq_codel\n" +
+		"filter add dev wg0 parent 1: protocol ip prio 1 u32 match ip dst 10.0.0.2/32 flowid 1:10\n" +
+		"filter add dev wg0 parent 1: protocol ipv6 prio 2 u32 match ip6 dst fd00::2/128 flowid 1:10\n" +
+		"class add dev wg0 parent 1: classid 1:11 htb rate 2000kbit\n" +
+		"qdisc add dev wg0 parent 1:11 fq_codel\n" +
+		"filter add dev wg0 parent 1: protocol ip prio 1 u32 match ip dst 10.0.0.3/32 flowid 1:11\n" +
+		"qdisc add dev wg0 handle ffff: ingress\n" +
+		"filter add dev wg0 parent ffff: protocol all prio 1 u32 match u32 0 0 action mirred egress redirect dev " + ifb + "\n" +
+		"qdisc add dev " + ifb + " root handle 1: htb\n" +
+		"class add dev " + ifb + " parent 1: classid 1:10 htb rate 500kbit\n" +
+		"qdisc add dev " + ifb + " parent 1:10 fq_codel\n" +
+		"filter add dev " + ifb + " parent 1: protocol ip prio 1 u32 match ip src 10.0.0.2/32 flowid 1:10\n" +
+		"filter add dev " + ifb + " parent 1: protocol ipv6 prio 2 u32 match ip6 src fd00::2/128 flowid 1:10\n"
+	if got := TrafficControlScript("wg0", limits); got != want {
+		t.Errorf("TrafficControlScript() =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := TrafficControlScript("wg0", nil); got != "" {
+		t.Errorf("Expected an empty script without limits, got %q", got)
+	}
+	if name := IfbName("wg-a-very-long1"); len(name) > 15 || name == ifb {
+		t.Errorf("Unexpected ifb name %q", name)
+	}
+}
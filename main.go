@@ -30,6 +30,8 @@ var frontendFS embed.FS
 func main() {
 	var configPath = flag.String("c", "./config.json", "Path to configuration file")
 	var newPassword = flag.String("p", "", "Set new password in configuration file")
+	var resetTwoFactor = flag.String("reset-2fa", "", "Disable two-factor authentication of a user in configuration file")
+	var rotateSecretKey = flag.String("rotate-secret-key", "", "Encrypt the secrets in configuration file with the key in this file, generated if missing")
 	var showVersion = flag.Bool("v", false, "Show version information")
 	var cleanupOnly = flag.Bool("cleanup", false, "Clean up all interfaces and firewall rules created by this app, then exit")
 	flag.Parse()
@@ -57,14 +59,42 @@ func main() {
 		fmt.Printf("Created new configuration file with random password printed above\n")
 	}
 
+	if *resetTwoFactor != "" {
+		if err := cfg.DisableTwoFactor(*resetTwoFactor); err != nil {
+			log.Fatalf("Failed to reset two-factor authentication: %v", err)
+		}
+		if err := cfg.Save(); err != nil {
+			log.Fatalf("Failed to save configuration: %v", err)
+		}
+		fmt.Printf("Two-factor authentication of %s reset successfully\n", *resetTwoFactor)
+	}
+
+	if *rotateSecretKey != "" {
+		if err := rotateSecretKeyFile(cfg, *rotateSecretKey); err != nil {
+			log.Fatalf("Failed to rotate secret key: %v", err)
+		}
+		fmt.Printf("Secrets encrypted with the key in %s\n", *rotateSecretKey)
+		if os.Getenv(config.SecretKeyEnv) != "" {
+			fmt.Printf("Warning: %s is set and takes precedence over the key file, unset it or set it to the new key\n", config.SecretKeyEnv)
+		}
+	}
+
 	if *newPassword != "" {
 		fmt.Printf("Password updated successfully\n")
+	}
+	if *newPassword != "" || *resetTwoFactor != "" || *rotateSecretKey != "" {
 		return
 	}
 
+	firewallBackend, err := internalservice.NewFirewallBackend(cfg.FirewallBackend, cfg.WGPanelId)
+	if err != nil {
+		log.Fatalf("Failed to initialize firewall backend: %v", err)
+	}
+	firewallService := internalservice.NewFirewallService(firewallBackend)
+
 	// Handle cleanup-only mode
 	if *cleanupOnly {
-		performCleanupAndExit(cfg)
+		performCleanupAndExit(cfg, firewallService)
 		return
 	}
 
@@ -75,7 +105,7 @@ func main() {
 	// Perform system checks before starting services
 	fnedmsg := config.ToFrontendMessage{}
 	forward_accept := false
-	if forward_accept, err = performSystemChecks(); err != nil {
+	if forward_accept, err = performSystemChecks(cfg.WireGuardBackend, cfg.FirewallBackend); err != nil {
 		logging.LogError("System check failed: %v", err)
 		fmt.Printf("Warning: %v\n", err)
 		fnedmsg.InitWarningMsg = err.Error()
@@ -83,7 +113,6 @@ func main() {
 	fnedmsg.Firewalldefault = !forward_accept
 
 	// Initialize services
-	firewallService := internalservice.NewFirewallService()
 	pseudoBridgeService := internalservice.NewPseudoBridgeService()
 	snatRoamingService := internalservice.NewSNATRoamingService(pseudoBridgeService, firewallService)
 	cfg.LoadInternalServices(pseudoBridgeService, snatRoamingService, fnedmsg)
@@ -110,7 +139,7 @@ func main() {
 	// Perform cleanup
 	pseudoBridgeService.Stop()
 	snatRoamingService.Stop()
-	performCleanup(cfg)
+	performCleanup(cfg, firewallService)
 }
 
 func loadOrCreateConfig(configPath, newPassword string) (*config.Config, bool, error) {
@@ -135,10 +164,17 @@ func loadOrCreateConfig(configPath, newPassword string) (*config.Config, bool, e
 
 		cfg := &config.Config{
 			WireGuardConfigPath: "/etc/wireguard",
+			WireGuardBackend:    config.WireGuardBackendWgQuick,
+			FirewallBackend:     internalservice.FirewallBackendIptables,
 			WgIfPrefix:          "wg-",
 			LogLevel:            logging.LogLevelInfo,
 			User:                "admin",
 			Password:            string(hashedPassword),
+			Users:               make(map[string]*config.User),
+			APITokens:           make(map[string]*config.APIToken),
+			PortalLinks:         make(map[string]*config.PortalLink),
+			DownloadLinks:       make(map[string]*config.DownloadLink),
+			Webhooks:            make(map[string]*config.Webhook),
 			ListenIP:            "0.0.0.0",
 			ListenPort:          5000,
 			BasePath:            "/",
@@ -147,6 +183,37 @@ func loadOrCreateConfig(configPath, newPassword string) (*config.Config, bool, e
 			WGPanelTitle:        "Wireguard Server Panel",
 			Interfaces:          make(map[string]*models.Interface),
 			Sessions:            make(map[string]*config.Session),
+			Reconcile:           config.ReconcileConfig{IntervalSeconds: 60},
+			ClientExpiry:        config.ClientExpiryConfig{IntervalSeconds: 60},
+			AuditLogPath:        filepath.Join(filepath.Dir(configPath), "audit.jsonl"),
+			RevisionsPath:       filepath.Join(filepath.Dir(configPath), "revisions"),
+			Storage:             config.StorageJSON,
+			StoragePath:         filepath.Join(filepath.Dir(configPath), "wg-panel.db"),
+			TrafficQuota: config.TrafficQuotaConfig{
+				IntervalSeconds: 60,
+				UsagePath:       filepath.Join(filepath.Dir(configPath), "usage.json"),
+			},
+			TrafficHistory: config.TrafficHistoryConfig{
+				IntervalSeconds: 60,
+				Path:            filepath.Join(filepath.Dir(configPath), "history.db"),
+				MinuteHours:     24,
+				HourDays:        31,
+			},
+			Events: config.EventsConfig{
+				PollSeconds: 5,
+			},
+			LoginProtection: config.LoginProtectionConfig{
+				MaxFailures:      10,
+				BaseDelaySeconds: 1,
+				MaxDelaySeconds:  60,
+				LockoutSeconds:   900,
+				ResetSeconds:     3600,
+			},
+			TLS: config.TLSConfig{
+				Mode:     config.TLSModeOff,
+				CertDir:  filepath.Join(filepath.Dir(configPath), "certs"),
+				HTTPPort: 80,
+			},
 		}
 
 		if err := saveConfig(configPath, cfg); err != nil {
@@ -177,7 +244,7 @@ func loadOrCreateConfig(configPath, newPassword string) (*config.Config, bool, e
 			return nil, false, fmt.Errorf("failed to hash new password:-> %v", err)
 		}
 		cfg.Password = string(hashedPassword)
-		if err := saveConfig(configPath, cfg); err != nil {
+		if err := cfg.Save(); err != nil {
 			return nil, false, fmt.Errorf("failed to save updated config:-> %v", err)
 		}
 	}
@@ -186,6 +253,36 @@ func loadOrCreateConfig(configPath, newPassword string) (*config.Config, bool, e
 	return cfg, false, nil
 }
 
+// rotateSecretKeyFile encrypts the secrets of the configuration with the key of a file,
+// a new key is written to the file if it doesn't exist. The previous key must still
+// be set to decrypt the configuration.
+func rotateSecretKeyFile(cfg *config.Config, path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(absPath)
+	if os.IsNotExist(err) {
+		key, err := config.GenerateSecretKey()
+		if err != nil {
+			return err
+		}
+		data = []byte(key + "\n")
+		if err := utils.WriteFileAtomic(absPath, data, 0600); err != nil {
+			return fmt.Errorf("failed to write secret key file:-> %v", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read secret key file:-> %v", err)
+	}
+	key, err := config.ParseSecretKey(string(data))
+	if err != nil {
+		return err
+	}
+
+	cfg.SecretKeyFile = absPath
+	return cfg.RotateSecretKey(key)
+}
+
 func generateRandomPassword() (string, error) {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, 16)
@@ -213,7 +310,7 @@ func saveConfig(configPath string, cfg *config.Config) error {
 }
 
 // performSystemChecks validates system configuration and required tools
-func performSystemChecks() (forward_accept bool, err error) {
+func performSystemChecks(wgBackend string, fwBackend string) (forward_accept bool, err error) {
 	var warnings []string
 
 	// Check IP forwarding settings
@@ -222,7 +319,7 @@ func performSystemChecks() (forward_accept bool, err error) {
 	}
 
 	// Check required tools installation
-	if err := checkRequiredTools(&warnings); err != nil {
+	if err := checkRequiredTools(&warnings, wgBackend, fwBackend); err != nil {
 		warnings = append(warnings, err.Error())
 	}
 
@@ -260,8 +357,17 @@ func checkIPForwarding(warnings *[]string) error {
 }
 
 // checkRequiredTools verifies all required system tools are installed
-func checkRequiredTools(warnings *[]string) error {
-	requiredTools := []string{"ip", "wg", "wg-quick", "iptables", "ip6tables", "iptables-save", "ip6tables-save"}
+func checkRequiredTools(warnings *[]string, wgBackend string, fwBackend string) error {
+	requiredTools := []string{"ip"}
+	if fwBackend == internalservice.FirewallBackendNftables {
+		requiredTools = append(requiredTools, "nft")
+	} else {
+		requiredTools = append(requiredTools, "iptables", "ip6tables", "iptables-save", "ip6tables-save")
+	}
+	if wgBackend != config.WireGuardBackendNetlink {
+		// The netlink backend talks to the kernel directly and doesn't need wireguard-tools
+		requiredTools = append(requiredTools, "wg", "wg-quick")
+	}
 
 	for _, tool := range requiredTools {
 		if err := utils.RunCommand("which", tool); err != nil {
@@ -270,6 +376,8 @@ func checkRequiredTools(warnings *[]string) error {
 				*warnings = append(*warnings, fmt.Sprintf("%s not found. Install with: apt-get install iproute2 (Ubuntu/Debian) or yum install iproute (RHEL/CentOS)", tool))
 			case "wg", "wg-quick":
 				*warnings = append(*warnings, fmt.Sprintf("%s not found. Install WireGuard tools with: apt-get install wireguard-tools (Ubuntu/Debian) or yum install wireguard-tools (RHEL/CentOS)", tool))
+			case "nft":
+				*warnings = append(*warnings, fmt.Sprintf("%s not found. Install with: apt-get install nftables (Ubuntu/Debian) or yum install nftables (RHEL/CentOS)", tool))
 			case "iptables", "ip6tables", "iptables-save", "ip6tables-save":
 				*warnings = append(*warnings, fmt.Sprintf("%s not found. Install with: apt-get install iptables (Ubuntu/Debian) or yum install iptables (RHEL/CentOS)", tool))
 			default:
@@ -304,18 +412,21 @@ func checkFirewallPolicies(warnings *[]string) (forward_accept bool, err error)
 }
 
 // performCleanup performs cleanup during normal shutdown
-func performCleanup(cfg *config.Config) {
+func performCleanup(cfg *config.Config, fw *internalservice.FirewallService) {
 	if cfg == nil {
 		logging.LogError("Cannot perform cleanup: configuration is nil")
 		return
 	}
 
 	logging.LogInfo("Performing cleanup before shutdown...")
-	cfg.CleanUp()
+	cfg.CleanUp(fw)
+	if err := cfg.Close(); err != nil {
+		logging.LogError("Failed to close storage: %v", err)
+	}
 }
 
 // performCleanupAndExit performs cleanup and exits (for --cleanup flag)
-func performCleanupAndExit(cfg *config.Config) {
+func performCleanupAndExit(cfg *config.Config, fw *internalservice.FirewallService) {
 	// Initialize logger for cleanup output
 	if cfg != nil {
 		logging.InitLogger(cfg.LogLevel)
@@ -328,7 +439,7 @@ func performCleanupAndExit(cfg *config.Config) {
 		os.Exit(1)
 	}
 
-	performCleanup(cfg)
+	performCleanup(cfg, fw)
 
 	fmt.Printf("Cleanup completed successfully. All interfaces and firewall rules removed.\n")
 	os.Exit(0)